@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/client"
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// benchKinds are the kinds of requests the bench command sends, each built against an exam of the instance
+var benchKinds = map[string]func(base string, e benchExam, rng *rand.Rand) (*http.Request, error){
+	// The full listing, as the frontend loads it on start
+	"list": func(base string, _ benchExam, _ *rand.Rand) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, base+"/api/v1/exams", nil)
+	},
+	// One exam with its questions, as a student opening it fetches it
+	"exam": func(base string, e benchExam, _ *rand.Rand) (*http.Request, error) {
+		body, _ := json.Marshal(map[string]any{"exams": []map[string]string{{"subject": e.subject, "name": e.name}}})
+		return benchPost(base+"/api/v1/exams/batch", body)
+	},
+	// A graded submission of random answers, recorded by the instance like any other
+	"submit": func(base string, e benchExam, rng *rand.Rand) (*http.Request, error) {
+		answers := make([]exam.Answer, e.questions)
+		for i := range answers {
+			answers[i] = exam.ChoiceAnswer(rng.IntN(4))
+		}
+		body, _ := json.Marshal(map[string]any{
+			"subject": e.subject, "exam": e.name, "answers": answers,
+			"userId": "bench-" + strconv.Itoa(rng.IntN(1000)),
+		})
+		return benchPost(base+"/api/v1/attempts", body)
+	},
+}
+
+// benchExam is an exam of the instance under test that requests are made against
+type benchExam struct {
+	subject, name string
+	questions     int
+}
+
+// benchPost returns a POST request sending a JSON body
+func benchPost(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// runBench replays a mix of requests against a running instance and reports their latency
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	serverURL := fs.String("server", cmp.Or(os.Getenv("MOCK_EXAM_SERVER"), "http://localhost:8080"), "URL of the instance under test (defaults to $MOCK_EXAM_SERVER)")
+	token := fs.String("token", os.Getenv("MOCK_EXAM_TOKEN"), "bearer token sent with every request (defaults to $MOCK_EXAM_TOKEN)")
+	duration := fs.Duration("duration", 30*time.Second, "how long to send requests for")
+	concurrency := fs.Int("concurrency", 8, "number of requests in flight at once")
+	mix := fs.String("mix", "list=20,exam=60,submit=20", "weights of the kinds of request sent: list, exam and submit; submissions are recorded as attempts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	weights, err := parseBenchMix(*mix)
+	if err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return errors.New("bench: -concurrency must be at least 1")
+	}
+	base := strings.TrimSuffix(*serverURL, "/")
+
+	c := client.New(base)
+	c.Token = *token
+	subjects, err := c.Exams(context.Background(), client.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("bench: failed to list the exams of %s: %w", base, err)
+	}
+	var exams []benchExam
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		exams = append(exams, benchExam{subject: subject, name: e.Name, questions: e.QuestionCount})
+	})
+	if len(exams) == 0 {
+		return fmt.Errorf("bench: %s has no exams to request (try the seed command)", base)
+	}
+
+	fmt.Printf("Sending %s of requests to %s, %d at a time, across %d exam(s)...\n", *duration, base, *concurrency, len(exams))
+	// Every worker keeps its connection open, as long-lived clients of the instance would
+	httpClient := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}}
+	results := newBenchResults()
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for worker := range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(worker)))
+			for time.Now().Before(deadline) {
+				kind := weights.pick(rng)
+				req, err := benchKinds[kind](base, exams[rng.IntN(len(exams))], rng)
+				if err != nil {
+					results.add(kind, 0, err)
+					continue
+				}
+				if *token != "" {
+					req.Header.Set("Authorization", "Bearer "+*token)
+				}
+				start := time.Now()
+				err = sendBenchRequest(httpClient, req)
+				results.add(kind, time.Since(start), err)
+			}
+		}()
+	}
+	wg.Wait()
+	results.print(os.Stdout, *duration)
+	return nil
+}
+
+// sendBenchRequest sends a request, reading the whole response, and fails on error statuses
+func sendBenchRequest(c *http.Client, req *http.Request) error {
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// benchMix is the weighted choice of request kinds
+type benchMix struct {
+	kinds   []string
+	weights []int
+	total   int
+}
+
+// parseBenchMix parses weights given as kind=weight pairs separated by commas
+func parseBenchMix(s string) (benchMix, error) {
+	var mix benchMix
+	for _, pair := range strings.Split(s, ",") {
+		kind, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		n, err := strconv.Atoi(weight)
+		if !ok || err != nil || n < 0 {
+			return benchMix{}, fmt.Errorf("bench: -mix must be kind=weight pairs, got %q", pair)
+		}
+		if benchKinds[kind] == nil {
+			return benchMix{}, fmt.Errorf("bench: unknown request kind %q (use list, exam or submit)", kind)
+		}
+		if n > 0 {
+			mix.kinds, mix.weights, mix.total = append(mix.kinds, kind), append(mix.weights, n), mix.total+n
+		}
+	}
+	if mix.total == 0 {
+		return benchMix{}, errors.New("bench: -mix gives no request kind a weight")
+	}
+	return mix, nil
+}
+
+// pick returns a request kind with the odds of its weight
+func (m benchMix) pick(rng *rand.Rand) string {
+	n := rng.IntN(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.kinds[i]
+		}
+		n -= w
+	}
+	return m.kinds[len(m.kinds)-1]
+}
+
+// benchResults collects the latencies and errors of the requests sent, by kind
+type benchResults struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	firstErr  map[string]error
+}
+
+func newBenchResults() *benchResults {
+	return &benchResults{latencies: map[string][]time.Duration{}, errors: map[string]int{}, firstErr: map[string]error{}}
+}
+
+// add records one request; failed requests count as errors and not towards the latencies
+func (r *benchResults) add(kind string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors[kind]++
+		if r.firstErr[kind] == nil {
+			r.firstErr[kind] = err
+		}
+		return
+	}
+	r.latencies[kind] = append(r.latencies[kind], latency)
+}
+
+// print writes the throughput and latency percentiles of every kind of request, and of all of them
+func (r *benchResults) print(w io.Writer, elapsed time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "KIND\tREQUESTS\tERRORS\tREQ/S\tP50\tP90\tP99\tMAX\t")
+	var all []time.Duration
+	allErrors := 0
+	kinds := slices.DeleteFunc(slices.Sorted(maps.Keys(benchKinds)), func(k string) bool {
+		return len(r.latencies[k]) == 0 && r.errors[k] == 0
+	})
+	row := func(kind string, latencies []time.Duration, errs int) {
+		slices.Sort(latencies)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n", kind, len(latencies)+errs, errs,
+			float64(len(latencies)+errs)/elapsed.Seconds(),
+			percentile(latencies, 0.50), percentile(latencies, 0.90), percentile(latencies, 0.99), percentile(latencies, 1))
+	}
+	for _, kind := range kinds {
+		row(kind, r.latencies[kind], r.errors[kind])
+		all = append(all, r.latencies[kind]...)
+		allErrors += r.errors[kind]
+	}
+	row("all", all, allErrors)
+	tw.Flush()
+	for _, kind := range kinds {
+		if err := r.firstErr[kind]; err != nil {
+			fmt.Fprintf(w, "First %s error: %v\n", kind, err)
+		}
+	}
+}
+
+// percentile returns the latency below which the fraction p of the sorted latencies fall
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := max(int(float64(len(sorted))*p+0.999999)-1, 0)
+	return sorted[min(i, len(sorted)-1)].Round(10 * time.Microsecond)
+}
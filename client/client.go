@@ -0,0 +1,255 @@
+// Package client calls the API of an exam server from Go, for test tooling and bots: listing exams, taking them
+// through sessions and submitting answers.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/server"
+)
+
+// apiPrefix is the path of the API version the client speaks
+const apiPrefix = "/api/v1"
+
+// Client calls the API of the exam server at a base URL. Its fields may be set before the first call.
+type Client struct {
+	BaseURL    string       // URL the server is mounted at, without the /api path
+	HTTPClient *http.Client // defaults to http.DefaultClient
+	Token      string       // bearer token sent with every request, for instructor and admin endpoints
+	Window     string       // identifies this client as the window holding its sessions, when sessions are not shared
+}
+
+// New returns a client of the exam server at baseURL, such as http://localhost:8080
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Error is a response of the server with an error status, carrying the message the server gave
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server responded %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// ListOptions shapes an exam listing; the zero value lists every exam with its questions
+type ListOptions struct {
+	Flat       bool // list every subject at the top level instead of nesting child subjects
+	RenderHTML bool // render Markdown question text to HTML
+	SortBy     string
+}
+
+// Exams returns the subjects of the server with their exams
+func (c *Client) Exams(ctx context.Context, opts ListOptions) ([]exam.Subject, error) {
+	query := url.Values{}
+	if opts.Flat {
+		query.Set("layout", "flat")
+	}
+	if opts.RenderHTML {
+		query.Set("render", "html")
+	}
+	if opts.SortBy != "" {
+		query.Set("sort", opts.SortBy)
+	}
+	var subjects []exam.Subject
+	err := c.do(ctx, http.MethodGet, "/exams?"+query.Encode(), nil, &subjects)
+	return subjects, err
+}
+
+// Exam returns one exam as the listing serves it by its subject and name, with or without extension, or slug
+func (c *Client) Exam(ctx context.Context, subject, name string) (exam.ExamFile, error) {
+	var e server.SubjectExam
+	err := c.do(ctx, http.MethodGet, "/exams/"+url.PathEscape(subject)+"/"+url.PathEscape(name), nil, &e)
+	return e.ExamFile, err
+}
+
+// SessionStart is the request to start an exam session, or resume the user's unfinished one
+type SessionStart struct {
+	Subject    string                 `json:"subject"`
+	Exam       string                 `json:"exam"`
+	UserID     string                 `json:"userId,omitempty"` // anonymous sessions are never resumed
+	AccessCode string                 `json:"accessCode,omitempty"`
+	Variant    *server.VariantOptions `json:"variant,omitempty"` // take a per-user paper instead of the exam as authored
+	Client     string                 `json:"client,omitempty"`
+}
+
+// StartSession starts a session of an exam, or resumes the user's unfinished session of it
+func (c *Client) StartSession(ctx context.Context, start SessionStart) (*server.Session, error) {
+	start.Client = c.Window
+	var session server.Session
+	if err := c.do(ctx, http.MethodPost, "/sessions", start, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Session returns the current state of a session
+func (c *Client) Session(ctx context.Context, id string) (*server.Session, error) {
+	var session server.Session
+	if err := c.do(ctx, http.MethodGet, "/sessions/"+url.PathEscape(id), nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SaveAnswers saves answers to a session by question index; a nil answer clears the question. Questions not in
+// answers keep their saved answer.
+func (c *Client) SaveAnswers(ctx context.Context, id string, answers map[int]exam.Answer) (*server.Session, error) {
+	body := struct {
+		Answers map[string]exam.Answer `json:"answers"`
+	}{map[string]exam.Answer{}}
+	for i, a := range answers {
+		body.Answers[strconv.Itoa(i)] = a
+	}
+	var session server.Session
+	if err := c.do(ctx, http.MethodPatch, "/sessions/"+url.PathEscape(id)+"/answers", body, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// PauseSession pauses a session of an untimed exam, stopping its clock and locking its answers until it is resumed
+func (c *Client) PauseSession(ctx context.Context, id string) (*server.Session, error) {
+	var session server.Session
+	if err := c.do(ctx, http.MethodPost, "/sessions/"+url.PathEscape(id)+"/pause", nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ResumeSession resumes a paused session
+func (c *Client) ResumeSession(ctx context.Context, id string) (*server.Session, error) {
+	var session server.Session
+	if err := c.do(ctx, http.MethodPost, "/sessions/"+url.PathEscape(id)+"/resume", nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// submitTries is how many times a session submission is sent before giving up
+const submitTries = 3
+
+// SubmitSession grades the saved answers of a session, closing it, and returns the recorded attempt. Submissions
+// failing in transit or with a server error are sent again under the same Idempotency-Key, so a retry of a
+// submission the server did record returns its attempt instead of grading the session twice.
+func (c *Client) SubmitSession(ctx context.Context, id string) (*server.Attempt, error) {
+	key := make([]byte, 16)
+	rand.Read(key)
+	header := http.Header{"Idempotency-Key": {hex.EncodeToString(key)}}
+	var err error
+	for try, wait := 0, time.Second; try < submitTries; try, wait = try+1, wait*2 {
+		if try > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		var a server.Attempt
+		if err = c.send(ctx, http.MethodPost, "/sessions/"+url.PathEscape(id)+"/submit", header, nil, &a); err == nil {
+			return &a, nil
+		}
+		// Besides server errors, a conflict may be the first try still being graded
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusConflict {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// ScoreReport returns the score report of a submitted session
+func (c *Client) ScoreReport(ctx context.Context, id string) (*server.ScoreReport, error) {
+	var report server.ScoreReport
+	if err := c.do(ctx, http.MethodGet, "/sessions/"+url.PathEscape(id)+"/report", nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Submission is a set of answers graded and recorded in one request, without a session
+type Submission struct {
+	Subject    string                 `json:"subject"`
+	Exam       string                 `json:"exam"`
+	ExamID     string                 `json:"examId,omitempty"` // takes precedence over subject and exam
+	UserID     string                 `json:"userId,omitempty"`
+	AccessCode string                 `json:"accessCode,omitempty"` // of the current sitting, for exams with an access code
+	Answers    []exam.Answer          `json:"answers"`              // in exam order, or in paper order for a variant
+	Variant    *server.VariantOptions `json:"variant,omitempty"`
+}
+
+// SubmitAnswers grades answers to an exam and returns the recorded attempt
+func (c *Client) SubmitAnswers(ctx context.Context, sub Submission) (*server.Attempt, error) {
+	var a server.Attempt
+	if err := c.do(ctx, http.MethodPost, "/attempts", sub, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// do sends a request with body encoded as JSON to the API path and decodes the response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	return c.send(ctx, method, path, nil, body, out)
+}
+
+// send is do with extra request headers
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+apiPrefix+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Window != "" {
+		req.Header.Set("X-Session-Client", c.Window)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
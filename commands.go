@@ -0,0 +1,774 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/server"
+)
+
+// command describes a CLI subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands returns the available subcommands in the order they are listed in the usage text
+func commands() []command {
+	return []command{
+		{"serve", "Start the HTTP server (default)", runServe},
+		{"validate", "Check exam files for syntax and schema errors", runValidate},
+		{"import", "Copy exam files, an export bundle, a spreadsheet, or a Quizlet set into the exam directory", runImport},
+		{"migrate", "Rewrite exam files in older layouts into the current schema, keeping backups", runMigrate},
+		{"export", "Write all exams to a single JSON bundle", runExport},
+		{"stats", "Print question counts per subject and exam", runStats},
+		{"seed", "Generate a tree of fake exams for load tests and frontend development", runSeed},
+		{"bench", "Replay a mix of requests against a running server and report latency percentiles", runBench},
+		{"take", "Take an exam in the terminal, from the exam directory or a server", runTake},
+		{"gen", "Generate code from the Go types of the server, such as TypeScript types for the frontend", runGen},
+	}
+}
+
+// run dispatches args to the matching subcommand, falling back to serve when no subcommand is given
+func run(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+
+	name := args[0]
+	if name == "help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+
+	printUsage(os.Stderr)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// printUsage writes the list of subcommands to w
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+}
+
+// newFlagSet creates a flag set for the named subcommand with the shared -dir flag
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	dir := fs.String("dir", "json", "directory containing the subject folders")
+	return fs, dir
+}
+
+// graderFlag adds the repeatable -grader flag, which registers a grader process for a custom question type
+func graderFlag(fs *flag.FlagSet) {
+	fs.Func("grader", "grade questions of a custom type with a process, as type=command args (repeatable)", exam.ParseGraderFlag)
+}
+
+// answerKeyFlags adds the flags giving the secret that sealed answer keys are opened with, and returns the function
+// that sets it once the flags are parsed
+func answerKeyFlags(fs *flag.FlagSet) func() error {
+	secret := fs.String("answer-key-secret", os.Getenv("ANSWER_KEY_SECRET"), "base64-encoded 32-byte AES key sealing the answer keys of exam files (defaults to $ANSWER_KEY_SECRET)")
+	file := fs.String("answer-key-secret-file", os.Getenv("ANSWER_KEY_SECRET_FILE"), "file holding the answer key secret, such as one provisioned by a KMS or secret manager (defaults to $ANSWER_KEY_SECRET_FILE)")
+	return func() error {
+		if *file == "" {
+			return exam.SetAnswerKeySecret(*secret)
+		}
+		if *secret != "" {
+			return errors.New("set either the answer key secret or the file holding it, not both")
+		}
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("failed to read answer key secret: %w", err)
+		}
+		return exam.SetAnswerKeySecret(string(data))
+	}
+}
+
+// runServe starts the HTTP server
+func runServe(args []string) error {
+	fs, dir := newFlagSet("serve")
+
+	// Get port from environment variable, default to 8080
+	defaultPort := os.Getenv("PORT")
+	if defaultPort == "" {
+		defaultPort = "8080"
+	}
+	port := fs.String("port", defaultPort, "port to listen on (defaults to $PORT or 8080)")
+	static := fs.String("static", "web", "directory to serve the frontend from, which must not hold the exam, data or media directory")
+	mediaDir := fs.String("media", "media", "directory containing the per-subject media folders")
+	instructorTokens := fs.String("instructor-tokens", os.Getenv("INSTRUCTOR_TOKENS"), "comma-separated bearer tokens granting the instructor role, each optionally followed by =<subject>[:<subject>...] to limit it to those subjects (defaults to $INSTRUCTOR_TOKENS)")
+	authorTokens := fs.String("author-tokens", os.Getenv("AUTHOR_TOKENS"), "comma-separated bearer tokens granting the exam editing routes of the admin API, limited to subjects as instructor tokens (defaults to $AUTHOR_TOKENS)")
+	ltiConfig := fs.String("lti-config", os.Getenv("LTI_CONFIG"), "JSON file of LTI 1.3 platform registrations (defaults to $LTI_CONFIG; LTI disabled if empty)")
+	xapiEndpoint := fs.String("xapi-endpoint", os.Getenv("XAPI_ENDPOINT"), "xAPI LRS endpoint that attempt statements are sent to (defaults to $XAPI_ENDPOINT; disabled if empty)")
+	xapiKey := fs.String("xapi-key", os.Getenv("XAPI_KEY"), "basic auth username of the LRS (defaults to $XAPI_KEY)")
+	xapiSecret := fs.String("xapi-secret", os.Getenv("XAPI_SECRET"), "basic auth password of the LRS (defaults to $XAPI_SECRET)")
+	xapiBase := fs.String("xapi-activity-base", "urn:mock-exam:", "prefix of the xAPI activity IDs of exams")
+	xapiHomePage := fs.String("xapi-homepage", "urn:mock-exam", "account home page of the xAPI actors")
+	eventsURL := fs.String("events", os.Getenv("EVENTS_URL"), "kafka://broker[,broker...] or nats://host[:port] URL that exam, session and attempt events are published to (defaults to $EVENTS_URL; disabled if empty)")
+	eventsPrefix := fs.String("events-prefix", "mockexam", "prefix of the event topics or subjects, as in <prefix>.attempt.graded")
+	dataDir := fs.String("data", "data", "directory where attempts and other server state are stored")
+	imageCache := fs.String("image-cache", "", "directory where resized images are cached (defaults to the user cache directory)")
+	adminToken := fs.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token for the admin API (defaults to $ADMIN_TOKEN; admin API disabled if empty)")
+	defaultSanitize := os.Getenv("SANITIZE_HTML")
+	if defaultSanitize == "" {
+		defaultSanitize = "ugc"
+	}
+	sanitize := fs.String("sanitize", defaultSanitize, "HTML sanitization of exam content: "+strings.Join(server.SanitizeModes, ", ")+" (defaults to $SANITIZE_HTML or ugc)")
+	sortMode := fs.String("sort", "name", "ordering of subjects and exams: "+strings.Join(exam.SortModes, ", "))
+	redact := fs.Bool("redact-answers", os.Getenv("REDACT_ANSWERS") != "false", "strip answers, correct indexes and explanations from public exam responses (disable with $REDACT_ANSWERS=false)")
+	autosaveDebounce := fs.Duration("autosave-debounce", 2*time.Second, "how long clients wait after an answer before autosaving a session")
+	concurrent := fs.String("concurrent-sessions", "allow", "handling of a session opened in a second window: "+strings.Join(server.ConcurrentModes, ", "))
+	dailyCount := fs.Int("daily-questions", 5, "number of questions in the daily challenge")
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file of the log level, rate limits, CORS, availability and debug endpoint settings, reloaded on SIGHUP (defaults to $CONFIG_FILE)")
+	graderFlag(fs)
+	gzipLevel := fs.Int("gzip-level", 6, "gzip compression level of responses, 1 (fastest) to 9 (smallest), or 0 for the default")
+	gzipMinSize := fs.Int("gzip-min-size", 1024, "size in bytes below which responses are sent uncompressed")
+	examsCacheTTL := fs.Duration("exams-cache-ttl", 0, "how long /api/exams responses are reused before the exam directory is read again; concurrent identical requests always share one read")
+	watch := fs.Bool("watch", false, "cache exam content and reload it automatically when files change")
+	watchInterval := fs.Duration("watch-interval", time.Second, "how often -watch checks for changed files")
+	redisURL := fs.String("redis", os.Getenv("REDIS_URL"), "redis:// or rediss:// URL of a Redis shared by replicas for exam listings, sessions and rate limits (defaults to $REDIS_URL; disabled if empty)")
+	redisPrefix := fs.String("redis-prefix", "mockexam:", "prefix of the Redis keys, so deployments can share one Redis")
+	signingKeys := fs.String("signing-keys", os.Getenv("SIGNING_KEYS"), "file of the minisign public keys exam files are signed with; exams are marked with their signature state (defaults to $SIGNING_KEYS; not checked if empty)")
+	requireSignatures := fs.Bool("require-signatures", false, "leave out exams without a valid signature of a -signing-keys key instead of marking them")
+	publishApproval := fs.Bool("publish-approval", false, "require a second instructor or admin to approve the publication of uploaded draft exams")
+	answerKey := answerKeyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := answerKey(); err != nil {
+		return err
+	}
+
+	// The log level of the configuration file applies to every log line, those of the log package included
+	logLevel := new(slog.LevelVar)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
+	srv, err := server.New(server.Config{
+		Dir:                *dir,
+		DataDir:            *dataDir,
+		Static:             *static,
+		MediaDir:           *mediaDir,
+		ImageCache:         *imageCache,
+		AdminToken:         *adminToken,
+		InstructorTokens:   strings.Split(*instructorTokens, ","),
+		AuthorTokens:       strings.Split(*authorTokens, ","),
+		Sanitize:           *sanitize,
+		Sort:               *sortMode,
+		RedactAnswers:      *redact,
+		SigningKeys:        *signingKeys,
+		RequireSignatures:  *requireSignatures,
+		PublishApproval:    *publishApproval,
+		AutosaveDebounce:   *autosaveDebounce,
+		ConcurrentSessions: *concurrent,
+		DailyQuestions:     *dailyCount,
+		ConfigFile:         *configFile,
+		LogLevel:           logLevel,
+		GzipLevel:          *gzipLevel,
+		GzipMinSize:        *gzipMinSize,
+		ExamsCacheTTL:      *examsCacheTTL,
+		Watch:              *watch,
+		WatchInterval:      *watchInterval,
+		RedisURL:           *redisURL,
+		RedisPrefix:        *redisPrefix,
+		LTIConfig:          *ltiConfig,
+		XAPI: server.XAPIConfig{
+			Endpoint:     *xapiEndpoint,
+			Username:     *xapiKey,
+			Password:     *xapiSecret,
+			ActivityBase: *xapiBase,
+			HomePage:     *xapiHomePage,
+		},
+		Events: server.EventsConfig{
+			URL:    *eventsURL,
+			Prefix: *eventsPrefix,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if *configFile != "" {
+		go reloadOnSignal(srv)
+	}
+
+	fmt.Printf("Server starting on port %s...\n", *port)
+	log.Printf("Application started on port %s", *port)
+	return http.ListenAndServe(":"+*port, srv.Handler())
+}
+
+// reloadOnSignal reloads the configuration of srv every time the process receives SIGHUP
+func reloadOnSignal(srv *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := srv.ReloadConfig(); err != nil {
+			log.Printf("Failed to reload configuration: %v", err)
+		}
+	}
+}
+
+// runValidate parses every exam file under the exam directory and reports problems
+func runValidate(args []string) error {
+	fs, dir := newFlagSet("validate")
+	mediaDir := fs.String("media", "media", "directory containing the per-subject media folders")
+	requireIDs := fs.Bool("require-ids", false, "report exams and questions without an \"id\", and ids used by more than one exam")
+	signingKeys := fs.String("signing-keys", os.Getenv("SIGNING_KEYS"), "file of minisign public keys; exam files without a valid signature of one of them are reported (defaults to $SIGNING_KEYS)")
+	graderFlag(fs)
+	answerKey := answerKeyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := answerKey(); err != nil {
+		return err
+	}
+	var keys []exam.PublicKey
+	if *signingKeys != "" {
+		data, err := os.ReadFile(*signingKeys)
+		if err != nil {
+			return fmt.Errorf("failed to read signing keys: %w", err)
+		}
+		if keys, err = exam.ParsePublicKeys(data); err != nil {
+			return fmt.Errorf("failed to parse signing keys %s: %w", *signingKeys, err)
+		}
+	}
+
+	checked, failed := 0, 0
+	examIDs := map[string]string{} // exam ids with the file first using them
+	err := filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !exam.IsJSONFile(path) {
+			return nil
+		}
+
+		checked++
+		problems := exam.ValidateFile(*dir, path, *mediaDir)
+		if *requireIDs && len(problems) == 0 && exam.IsExamFile(path) {
+			problems = checkIDs(path, examIDs)
+		}
+		if keys != nil && exam.IsExamFile(path) {
+			if _, _, err := exam.VerifyExamFile(path, keys); err != nil {
+				problems = append(problems, "signature: "+err.Error())
+			}
+		}
+		if len(problems) > 0 {
+			failed++
+			fmt.Printf("FAIL %s\n", path)
+			for _, p := range problems {
+				fmt.Printf("  - %s\n", p)
+			}
+		} else {
+			fmt.Printf("ok   %s\n", path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d file(s) checked, %d with problems\n", checked, failed)
+	if failed > 0 {
+		return fmt.Errorf("validation failed for %d file(s)", failed)
+	}
+	return nil
+}
+
+// runMigrate rewrites the exam files under the exam directory that use an older layout into the current schema,
+// saving a copy of each original next to it first
+func runMigrate(args []string) error {
+	fs, dir := newFlagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "only report the changes that would be made")
+	suffix := fs.String("backup-suffix", ".bak", "suffix appended to the file name of the backup of a migrated file")
+	force := fs.Bool("force", false, "overwrite backups left by an earlier migration")
+	assignIDs := fs.Bool("assign-ids", false, "also give the exams and questions without an \"id\" a random one")
+	sealKeys := fs.Bool("seal-keys", false, "also encrypt the answer keys with the answer key secret, so the files do not give the answers away")
+	answerKey := answerKeyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := answerKey(); err != nil {
+		return err
+	}
+	if *suffix == "" || exam.IsJSONFile("x"+*suffix) {
+		return errors.New("migrate: -backup-suffix must be set and must not be a JSON extension")
+	}
+
+	checked, migrated, failed := 0, 0, 0
+	err := filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !exam.IsExamFile(path) {
+			return nil
+		}
+
+		checked++
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		if len(content) == 0 {
+			fmt.Printf("skip %s (empty)\n", path)
+			return nil
+		}
+		updated, changes, err := exam.MigrateFile(path, content, exam.MigrateOptions{AssignIDs: *assignIDs, SealKeys: *sealKeys})
+		if err == nil && len(changes) > 0 {
+			// A migration must not leave a file that no longer loads
+			parsed, _ := exam.ParseContent(path, updated)
+			if problems := exam.ValidateDocument(parsed); len(problems) > 0 {
+				err = fmt.Errorf("migrated exam is not valid: %s", strings.Join(problems, "; "))
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s\n  - %v\n", path, err)
+			return nil
+		}
+		if len(changes) == 0 {
+			fmt.Printf("ok   %s\n", path)
+			return nil
+		}
+
+		migrated++
+		fmt.Printf("migrate %s\n", path)
+		for _, c := range changes {
+			fmt.Printf("  - %s\n", c)
+		}
+		if *dryRun {
+			return nil
+		}
+		backup := path + *suffix
+		if _, err := os.Stat(backup); err == nil && !*force {
+			return fmt.Errorf("migrate: backup %s already exists (use -force to overwrite)", backup)
+		}
+		if err := os.WriteFile(backup, content, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write backup %s: %w", backup, err)
+		}
+		if err := os.WriteFile(path, updated, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "migrated"
+	if *dryRun {
+		verb = "to migrate"
+	}
+	fmt.Printf("\n%d file(s) checked, %d %s, %d failed\n", checked, migrated, verb, failed)
+	if *sealKeys && migrated > 0 && !*dryRun {
+		fmt.Printf("The backups (*%s) still hold the answer keys in plain text; delete them once the sealed files are checked\n", *suffix)
+	}
+	if failed > 0 {
+		return fmt.Errorf("migration failed for %d file(s)", failed)
+	}
+	return nil
+}
+
+// checkIDs reports the missing identifiers of the exam file at path, and an exam id already used by another file
+// of examIDs
+func checkIDs(path string, examIDs map[string]string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	parsed, err := exam.ParseContent(path, content)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	problems := exam.CheckIDs(parsed)
+	if obj, ok := parsed.(map[string]any); ok {
+		if id, _ := obj["id"].(string); id != "" {
+			if first, dup := examIDs[id]; dup {
+				problems = append(problems, fmt.Sprintf("exam \"id\" %q is already used by %s", id, first))
+			} else {
+				examIDs[id] = path
+			}
+		}
+	}
+	return problems
+}
+
+// runImport copies exam files, an export bundle, or spreadsheets into the exam directory after validating them
+func runImport(args []string) error {
+	fs, dir := newFlagSet("import")
+	subject := fs.String("subject", "", "subject folder to import plain exam files and spreadsheets into")
+	force := fs.Bool("force", false, "overwrite exams that already exist")
+	name := fs.String("name", "", "exam file name for an imported spreadsheet (defaults to the source file name)")
+	template := fs.String("template", "", "JSON file mapping spreadsheet columns to question fields")
+	format := fs.String("format", "", "input format: leave empty to detect, or \"quizlet\" for a Quizlet text export")
+	var quizlet exam.QuizletOptions
+	fs.StringVar(&quizlet.TermSeparator, "term-sep", "\t", "Quizlet separator between term and definition")
+	fs.StringVar(&quizlet.CardSeparator, "card-sep", "\n", "Quizlet separator between cards")
+	fs.IntVar(&quizlet.Choices, "choices", 4, "number of choices per question built from a Quizlet set")
+	fs.BoolVar(&quizlet.Reverse, "reverse", false, "ask for the Quizlet term given its definition")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("import: no input files given")
+	}
+
+	for _, src := range fs.Args() {
+		// Quizlet exports are flashcards, turned into multiple-choice questions
+		if *format == "quizlet" {
+			if *subject == "" {
+				return fmt.Errorf("import: -subject is required for Quizlet set %s", src)
+			}
+			if err := importQuizlet(*dir, *subject, *name, src, quizlet, *force); err != nil {
+				return err
+			}
+			continue
+		} else if *format != "" {
+			return fmt.Errorf("import: unknown format %q", *format)
+		}
+
+		// Google Sheets links and .xlsx/.csv/.tsv files are converted with the column template
+		if exam.IsSheetSource(src) {
+			if *subject == "" {
+				return fmt.Errorf("import: -subject is required for spreadsheet %s", src)
+			}
+			tmpl, err := exam.LoadSheetTemplate(*template)
+			if err != nil {
+				return err
+			}
+			if err := importSheet(*dir, *subject, *name, src, tmpl, *force); err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", src, err)
+		}
+		parsed, err := exam.ParseContent(src, content)
+		if err != nil {
+			return err
+		}
+
+		// An export bundle carries its own subject and exam names
+		if subjects, ok := decodeBundle(parsed); ok {
+			if err := importBundle(*dir, "", subjects, *force); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if *subject == "" {
+			return fmt.Errorf("import: -subject is required for plain exam file %s", src)
+		}
+		if problems := exam.ValidateDocument(parsed); len(problems) > 0 {
+			return fmt.Errorf("import: %s is not a valid exam: %s", src, strings.Join(problems, "; "))
+		}
+		if err := printImported(exam.WriteFile(*dir, *subject, filepath.Base(src), content, *force)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printImported reports a file written by an import, passing on the error of writing it
+func printImported(target string, err error) error {
+	if err == nil {
+		fmt.Printf("imported %s\n", target)
+	}
+	return err
+}
+
+// importSheet converts a spreadsheet to an exam and writes it into the subject folder
+func importSheet(dir, subject, name, src string, tmpl exam.SheetTemplate, force bool) error {
+	rows, err := exam.LoadSheet(src)
+	if err != nil {
+		return err
+	}
+	questions, problems := exam.SheetToQuestions(rows, tmpl)
+	if len(problems) > 0 {
+		return fmt.Errorf("import: %s: %s", src, strings.Join(problems, "; "))
+	}
+
+	if name == "" {
+		name = exam.SheetName(src)
+	}
+	if !exam.IsExamFile(name) {
+		name += ".json"
+	}
+	return printImported(exam.Import(dir, subject, exam.ExamFile{Name: name, Content: questions}, force))
+}
+
+// importQuizlet converts a Quizlet text export to an exam and writes it into the subject folder
+func importQuizlet(dir, subject, name, src string, opts exam.QuizletOptions, force bool) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", src, err)
+	}
+	cards, err := exam.ParseQuizlet(string(content), opts)
+	if err != nil {
+		return fmt.Errorf("import: %s: %w", src, err)
+	}
+	questions, err := exam.QuizletToQuestions(cards, opts)
+	if err != nil {
+		return fmt.Errorf("import: %s: %w", src, err)
+	}
+
+	if name == "" {
+		name = exam.SheetName(src)
+	}
+	if !exam.IsExamFile(name) {
+		name += ".json"
+	}
+	return printImported(exam.Import(dir, subject, exam.ExamFile{Name: name, Content: questions}, force))
+}
+
+// importBundle writes the subjects of an export bundle, including their child subjects, below the parent subject path
+func importBundle(dir, parent string, subjects []exam.Subject, force bool) error {
+	for _, s := range subjects {
+		subjectPath := s.Path
+		if subjectPath == "" {
+			subjectPath = path.Join(parent, s.Name)
+		}
+		if s.SubjectMeta != (exam.SubjectMeta{}) {
+			if err := importSubjectMeta(dir, subjectPath, s.SubjectMeta, force); err != nil {
+				return err
+			}
+		}
+		for _, e := range s.Exams {
+			if err := printImported(exam.Import(dir, subjectPath, e, force)); err != nil {
+				return err
+			}
+		}
+		if err := importBundle(dir, subjectPath, s.Subjects, force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importSubjectMeta writes the metadata of a bundled subject as subject.json in the subject folder
+func importSubjectMeta(dir, subject string, meta exam.SubjectMeta, force bool) error {
+	if problems := exam.ValidateSubjectMeta(meta); len(problems) > 0 {
+		return fmt.Errorf("import: metadata of subject %s is invalid: %s", subject, strings.Join(problems, "; "))
+	}
+	data, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of subject %s: %w", subject, err)
+	}
+	return printImported(exam.WriteFile(dir, subject, "subject.json", append(data, '\n'), force))
+}
+
+// decodeBundle reports whether parsed content has the shape of an export bundle and decodes it
+func decodeBundle(parsed any) ([]exam.Subject, bool) {
+	items, ok := parsed.([]any)
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+	for _, item := range items {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if _, ok := obj["exams"]; !ok {
+			return nil, false
+		}
+	}
+
+	// Round-trip through JSON to decode into the typed structs
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		return nil, false
+	}
+	var subjects []exam.Subject
+	if err := json.Unmarshal(data, &subjects); err != nil {
+		return nil, false
+	}
+	return subjects, true
+}
+
+// runExport writes all exams to a single JSON bundle that import can read back
+func runExport(args []string) error {
+	fs, dir := newFlagSet("export")
+	output := fs.String("o", "", "file to write the bundle to (defaults to stdout)")
+	subject := fs.String("subject", "", "only export the subject with this path, including its child subjects")
+	scorm := fs.String("scorm", "", "export the exam <subject>/<name> as a SCORM package instead of a bundle")
+	scormVersion := fs.String("scorm-version", "1.2", "SCORM version of the package: "+strings.Join(server.SCORMVersions, ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	subjects, err := exam.ReadDir(context.Background(), *dir)
+	if err != nil {
+		return err
+	}
+	if *scorm != "" {
+		return exportSCORMFile(subjects, *scorm, *scormVersion, *output)
+	}
+	if *subject != "" {
+		found := exam.FindSubject(subjects, *subject)
+		if found == nil {
+			return fmt.Errorf("export: subject %q not found", *subject)
+		}
+		subjects = []exam.Subject{*found}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(subjects); err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	return nil
+}
+
+// exportSCORMFile writes the exam named by ref as a SCORM package to the output file, or stdout
+func exportSCORMFile(subjects []exam.Subject, ref, version, output string) error {
+	i := strings.LastIndexByte(ref, '/')
+	if i < 0 {
+		return fmt.Errorf("export: -scorm must be <subject>/<name>, got %q", ref)
+	}
+	e, ok := exam.FindExam(subjects, ref[:i], ref[i+1:])
+	if !ok {
+		return fmt.Errorf("export: exam %q not found", ref)
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	return server.WriteSCORMPackage(w, ref[:i], e, version)
+}
+
+// runSeed writes a generated tree of fake exams into the exam directory
+func runSeed(args []string) error {
+	fs, dir := newFlagSet("seed")
+	subjects := fs.Int("subjects", 5, "number of subjects")
+	exams := fs.Int("exams", 20, "number of exams per subject")
+	questions := fs.Int("questions", 50, "number of questions per exam")
+	seed := fs.Uint64("seed", 0, "seed of the generated content, which the same tree is generated again with (random when 0)")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *subjects < 1 || *exams < 1 || *questions < 1 {
+		return errors.New("seed: -subjects, -exams and -questions must be at least 1")
+	}
+	if *seed == 0 {
+		*seed = uint64(time.Now().UnixNano())
+	}
+
+	tree := exam.Seed(exam.SeedOptions{Subjects: *subjects, Exams: *exams, Questions: *questions, Seed: *seed})
+	if err := importBundle(*dir, "", tree, *force); err != nil {
+		return err
+	}
+	fmt.Printf("\nGenerated %d subject(s), %d exam(s), %d question(s) with -seed %d\n", *subjects, *subjects**exams, *subjects**exams**questions, *seed)
+	return nil
+}
+
+// runStats prints question counts per subject and exam
+func runStats(args []string) error {
+	fs, dir := newFlagSet("stats")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tree, err := exam.ReadDir(context.Background(), *dir)
+	if err != nil {
+		return err
+	}
+	subjects := exam.FlattenSubjects(tree)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUBJECT\tEXAM\tQUESTIONS")
+	totalExams, totalQuestions := 0, 0
+	for _, s := range subjects {
+		for _, e := range s.Exams {
+			n := e.QuestionCount
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", s.Name, e.Name, n)
+			totalExams++
+			totalQuestions += n
+		}
+	}
+	tw.Flush()
+
+	fmt.Printf("\n%d subject(s), %d exam(s), %d question(s)\n", len(subjects), totalExams, totalQuestions)
+	return nil
+}
+
+// generators are the targets of the gen command, writing generated code to w
+var generators = map[string]func(w io.Writer) error{
+	"types": server.WriteTypeScript,
+}
+
+// runGen writes the generated code of a target, such as "gen types" for the TypeScript types of the API
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	output := fs.String("o", "", "file to write the generated code to (defaults to stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s gen <target> [flags]\n\nTargets:\n  types\tTypeScript interfaces of Subject, ExamFile, Question, Session and Result\n\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		return errors.New("gen: missing target")
+	}
+	target := args[0]
+	generate, ok := generators[target]
+	if !ok {
+		return fmt.Errorf("gen: unknown target %q", target)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	return generate(w)
+}
@@ -0,0 +1,129 @@
+package exam
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BankDir is the folder of the exam directory holding the question banks, which is not a subject. Each bank is a
+// JSON file of questions, <BankDir>/<name>.json, whose questions exams reference by their "id".
+const BankDir = "_banks"
+
+// questionRefScheme prefixes the references to bank questions, "bank:<name>#<id>"
+const questionRefScheme = "bank:"
+
+// Banks are the question banks by name
+type Banks map[string]Bank
+
+// Bank is a question bank with its questions by id and the file it was read from
+type Bank struct {
+	Questions map[string]map[string]any
+	File      string // name of the file in BankDir
+	SHA256    string // of the raw file content, which its signature covers
+}
+
+// questionRef is a reference to question id of bank that was resolved
+type questionRef struct {
+	bank, id string
+}
+
+// ParseQuestionRef splits a question reference of the form bank:<name>#<id>
+func ParseQuestionRef(ref string) (bank, id string, err error) {
+	rest, ok := strings.CutPrefix(ref, questionRefScheme)
+	bank, id, found := strings.Cut(rest, "#")
+	if !ok || !found || !ValidName(bank) || id == "" {
+		return "", "", fmt.Errorf("invalid questionRef %q (expected %s<bank>#<question id>)", ref, questionRefScheme)
+	}
+	return bank, id, nil
+}
+
+// ReadBanks reads the question banks of the exam directory dir, none if it has no bank folder
+func ReadBanks(dir string) (Banks, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, BankDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return Banks{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read question banks: %w", err)
+	}
+	banks := Banks{}
+	for _, entry := range entries {
+		if entry.IsDir() || !IsExamFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, BankDir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+		parsed, err := ParseContent(path, content)
+		if err != nil {
+			return nil, err
+		}
+		// A bank is written like an exam, so it can be validated and uploaded as one
+		_, questions, err := splitExam(parsed, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load question bank %s: %w", path, err)
+		}
+		bank := Bank{Questions: map[string]map[string]any{}, File: entry.Name(), SHA256: contentHash(content)}
+		for _, item := range Questions(questions) {
+			if q, ok := item.(map[string]any); ok {
+				if id, _ := q["id"].(string); id != "" {
+					bank.Questions[id] = q
+				}
+			}
+		}
+		banks[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = bank
+	}
+	return banks, nil
+}
+
+// ResolveQuestionRefs replaces the questions of an exam that reference a bank question, {"questionRef":
+// "bank:<name>#<id>"}, with a copy of that question. Other fields of the referencing question, such as its
+// "section" or "id", take precedence over those of the bank. References that cannot be resolved are left as they
+// are and reported.
+func ResolveQuestionRefs(questions any, banks Banks) (any, []string) {
+	resolved, _, problems := resolveQuestionRefs(questions, banks)
+	return resolved, problems
+}
+
+// resolveQuestionRefs resolves the question references of an exam like ResolveQuestionRefs, also returning the
+// references that were resolved
+func resolveQuestionRefs(questions any, banks Banks) (any, []questionRef, []string) {
+	items := Questions(questions)
+	if items == nil {
+		return questions, nil, nil
+	}
+	var refs []questionRef
+	var problems []string
+	resolved := make([]any, len(items))
+	for i, item := range items {
+		resolved[i] = item
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		ref, ok := q["questionRef"].(string)
+		if !ok {
+			continue
+		}
+		bank, id, err := ParseQuestionRef(ref)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("question %d: %v", i+1, err))
+			continue
+		}
+		shared, ok := banks[bank].Questions[id]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("question %d: questionRef %q not found", i+1, ref))
+			continue
+		}
+		copied := maps.Clone(shared)
+		maps.Copy(copied, q)
+		resolved[i] = copied
+		refs = append(refs, questionRef{bank, id})
+	}
+	return resolved, refs, problems
+}
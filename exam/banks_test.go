@@ -0,0 +1,133 @@
+package exam
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	bankedExam = `{"questions": [{"questionRef": "bank:shared#q1"}, {"question": "2 + 2?", "choices": ["4", "5"], "correct": 0}]}`
+	sharedBank = `{"questions": [{"id": "q1", "question": "1 + 1?", "choices": ["1", "2"], "correct": 1}]}`
+)
+
+// writeTestDir writes files, by their slash-separated path, to a new exam directory and returns it
+func writeTestDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readTestExam reads the exam directory dir and returns its only exam
+func readTestExam(t *testing.T, dir string) ExamFile {
+	t.Helper()
+	subjects, err := ReadDir(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []ExamFile
+	WalkExams(subjects, func(_ string, e ExamFile) { found = append(found, e) })
+	if len(found) != 1 {
+		t.Fatalf("read %d exams, want 1", len(found))
+	}
+	return found[0]
+}
+
+func TestExamHashCoversBankQuestions(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{"Math/algebra.json": bankedExam, BankDir + "/shared.json": sharedBank})
+	before := readTestExam(t, dir)
+	if before.SHA256 == contentHash([]byte(bankedExam)) {
+		t.Error("hash of an exam with bank questions is the hash of its file alone")
+	}
+
+	edited := `{"questions": [{"id": "q1", "question": "1 + 1?", "choices": ["2", "3"], "correct": 0}]}`
+	if err := os.WriteFile(filepath.Join(dir, BankDir, "shared.json"), []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if after := readTestExam(t, dir); after.SHA256 == before.SHA256 {
+		t.Error("editing a referenced bank question left the exam hash unchanged")
+	}
+
+	plain := readTestExam(t, writeTestDir(t, map[string]string{"Math/algebra.json": `{"questions": []}`}))
+	if plain.SHA256 != contentHash([]byte(`{"questions": []}`)) {
+		t.Error("hash of an exam without bank questions is not the hash of its file")
+	}
+}
+
+func TestExamProblemsAreReturned(t *testing.T) {
+	e := readTestExam(t, writeTestDir(t, map[string]string{"Math/algebra.json": bankedExam}))
+	if len(e.Problems) != 1 {
+		t.Errorf("problems = %q, want the unresolved reference", e.Problems)
+	}
+}
+
+// testSigner signs content in the legacy minisign format, which VerifySignature accepts
+type testSigner struct {
+	key  PublicKey
+	priv ed25519.PrivateKey
+}
+
+func newTestSigner(t *testing.T) testSigner {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{key: PublicKey{ID: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, Key: pub}, priv: priv}
+}
+
+// sign writes the signature file of the file at path
+func (s testSigner) sign(t *testing.T, path string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(append([]byte("Ed"), s.key.ID[:]...), ed25519.Sign(s.priv, content)...)
+	trusted := "test"
+	global := ed25519.Sign(s.priv, append(sig[10:], trusted...))
+	signature := "untrusted comment: test\n" + base64.StdEncoding.EncodeToString(sig) + "\ntrusted comment: " + trusted + "\n" + base64.StdEncoding.EncodeToString(global) + "\n"
+	if err := os.WriteFile(path+SignatureSuffix, []byte(signature), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSignaturesCoversBanks(t *testing.T) {
+	dir := writeTestDir(t, map[string]string{"Math/algebra.json": bankedExam, BankDir + "/shared.json": sharedBank})
+	signer := newTestSigner(t)
+	signer.sign(t, filepath.Join(dir, "Math", "algebra.json"))
+
+	state := func() string {
+		subjects, err := ReadDir(context.Background(), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		CheckSignatures(dir, subjects, []PublicKey{signer.key})
+		return subjects[0].Exams[0].Signature
+	}
+	if got := state(); got != SignatureUnsigned {
+		t.Errorf("exam referencing an unsigned bank: signature %s, want %s", got, SignatureUnsigned)
+	}
+	signer.sign(t, filepath.Join(dir, BankDir, "shared.json"))
+	if got := state(); got != SignatureVerified {
+		t.Errorf("exam and bank signed: signature %s, want %s", got, SignatureVerified)
+	}
+	if err := os.WriteFile(filepath.Join(dir, BankDir, "shared.json"), []byte(sharedBank+" "), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := state(); got != SignatureInvalid {
+		t.Errorf("bank edited after signing: signature %s, want %s", got, SignatureInvalid)
+	}
+}
@@ -0,0 +1,274 @@
+// Package exam reads, validates, imports and grades the exam files of a content directory: one folder per
+// subject, holding JSON or JSONC exam files and optional subject metadata.
+package exam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	jsonc "github.com/marcozac/go-jsonc"
+)
+
+// ExamFile represents a JSON file with its name and content
+type ExamFile struct {
+	Name             string         `json:"name"`
+	ID               string         `json:"id,omitempty"`   // stable identifier, from the file or assigned by the server
+	Slug             string         `json:"slug,omitempty"` // URL-safe name of the exam within its subject
+	Meta             *ExamMeta      `json:"meta,omitempty"`
+	QuestionCount    int            `json:"questionCount"`
+	EstimatedMinutes int            `json:"estimatedMinutes"`
+	Size             int64          `json:"size"`
+	SHA256           string         `json:"sha256"`
+	Content          any            `json:"content,omitempty"`
+	Rating           *RatingSummary `json:"rating,omitempty"`
+	Signature        string         `json:"signature,omitempty"` // signature state, when signatures are checked
+	SignedBy         string         `json:"signedBy,omitempty"`  // ID of the key of a verified signature
+
+	ModTime  time.Time `json:"-"` // when the file was last modified, which exams can be sorted by
+	Problems []string  `json:"-"` // question references that could not be resolved, left for the caller to report
+
+	fileSHA256 string            // of the raw file content, which its signature covers
+	bankFiles  map[string]string // SHA-256 of the raw content of the bank files it references, by file name
+}
+
+// Subject represents a subject with its name and associated exams; nested folders become child subjects
+type Subject struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	SubjectMeta
+	Exams    []ExamFile `json:"exams"`
+	Subjects []Subject  `json:"subjects,omitempty"`
+}
+
+// ReadDir reads all JSON files from dir organized by subjects and returns the tree of subjects with their exams.
+// It stops with the error of ctx once ctx is done, so a cancelled request does not keep walking the directory.
+func ReadDir(ctx context.Context, dir string) ([]Subject, error) {
+	subjectsMap := make(map[string][]ExamFile)
+	metaMap := make(map[string]SubjectMeta)
+	err := WalkDir(ctx, dir, func(subject Subject) error {
+		if len(subject.Exams) > 0 {
+			subjectsMap[subject.Path] = subject.Exams
+		}
+		metaMap[subject.Path] = subject.SubjectMeta
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := buildSubjectTree(filepath.Base(dir), subjectsMap, metaMap)
+
+	// Map iteration order is random, so always hand out a stable order
+	SortSubjects(subjects, "name")
+	return subjects, nil
+}
+
+// WalkDir reads the exam directory dir one folder at a time, calling fn with the subject of each folder holding
+// exams or subject metadata as soon as that folder is read, before its subfolders. The subject has no child
+// subjects; exams placed directly in dir form a subject named after dir with an empty path. Folders are visited
+// in lexical order. An error returned by fn, or the error of ctx once ctx is done, stops the walk.
+func WalkDir(ctx context.Context, dir string, fn func(Subject) error) error {
+	// Exams are resolved against the banks as they are now, so a fixed bank question is fixed in every exam
+	banks, err := ReadBanks(dir)
+	if err != nil {
+		return err
+	}
+
+	// The subject is identified by the folder path relative to the json directory
+	var walk func(folder, subjectPath string) error
+	walk = func(folder, subjectPath string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			return err
+		}
+		subject := Subject{Name: path.Base(subjectPath), Path: subjectPath, Exams: []ExamFile{}}
+		if subjectPath == "" {
+			subject.Name = filepath.Base(dir)
+		}
+		hasMeta := false
+		var subfolders []os.DirEntry
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			file := filepath.Join(folder, entry.Name())
+			if entry.IsDir() {
+				if file != filepath.Join(dir, BankDir) {
+					subfolders = append(subfolders, entry)
+				}
+				continue
+			}
+
+			// Subject metadata files describe the folder they are in
+			if isSubjectMetaFile(file) {
+				content, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read file %s: %w", file, err)
+				}
+				meta, err := parseSubjectMeta(file, content)
+				if err != nil {
+					return err
+				}
+				subject.SubjectMeta, hasMeta = meta, true
+				continue
+			}
+
+			// Check if it's a file and has a .json or .jsonc extension
+			if IsExamFile(file) {
+				examFile, ok, err := readExamFile(file, banks)
+				if err != nil {
+					return err
+				}
+				if ok {
+					subject.Exams = append(subject.Exams, examFile)
+				}
+			}
+		}
+
+		if len(subject.Exams) > 0 || hasMeta {
+			assignSlugs([]Subject{subject})
+			if err := fn(subject); err != nil {
+				return err
+			}
+		}
+		for _, sub := range subfolders {
+			if err := walk(filepath.Join(folder, sub.Name()), path.Join(subjectPath, sub.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(dir, "")
+}
+
+// readExamFile reads the exam file at path, resolving its question references against banks. Empty files, which
+// validation reports, are skipped and reported as not read.
+func readExamFile(path string, banks Banks) (ExamFile, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ExamFile{}, false, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	// Read the file content
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ExamFile{}, false, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	// Skip empty files
+	if len(content) == 0 {
+		return ExamFile{}, false, nil
+	}
+
+	parsedContent, err := ParseContent(path, content)
+	if err != nil {
+		return ExamFile{}, false, err
+	}
+
+	// Exams written as an object carry metadata next to their questions
+	meta, questions, err := splitExam(parsedContent, false)
+	if err != nil {
+		return ExamFile{}, false, fmt.Errorf("failed to load exam %s: %w", path, err)
+	}
+	questions, refs, problems := resolveQuestionRefs(questions, banks)
+
+	examFile := ExamFile{
+		Name:             info.Name(),
+		Meta:             meta,
+		QuestionCount:    len(Questions(questions)),
+		EstimatedMinutes: estimateMinutes(meta, questions),
+		Size:             int64(len(content)),
+		SHA256:           examHash(content, banks, refs),
+		Content:          questions,
+		ModTime:          info.ModTime(),
+		Problems:         problems,
+		fileSHA256:       contentHash(content),
+	}
+	for _, ref := range refs {
+		if examFile.bankFiles == nil {
+			examFile.bankFiles = map[string]string{}
+		}
+		examFile.bankFiles[banks[ref.bank].File] = banks[ref.bank].SHA256
+	}
+	if meta != nil {
+		examFile.ID = meta.ID
+	}
+	return examFile, true, nil
+}
+
+// contentHash returns the hex-encoded SHA-256 of raw exam file content, letting clients detect changed exams
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// examHash returns the hex-encoded SHA-256 of raw exam file content and the bank questions it references, so an
+// edit of either changes the hash. Exams without references hash as their content does.
+func examHash(content []byte, banks Banks, refs []questionRef) string {
+	if len(refs) == 0 {
+		return contentHash(content)
+	}
+	h := sha256.New()
+	h.Write(content)
+	for _, ref := range refs {
+		// Maps are encoded with sorted keys, so the same question always hashes the same
+		data, _ := json.Marshal(banks[ref.bank].Questions[ref.id])
+		h.Write([]byte{0})
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IsJSONFile reports whether path has a .json or .jsonc extension
+func IsJSONFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".json" || ext == ".jsonc"
+}
+
+// IsExamFile reports whether path has a .json or .jsonc extension and is not a subject metadata file
+func IsExamFile(path string) bool {
+	return IsJSONFile(path) && !isSubjectMetaFile(path)
+}
+
+// ParseContent parses the raw content of an exam file, choosing the JSON or JSONC parser from the file extension
+func ParseContent(path string, content []byte) (any, error) {
+	// Parse JSON content to interface{}
+	var parsedContent interface{}
+	if err := unmarshalFile(path, content, &parsedContent); err != nil {
+		return nil, err
+	}
+	return parsedContent, nil
+}
+
+// unmarshalFile decodes the content of a .json or .jsonc file into v
+func unmarshalFile(path string, content []byte, v any) error {
+	// Errors are reported with the line and column they were found at
+	if filepath.Ext(path) == ".jsonc" {
+		// Use jsonc package for JSONC files
+		if err := jsonc.Unmarshal(content, v); err != nil {
+			return locateParseError(path, "JSONC", content, err)
+		}
+	} else {
+		// Use standard json package for regular JSON files
+		if err := json.Unmarshal(content, v); err != nil {
+			return locateParseError(path, "JSON", content, err)
+		}
+	}
+	return nil
+}
+
+// RatingSummary aggregates the ratings of an exam for the listing
+type RatingSummary struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+	Stars   [5]int  `json:"stars"` // number of ratings with 1 to 5 stars
+}
@@ -0,0 +1,166 @@
+package exam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// examDifficulties lists the accepted values of the difficulty field
+var examDifficulties = []string{"easy", "medium", "hard"}
+
+// Visibilities of exams, which decide who they are listed and served to
+const (
+	VisibilityPublic     = "public"     // everyone, the default
+	VisibilityGroup      = "group"      // members of the groups the exam is assigned to
+	VisibilityInstructor = "instructor" // instructors and admins only
+)
+
+// examVisibilities lists the accepted values of the visibility field
+var examVisibilities = []string{VisibilityPublic, VisibilityGroup, VisibilityInstructor}
+
+// ExamMeta holds the standard top-level fields of an exam file written as an object with a "questions" list
+type ExamMeta struct {
+	ID           string   `json:"id,omitempty"` // stable identifier, kept when the file is renamed or moved
+	Title        string   `json:"title,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Instructions string   `json:"instructions,omitempty"`
+	Author       string   `json:"author,omitempty"`
+	Difficulty   string   `json:"difficulty,omitempty"`
+	Duration     int      `json:"duration,omitempty"`     // minutes
+	PassingScore *float64 `json:"passingScore,omitempty"` // percent
+	Order        *float64 `json:"order,omitempty"`
+	Visibility   string   `json:"visibility,omitempty"` // one of examVisibilities; public when empty
+
+	Scoring  *ScoringRules `json:"scoring,omitempty"`
+	Sections []Section     `json:"sections,omitempty"`
+	Scale    *ScoreScale   `json:"scale,omitempty"`
+	Report   *ReportFormat `json:"report,omitempty"`
+}
+
+// ReportFormat words the score reports of an exam after those of the real test it mocks
+type ReportFormat struct {
+	Title      string `json:"title,omitempty"`      // heading of the report; the title of the exam when empty
+	ScoreLabel string `json:"scoreLabel,omitempty"` // name of the scaled score, such as "Total Score"
+	Footer     string `json:"footer,omitempty"`     // closing note, such as how to read the scores
+}
+
+// splitExam separates parsed exam content into its metadata and question list.
+// Legacy exams are a bare array of questions and have no metadata.
+func splitExam(parsed any, strict bool) (*ExamMeta, any, error) {
+	obj, ok := parsed.(map[string]any)
+	if !ok {
+		return nil, parsed, nil
+	}
+
+	questions, ok := obj["questions"]
+	if !ok {
+		return nil, nil, errors.New(`exam object must have a "questions" list`)
+	}
+
+	fields := make(map[string]any, len(obj))
+	for k, v := range obj {
+		if k != "questions" {
+			fields[k] = v
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Strict decoding reports misspelled fields, which loading tolerates
+	var meta ExamMeta
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid exam metadata: %w", err)
+	}
+	return &meta, questions, nil
+}
+
+// secondsPerQuestion is the time assumed per question when an exam does not declare its duration
+const secondsPerQuestion = 80
+
+// estimateMinutes returns the declared duration of an exam, or an estimate from its question count
+func estimateMinutes(meta *ExamMeta, questions any) int {
+	if meta != nil && meta.Duration > 0 {
+		return meta.Duration
+	}
+	seconds := len(Questions(questions)) * secondsPerQuestion
+	return (seconds + 59) / 60
+}
+
+// Document rebuilds the file content of an exam from its metadata and questions
+func Document(meta *ExamMeta, questions any) any {
+	if meta == nil {
+		return questions
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return questions
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return questions
+	}
+	doc["questions"] = questions
+	return doc
+}
+
+// validateExamMeta checks the values of exam metadata
+func validateExamMeta(meta *ExamMeta) []string {
+	if meta == nil {
+		return nil
+	}
+
+	var problems []string
+	if meta.Duration < 0 {
+		problems = append(problems, "duration must not be negative")
+	}
+	if meta.PassingScore != nil && (*meta.PassingScore < 0 || *meta.PassingScore > 100) {
+		problems = append(problems, "passingScore must be a percentage between 0 and 100")
+	}
+	problems = append(problems, checkScoringRules(meta.Scoring)...)
+	problems = append(problems, checkScoreScale(meta.Scale)...)
+	if meta.Difficulty != "" {
+		known := false
+		for _, d := range examDifficulties {
+			known = known || d == meta.Difficulty
+		}
+		if !known {
+			problems = append(problems, fmt.Sprintf("difficulty %q must be one of %v", meta.Difficulty, examDifficulties))
+		}
+	}
+	if meta.Visibility != "" && !slices.Contains(examVisibilities, meta.Visibility) {
+		problems = append(problems, fmt.Sprintf("visibility %q must be one of %v", meta.Visibility, examVisibilities))
+	}
+	return problems
+}
+
+// Visibility returns who exam e is listed and served to, one of the Visibility constants
+func Visibility(e ExamFile) string {
+	if e.Meta == nil || e.Meta.Visibility == "" {
+		return VisibilityPublic
+	}
+	return e.Meta.Visibility
+}
+
+// ValidateDocument checks parsed exam file content, either a legacy question array or an object with metadata
+func ValidateDocument(parsed any) []string {
+	meta, questions, err := splitExam(parsed, true)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var sections []Section
+	if meta != nil {
+		sections = meta.Sections
+	}
+	problems := append(validateExamMeta(meta), validateExamContent(questions)...)
+	return append(problems, checkSections(sections, questions)...)
+}
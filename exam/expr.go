@@ -0,0 +1,248 @@
+package exam
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprFuncs are the functions available in template expressions
+var exprFuncs = map[string]func(args []float64) (float64, error){
+	"abs":   unaryFunc(math.Abs),
+	"sqrt":  unaryFunc(math.Sqrt),
+	"floor": unaryFunc(math.Floor),
+	"ceil":  unaryFunc(math.Ceil),
+	"round": func(args []float64) (float64, error) {
+		switch len(args) {
+		case 1:
+			return math.Round(args[0]), nil
+		case 2:
+			scale := math.Pow(10, args[1])
+			return math.Round(args[0]*scale) / scale, nil
+		}
+		return 0, errors.New("round takes one or two arguments")
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("min needs an argument")
+		}
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m, nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("max needs an argument")
+		}
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m, nil
+	},
+}
+
+// unaryFunc adapts a one-argument math function
+func unaryFunc(fn func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, errors.New("expected one argument")
+		}
+		return fn(args[0]), nil
+	}
+}
+
+// exprParser evaluates arithmetic expressions over named variables by recursive descent
+type exprParser struct {
+	src  string
+	pos  int
+	vars map[string]float64
+}
+
+// evalExpr evaluates an arithmetic expression with + - * / % ^, parentheses, variables and exprFuncs
+func evalExpr(src string, vars map[string]float64) (float64, error) {
+	p := &exprParser{src: src, vars: vars}
+	v, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected %q in expression %q", p.src[p.pos:], src)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("expression %q is not a finite number", src)
+	}
+	return v, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept consumes op if it is next
+func (p *exprParser) accept(op byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) sum() (float64, error) {
+	v, err := p.product()
+	for err == nil {
+		switch {
+		case p.accept('+'):
+			var r float64
+			r, err = p.product()
+			v += r
+		case p.accept('-'):
+			var r float64
+			r, err = p.product()
+			v -= r
+		default:
+			return v, nil
+		}
+	}
+	return 0, err
+}
+
+func (p *exprParser) product() (float64, error) {
+	v, err := p.unary()
+	for err == nil {
+		switch {
+		case p.accept('*'):
+			var r float64
+			r, err = p.unary()
+			v *= r
+		case p.accept('/'):
+			var r float64
+			if r, err = p.unary(); err == nil && r == 0 {
+				err = errors.New("division by zero")
+			}
+			v /= r
+		case p.accept('%'):
+			var r float64
+			if r, err = p.unary(); err == nil && r == 0 {
+				err = errors.New("division by zero")
+			}
+			v = math.Mod(v, r)
+		default:
+			return v, nil
+		}
+	}
+	return 0, err
+}
+
+func (p *exprParser) unary() (float64, error) {
+	if p.accept('-') {
+		v, err := p.unary()
+		return -v, err
+	}
+	if p.accept('+') {
+		return p.unary()
+	}
+	return p.power()
+}
+
+// power is right associative and binds tighter than unary minus on its left
+func (p *exprParser) power() (float64, error) {
+	base, err := p.atom()
+	if err != nil {
+		return 0, err
+	}
+	if p.accept('^') {
+		exp, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exp), nil
+	}
+	return base, nil
+}
+
+func (p *exprParser) atom() (float64, error) {
+	p.skipSpace()
+	if p.accept('(') {
+		v, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(')') {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		return v, nil
+	}
+
+	start := p.pos
+	if p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.src[start:p.pos], 64)
+	}
+
+	for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || (p.pos > start && isDigit(p.src[p.pos]))) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	if name == "" {
+		if p.pos >= len(p.src) {
+			return 0, errors.New("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q in expression", p.src[p.pos:])
+	}
+
+	if fn, ok := exprFuncs[name]; ok && p.accept('(') {
+		var args []float64
+		if !p.accept(')') {
+			for {
+				arg, err := p.sum()
+				if err != nil {
+					return 0, err
+				}
+				args = append(args, arg)
+				if p.accept(')') {
+					break
+				}
+				if !p.accept(',') {
+					return 0, fmt.Errorf("expected , or ) in arguments of %s", name)
+				}
+			}
+		}
+		v, err := fn(args)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		return v, nil
+	}
+
+	v, ok := p.vars[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown variable %q", name)
+	}
+	return v, nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// formatNumber prints a value without floating point noise, rounded to decimals places when decimals >= 0
+func formatNumber(v float64, decimals int) string {
+	if decimals >= 0 {
+		return strconv.FormatFloat(v, 'f', decimals, 64)
+	}
+	s := strconv.FormatFloat(math.Round(v*1e9)/1e9, 'f', -1, 64)
+	if s == "-0" {
+		return "0"
+	}
+	return strings.TrimSuffix(s, ".")
+}
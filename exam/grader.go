@@ -0,0 +1,304 @@
+package exam
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Answer is the response to one question as sent by the client: the index of the chosen choice for
+// multiple-choice questions, or any JSON value a custom question type defines. Unanswered questions are nil.
+type Answer json.RawMessage
+
+// MarshalJSON encodes the answer as given, or null when unanswered
+func (a Answer) MarshalJSON() ([]byte, error) {
+	if len(a) == 0 {
+		return []byte("null"), nil
+	}
+	return a, nil
+}
+
+// UnmarshalJSON keeps the raw answer for the grader of its question; null leaves the question unanswered
+func (a *Answer) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*a = nil
+		return nil
+	}
+	*a = append((*a)[:0], data...)
+	return nil
+}
+
+// ChoiceAnswer returns the answer choosing choice i
+func ChoiceAnswer(i int) Answer {
+	return Answer(strconv.Itoa(i))
+}
+
+// Choice returns the choice index of the answer, if it is one
+func (a Answer) Choice() (int, bool) {
+	var i int
+	if len(a) == 0 || json.Unmarshal(a, &i) != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// String returns the answer as shown in exports: text answers unquoted, anything else as JSON
+func (a Answer) String() string {
+	var s string
+	if json.Unmarshal(a, &s) == nil {
+		return s
+	}
+	return string(a)
+}
+
+// Grader grades the answers to one type of question
+type Grader interface {
+	// Grade reports whether answer is a correct response to q, which includes the answer key
+	Grade(q map[string]any, answer Answer) (bool, error)
+}
+
+// questionChecker is implemented by graders that check the questions of their type when exams are validated
+type questionChecker interface {
+	CheckQuestion(q map[string]any) []string
+}
+
+// DefaultQuestionType is the type of questions without a "type" field
+const DefaultQuestionType = "choice"
+
+var (
+	gradersMu sync.RWMutex
+	graders   = map[string]Grader{
+		DefaultQuestionType: choiceGrader{},
+		"text":              textGrader{},
+	}
+)
+
+// registerGrader makes g grade the questions whose "type" is questionType. Graders compiled into the server
+// register from an init function; registering a type twice is a programming error and panics.
+func registerGrader(questionType string, g Grader) {
+	gradersMu.Lock()
+	defer gradersMu.Unlock()
+	if _, ok := graders[questionType]; ok {
+		panic("grader already registered for question type " + questionType)
+	}
+	graders[questionType] = g
+}
+
+// QuestionType returns the type of a question, which selects its grader
+func QuestionType(q map[string]any) string {
+	t, _ := q["type"].(string)
+	return cmp.Or(t, DefaultQuestionType)
+}
+
+// graderFor returns the grader of a question's type
+func graderFor(q map[string]any) (Grader, error) {
+	gradersMu.RLock()
+	defer gradersMu.RUnlock()
+	g, ok := graders[QuestionType(q)]
+	if !ok {
+		return nil, fmt.Errorf("no grader for question type %q", QuestionType(q))
+	}
+	return g, nil
+}
+
+// GradeQuestion grades one answer with the grader of its question, opening a sealed answer key first;
+// unanswered questions are wrong
+func GradeQuestion(q map[string]any, answer Answer) (bool, error) {
+	q, err := OpenAnswerKey(q)
+	if err != nil {
+		return false, err
+	}
+	g, err := graderFor(q)
+	if err != nil {
+		return false, err
+	}
+	if answer == nil {
+		return false, nil
+	}
+	return g.Grade(q, answer)
+}
+
+// choiceGrader grades multiple-choice questions against the index in "correct"
+type choiceGrader struct{}
+
+func (choiceGrader) Grade(q map[string]any, answer Answer) (bool, error) {
+	key, ok := q["correct"].(float64)
+	choice, chosen := answer.Choice()
+	return ok && chosen && int(key) == choice, nil
+}
+
+// textGrader grades short text answers against "answer", a string or a list of accepted strings.
+// Case and surrounding or repeated whitespace are ignored.
+type textGrader struct{}
+
+func (textGrader) Grade(q map[string]any, answer Answer) (bool, error) {
+	var text string
+	if err := json.Unmarshal(answer, &text); err != nil {
+		return false, nil
+	}
+	for _, accepted := range textAnswers(q) {
+		if normalizeText(accepted) == normalizeText(text) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (textGrader) CheckQuestion(q map[string]any) []string {
+	if len(textAnswers(q)) == 0 {
+		return []string{"\"answer\" must be a non-empty string or a list of them"}
+	}
+	return nil
+}
+
+// textAnswers returns the accepted answers of a text question
+func textAnswers(q map[string]any) []string {
+	switch key := q["answer"].(type) {
+	case string:
+		if key != "" {
+			return []string{key}
+		}
+	case []any:
+		var out []string
+		for _, item := range key {
+			s, ok := item.(string)
+			if !ok || s == "" {
+				return nil
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+	return nil
+}
+
+// normalizeText folds case and whitespace so equivalent text answers compare equal
+func normalizeText(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// commandGraderTimeout is how long a grader process may take to answer before it is restarted
+const commandGraderTimeout = 10 * time.Second
+
+// commandGrader grades questions in a separate process, so graders can be written in any language.
+// The process reads one JSON object per line on stdin, {"type", "question", "answer"}, and answers each
+// with one line on stdout, {"correct": bool} or {"error": "..."}. It is started on first use and
+// restarted if it exits or stops answering.
+type commandGrader struct {
+	questionType string
+	command      []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// ParseGraderFlag registers the command grader of a -grader flag of the form type=command args...
+func ParseGraderFlag(value string) error {
+	questionType, command, ok := strings.Cut(value, "=")
+	args := strings.Fields(command)
+	if !ok || questionType == "" || len(args) == 0 {
+		return errors.New("expected type=command")
+	}
+	gradersMu.RLock()
+	_, taken := graders[questionType]
+	gradersMu.RUnlock()
+	if taken {
+		return fmt.Errorf("question type %q already has a grader", questionType)
+	}
+	registerGrader(questionType, &commandGrader{questionType: questionType, command: args})
+	return nil
+}
+
+// start launches the grader process; the caller holds the lock
+func (g *commandGrader) start() error {
+	cmd := exec.Command(g.command[0], g.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start grader for %s questions: %w", g.questionType, err)
+	}
+	g.cmd, g.stdin, g.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the grader process so the next answer starts a new one; the caller holds the lock
+func (g *commandGrader) stop() {
+	if g.cmd == nil {
+		return
+	}
+	g.stdin.Close()
+	g.cmd.Process.Kill()
+	g.cmd.Wait()
+	g.cmd = nil
+}
+
+func (g *commandGrader) Grade(q map[string]any, answer Answer) (bool, error) {
+	req, err := json.Marshal(map[string]any{"type": g.questionType, "question": q, "answer": answer})
+	if err != nil {
+		return false, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cmd == nil {
+		if err := g.start(); err != nil {
+			return false, err
+		}
+	}
+	if _, err := g.stdin.Write(append(req, '\n')); err != nil {
+		g.stop()
+		return false, fmt.Errorf("grader for %s questions: %w", g.questionType, err)
+	}
+
+	type reply struct {
+		line []byte
+		err  error
+	}
+	replies := make(chan reply, 1)
+	stdout := g.stdout
+	go func() {
+		line, err := stdout.ReadBytes('\n')
+		replies <- reply{line, err}
+	}()
+	var got reply
+	select {
+	case got = <-replies:
+	case <-time.After(commandGraderTimeout):
+		g.stop()
+		return false, fmt.Errorf("grader for %s questions did not answer within %s", g.questionType, commandGraderTimeout)
+	}
+	if got.err != nil {
+		g.stop()
+		return false, fmt.Errorf("grader for %s questions: %w", g.questionType, got.err)
+	}
+
+	var resp struct {
+		Correct bool   `json:"correct"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(got.line, &resp); err != nil {
+		return false, fmt.Errorf("grader for %s questions sent an invalid reply: %w", g.questionType, err)
+	}
+	if resp.Error != "" {
+		return false, fmt.Errorf("grader for %s questions: %s", g.questionType, resp.Error)
+	}
+	return resp.Correct, nil
+}
@@ -0,0 +1,53 @@
+package exam
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Import validates a bundled exam and writes it as indented JSON into the subject folder, returning the path
+// of the file written
+func Import(dir, subject string, exam ExamFile, force bool) (string, error) {
+	doc := Document(exam.Meta, exam.Content)
+	if problems := ValidateDocument(doc); len(problems) > 0 {
+		return "", fmt.Errorf("import: %s/%s is not a valid exam: %s", subject, exam.Name, strings.Join(problems, "; "))
+	}
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode exam %s/%s: %w", subject, exam.Name, err)
+	}
+	return WriteFile(dir, subject, exam.Name, append(data, '\n'), force)
+}
+
+// WriteFile writes raw exam content to dir/subject/name, refusing to overwrite unless force is set, and returns
+// the path of the file written
+func WriteFile(dir, subject, name string, data []byte, force bool) (string, error) {
+	if !ValidSubjectPath(subject) || !ValidName(name) {
+		return "", fmt.Errorf("import: invalid subject or exam name %q/%q", subject, name)
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(subject), name)
+	if _, err := os.Stat(target); err == nil && !force {
+		return "", fmt.Errorf("import: %s already exists (use -force to overwrite)", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+	if err := os.WriteFile(target, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write file %s: %w", target, err)
+	}
+	return target, nil
+}
+
+// SheetName derives an exam file name from a spreadsheet path or URL
+func SheetName(src string) string {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return "sheet_" + time.Now().Format("20060102_150405") + ".json"
+	}
+	base := filepath.Base(src)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".json"
+}
@@ -0,0 +1,82 @@
+package exam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MediaTypes maps the file extensions allowed for question media to their MIME types
+var MediaTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".svg":  "image/svg+xml",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".m4a":  "audio/mp4",
+}
+
+// mediaFields lists the question fields that may reference a media file
+var mediaFields = []string{"image", "audio"}
+
+// MediaURLPrefix is the URL path under which media files are served
+const MediaURLPrefix = "/api/media/"
+
+// resolveMediaRef maps a media reference from an exam in subject to the path it points to inside the media directory;
+// relative references are resolved against the subject's media folder
+func resolveMediaRef(subject, ref string) (string, bool) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "data:") {
+		return "", false
+	}
+	if rest, ok := strings.CutPrefix(ref, MediaURLPrefix); ok {
+		return rest, true
+	}
+	return path.Join(subject, ref), true
+}
+
+// checkMediaRefs verifies that every media reference in the questions of an exam resolves to a file in mediaDir
+func checkMediaRefs(content any, subject, mediaDir string) []string {
+	var problems []string
+	for i, item := range Questions(content) {
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range mediaFields {
+			ref, ok := q[field].(string)
+			if !ok || ref == "" {
+				continue
+			}
+			name, local := resolveMediaRef(subject, ref)
+			if !local {
+				continue
+			}
+			if err := checkMediaFile(mediaDir, name); err != nil {
+				problems = append(problems, fmt.Sprintf("question %d: %s %q %v", i+1, field, ref, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkMediaFile reports why a media file cannot be served, or nil if it can
+func checkMediaFile(mediaDir, name string) error {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return errors.New("is not a valid media path")
+	}
+	if _, ok := MediaTypes[strings.ToLower(path.Ext(name))]; !ok {
+		return errors.New("has an unsupported media type")
+	}
+	info, err := os.Stat(filepath.Join(mediaDir, filepath.FromSlash(name)))
+	if err != nil || info.IsDir() {
+		return errors.New("does not exist")
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package exam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fieldAlias is a field name used by older exam files, with the name of the current field it migrates to
+type fieldAlias struct {
+	old, current string
+}
+
+// examFieldAliases are the top-level fields of older exam files, in the order they are tried
+var examFieldAliases = []fieldAlias{
+	{"items", "questions"},
+	{"name", "title"},
+	{"instruction", "instructions"},
+	{"timeLimit", "duration"},
+	{"time_limit", "duration"},
+	{"minutes", "duration"},
+	{"passing_score", "passingScore"},
+	{"passMark", "passingScore"},
+}
+
+// questionFieldAliases are the question fields of older exam files, in the order they are tried
+var questionFieldAliases = []fieldAlias{
+	{"q", "question"},
+	{"text", "question"},
+	{"prompt", "question"},
+	{"options", "choices"},
+	{"rationale", "explanation"},
+	{"explain", "explanation"},
+}
+
+// answerFields are the fields older multiple-choice questions gave their answer in, in the order they are tried
+var answerFields = []string{"answer", "answerIndex", "correctIndex", "correct_answer"}
+
+// MigrateDocument rewrites parsed exam content written in an older layout into the current schema: an object
+// with the exam metadata and a "questions" list of questions using the standard field names. It returns the
+// migrated content with a description of every change made, which is empty if the content was up to date.
+func MigrateDocument(parsed any) (any, []string) {
+	var changes []string
+	obj, ok := parsed.(map[string]any)
+	if !ok {
+		// Legacy exams are a bare array of questions
+		if _, ok := parsed.([]any); !ok {
+			return parsed, nil
+		}
+		obj = map[string]any{"questions": parsed}
+		changes = append(changes, "wrapped the question list in an exam object")
+	} else {
+		obj = maps.Clone(obj)
+		changes = append(changes, renameFields(obj, examFieldAliases, "")...)
+	}
+
+	items, ok := obj["questions"].([]any)
+	if !ok {
+		return obj, changes
+	}
+	questions := make([]any, len(items))
+	for i, item := range items {
+		q, ok := item.(map[string]any)
+		if !ok {
+			questions[i] = item
+			continue
+		}
+		var qchanges []string
+		questions[i], qchanges = migrateQuestion(q, fmt.Sprintf("question %d: ", i+1))
+		changes = append(changes, qchanges...)
+	}
+	obj["questions"] = questions
+	return obj, changes
+}
+
+// migrateQuestion rewrites one question into the current schema; changes are described with prefix
+func migrateQuestion(q map[string]any, prefix string) (map[string]any, []string) {
+	q = maps.Clone(q)
+	var changes []string
+
+	// Tags used to be a comma-separated string
+	if s, ok := q["tags"].(string); ok {
+		var tags []any
+		for _, tag := range strings.Split(s, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		q["tags"] = tags
+		changes = append(changes, prefix+`split "tags" into a list`)
+	}
+
+	// Only untyped questions predate question types, so the fields of the others are left as they are
+	if _, typed := q["type"]; typed || isTemplateQuestion(q) {
+		return q, changes
+	}
+	changes = append(changes, renameFields(q, questionFieldAliases, prefix)...)
+
+	// Choices keyed by a label, such as {"A": "...", "B": "..."}, become a list in label order
+	var labels []string
+	if m, ok := q["choices"].(map[string]any); ok {
+		labels = sortedLabels(m)
+		choices := make([]any, len(labels))
+		for i, label := range labels {
+			choices[i] = m[label]
+		}
+		q["choices"] = choices
+		changes = append(changes, prefix+`turned the labelled "choices" into a list`)
+	}
+	choices, hasChoices := q["choices"].([]any)
+
+	// A question with an answer but no choices is a short text question
+	if !hasChoices {
+		if _, ok := q["answer"]; ok && len(textAnswers(q)) > 0 {
+			q["type"] = "text"
+			changes = append(changes, prefix+`set "type" to "text" for the "answer" without choices`)
+		}
+		return q, changes
+	}
+
+	// The answer used to be given by another field, as a label, the text of a choice or a number in a string
+	field := "correct"
+	if _, ok := q["correct"]; !ok {
+		for _, name := range answerFields {
+			if _, ok := q[name]; ok {
+				field = name
+				break
+			}
+		}
+	}
+	value, ok := q[field]
+	if !ok {
+		return q, changes
+	}
+	index, converted := answerIndex(value, choices, labels)
+	if !converted {
+		if field != "correct" {
+			q["correct"] = value
+			delete(q, field)
+			changes = append(changes, fmt.Sprintf("%srenamed %q to \"correct\"", prefix, field))
+		}
+		return q, changes
+	}
+	q["correct"] = float64(index)
+	if field != "correct" {
+		delete(q, field)
+	}
+	changes = append(changes, fmt.Sprintf("%sconverted %q %s to the choice index %d in \"correct\"", prefix, field, formatValue(value), index))
+	return q, changes
+}
+
+// answerIndex resolves the answer of an older multiple-choice question to the index of its choice. It reports
+// false if value is already an index, or does not name a choice.
+func answerIndex(value any, choices []any, labels []string) (int, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+	s = strings.TrimSpace(s)
+	for i, label := range labels {
+		if strings.EqualFold(label, s) {
+			return i, true
+		}
+	}
+	for i, c := range choices {
+		if text, ok := c.(string); ok && text == s {
+			return i, true
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(choices) {
+		return n, true
+	}
+	return 0, false
+}
+
+// renameFields moves the fields of obj named by an alias to their current name, unless that is already set
+func renameFields(obj map[string]any, aliases []fieldAlias, prefix string) []string {
+	var changes []string
+	for _, a := range aliases {
+		v, ok := obj[a.old]
+		if !ok {
+			continue
+		}
+		if _, taken := obj[a.current]; taken {
+			continue
+		}
+		obj[a.current] = v
+		delete(obj, a.old)
+		changes = append(changes, fmt.Sprintf("%srenamed %q to %q", prefix, a.old, a.current))
+	}
+	return changes
+}
+
+// sortedLabels returns the keys of labelled choices in order, numerically if they are all numbers
+func sortedLabels(m map[string]any) []string {
+	labels := make([]string, 0, len(m))
+	numeric := true
+	for label := range m {
+		labels = append(labels, label)
+		_, err := strconv.Atoi(label)
+		numeric = numeric && err == nil
+	}
+	slices.SortFunc(labels, func(a, b string) int {
+		if numeric {
+			x, _ := strconv.Atoi(a)
+			y, _ := strconv.Atoi(b)
+			return x - y
+		}
+		return strings.Compare(a, b)
+	})
+	return labels
+}
+
+// formatValue formats a JSON value for a change description
+func formatValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// AssignIDs gives migrated exam content, and each of its questions, a new random "id" where it has none, so
+// they keep their identity when the file is renamed or its questions are reordered
+func AssignIDs(doc any) (any, []string) {
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return doc, nil
+	}
+	obj = maps.Clone(obj)
+	var changes []string
+	if id, _ := obj["id"].(string); id == "" {
+		obj["id"] = NewID()
+		changes = append(changes, "assigned an \"id\" to the exam")
+	}
+	items, ok := obj["questions"].([]any)
+	if !ok {
+		return obj, changes
+	}
+	questions := make([]any, len(items))
+	for i, item := range items {
+		questions[i] = item
+		if q, ok := item.(map[string]any); ok {
+			if id, _ := q["id"].(string); id == "" {
+				q = maps.Clone(q)
+				q["id"] = NewID()
+				questions[i] = q
+				changes = append(changes, fmt.Sprintf("question %d: assigned an \"id\"", i+1))
+			}
+		}
+	}
+	obj["questions"] = questions
+	return obj, changes
+}
+
+// MigrateOptions selects the changes MigrateFile makes besides moving the content to the current schema
+type MigrateOptions struct {
+	AssignIDs bool // give the exam and its questions identifiers
+	SealKeys  bool // seal the answer keys with the answer key secret
+}
+
+// MigrateFile migrates the raw content of the exam file at path, returning the new content and the changes
+// made. JSONC files keep the comments above their content; comments inside it are lost.
+func MigrateFile(path string, content []byte, opts MigrateOptions) ([]byte, []string, error) {
+	parsed, err := ParseContent(path, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrated, changes := MigrateDocument(parsed)
+	if opts.AssignIDs {
+		var idChanges []string
+		migrated, idChanges = AssignIDs(migrated)
+		changes = append(changes, idChanges...)
+	}
+	if opts.SealKeys {
+		var sealChanges []string
+		if migrated, sealChanges, err = SealAnswerKeys(migrated); err != nil {
+			return nil, nil, err
+		}
+		changes = append(changes, sealChanges...)
+	}
+	if len(changes) == 0 {
+		return content, nil, nil
+	}
+
+	data, err := json.MarshalIndent(migrated, "", "    ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode exam %s: %w", path, err)
+	}
+	var out bytes.Buffer
+	if filepath.Ext(path) == ".jsonc" {
+		if header := leadingComments(content); len(header) > 0 {
+			out.Write(header)
+			out.WriteByte('\n')
+		}
+	}
+	out.Write(data)
+	out.WriteByte('\n')
+	return out.Bytes(), changes, nil
+}
+
+// leadingComments returns the comments at the start of JSONC content, before its first value
+func leadingComments(content []byte) []byte {
+	blanked := blankComments(content)
+	end := 0
+	for i, c := range blanked {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			break
+		}
+		if content[i] != c {
+			end = i + 1
+		}
+	}
+	return bytes.TrimSpace(content[:end])
+}
@@ -0,0 +1,102 @@
+package exam
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// questionID returns the identifier of a question: its own "id" field if it has one, otherwise a hash of
+// where it sits so feedback about it can be traced back to the exam
+func questionID(q map[string]any, subject, exam string, index int) string {
+	if id, ok := q["id"].(string); ok && id != "" {
+		return id
+	}
+	return PositionalQuestionID(subject, exam, index)
+}
+
+// PositionalQuestionID returns the identifier derived from the position of a question without its own "id",
+// which changes when the question or its exam file moves
+func PositionalQuestionID(subject, exam string, index int) string {
+	sum := sha256.Sum256([]byte(subject + "\x00" + exam + "\x00" + strconv.Itoa(index)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewID returns a random version 4 UUID, the form of the identifiers assigned to exams and questions
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// WithQuestionIDs returns a copy of a question list with the identifier of every question filled in
+func WithQuestionIDs(questions []any, subject, exam string) []any {
+	out := make([]any, len(questions))
+	for i, item := range questions {
+		q, ok := item.(map[string]any)
+		if !ok {
+			out[i] = item
+			continue
+		}
+		copied := maps.Clone(q)
+		copied["id"] = questionID(q, subject, exam, i)
+		out[i] = copied
+	}
+	return out
+}
+
+// FindQuestion looks up a question by its identifier across every exam
+func FindQuestion(subjects []Subject, id string) (PoolQuestion, bool) {
+	for _, p := range QuestionPool(subjects, nil) {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return PoolQuestion{}, false
+}
+
+// PoolQuestion is a question drawn from an exam, with the exam it came from
+type PoolQuestion struct {
+	ID       string `json:"id"`
+	Subject  string `json:"subject"`
+	Exam     string `json:"exam"`
+	ExamID   string `json:"examId,omitempty"`
+	Index    int    `json:"index"`
+	Question any    `json:"question"`
+}
+
+// QuestionPool collects the questions of every exam for which keep returns true, with templates filled in
+// with the shared paper of their exam so they match the exam as normally served
+func QuestionPool(subjects []Subject, keep func(subject string, e ExamFile) bool) []PoolQuestion {
+	var pool []PoolQuestion
+	WalkExams(subjects, func(subject string, e ExamFile) {
+		if keep != nil && !keep(subject, e) {
+			return
+		}
+		questions := WithQuestionIDs(InstantiateQuestions(Questions(e.Content), VariantSeed("", subject, e.Name)), subject, e.Name)
+		for i, item := range questions {
+			if q, ok := item.(map[string]any); ok {
+				pool = append(pool, PoolQuestion{ID: q["id"].(string), Subject: subject, Exam: e.Name, ExamID: e.ID, Index: i, Question: q})
+			}
+		}
+	})
+	return pool
+}
+
+// QuestionTags returns the lower-cased tags of a question
+func QuestionTags(q map[string]any) []string {
+	items, _ := q["tags"].([]any)
+	var tags []string
+	for _, item := range items {
+		if tag, ok := item.(string); ok {
+			tags = append(tags, strings.ToLower(tag))
+		}
+	}
+	return tags
+}
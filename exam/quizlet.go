@@ -0,0 +1,119 @@
+package exam
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// quizletCard is one term/definition pair of a Quizlet set
+type quizletCard struct {
+	term, definition string
+}
+
+// QuizletOptions controls how a Quizlet export is parsed and turned into multiple-choice questions
+type QuizletOptions struct {
+	TermSeparator string // between term and definition, tab by default
+	CardSeparator string // between cards, newline by default
+	Choices       int    // number of choices per question, including the correct one
+	Reverse       bool   // ask for the term given its definition instead
+}
+
+// ParseQuizlet splits the text of a Quizlet export into cards
+func ParseQuizlet(text string, opts QuizletOptions) ([]quizletCard, error) {
+	// Separators typed on the command line arrive as literal \t and \n
+	unescape := strings.NewReplacer(`\t`, "\t", `\n`, "\n")
+	termSep, cardSep := unescape.Replace(opts.TermSeparator), unescape.Replace(opts.CardSeparator)
+	if termSep == "" {
+		termSep = "\t"
+	}
+	if cardSep == "" {
+		cardSep = "\n"
+	}
+
+	text = strings.ReplaceAll(strings.TrimPrefix(text, "\ufeff"), "\r\n", "\n")
+	var cards []quizletCard
+	for i, raw := range strings.Split(text, cardSep) {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		term, definition, ok := strings.Cut(raw, termSep)
+		term, definition = strings.TrimSpace(term), strings.TrimSpace(definition)
+		if !ok || term == "" || definition == "" {
+			return nil, fmt.Errorf("card %d: expected a term and a definition separated by %q", i+1, termSep)
+		}
+		cards = append(cards, quizletCard{term: term, definition: definition})
+	}
+	return cards, nil
+}
+
+// QuizletToQuestions turns flashcards into multiple-choice questions, using the answers of other cards as distractors.
+// Choices are picked with a seed derived from each card so importing the same set twice gives the same exam.
+func QuizletToQuestions(cards []quizletCard, opts QuizletOptions) ([]any, error) {
+	if opts.Choices == 0 {
+		opts.Choices = 4
+	}
+	if opts.Choices < 2 {
+		return nil, errors.New("at least two choices per question are needed")
+	}
+
+	// Answers are de-duplicated so a distractor never repeats the correct answer
+	var answers []string
+	seen := make(map[string]bool)
+	for _, c := range cards {
+		a := c.definition
+		if opts.Reverse {
+			a = c.term
+		}
+		if !seen[strings.ToLower(a)] {
+			seen[strings.ToLower(a)] = true
+			answers = append(answers, a)
+		}
+	}
+	if len(answers) < 2 {
+		return nil, errors.New("a Quizlet set needs at least two different answers to build choices")
+	}
+
+	questions := make([]any, 0, len(cards))
+	for _, c := range cards {
+		prompt, answer := c.term, c.definition
+		if opts.Reverse {
+			prompt, answer = c.definition, c.term
+		}
+
+		h := fnv.New64a()
+		h.Write([]byte(prompt + "\x00" + answer))
+		rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+		var distractors []string
+		for _, i := range rng.Perm(len(answers)) {
+			if len(distractors) == opts.Choices-1 {
+				break
+			}
+			if !strings.EqualFold(answers[i], answer) {
+				distractors = append(distractors, answers[i])
+			}
+		}
+
+		correct := rng.Intn(len(distractors) + 1)
+		choices := make([]any, 0, len(distractors)+1)
+		for i, d := range distractors {
+			if i == correct {
+				choices = append(choices, answer)
+			}
+			choices = append(choices, d)
+		}
+		if correct == len(distractors) {
+			choices = append(choices, answer)
+		}
+
+		questions = append(questions, map[string]any{
+			"question": prompt,
+			"choices":  choices,
+			"correct":  float64(correct),
+		})
+	}
+	return questions, nil
+}
@@ -0,0 +1,116 @@
+package exam
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Raw results a score scale can be looked up with
+const (
+	ScaleBasisScore   = "score"   // number of right answers, the default
+	ScaleBasisPoints  = "points"  // points under the scoring rules of the exam
+	ScaleBasisPercent = "percent" // final percentage
+)
+
+// scaleBases lists the accepted values of the basis of a score scale
+var scaleBases = []string{ScaleBasisScore, ScaleBasisPoints, ScaleBasisPercent}
+
+// ScoreScale transforms the raw result of a graded attempt into the scores of a standardized test report: a
+// scaled score looked up in a conversion table and the percentile rank among earlier attempts at the exam
+type ScoreScale struct {
+	Basis       string     `json:"basis,omitempty"` // one of scaleBases; score when empty
+	Table       []ScaleRow `json:"table,omitempty"` // ordered by raw result
+	Interpolate bool       `json:"interpolate,omitempty"`
+	Percentile  bool       `json:"percentile,omitempty"`
+}
+
+// ScaleRow is a row of a conversion table: the scaled score a raw result converts to
+type ScaleRow struct {
+	Raw    float64 `json:"raw"`
+	Scaled float64 `json:"scaled"`
+}
+
+// checkScoreScale checks a score scale of exam metadata
+func checkScoreScale(scale *ScoreScale) []string {
+	if scale == nil {
+		return nil
+	}
+	var problems []string
+	if scale.Basis != "" && !slices.Contains(scaleBases, scale.Basis) {
+		problems = append(problems, fmt.Sprintf("scale: basis %q must be one of %v", scale.Basis, scaleBases))
+	}
+	for i := 1; i < len(scale.Table); i++ {
+		if scale.Table[i].Raw <= scale.Table[i-1].Raw {
+			problems = append(problems, fmt.Sprintf("scale: table[%d]: raw results must increase from row to row", i))
+		}
+	}
+	if len(scale.Table) == 0 && !scale.Percentile {
+		problems = append(problems, "scale: needs a table or percentile")
+	}
+	return problems
+}
+
+// RawResult returns the result of an attempt the scale is looked up with, given its number of right answers,
+// points (nil without scoring rules) and percentage
+func (s ScoreScale) RawResult(score int, points *float64, percent float64) float64 {
+	switch s.Basis {
+	case ScaleBasisPoints:
+		if points != nil {
+			return *points
+		}
+		return float64(score)
+	case ScaleBasisPercent:
+		return percent
+	default:
+		return float64(score)
+	}
+}
+
+// Scaled converts a raw result with the table of the scale. Results between two rows take the scaled score of
+// the row below, or one on the line between both rows when the scale interpolates; results beyond the table take
+// the score of its nearest end. It returns false if the scale has no table.
+func (s ScoreScale) Scaled(raw float64) (float64, bool) {
+	if len(s.Table) == 0 {
+		return 0, false
+	}
+	i, exact := slices.BinarySearchFunc(s.Table, raw, func(row ScaleRow, raw float64) int {
+		switch {
+		case row.Raw < raw:
+			return -1
+		case row.Raw > raw:
+			return 1
+		}
+		return 0
+	})
+	switch {
+	case exact:
+		return s.Table[i].Scaled, true
+	case i == 0:
+		return s.Table[0].Scaled, true
+	case i == len(s.Table):
+		return s.Table[i-1].Scaled, true
+	}
+	below, above := s.Table[i-1], s.Table[i]
+	if !s.Interpolate {
+		return below.Scaled, true
+	}
+	return below.Scaled + (above.Scaled-below.Scaled)*(raw-below.Raw)/(above.Raw-below.Raw), true
+}
+
+// PercentileRank returns the percentage of earlier results below raw, counting ties as half below, or false if
+// there are no earlier results
+func PercentileRank(raw float64, earlier []float64) (float64, bool) {
+	if len(earlier) == 0 {
+		return 0, false
+	}
+	var below float64
+	for _, r := range earlier {
+		switch {
+		case r < raw:
+			below++
+		case r == raw:
+			below += 0.5
+		}
+	}
+	return below * 100 / float64(len(earlier)), true
+}
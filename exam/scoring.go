@@ -0,0 +1,224 @@
+package exam
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+)
+
+// scoringCostLimit bounds the work a scoring expression may do, so a careless rule cannot stall grading
+const scoringCostLimit = 100000
+
+// ScoringRules are the CEL expressions an exam declares to score attempts beyond one point per right answer.
+//
+// points is evaluated for every question with question (the question object), index, answered and correct,
+// and returns the points earned; it defaults to correct ? 1.0 : 0.0. Evaluating it as if every answer were
+// right gives the points available. score is evaluated once with points, maxPoints, percent, correct (number
+// of right answers), total (number of questions), tags and sections (points, maxPoints and percent per tag and per
+// section), and returns the final percentage; it defaults to percent, which is weighted by section if the exam
+// has sections.
+type ScoringRules struct {
+	Points string `json:"points,omitempty"`
+	Score  string `json:"score,omitempty"`
+}
+
+var (
+	pointsEnv = sync.OnceValues(func() (*cel.Env, error) {
+		return cel.NewEnv(ext.Math(), ext.Strings(),
+			cel.Variable("question", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("index", cel.IntType),
+			cel.Variable("answered", cel.BoolType),
+			cel.Variable("correct", cel.BoolType),
+		)
+	})
+	scoreEnv = sync.OnceValues(func() (*cel.Env, error) {
+		return cel.NewEnv(ext.Math(), ext.Strings(),
+			cel.Variable("points", cel.DoubleType),
+			cel.Variable("maxPoints", cel.DoubleType),
+			cel.Variable("percent", cel.DoubleType),
+			cel.Variable("correct", cel.IntType),
+			cel.Variable("total", cel.IntType),
+			cel.Variable("tags", cel.MapType(cel.StringType, cel.MapType(cel.StringType, cel.DoubleType))),
+			cel.Variable("sections", cel.MapType(cel.StringType, cel.MapType(cel.StringType, cel.DoubleType))),
+		)
+	})
+
+	// Compiled programs are shared by every attempt at exams with the same expression
+	programsMu sync.Mutex
+	programs   = map[string]cel.Program{}
+)
+
+// compileScoring compiles a scoring expression in env, checking that it returns a number
+func compileScoring(env func() (*cel.Env, error), expr string) (cel.Program, error) {
+	e, err := env()
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%p\x00%s", e, expr)
+	programsMu.Lock()
+	defer programsMu.Unlock()
+	if prg, ok := programs[key]; ok {
+		return prg, nil
+	}
+
+	ast, iss := e.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	switch ast.OutputType() {
+	case cel.DoubleType, cel.IntType, cel.DynType:
+	default:
+		return nil, fmt.Errorf("expression must return a number, not %s", ast.OutputType())
+	}
+	prg, err := e.Program(ast, cel.CostLimit(scoringCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	programs[key] = prg
+	return prg, nil
+}
+
+// evalNumber evaluates a compiled scoring expression and converts its result to a float
+func evalNumber(prg cel.Program, vars map[string]any) (float64, error) {
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	var f float64
+	switch v := out.Value().(type) {
+	case float64:
+		f = v
+	case int64:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	default:
+		return 0, fmt.Errorf("expression returned %s, not a number", out.Type())
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("expression returned a non-finite number")
+	}
+	return f, nil
+}
+
+// checkScoringRules compiles the expressions of an exam's scoring rules and reports the ones that are invalid
+func checkScoringRules(rules *ScoringRules) []string {
+	if rules == nil {
+		return nil
+	}
+	var problems []string
+	if rules.Points != "" {
+		if _, err := compileScoring(pointsEnv, rules.Points); err != nil {
+			problems = append(problems, fmt.Sprintf("scoring.points: %v", err))
+		}
+	}
+	if rules.Score != "" {
+		if _, err := compileScoring(scoreEnv, rules.Score); err != nil {
+			problems = append(problems, fmt.Sprintf("scoring.score: %v", err))
+		}
+	}
+	return problems
+}
+
+// scoreResult is the outcome of applying an exam's scoring rules to a graded attempt
+type scoreResult struct {
+	Points    float64
+	MaxPoints float64
+	Percent   float64
+	Sections  []SectionResult // one per section of the exam
+}
+
+// ApplyScoringRules scores graded questions with the rules and sections of their exam; items that are not
+// question objects, such as questions left off a paper, are skipped. The percentage is kept between 0 and 100.
+func ApplyScoringRules(rules ScoringRules, sections []Section, questions []any, answers []Answer, correct []bool) (scoreResult, error) {
+	var points cel.Program
+	if rules.Points != "" {
+		var err error
+		if points, err = compileScoring(pointsEnv, rules.Points); err != nil {
+			return scoreResult{}, fmt.Errorf("scoring.points: %w", err)
+		}
+	}
+
+	type tally struct{ points, max float64 }
+	var res scoreResult
+	tags := map[string]*tally{}
+	right, total := 0, 0
+	earnedBy, availableBy := make([]float64, len(questions)), make([]float64, len(questions))
+	for i, item := range questions {
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		total++
+		answered := i < len(answers) && answers[i] != nil
+		earned, available := 0.0, 1.0
+		if correct[i] {
+			earned = 1
+			right++
+		}
+		if points != nil {
+			vars := map[string]any{"question": q, "index": i, "answered": answered, "correct": correct[i]}
+			var err error
+			if earned, err = evalNumber(points, vars); err != nil {
+				return scoreResult{}, fmt.Errorf("scoring.points of question %d: %w", i+1, err)
+			}
+			vars["answered"], vars["correct"] = true, true
+			if available, err = evalNumber(points, vars); err != nil {
+				return scoreResult{}, fmt.Errorf("scoring.points of question %d: %w", i+1, err)
+			}
+		}
+		earnedBy[i], availableBy[i] = earned, available
+		res.Points += earned
+		res.MaxPoints += available
+		for _, t := range QuestionTags(q) {
+			if tags[t] == nil {
+				tags[t] = &tally{}
+			}
+			tags[t].points += earned
+			tags[t].max += available
+		}
+	}
+	if res.MaxPoints > 0 {
+		res.Percent = res.Points * 100 / res.MaxPoints
+	}
+	if len(sections) > 0 {
+		res.Sections, res.Percent = scoreSections(sections, questions, correct, earnedBy, availableBy)
+	}
+
+	if rules.Score != "" {
+		score, err := compileScoring(scoreEnv, rules.Score)
+		if err != nil {
+			return scoreResult{}, fmt.Errorf("scoring.score: %w", err)
+		}
+		byTag := make(map[string]map[string]float64, len(tags))
+		for t, s := range tags {
+			percent := 0.0
+			if s.max > 0 {
+				percent = s.points * 100 / s.max
+			}
+			byTag[t] = map[string]float64{"points": s.points, "maxPoints": s.max, "percent": percent}
+		}
+		bySection := make(map[string]map[string]float64, len(res.Sections))
+		for _, s := range res.Sections {
+			bySection[s.Name] = map[string]float64{"points": s.Points, "maxPoints": s.MaxPoints, "percent": s.Percent}
+		}
+		res.Percent, err = evalNumber(score, map[string]any{
+			"points":    res.Points,
+			"maxPoints": res.MaxPoints,
+			"percent":   res.Percent,
+			"correct":   right,
+			"total":     total,
+			"tags":      byTag,
+			"sections":  bySection,
+		})
+		if err != nil {
+			return scoreResult{}, fmt.Errorf("scoring.score: %w", err)
+		}
+	}
+	res.Percent = min(100, max(0, res.Percent))
+	return res, nil
+}
@@ -0,0 +1,180 @@
+package exam
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+)
+
+// SealedKeyField is the question field holding its answer key encrypted with the answer key secret, in place
+// of the plain fields of sealedKeyFields
+const SealedKeyField = "sealedKey"
+
+// sealedKeyFields are the fields of a question that are encrypted when its answer key is sealed
+var sealedKeyFields = []string{"correct", "answer"}
+
+// sealedKeyPrefix marks the format of sealed keys: AES-256-GCM, base64 of the nonce followed by the ciphertext
+const sealedKeyPrefix = "v1:"
+
+// errNoAnswerKeySecret is returned for sealed answer keys when no secret is configured to open them
+var errNoAnswerKeySecret = errors.New("no answer key secret is configured")
+
+var (
+	answerKeyMu   sync.RWMutex
+	answerKeyAEAD cipher.AEAD
+)
+
+// SetAnswerKeySecret sets the base64-encoded 32-byte AES key that answer keys are sealed with, for opening them
+// when answers are graded and for sealing them in exam files. An empty secret leaves sealed keys closed.
+func SetAnswerKeySecret(secret string) error {
+	secret = strings.TrimSpace(secret)
+	var aead cipher.AEAD
+	if secret != "" {
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil || len(key) != 32 {
+			return errors.New("answer key secret must be 32 bytes encoded as base64")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		if aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	answerKeyMu.Lock()
+	defer answerKeyMu.Unlock()
+	answerKeyAEAD = aead
+	return nil
+}
+
+// currentAnswerKeyAEAD returns the cipher of the answer key secret, or an error if none is configured
+func currentAnswerKeyAEAD() (cipher.AEAD, error) {
+	answerKeyMu.RLock()
+	defer answerKeyMu.RUnlock()
+	if answerKeyAEAD == nil {
+		return nil, errNoAnswerKeySecret
+	}
+	return answerKeyAEAD, nil
+}
+
+// isSealed reports whether a question carries a sealed answer key
+func isSealed(q map[string]any) bool {
+	_, ok := q[SealedKeyField]
+	return ok
+}
+
+// SealAnswerKey returns a copy of a question with its answer key fields encrypted into SealedKeyField. Questions
+// without an answer key, already sealed ones and templates, whose answers are worked out from their text, are
+// returned as they are.
+func SealAnswerKey(q map[string]any) (map[string]any, error) {
+	if isSealed(q) || isTemplateQuestion(q) {
+		return q, nil
+	}
+	key := map[string]any{}
+	for _, field := range sealedKeyFields {
+		if v, ok := q[field]; ok {
+			key[field] = v
+		}
+	}
+	if len(key) == 0 {
+		return q, nil
+	}
+	aead, err := currentAnswerKeyAEAD()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := maps.Clone(q)
+	for field := range key {
+		delete(sealed, field)
+	}
+	sealed[SealedKeyField] = sealedKeyPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil))
+	return sealed, nil
+}
+
+// OpenAnswerKey returns a copy of a question with its sealed answer key decrypted back into the plain fields.
+// Questions without a sealed key are returned as they are.
+func OpenAnswerKey(q map[string]any) (map[string]any, error) {
+	if !isSealed(q) {
+		return q, nil
+	}
+	aead, err := currentAnswerKeyAEAD()
+	if err != nil {
+		return nil, err
+	}
+	s, _ := q[SealedKeyField].(string)
+	encoded, ok := strings.CutPrefix(s, sealedKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a sealed answer key", SealedKeyField)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%q is not a sealed answer key", SealedKeyField)
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("sealed answer key does not open with the configured answer key secret")
+	}
+	var key map[string]any
+	if err := json.Unmarshal(plain, &key); err != nil {
+		return nil, fmt.Errorf("failed to parse sealed answer key: %w", err)
+	}
+	open := maps.Clone(q)
+	delete(open, SealedKeyField)
+	for _, field := range sealedKeyFields {
+		if v, ok := key[field]; ok {
+			open[field] = v
+		}
+	}
+	return open, nil
+}
+
+// SealAnswerKeys seals the answer key of every question of migrated exam content, as SealAnswerKey does
+func SealAnswerKeys(doc any) (any, []string, error) {
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return doc, nil, nil
+	}
+	items, ok := obj["questions"].([]any)
+	if !ok {
+		return obj, nil, nil
+	}
+	var changes []string
+	questions := make([]any, len(items))
+	for i, item := range items {
+		questions[i] = item
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		sealed, err := SealAnswerKey(q)
+		if err != nil {
+			return nil, nil, fmt.Errorf("question %d: %w", i+1, err)
+		}
+		if isSealed(sealed) && !isSealed(q) {
+			questions[i] = sealed
+			changes = append(changes, fmt.Sprintf("question %d: sealed the answer key", i+1))
+		}
+	}
+	if len(changes) == 0 {
+		return obj, nil, nil
+	}
+	obj = maps.Clone(obj)
+	obj["questions"] = questions
+	return obj, changes, nil
+}
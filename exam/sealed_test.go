@@ -0,0 +1,139 @@
+package exam
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// setTestSecret sets an answer key secret made of b repeated, clearing it when the test ends
+func setTestSecret(t *testing.T, b byte) {
+	t.Helper()
+	if err := SetAnswerKeySecret(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetAnswerKeySecret("") })
+}
+
+// parseQuestion decodes a question the way exam files are read, numbers as float64
+func parseQuestion(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var q map[string]any
+	if err := json.Unmarshal([]byte(s), &q); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestSealAnswerKeyHidesKeyAndOpensBack(t *testing.T) {
+	setTestSecret(t, 1)
+	q := parseQuestion(t, `{"question": "2 + 2?", "choices": ["3", "4"], "correct": 1, "explanation": "Add them"}`)
+
+	sealed, err := SealAnswerKey(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sealed["correct"]; ok {
+		t.Fatalf("sealed question still has its plain key: %v", sealed)
+	}
+	if _, ok := q["correct"]; !ok {
+		t.Fatal("sealing changed the question it was given")
+	}
+	key, _ := sealed[SealedKeyField].(string)
+	if !strings.HasPrefix(key, sealedKeyPrefix) {
+		t.Fatalf("sealed key %q lacks the %s prefix", key, sealedKeyPrefix)
+	}
+	if sealed["question"] != q["question"] || sealed["explanation"] != q["explanation"] {
+		t.Fatalf("sealing changed other fields: %v", sealed)
+	}
+
+	again, err := SealAnswerKey(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[SealedKeyField] != key {
+		t.Fatal("sealing a sealed question sealed it again")
+	}
+
+	opened, err := OpenAnswerKey(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened["correct"] != float64(1) {
+		t.Fatalf("opened key = %v, want 1", opened["correct"])
+	}
+	if _, ok := opened[SealedKeyField]; ok {
+		t.Fatal("opened question still has its sealed key")
+	}
+}
+
+func TestSealAnswerKeyLeavesQuestionsWithoutKey(t *testing.T) {
+	setTestSecret(t, 1)
+	q := parseQuestion(t, `{"question": "Describe it", "type": "essay"}`)
+	sealed, err := SealAnswerKey(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isSealed(sealed) {
+		t.Fatalf("question without a key was sealed: %v", sealed)
+	}
+}
+
+func TestOpenAnswerKeyNeedsTheSecret(t *testing.T) {
+	setTestSecret(t, 1)
+	sealed, err := SealAnswerKey(parseQuestion(t, `{"question": "Capital of France?", "type": "text", "answer": "Paris"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestSecret(t, 2)
+	if _, err := OpenAnswerKey(sealed); err == nil {
+		t.Error("sealed key opened with another secret")
+	}
+
+	SetAnswerKeySecret("")
+	if _, err := OpenAnswerKey(sealed); err == nil {
+		t.Error("sealed key opened without a secret")
+	}
+	if _, err := SealAnswerKey(parseQuestion(t, `{"question": "q", "correct": 0}`)); err == nil {
+		t.Error("answer key sealed without a secret")
+	}
+}
+
+func TestOpenAnswerKeyRejectsTamperedKey(t *testing.T) {
+	setTestSecret(t, 1)
+	sealed, err := SealAnswerKey(parseQuestion(t, `{"question": "q", "choices": ["a", "b"], "correct": 0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed[SealedKeyField].(string), sealedKeyPrefix))
+	data[len(data)-1] ^= 1
+	sealed[SealedKeyField] = sealedKeyPrefix + base64.StdEncoding.EncodeToString(data)
+	if _, err := OpenAnswerKey(sealed); err == nil {
+		t.Error("tampered sealed key opened")
+	}
+
+	sealed[SealedKeyField] = "not a key"
+	if _, err := OpenAnswerKey(sealed); err == nil {
+		t.Error("malformed sealed key opened")
+	}
+}
+
+func TestGradeQuestionOpensSealedKey(t *testing.T) {
+	setTestSecret(t, 1)
+	sealed, err := SealAnswerKey(parseQuestion(t, `{"question": "2 + 2?", "choices": ["3", "4"], "correct": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for choice, want := range []bool{false, true} {
+		got, err := GradeQuestion(sealed, ChoiceAnswer(choice))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("choice %d graded %v, want %v", choice, got, want)
+		}
+	}
+}
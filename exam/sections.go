@@ -0,0 +1,149 @@
+package exam
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Section is a part of an exam declared in its metadata. Questions belong to the section named by their
+// "section" field. When an exam has sections, its percentage is the mean of the section percentages weighted by
+// Weight, and an attempt only passes if it reaches the PassingScore of every section that has one as well as that
+// of the exam.
+type Section struct {
+	Name         string   `json:"name"`
+	Weight       *float64 `json:"weight,omitempty"`       // defaults to 1
+	PassingScore *float64 `json:"passingScore,omitempty"` // percent
+}
+
+// weight returns the weight of the section
+func (s Section) weight() float64 {
+	if s.Weight == nil {
+		return 1
+	}
+	return *s.Weight
+}
+
+// SectionResult is the score of an attempt in one section of the exam
+type SectionResult struct {
+	Name      string  `json:"name"`
+	Weight    float64 `json:"weight"`
+	Correct   int     `json:"correct"`
+	Total     int     `json:"total"`
+	Points    float64 `json:"points"`
+	MaxPoints float64 `json:"maxPoints"`
+	Percent   float64 `json:"percent"`
+	Passed    *bool   `json:"passed,omitempty"` // set when the section has a passing score
+}
+
+// QuestionSection returns the name of the section a question belongs to, or "" if it names none
+func QuestionSection(q map[string]any) string {
+	s, _ := q["section"].(string)
+	return s
+}
+
+// checkSections checks the sections of exam metadata against the questions of the exam
+func checkSections(sections []Section, content any) []string {
+	var problems []string
+	names := map[string]bool{}
+	total := 0.0
+	for i, s := range sections {
+		switch {
+		case s.Name == "":
+			problems = append(problems, fmt.Sprintf("sections[%d]: missing name", i))
+		case names[s.Name]:
+			problems = append(problems, fmt.Sprintf("sections[%d]: name %q is already used", i, s.Name))
+		}
+		names[s.Name] = true
+		if s.weight() < 0 {
+			problems = append(problems, fmt.Sprintf("sections[%d]: weight must not be negative", i))
+		}
+		total += max(0, s.weight())
+		if s.PassingScore != nil && (*s.PassingScore < 0 || *s.PassingScore > 100) {
+			problems = append(problems, fmt.Sprintf("sections[%d]: passingScore must be a percentage between 0 and 100", i))
+		}
+	}
+	if len(sections) > 0 && total == 0 {
+		problems = append(problems, "sections must not all have a weight of 0")
+	}
+
+	items, _ := content.([]any)
+	used := map[string]bool{}
+	for i, item := range items {
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if v, ok := q["section"]; ok {
+			if s, ok := v.(string); !ok || s == "" {
+				problems = append(problems, fmt.Sprintf("question %d: \"section\" must be a non-empty string", i+1))
+				continue
+			}
+		}
+		switch name := QuestionSection(q); {
+		case len(sections) == 0:
+		case name == "":
+			problems = append(problems, fmt.Sprintf("question %d: missing \"section\" (the exam has sections)", i+1))
+		case !names[name]:
+			problems = append(problems, fmt.Sprintf("question %d: section %q is not one of the sections of the exam", i+1, name))
+		default:
+			used[name] = true
+		}
+	}
+	for _, s := range sections {
+		if s.Name != "" && len(items) > 0 && !used[s.Name] {
+			problems = append(problems, fmt.Sprintf("section %q has no questions", s.Name))
+		}
+	}
+	return problems
+}
+
+// scoreSections totals the points earned and available per question into the sections of an exam and returns
+// their results with the weighted percentage of the exam. Questions outside the sections do not count.
+func scoreSections(sections []Section, questions []any, correct []bool, earned, available []float64) ([]SectionResult, float64) {
+	results := make([]SectionResult, len(sections))
+	for i, s := range sections {
+		results[i] = SectionResult{Name: s.Name, Weight: s.weight()}
+	}
+	for i, item := range questions {
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		j := slices.IndexFunc(sections, func(s Section) bool { return s.Name == QuestionSection(q) })
+		if j < 0 {
+			continue
+		}
+		results[j].Total++
+		if correct[i] {
+			results[j].Correct++
+		}
+		results[j].Points += earned[i]
+		results[j].MaxPoints += available[i]
+	}
+
+	var weighted, weights float64
+	for i := range results {
+		r := &results[i]
+		if r.MaxPoints > 0 {
+			r.Percent = min(100, max(0, r.Points*100/r.MaxPoints))
+		}
+		if sections[i].PassingScore != nil {
+			passed := r.Percent >= *sections[i].PassingScore
+			r.Passed = &passed
+		}
+		// Sections with nothing to score, such as ones left off a paper, do not weigh on the exam
+		if r.MaxPoints > 0 {
+			weighted += r.Percent * r.Weight
+			weights += r.Weight
+		}
+	}
+	if weights == 0 {
+		return results, 0
+	}
+	return results, weighted / weights
+}
+
+// SectionsPassed reports whether an attempt reached the passing score of every section that has one
+func SectionsPassed(results []SectionResult) bool {
+	return !slices.ContainsFunc(results, func(r SectionResult) bool { return r.Passed != nil && !*r.Passed })
+}
@@ -0,0 +1,263 @@
+package exam
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// SeedOptions sizes a generated exam tree
+type SeedOptions struct {
+	Subjects  int    // number of subjects
+	Exams     int    // exams per subject
+	Questions int    // questions per exam
+	Seed      uint64 // the same seed generates the same tree
+}
+
+// seedFact is a term of a subject with its definition, which generated questions ask about
+type seedFact struct {
+	topic, term, definition string
+}
+
+// seedArea is a subject of the built-in bank that generated exams draw their questions from
+type seedArea struct {
+	name, description, icon string
+	facts                   []seedFact
+}
+
+// seedAreas is the bank of subjects generated trees are made of; further subjects repeat them with a number
+var seedAreas = []seedArea{
+	{"Biology", "Cells, genetics and the living world", "🧬", []seedFact{
+		{"cells", "mitochondrion", "the organelle that produces most of the cell's ATP"},
+		{"cells", "ribosome", "the structure that synthesizes proteins from messenger RNA"},
+		{"cells", "cell membrane", "the lipid bilayer that controls what enters and leaves the cell"},
+		{"genetics", "allele", "one of the alternative forms of a gene"},
+		{"genetics", "genotype", "the genetic makeup of an organism"},
+		{"genetics", "phenotype", "the observable traits of an organism"},
+		{"genetics", "meiosis", "the cell division that produces four haploid gametes"},
+		{"ecology", "producer", "an organism that makes its own food, usually by photosynthesis"},
+		{"ecology", "niche", "the role and position a species has in its environment"},
+		{"ecology", "biome", "a large community of plants and animals occupying a major habitat"},
+		{"physiology", "homeostasis", "the maintenance of a stable internal environment"},
+		{"physiology", "enzyme", "a protein that speeds up a chemical reaction without being consumed"},
+	}},
+	{"Chemistry", "Matter, reactions and the periodic table", "⚗️", []seedFact{
+		{"atoms", "isotope", "atoms of the same element with different numbers of neutrons"},
+		{"atoms", "electron shell", "an energy level that electrons occupy around a nucleus"},
+		{"atoms", "atomic number", "the number of protons in the nucleus of an atom"},
+		{"bonding", "covalent bond", "a bond formed by two atoms sharing a pair of electrons"},
+		{"bonding", "ionic bond", "the attraction between oppositely charged ions"},
+		{"bonding", "electronegativity", "the tendency of an atom to attract shared electrons"},
+		{"reactions", "catalyst", "a substance that lowers the activation energy of a reaction"},
+		{"reactions", "oxidation", "the loss of electrons by a substance"},
+		{"reactions", "exothermic reaction", "a reaction that releases energy to its surroundings"},
+		{"solutions", "solvent", "the substance in which a solute dissolves"},
+		{"solutions", "molarity", "the number of moles of solute per liter of solution"},
+		{"solutions", "pH", "a measure of the hydrogen ion concentration of a solution"},
+	}},
+	{"History", "Events and ideas that shaped the modern world", "🏛️", []seedFact{
+		{"ancient", "Code of Hammurabi", "a Babylonian law code carved on a stone stele"},
+		{"ancient", "Pax Romana", "two centuries of relative peace across the Roman Empire"},
+		{"ancient", "Silk Road", "the network of trade routes linking China and the Mediterranean"},
+		{"medieval", "Magna Carta", "the 1215 charter limiting the power of the English king"},
+		{"medieval", "feudalism", "a system exchanging land for military service and loyalty"},
+		{"medieval", "Black Death", "the plague pandemic that swept Europe in the 14th century"},
+		{"modern", "Industrial Revolution", "the shift from hand production to machines and factories"},
+		{"modern", "Enlightenment", "the 18th-century movement championing reason and individual rights"},
+		{"modern", "Congress of Vienna", "the 1815 conference that redrew Europe after Napoleon"},
+		{"twentieth century", "Marshall Plan", "the American program to rebuild Western Europe after 1945"},
+		{"twentieth century", "Cold War", "the geopolitical rivalry between the United States and the Soviet Union"},
+		{"twentieth century", "decolonization", "the process by which colonies gained independence"},
+	}},
+	{"Computer Science", "Algorithms, data structures and systems", "💻", []seedFact{
+		{"data structures", "hash table", "a structure mapping keys to values through a hash function"},
+		{"data structures", "linked list", "a sequence of nodes each pointing to the next"},
+		{"data structures", "heap", "a tree where every parent is ordered relative to its children"},
+		{"algorithms", "binary search", "finding an item in a sorted list by halving the search range"},
+		{"algorithms", "memoization", "caching the results of function calls to avoid recomputing them"},
+		{"algorithms", "Big O notation", "a description of how running time grows with input size"},
+		{"systems", "deadlock", "a state where processes wait forever on each other's resources"},
+		{"systems", "virtual memory", "an abstraction giving each process its own address space"},
+		{"systems", "cache", "a small fast store of recently used data"},
+		{"networks", "TCP", "a protocol providing reliable, ordered delivery of a byte stream"},
+		{"networks", "DNS", "the system translating domain names into IP addresses"},
+		{"networks", "latency", "the time a message takes to travel from sender to receiver"},
+	}},
+	{"Economics", "Markets, incentives and policy", "📈", []seedFact{
+		{"microeconomics", "opportunity cost", "the value of the next best alternative given up"},
+		{"microeconomics", "elasticity", "how much demand or supply responds to a change in price"},
+		{"microeconomics", "marginal utility", "the extra satisfaction from consuming one more unit"},
+		{"markets", "monopoly", "a market with a single seller and no close substitutes"},
+		{"markets", "equilibrium price", "the price at which quantity supplied equals quantity demanded"},
+		{"markets", "externality", "a cost or benefit affecting parties outside a transaction"},
+		{"macroeconomics", "inflation", "a general rise in the price level over time"},
+		{"macroeconomics", "GDP", "the market value of all final goods and services produced in a country"},
+		{"macroeconomics", "recession", "a significant decline in economic activity lasting months"},
+		{"policy", "fiscal policy", "government use of spending and taxation to influence the economy"},
+		{"policy", "monetary policy", "central bank management of interest rates and money supply"},
+		{"policy", "tariff", "a tax imposed on imported goods"},
+	}},
+	{"Geography", "Places, landforms and climate", "🌍", []seedFact{
+		{"landforms", "delta", "a landform built from sediment where a river meets the sea"},
+		{"landforms", "plateau", "an area of high, relatively flat land"},
+		{"landforms", "fjord", "a long, narrow sea inlet between steep cliffs carved by glaciers"},
+		{"climate", "monsoon", "a seasonal reversal of wind bringing heavy rain"},
+		{"climate", "rain shadow", "a dry area on the leeward side of a mountain range"},
+		{"climate", "permafrost", "ground that stays frozen for at least two years"},
+		{"population", "urbanization", "the growth in the share of people living in cities"},
+		{"population", "migration", "the movement of people from one place to another"},
+		{"population", "population density", "the number of people per unit of area"},
+		{"maps", "latitude", "the angular distance north or south of the equator"},
+		{"maps", "longitude", "the angular distance east or west of the prime meridian"},
+		{"maps", "scale", "the ratio between distances on a map and on the ground"},
+	}},
+	{"Physics", "Motion, energy and waves", "🔭", []seedFact{
+		{"mechanics", "inertia", "the tendency of an object to resist changes in its motion"},
+		{"mechanics", "momentum", "the product of an object's mass and velocity"},
+		{"mechanics", "friction", "the force resisting relative motion of surfaces in contact"},
+		{"energy", "kinetic energy", "the energy an object has because of its motion"},
+		{"energy", "potential energy", "the energy stored by an object's position or state"},
+		{"energy", "power", "the rate at which work is done"},
+		{"waves", "frequency", "the number of wave cycles passing a point per second"},
+		{"waves", "refraction", "the bending of a wave as it passes between media"},
+		{"waves", "amplitude", "the maximum displacement of a wave from its rest position"},
+		{"electricity", "resistance", "the opposition of a material to the flow of electric current"},
+		{"electricity", "voltage", "the electric potential difference between two points"},
+		{"electricity", "current", "the rate of flow of electric charge"},
+	}},
+	{"Mathematics", "Arithmetic, algebra and geometry", "📐", []seedFact{
+		{"algebra", "coefficient", "the number multiplying a variable in a term"},
+		{"algebra", "polynomial", "an expression of variables and coefficients using only addition and multiplication"},
+		{"algebra", "function", "a relation assigning exactly one output to each input"},
+		{"geometry", "hypotenuse", "the longest side of a right triangle"},
+		{"geometry", "congruent", "having the same shape and size"},
+		{"geometry", "circumference", "the distance around a circle"},
+		{"statistics", "median", "the middle value of an ordered data set"},
+		{"statistics", "standard deviation", "a measure of how spread out values are around the mean"},
+		{"statistics", "outlier", "a value far from the others in a data set"},
+		{"number theory", "prime number", "a number greater than 1 with no divisors other than 1 and itself"},
+		{"number theory", "greatest common divisor", "the largest number dividing two integers exactly"},
+		{"number theory", "factorial", "the product of all positive integers up to a given number"},
+	}},
+}
+
+// seedAuthors are the authors generated exams are credited to
+var seedAuthors = []string{"A. Rivera", "J. Chen", "M. Okafor", "S. Lindqvist", "P. Sharma", "L. Moreau", "K. Tanaka", "D. Novak"}
+
+// Seed generates a tree of fake but plausible exams, for load tests and frontend development without real
+// content. Questions are multiple choice, true or false and short text answers about a built-in bank of terms.
+func Seed(opts SeedOptions) []Subject {
+	rng := rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x5eed))
+	subjects := make([]Subject, opts.Subjects)
+	for i := range subjects {
+		area := seedAreas[i%len(seedAreas)]
+		name := area.name
+		if round := i / len(seedAreas); round > 0 {
+			name = fmt.Sprintf("%s %d", area.name, round+1)
+		}
+		path := strings.ReplaceAll(strings.ToLower(name), " ", "-")
+		subjects[i] = Subject{
+			Name:        path,
+			Path:        path,
+			SubjectMeta: SubjectMeta{DisplayName: name, Description: area.description, Icon: area.icon},
+			Exams:       make([]ExamFile, opts.Exams),
+		}
+		for j := range subjects[i].Exams {
+			subjects[i].Exams[j] = seedExam(rng, area, name, path, j+1, opts.Questions)
+		}
+	}
+	return subjects
+}
+
+// seedExam generates exam number n of a subject
+func seedExam(rng *rand.Rand, area seedArea, name, path string, n, count int) ExamFile {
+	difficulty := examDifficulties[rng.IntN(len(examDifficulties))]
+	passing := float64(50 + 5*rng.IntN(6))
+	meta := &ExamMeta{
+		ID:           seedID(rng),
+		Title:        fmt.Sprintf("%s Practice Exam %d", name, n),
+		Description:  fmt.Sprintf("%d questions on %s.", count, strings.ToLower(area.description)),
+		Author:       seedAuthors[rng.IntN(len(seedAuthors))],
+		Difficulty:   difficulty,
+		Duration:     max(5, (count*3+1)/2),
+		PassingScore: &passing,
+	}
+	questions := make([]any, count)
+	for i := range questions {
+		questions[i] = seedQuestion(rng, area)
+	}
+	return ExamFile{Name: fmt.Sprintf("%s-practice-%02d.json", path, n), Meta: meta, Content: questions}
+}
+
+// seedQuestion generates one question about a random fact of area
+func seedQuestion(rng *rand.Rand, area seedArea) map[string]any {
+	fact := area.facts[rng.IntN(len(area.facts))]
+	q := map[string]any{
+		"id":          seedID(rng),
+		"tags":        []any{fact.topic},
+		"explanation": fmt.Sprintf("%s: %s.", fact.term, fact.definition),
+	}
+	others := make([]seedFact, 0, len(area.facts)-1)
+	for _, f := range area.facts {
+		if f != fact {
+			others = append(others, f)
+		}
+	}
+	rng.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+
+	switch kind := rng.IntN(10); {
+	case kind < 4:
+		q["question"] = fmt.Sprintf("Which term describes %s?", fact.definition)
+		q["choices"], q["correct"] = seedChoices(rng, fact.term, others, func(f seedFact) string { return f.term })
+	case kind < 7:
+		q["question"] = fmt.Sprintf("What is meant by **%s**?", fact.term)
+		q["choices"], q["correct"] = seedChoices(rng, upperFirst(fact.definition), others, func(f seedFact) string { return upperFirst(f.definition) })
+	case kind < 9:
+		definition, truth := fact.definition, true
+		if rng.IntN(2) == 0 {
+			definition, truth = others[0].definition, false
+		}
+		q["question"] = fmt.Sprintf("True or false: %s is %s.", fact.term, definition)
+		q["choices"] = []any{"True", "False"}
+		q["correct"] = map[bool]float64{true: 0, false: 1}[truth]
+	default:
+		q["type"] = "text"
+		q["question"] = fmt.Sprintf("Name the term: %s.", upperFirst(fact.definition))
+		q["answer"] = fact.term
+	}
+	return q
+}
+
+// seedChoices returns four shuffled choices, the right one and three drawn from others, with the index of the
+// right one
+func seedChoices(rng *rand.Rand, right string, others []seedFact, label func(seedFact) string) ([]any, float64) {
+	choices := []any{right}
+	for _, f := range others[:min(3, len(others))] {
+		choices = append(choices, label(f))
+	}
+	rng.Shuffle(len(choices), func(i, j int) { choices[i], choices[j] = choices[j], choices[i] })
+	for i, c := range choices {
+		if c == right {
+			return choices, float64(i)
+		}
+	}
+	return choices, 0
+}
+
+// seedID returns a random identifier drawn from rng, so the same seed gives the same IDs
+func seedID(rng *rand.Rand) string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+	return hex.EncodeToString(b)
+}
+
+// upperFirst returns s with its first letter in upper case
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
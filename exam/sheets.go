@@ -0,0 +1,429 @@
+package exam
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxSheetSize limits the size of a downloaded or uploaded spreadsheet
+const MaxSheetSize = 16 << 20
+
+// SheetTemplate maps spreadsheet columns to question fields. Columns are named by their header text
+// (matched case-insensitively) or, when no header matches, by their letter such as "B".
+type SheetTemplate struct {
+	HeaderRows  int      `json:"headerRows"`
+	Question    string   `json:"question"`
+	Choices     []string `json:"choices,omitempty"`
+	Correct     string   `json:"correct"`
+	Explanation string   `json:"explanation,omitempty"`
+	Image       string   `json:"image,omitempty"`
+
+	// CorrectFormat is how the correct answer is written: "letter" (A, B, ...), "number" (1-based),
+	// "index" (0-based), "text" (the choice itself), or "auto" to guess per row
+	CorrectFormat string `json:"correctFormat,omitempty"`
+}
+
+// DefaultSheetTemplate expects a header row with Question, Choice/Option columns, Answer, and an optional Explanation
+var DefaultSheetTemplate = SheetTemplate{
+	HeaderRows:    1,
+	Question:      "Question",
+	Correct:       "Answer",
+	Explanation:   "Explanation",
+	Image:         "Image",
+	CorrectFormat: "auto",
+}
+
+// columnLetters matches a spreadsheet column reference such as "C" or "AB"
+var columnLetters = regexp.MustCompile(`^[A-Za-z]{1,3}$`)
+
+// resolveColumn finds the index of a column by header text or letter, or -1
+func resolveColumn(header []string, name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return i
+		}
+	}
+	if columnLetters.MatchString(name) {
+		return columnIndex(strings.ToUpper(name))
+	}
+	return -1
+}
+
+// columnIndex converts a column letter reference to its 0-based index
+func columnIndex(letters string) int {
+	n := 0
+	for _, c := range letters {
+		n = n*26 + int(c-'A'+1)
+	}
+	return n - 1
+}
+
+// SheetToQuestions converts spreadsheet rows to a question list using tmpl, returning per-row problems
+func SheetToQuestions(rows [][]string, tmpl SheetTemplate) ([]any, []string) {
+	if len(rows) <= tmpl.HeaderRows {
+		return nil, []string{"spreadsheet has no question rows"}
+	}
+	var header []string
+	if tmpl.HeaderRows > 0 {
+		header = rows[tmpl.HeaderRows-1]
+	}
+
+	questionCol := resolveColumn(header, tmpl.Question)
+	correctCol := resolveColumn(header, tmpl.Correct)
+	explanationCol := resolveColumn(header, tmpl.Explanation)
+	imageCol := resolveColumn(header, tmpl.Image)
+	var choiceCols []int
+	if len(tmpl.Choices) > 0 {
+		for _, c := range tmpl.Choices {
+			choiceCols = append(choiceCols, resolveColumn(header, c))
+		}
+	} else {
+		// Without explicit choice columns, every Choice/Option header is a choice, in order
+		for i, h := range header {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if strings.HasPrefix(h, "choice") || strings.HasPrefix(h, "option") {
+				choiceCols = append(choiceCols, i)
+			}
+		}
+	}
+
+	if questionCol < 0 || correctCol < 0 || len(choiceCols) < 2 {
+		return nil, []string{fmt.Sprintf("template columns not found: question=%q correct=%q choices=%v", tmpl.Question, tmpl.Correct, tmpl.Choices)}
+	}
+
+	var questions []any
+	var problems []string
+	for r, row := range rows[tmpl.HeaderRows:] {
+		rowNum := r + tmpl.HeaderRows + 1
+		text := cell(row, questionCol)
+		if text == "" {
+			continue // blank rows are common at the end of sheets
+		}
+
+		var choices []any
+		var choiceText []string
+		for _, col := range choiceCols {
+			if c := cell(row, col); c != "" {
+				choices = append(choices, c)
+				choiceText = append(choiceText, c)
+			}
+		}
+
+		correct, err := parseCorrectAnswer(cell(row, correctCol), choiceText, tmpl.CorrectFormat)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("row %d: %v", rowNum, err))
+			continue
+		}
+
+		q := map[string]any{
+			"question": text,
+			"choices":  choices,
+			"correct":  float64(correct),
+		}
+		if e := cell(row, explanationCol); e != "" {
+			q["explanation"] = e
+		}
+		if img := cell(row, imageCol); img != "" {
+			q["image"] = img
+		}
+		questions = append(questions, q)
+	}
+	return questions, problems
+}
+
+// cell returns the trimmed value of a row's column, or "" if the row is too short
+func cell(row []string, col int) string {
+	if col < 0 || col >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[col])
+}
+
+// parseCorrectAnswer converts the answer cell of a row to a 0-based choice index
+func parseCorrectAnswer(value string, choices []string, format string) (int, error) {
+	if value == "" {
+		return 0, errors.New("missing correct answer")
+	}
+
+	index := -1
+	switch format {
+	case "letter":
+		if len(value) == 1 {
+			index = int(strings.ToUpper(value)[0] - 'A')
+		}
+	case "number", "index":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("correct answer %q is not a number", value)
+		}
+		index = n
+		if format == "number" {
+			index = n - 1
+		}
+	case "text":
+		index = choiceIndex(choices, value)
+	case "auto", "":
+		if i := choiceIndex(choices, value); i >= 0 {
+			index = i
+		} else if n, err := strconv.Atoi(value); err == nil {
+			index = n - 1
+		} else if len(value) == 1 {
+			index = int(strings.ToUpper(value)[0] - 'A')
+		}
+	default:
+		return 0, fmt.Errorf("unknown correct answer format %q", format)
+	}
+
+	if index < 0 || index >= len(choices) {
+		return 0, fmt.Errorf("correct answer %q does not match any of the %d choices", value, len(choices))
+	}
+	return index, nil
+}
+
+// choiceIndex returns the index of the choice equal to value, ignoring case, or -1
+func choiceIndex(choices []string, value string) int {
+	for i, c := range choices {
+		if strings.EqualFold(c, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// googleSheetID matches the document ID and optional sheet gid of a Google Sheets URL
+var googleSheetID = regexp.MustCompile(`^/spreadsheets/d/([A-Za-z0-9_-]+)`)
+
+// sheetExportURL rewrites a Google Sheets edit or share link to its CSV export URL; other URLs are returned unchanged
+func sheetExportURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "docs.google.com" {
+		return raw, nil
+	}
+	// Links from "Publish to the web" already point at an export
+	if strings.Contains(u.Path, "/pub") || strings.HasSuffix(u.Path, "/export") {
+		return raw, nil
+	}
+	m := googleSheetID.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", fmt.Errorf("not a Google Sheets link: %s", raw)
+	}
+
+	export := url.Values{"format": {"csv"}}
+	gid := u.Query().Get("gid")
+	if gid == "" {
+		gid = strings.TrimPrefix(u.Fragment, "gid=")
+	}
+	if gid != "" {
+		export.Set("gid", gid)
+	}
+	return "https://docs.google.com/spreadsheets/d/" + m[1] + "/export?" + export.Encode(), nil
+}
+
+// IsSheetSource reports whether src names a spreadsheet to import rather than an exam file
+func IsSheetSource(src string) bool {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return true
+	}
+	switch strings.ToLower(path.Ext(src)) {
+	case ".xlsx", ".csv", ".tsv":
+		return true
+	}
+	return false
+}
+
+// FetchSheet downloads a spreadsheet export and returns its bytes
+func FetchSheet(raw string) ([]byte, error) {
+	exportURL, err := sheetExportURL(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(exportURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", exportURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s (is the sheet published or shared publicly?)", exportURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxSheetSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", exportURL, err)
+	}
+	if len(data) > MaxSheetSize {
+		return nil, fmt.Errorf("spreadsheet is larger than %d bytes", MaxSheetSize)
+	}
+	return data, nil
+}
+
+// LoadSheet reads the rows of a spreadsheet from a URL or a local .xlsx, .csv, or .tsv file
+func LoadSheet(src string) ([][]string, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		data, err = FetchSheet(src)
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSheet(data, strings.ToLower(path.Ext(src)))
+}
+
+// ParseSheet parses spreadsheet bytes as XLSX when they are a zip archive, or as CSV/TSV otherwise
+func ParseSheet(data []byte, ext string) ([][]string, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readXLSX(data)
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	if ext == ".tsv" {
+		r.Comma = '\t'
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse spreadsheet: %w", err)
+	}
+	return rows, nil
+}
+
+// xlsxCell is a cell of an XLSX worksheet
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text string `xml:"t"`
+	} `xml:"is"`
+}
+
+// xlsxSheet is the part of an XLSX worksheet needed to read cell values
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxSharedStrings is the shared string table of an XLSX workbook
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+// readXLSX returns the cell values of the first worksheet of an XLSX workbook
+func readXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+
+	var shared []string
+	var sheet xlsxSheet
+	foundSheet := false
+	for _, f := range zr.File {
+		switch f.Name {
+		case "xl/sharedStrings.xml":
+			var sst xlsxSharedStrings
+			if err := decodeZipXML(f, &sst); err != nil {
+				return nil, err
+			}
+			for _, item := range sst.Items {
+				text := item.Text
+				for _, run := range item.Runs {
+					text += run.Text
+				}
+				shared = append(shared, text)
+			}
+		case "xl/worksheets/sheet1.xml":
+			if err := decodeZipXML(f, &sheet); err != nil {
+				return nil, err
+			}
+			foundSheet = true
+		}
+	}
+	if !foundSheet {
+		return nil, errors.New("workbook has no first worksheet")
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var values []string
+		for i, c := range row.Cells {
+			col := i
+			if letters := strings.TrimRight(c.Ref, "0123456789"); letters != "" {
+				col = columnIndex(letters)
+			}
+			for len(values) <= col {
+				values = append(values, "")
+			}
+			switch c.Type {
+			case "s":
+				if n, err := strconv.Atoi(c.Value); err == nil && n >= 0 && n < len(shared) {
+					values[col] = shared[n]
+				}
+			case "inlineStr":
+				values[col] = c.Inline.Text
+			default:
+				values[col] = c.Value
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// decodeZipXML decodes an XML file inside a zip archive into v
+func decodeZipXML(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(io.LimitReader(rc, MaxSheetSize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// LoadSheetTemplate reads a column template from a JSON file, filling unset fields from the default template
+func LoadSheetTemplate(file string) (SheetTemplate, error) {
+	tmpl := DefaultSheetTemplate
+	if file == "" {
+		return tmpl, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return tmpl, fmt.Errorf("failed to read template %s: %w", file, err)
+	}
+	if err := unmarshalFile(file, content, &tmpl); err != nil {
+		return tmpl, err
+	}
+	return tmpl, nil
+}
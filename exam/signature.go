@@ -0,0 +1,165 @@
+package exam
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureSuffix is appended to the name of an exam file to name its detached minisign signature
+const SignatureSuffix = ".minisig"
+
+// Signature states of exam files, set when exams are checked against trusted keys
+const (
+	SignatureVerified = "verified" // signed by a trusted key and unchanged since
+	SignatureUnsigned = "unsigned" // no signature file
+	SignatureInvalid  = "invalid"  // the signature does not match the content or a trusted key
+)
+
+// PublicKey is a minisign Ed25519 public key trusted to sign exam files
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// String returns the key ID in hex, as minisign prints it
+func (k PublicKey) String() string {
+	id := k.ID
+	for i, j := 0, len(id)-1; i < j; i, j = i+1, j-1 {
+		id[i], id[j] = id[j], id[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+// ParsePublicKeys parses minisign public keys, one base64 key per line as in the .pub files minisign writes.
+// Blank lines and "untrusted comment:" lines are skipped, so several key files can be concatenated.
+func ParsePublicKeys(data []byte) ([]PublicKey, error) {
+	var keys []PublicKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+			return nil, fmt.Errorf("line %d: not a minisign public key", i+1)
+		}
+		var k PublicKey
+		copy(k.ID[:], raw[2:10])
+		k.Key = ed25519.PublicKey(raw[10:])
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
+
+// VerifySignature checks a detached minisign signature of content, in either the prehashed or the legacy
+// format, against the trusted keys, and returns the key that made it
+func VerifySignature(keys []PublicKey, content, signature []byte) (PublicKey, error) {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return PublicKey{}, errors.New("not a minisign signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return PublicKey{}, errors.New("not a minisign signature")
+	}
+	trusted, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return PublicKey{}, errors.New("signature has no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return PublicKey{}, errors.New("signature has no valid global signature")
+	}
+
+	var message []byte
+	switch string(sig[:2]) {
+	case "ED":
+		sum := blake2b512(content)
+		message = sum[:]
+	case "Ed":
+		message = content
+	default:
+		return PublicKey{}, fmt.Errorf("unsupported signature algorithm %q", sig[:2])
+	}
+	for _, k := range keys {
+		if !bytes.Equal(k.ID[:], sig[2:10]) {
+			continue
+		}
+		if !ed25519.Verify(k.Key, message, sig[10:]) {
+			return PublicKey{}, fmt.Errorf("content does not match the signature of key %s", k)
+		}
+		// The global signature covers the trusted comment, so it cannot be swapped for another one
+		if !ed25519.Verify(k.Key, append(bytes.Clone(sig[10:]), trusted...), global) {
+			return PublicKey{}, fmt.Errorf("trusted comment does not match the signature of key %s", k)
+		}
+		return k, nil
+	}
+	return PublicKey{}, errors.New("signed by a key that is not trusted")
+}
+
+// CheckSignatures sets the signature state of every exam read from dir by checking the signature file next to
+// it against keys. Exams changed on disk since they were read count as invalid. The bank files an exam references
+// are checked the same way, and an exam is only verified if they are too; otherwise it takes the state of a bank
+// file that is not.
+func CheckSignatures(dir string, subjects []Subject, keys []PublicKey) {
+	for i := range subjects {
+		s := &subjects[i]
+		for j := range s.Exams {
+			e := &s.Exams[j]
+			path := filepath.Join(dir, filepath.FromSlash(s.Path), e.Name)
+			e.Signature, e.SignedBy = checkExamSignature(path, e.fileSHA256, keys)
+			for file, sha := range e.bankFiles {
+				if e.Signature != SignatureVerified {
+					break
+				}
+				if state, _ := checkExamSignature(filepath.Join(dir, BankDir, file), sha, keys); state != SignatureVerified {
+					e.Signature, e.SignedBy = state, ""
+				}
+			}
+		}
+		CheckSignatures(dir, s.Subjects, keys)
+	}
+}
+
+// ErrUnsigned is returned for exam files without a signature file
+var ErrUnsigned = errors.New("exam file is not signed")
+
+// VerifyExamFile checks the signature file next to the exam file at path against keys, returning the key that
+// signed it and the content that was verified
+func VerifyExamFile(path string, keys []PublicKey) (PublicKey, []byte, error) {
+	signature, err := os.ReadFile(path + SignatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return PublicKey{}, nil, ErrUnsigned
+	} else if err != nil {
+		return PublicKey{}, nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return PublicKey{}, nil, err
+	}
+	key, err := VerifySignature(keys, content, signature)
+	return key, content, err
+}
+
+// checkExamSignature returns the signature state of the exam file at path, which had the hash sha when it was
+// read, with the ID of the key that signed it
+func checkExamSignature(path, sha string, keys []PublicKey) (string, string) {
+	key, content, err := VerifyExamFile(path, keys)
+	switch {
+	case errors.Is(err, ErrUnsigned):
+		return SignatureUnsigned, ""
+	case err != nil || contentHash(content) != sha:
+		return SignatureInvalid, ""
+	}
+	return SignatureVerified, key.String()
+}
@@ -0,0 +1,71 @@
+package exam
+
+import (
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Slugify turns text into a URL-safe slug of lowercase ASCII letters and digits separated by single dashes
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// examSlugBase returns the slug an exam would have without collisions: from its title, or its file name
+func examSlugBase(e ExamFile) string {
+	var slug string
+	if e.Meta != nil {
+		slug = Slugify(e.Meta.Title)
+	}
+	if slug == "" {
+		slug = Slugify(strings.TrimSuffix(e.Name, path.Ext(e.Name)))
+	}
+	if slug == "" {
+		slug = "exam"
+	}
+	return slug
+}
+
+// assignSlugs sets the slug of every exam in the subject tree. Exams of a subject whose slugs collide are told
+// apart by a number in file name order, and no slug is the file name of another exam, which would shadow it.
+func assignSlugs(subjects []Subject) {
+	for i := range subjects {
+		exams := subjects[i].Exams
+		order := make([]int, len(exams))
+		for j := range order {
+			order[j] = j
+		}
+		slices.SortFunc(order, func(a, b int) int { return strings.Compare(exams[a].Name, exams[b].Name) })
+
+		stems := map[string]bool{}
+		for _, e := range exams {
+			stems[strings.TrimSuffix(e.Name, path.Ext(e.Name))] = true
+		}
+		claimed := map[string]bool{}
+		for _, j := range order {
+			base := examSlugBase(exams[j])
+			stem := strings.TrimSuffix(exams[j].Name, path.Ext(exams[j].Name))
+			slug := base
+			for n := 2; claimed[slug] || (stems[slug] && slug != stem); n++ {
+				slug = base + "-" + strconv.Itoa(n)
+			}
+			claimed[slug] = true
+			exams[j].Slug = slug
+		}
+		assignSlugs(subjects[i].Subjects)
+	}
+}
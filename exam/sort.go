@@ -0,0 +1,97 @@
+package exam
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortModes lists the accepted values of the -sort flag
+var SortModes = []string{"name", "order", "modified", "rating"}
+
+// CheckSortMode returns an error if mode is not a known sort mode
+func CheckSortMode(mode string) error {
+	for _, m := range SortModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort mode %q (expected one of %s)", mode, strings.Join(SortModes, ", "))
+}
+
+// sortKey holds the attributes subjects and exams are sorted by
+type sortKey struct {
+	name     string
+	order    float64
+	hasOrder bool
+	modTime  time.Time
+}
+
+// less compares two sort keys under mode, falling back to the name so the result is always deterministic.
+// Ratings change while the server runs, so the "rating" mode is applied to each listing by sortByRating.
+func (a sortKey) less(b sortKey, mode string) bool {
+	switch mode {
+	case "order":
+		// Entries with an explicit order come first
+		if a.hasOrder != b.hasOrder {
+			return a.hasOrder
+		}
+		if a.hasOrder && a.order != b.order {
+			return a.order < b.order
+		}
+	case "modified":
+		// Most recently modified first
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.After(b.modTime)
+		}
+	}
+
+	la, lb := strings.ToLower(a.name), strings.ToLower(b.name)
+	if la != lb {
+		return la < lb
+	}
+	return a.name < b.name
+}
+
+// SortSubjects orders subjects, their child subjects, and the exams within each subject in place according to mode
+func SortSubjects(subjects []Subject, mode string) {
+	for i := range subjects {
+		exams := subjects[i].Exams
+		sort.SliceStable(exams, func(a, b int) bool {
+			return exams[a].sortKey().less(exams[b].sortKey(), mode)
+		})
+		SortSubjects(subjects[i].Subjects, mode)
+	}
+	sort.SliceStable(subjects, func(a, b int) bool {
+		return subjects[a].sortKey().less(subjects[b].sortKey(), mode)
+	})
+}
+
+// sortKey returns the attributes an exam is sorted by
+func (e ExamFile) sortKey() sortKey {
+	key := sortKey{name: e.Name, modTime: e.ModTime}
+	if e.Meta != nil && e.Meta.Order != nil {
+		key.order, key.hasOrder = *e.Meta.Order, true
+	}
+	return key
+}
+
+// sortKey returns the attributes a subject is sorted by; its modification time is that of its newest exam, including child subjects
+func (s Subject) sortKey() sortKey {
+	key := sortKey{name: s.Name}
+	if s.Order != nil {
+		key.order, key.hasOrder = *s.Order, true
+	}
+	for _, e := range s.Exams {
+		if e.ModTime.After(key.modTime) {
+			key.modTime = e.ModTime
+		}
+	}
+	for _, child := range s.Subjects {
+		if t := child.sortKey().modTime; t.After(key.modTime) {
+			key.modTime = t
+		}
+	}
+	return key
+}
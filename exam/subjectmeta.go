@@ -0,0 +1,50 @@
+package exam
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// subjectMetaNames lists the file names recognized as subject metadata instead of exams
+var subjectMetaNames = []string{"subject.json", "subject.jsonc", "_meta.json", "_meta.jsonc"}
+
+// SubjectMeta is the optional metadata of a subject, read from a subject.json or _meta.jsonc file in its folder
+type SubjectMeta struct {
+	DisplayName string   `json:"displayName,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	Order       *float64 `json:"order,omitempty"`
+}
+
+// isSubjectMetaFile reports whether path names a subject metadata file
+func isSubjectMetaFile(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, n := range subjectMetaNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSubjectMeta parses the content of a subject metadata file
+func parseSubjectMeta(path string, content []byte) (SubjectMeta, error) {
+	var meta SubjectMeta
+	if err := unmarshalFile(path, content, &meta); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// ValidateSubjectMeta checks the values of subject metadata
+func ValidateSubjectMeta(meta SubjectMeta) []string {
+	var problems []string
+	if len(meta.DisplayName) > 200 {
+		problems = append(problems, "displayName is longer than 200 characters")
+	}
+	if meta.Icon != "" && strings.ContainsAny(meta.Icon, "<>\"") {
+		problems = append(problems, fmt.Sprintf("icon %q must be a plain name, emoji, or URL", meta.Icon))
+	}
+	return problems
+}
@@ -0,0 +1,139 @@
+package exam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetWidth is the number of characters of the offending line shown around the error position
+const snippetWidth = 72
+
+// ParseError is a JSON or JSONC syntax or type error located in the file it was found in
+type ParseError struct {
+	Path    string
+	Format  string // JSON or JSONC
+	Line    int    // 1-based
+	Column  int    // 1-based, in characters
+	Snippet string // the offending line with a caret under the error position
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %s in file %s at line %d, column %d: %v\n%s", e.Format, e.Path, e.Line, e.Column, e.Err, e.Snippet)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// locateParseError turns a decoding error of content into a ParseError pointing at where in content it
+// happened. Errors without a position, and JSONC syntax errors that cannot be reproduced on the content with
+// its comments blanked out, are returned wrapped as they are.
+func locateParseError(path, format string, content []byte, err error) error {
+	offset, ok := errorOffset(err)
+	if ok && format == "JSONC" {
+		// The JSONC parser reports offsets into the content with its comments removed, so the error is
+		// reproduced on a copy with the comments replaced by spaces, which keeps every offset in place
+		var v any
+		jerr := json.Unmarshal(blankComments(content), &v)
+		offset, ok = errorOffset(jerr)
+	}
+	if !ok {
+		return fmt.Errorf("failed to parse %s in file %s: %w", format, path, err)
+	}
+
+	// The offset counts the bytes read up to and including the offending one
+	pos := min(max(int(offset)-1, 0), max(len(content)-1, 0))
+	lineStart := strings.LastIndexByte(string(content[:pos]), '\n') + 1
+	lineEnd := len(content)
+	if i := strings.IndexByte(string(content[lineStart:]), '\n'); i >= 0 {
+		lineEnd = lineStart + i
+	}
+	line := strings.Count(string(content[:lineStart]), "\n") + 1
+	column := utf8.RuneCount(content[lineStart:pos]) + 1
+	return &ParseError{
+		Path:    path,
+		Format:  format,
+		Line:    line,
+		Column:  column,
+		Snippet: snippet(line, strings.TrimRight(string(content[lineStart:lineEnd]), "\r"), column),
+		Err:     err,
+	}
+}
+
+// errorOffset returns the byte offset of a JSON decoding error, if it has one
+func errorOffset(err error) (int64, bool) {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset, true
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Offset, true
+	}
+	return 0, false
+}
+
+// snippet shows line number n of a file, cut around column if it is long, with a caret under column
+func snippet(n int, text string, column int) string {
+	runes := []rune(strings.ReplaceAll(text, "\t", " "))
+	start := 0
+	prefix, suffix := "", ""
+	if len(runes) > snippetWidth {
+		start = max(0, min(column-1-snippetWidth/2, len(runes)-snippetWidth))
+		end := min(len(runes), start+snippetWidth)
+		if start > 0 {
+			prefix = "..."
+		}
+		if end < len(runes) {
+			suffix = "..."
+		}
+		runes = runes[start:end]
+	}
+	gutter := fmt.Sprintf("%d | ", n)
+	caret := strings.Repeat(" ", len(gutter)-2) + "| " + strings.Repeat(" ", len(prefix)+column-1-start) + "^"
+	return "    " + gutter + prefix + string(runes) + suffix + "\n    " + caret
+}
+
+// blankComments returns a copy of JSONC content with its line and block comments replaced by spaces, keeping
+// line breaks so offsets, lines and columns stay the same
+func blankComments(content []byte) []byte {
+	out := make([]byte, len(content))
+	copy(out, content)
+	inString, escaped := false, false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			out[i], out[i+1] = ' ', ' '
+			for i += 2; i < len(out) && !(out[i] == '*' && i+1 < len(out) && out[i+1] == '/'); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			if i+1 < len(out) {
+				out[i], out[i+1] = ' ', ' '
+				i++
+			}
+		}
+	}
+	return out
+}
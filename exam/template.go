@@ -0,0 +1,295 @@
+package exam
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// templateFields are the question fields whose {expression} placeholders are filled in
+var templateFields = []string{"question", "explanation"}
+
+// defaultTemplateChoices is how many options are generated for a template question with an answer expression
+const defaultTemplateChoices = 4
+
+// paramSpec is the value range of one template variable: either a list of values or min/max with an optional step
+type paramSpec struct {
+	Min    *float64  `json:"min,omitempty"`
+	Max    *float64  `json:"max,omitempty"`
+	Step   float64   `json:"step,omitempty"` // defaults to 1
+	Values []float64 `json:"values,omitempty"`
+}
+
+// isTemplateQuestion reports whether a question declares template variables
+func isTemplateQuestion(q map[string]any) bool {
+	_, ok := q["params"]
+	return ok
+}
+
+// templateParams decodes the "params" of a template question
+func templateParams(q map[string]any) (map[string]paramSpec, error) {
+	raw, err := json.Marshal(q["params"])
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]paramSpec
+	if err := json.Unmarshal(raw, &params); err != nil || params == nil {
+		return nil, errors.New(`"params" must map variable names to {"min", "max", "step"} or {"values"}`)
+	}
+	for name, p := range params {
+		switch {
+		case !validVarName(name):
+			return nil, fmt.Errorf("invalid variable name %q", name)
+		case len(p.Values) > 0:
+		case p.Min == nil || p.Max == nil:
+			return nil, fmt.Errorf("variable %q needs min and max, or values", name)
+		case *p.Max < *p.Min:
+			return nil, fmt.Errorf("variable %q has max below min", name)
+		case p.Step < 0:
+			return nil, fmt.Errorf("variable %q has a negative step", name)
+		}
+	}
+	return params, nil
+}
+
+// validVarName reports whether name can be referenced from an expression
+func validVarName(name string) bool {
+	if name == "" || isDigit(name[0]) {
+		return false
+	}
+	if _, ok := exprFuncs[name]; ok {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// drawParams picks a value for every variable; names are visited in sorted order so draws are reproducible
+func drawParams(params map[string]paramSpec, rng *rand.Rand) map[string]float64 {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	vars := make(map[string]float64, len(params))
+	for _, name := range names {
+		p := params[name]
+		if len(p.Values) > 0 {
+			vars[name] = p.Values[rng.IntN(len(p.Values))]
+			continue
+		}
+		step := p.Step
+		if step == 0 {
+			step = 1
+		}
+		steps := int(math.Floor((*p.Max-*p.Min)/step + 1e-9))
+		vars[name] = math.Round((*p.Min+float64(rng.IntN(steps+1))*step)*1e9) / 1e9
+	}
+	return vars
+}
+
+// expandTemplate replaces every {expression} in text with its value; "{{" and "}}" stand for literal braces
+func expandTemplate(text string, vars map[string]float64, decimals int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '{' && strings.HasPrefix(text[i:], "{{"), c == '}' && strings.HasPrefix(text[i:], "}}"):
+			b.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return "", errors.New("unclosed { in template")
+			}
+			v, err := evalExpr(text[i+1:i+end], vars)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(formatNumber(v, decimals))
+			i += end
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// instantiateQuestion fills in a template question with values drawn from rng; "decimals" rounds the options.
+// The result is an ordinary question: its choices are either the expanded authored choices,
+// or generated around the value of the "answer" expression, which is stored as the numeric "answer".
+func instantiateQuestion(q map[string]any, rng *rand.Rand) (map[string]any, error) {
+	params, err := templateParams(q)
+	if err != nil {
+		return nil, err
+	}
+	vars := drawParams(params, rng)
+	decimals := -1
+	if d, ok := q["decimals"].(float64); ok {
+		decimals = int(d)
+	}
+
+	out := make(map[string]any, len(q))
+	for k, v := range q {
+		out[k] = v
+	}
+	delete(out, "params")
+	delete(out, "decimals")
+	for _, field := range templateFields {
+		if text, ok := q[field].(string); ok {
+			if out[field], err = expandTemplate(text, vars, -1); err != nil {
+				return nil, fmt.Errorf("%s: %w", field, err)
+			}
+		}
+	}
+
+	if choices, ok := q["choices"].([]any); ok {
+		expanded := make([]any, len(choices))
+		for i, c := range choices {
+			text, _ := c.(string)
+			if expanded[i], err = expandTemplate(text, vars, decimals); err != nil {
+				return nil, fmt.Errorf("choice %d: %w", i+1, err)
+			}
+		}
+		out["choices"] = expanded
+		return out, nil
+	}
+
+	expr, _ := q["answer"].(string)
+	if expr == "" {
+		return nil, errors.New(`template question needs "choices" or an "answer" expression`)
+	}
+	answer, err := evalExpr(expr, vars)
+	if err != nil {
+		return nil, fmt.Errorf("answer: %w", err)
+	}
+	if decimals >= 0 {
+		scale := math.Pow(10, float64(decimals))
+		answer = math.Round(answer*scale) / scale
+	}
+	count := defaultTemplateChoices
+	if n, ok := q["choiceCount"].(float64); ok && n >= 2 {
+		count = int(n)
+	}
+	delete(out, "choiceCount")
+	choices, correct := numericChoices(answer, count, decimals, rng)
+	out["choices"] = choices
+	out["correct"] = float64(correct)
+	out["answer"] = answer
+	return out, nil
+}
+
+// numericChoices returns count distinct options around answer, with the answer at a random position
+func numericChoices(answer float64, count, decimals int, rng *rand.Rand) ([]any, int) {
+	// Distractors are spread by roughly a tenth of the answer, so they stay plausible at any magnitude
+	step := math.Abs(answer) / 10
+	if decimals >= 0 {
+		step = math.Max(math.Round(step*math.Pow(10, float64(decimals)))/math.Pow(10, float64(decimals)), math.Pow(10, -float64(decimals)))
+	} else {
+		step = math.Max(math.Round(step), 1)
+	}
+
+	seen := map[string]bool{formatNumber(answer, decimals): true}
+	values := []float64{answer}
+	for offset := 1; len(values) < count; offset++ {
+		for _, sign := range rng.Perm(2) {
+			v := answer + float64(offset*(2*sign-1))*step
+			if s := formatNumber(v, decimals); !seen[s] && len(values) < count {
+				seen[s] = true
+				values = append(values, v)
+			}
+		}
+	}
+	// Shuffle everything but keep track of where the answer went
+	perm := rng.Perm(len(values))
+	choices := make([]any, len(values))
+	correct := 0
+	for i, from := range perm {
+		choices[i] = formatNumber(values[from], decimals)
+		if from == 0 {
+			correct = i
+		}
+	}
+	return choices, correct
+}
+
+// InstantiateQuestions returns the question list with every template question filled in from seed.
+// Each question draws from its own stream so editing one template leaves the others unchanged.
+func InstantiateQuestions(questions []any, seed [32]byte) []any {
+	var out []any
+	for i, item := range questions {
+		q, ok := item.(map[string]any)
+		if !ok || !isTemplateQuestion(q) {
+			continue
+		}
+		filled, err := instantiateQuestion(q, VariantRand(seed, "params "+strconv.Itoa(i)))
+		if err != nil {
+			// Broken templates are reported by validate; serve them as written
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(questions)
+		}
+		out[i] = filled
+	}
+	if out == nil {
+		return questions
+	}
+	return out
+}
+
+// validateTemplateQuestion checks the params and expressions of a template question by instantiating it
+// with the lowest or first value of every variable
+func validateTemplateQuestion(q map[string]any) []string {
+	params, err := templateParams(q)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	fixed := make(map[string]any, len(params))
+	for name, p := range params {
+		if len(p.Values) > 0 {
+			fixed[name] = map[string]any{"values": []float64{p.Values[0]}}
+		} else {
+			fixed[name] = map[string]any{"min": *p.Min, "max": *p.Min}
+		}
+	}
+	probe := make(map[string]any, len(q))
+	for k, v := range q {
+		probe[k] = v
+	}
+	probe["params"] = fixed
+
+	filled, err := instantiateQuestion(probe, rand.New(rand.NewPCG(1, 1)))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if _, ok := q["choices"]; !ok {
+		return nil
+	}
+	// Authored choices keep their "correct" index, which is checked like any other question
+	return validateExamContent([]any{filled})
+}
+
+// VariantSeed derives the seed of a user's paper from the user and the exam
+func VariantSeed(user, subject, exam string) [32]byte {
+	return sha256.Sum256([]byte(user + "\x00" + subject + "\x00" + exam))
+}
+
+// VariantRand returns a random source seeded from seed and a stream label, so each use of randomness
+// on a paper is stable even when another one changes
+func VariantRand(seed [32]byte, stream string) *rand.Rand {
+	sum := sha256.Sum256(append(seed[:], stream...))
+	return rand.New(rand.NewPCG(binary.LittleEndian.Uint64(sum[:8]), binary.LittleEndian.Uint64(sum[8:16])))
+}
@@ -0,0 +1,217 @@
+package exam
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// subjectPathOf returns the slash-separated path of the folder containing file, relative to the exam directory root
+func subjectPathOf(root, file string) (string, error) {
+	rel, err := filepath.Rel(root, filepath.Dir(file))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve subject of %s: %w", file, err)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// ValidSubjectPath reports whether p is a slash-separated path of valid subject names
+func ValidSubjectPath(p string) bool {
+	if p == "" {
+		return false
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if !ValidName(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildSubjectTree turns the exams and metadata keyed by subject path into a tree of subjects.
+// Folders without exams of their own become categories holding their child subjects, and exams
+// placed directly in the exam directory form a subject named after the directory itself.
+func buildSubjectTree(rootName string, exams map[string][]ExamFile, metas map[string]SubjectMeta) []Subject {
+	// Collect every subject path that has exams, including all of its ancestors
+	paths := make(map[string]bool)
+	for p := range exams {
+		for ; p != "" && p != "."; p = path.Dir(p) {
+			paths[p] = true
+		}
+	}
+
+	var build func(parent string) []Subject
+	build = func(parent string) []Subject {
+		var subjects []Subject
+		for p := range paths {
+			if parentSubjectPath(p) != parent {
+				continue
+			}
+			subjectExams := exams[p]
+			if subjectExams == nil {
+				subjectExams = []ExamFile{}
+			}
+			subjects = append(subjects, Subject{
+				Name:        path.Base(p),
+				Path:        p,
+				SubjectMeta: metas[p],
+				Exams:       subjectExams,
+				Subjects:    build(p),
+			})
+		}
+		return subjects
+	}
+
+	subjects := build("")
+	if rootExams, ok := exams[""]; ok {
+		subjects = append(subjects, Subject{
+			Name:        rootName,
+			SubjectMeta: metas[""],
+			Exams:       rootExams,
+		})
+	}
+	return subjects
+}
+
+// parentSubjectPath returns the path of the subject containing p, or "" for a top-level subject
+func parentSubjectPath(p string) string {
+	if parent := path.Dir(p); parent != "." {
+		return parent
+	}
+	return ""
+}
+
+// FlattenSubjects returns every subject of the tree that has exams as a flat list named by its path
+func FlattenSubjects(subjects []Subject) []Subject {
+	var flat []Subject
+	var walk func([]Subject)
+	walk = func(subjects []Subject) {
+		for _, s := range subjects {
+			if len(s.Exams) > 0 {
+				entry := s
+				if entry.Path != "" {
+					entry.Name = entry.Path
+				}
+				entry.Subjects = nil
+				flat = append(flat, entry)
+			}
+			walk(s.Subjects)
+		}
+	}
+	walk(subjects)
+	return flat
+}
+
+// FindSubject returns the subject with the given path from the tree, or nil
+func FindSubject(subjects []Subject, p string) *Subject {
+	for i := range subjects {
+		s := &subjects[i]
+		if s.Path == p {
+			return s
+		}
+		if strings.HasPrefix(p, s.Path+"/") {
+			return FindSubject(s.Subjects, p)
+		}
+	}
+	return nil
+}
+
+// SubjectID returns the identifier of a subject used in API references: its path, or its name for the root subject
+func SubjectID(s Subject) string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return s.Name
+}
+
+// WalkExams calls fn for every exam in the subject tree together with the identifier of its subject
+func WalkExams(subjects []Subject, fn func(subject string, e ExamFile)) {
+	for _, s := range subjects {
+		for _, e := range s.Exams {
+			fn(SubjectID(s), e)
+		}
+		WalkExams(s.Subjects, fn)
+	}
+}
+
+// LastModified returns the newest modification time of the exams in the subject tree
+func LastModified(subjects []Subject) time.Time {
+	var newest time.Time
+	WalkExams(subjects, func(_ string, e ExamFile) {
+		if e.ModTime.After(newest) {
+			newest = e.ModTime
+		}
+	})
+	return newest
+}
+
+// FilterExams returns a copy of the subject tree keeping only the exams for which keep returns true;
+// subjects left without exams or child subjects are dropped
+func FilterExams(subjects []Subject, keep func(subject string, e ExamFile) bool) []Subject {
+	var out []Subject
+	for _, s := range subjects {
+		filtered := s
+		filtered.Exams = []ExamFile{}
+		for _, e := range s.Exams {
+			if keep(SubjectID(s), e) {
+				filtered.Exams = append(filtered.Exams, e)
+			}
+		}
+		filtered.Subjects = FilterExams(s.Subjects, keep)
+		if len(filtered.Exams) > 0 || len(filtered.Subjects) > 0 {
+			out = append(out, filtered)
+		}
+	}
+	return out
+}
+
+// ValidName reports whether name can be used as a single subject or exam path element
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && name == filepath.Base(name) && !strings.ContainsAny(name, `/\`)
+}
+
+// FindExam looks up an exam by subject path and file name, with or without the extension, by its identifier,
+// or by its slug
+func FindExam(subjects []Subject, subject, name string) (ExamFile, bool) {
+	s := FindSubject(subjects, subject)
+	if s == nil {
+		// The root subject is referenced by its name
+		for _, top := range subjects {
+			if top.Path == "" && top.Name == subject {
+				s = &top
+				break
+			}
+		}
+	}
+	if s == nil {
+		return ExamFile{}, false
+	}
+	for _, e := range s.Exams {
+		if e.Name == name || strings.TrimSuffix(e.Name, path.Ext(e.Name)) == name || (e.ID != "" && e.ID == name) {
+			return e, true
+		}
+	}
+	for _, e := range s.Exams {
+		if e.Slug != "" && e.Slug == name {
+			return e, true
+		}
+	}
+	return ExamFile{}, false
+}
+
+// FindExamByID looks up an exam by its identifier across every subject, returning the identifier of its subject
+func FindExamByID(subjects []Subject, id string) (string, ExamFile, bool) {
+	var subject string
+	var found ExamFile
+	WalkExams(subjects, func(s string, e ExamFile) {
+		if id != "" && e.ID == id && found.ID == "" {
+			subject, found = s, e
+		}
+	})
+	return subject, found, found.ID != ""
+}
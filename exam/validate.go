@@ -0,0 +1,195 @@
+package exam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ValidateFile reads and parses the exam or subject metadata file at path and returns a list of problems found,
+// including unresolved media references
+func ValidateFile(root, path, mediaDir string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := ValidateContent(path, content)
+	if len(problems) > 0 || isSubjectMetaFile(path) {
+		return problems
+	}
+	parsed, _ := ParseContent(path, content)
+	_, questions, _ := splitExam(parsed, false)
+	subject, err := subjectPathOf(root, path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return checkMediaRefs(questions, subject, mediaDir)
+}
+
+// ValidateContent parses the raw content of an exam or subject metadata file named path and returns a list of problems found
+func ValidateContent(path string, content []byte) []string {
+	if len(content) == 0 {
+		return []string{"file is empty"}
+	}
+
+	if isSubjectMetaFile(path) {
+		meta, err := parseSubjectMeta(path, content)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		return ValidateSubjectMeta(meta)
+	}
+
+	parsed, err := ParseContent(path, content)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return ValidateDocument(parsed)
+}
+
+// validateExamContent checks that a question list is made of well-formed questions
+func validateExamContent(content any) []string {
+	items, ok := content.([]any)
+	if !ok {
+		return []string{"exam must be a JSON array of questions"}
+	}
+	if len(items) == 0 {
+		return []string{"exam has no questions"}
+	}
+
+	var problems []string
+	seen := map[string]int{} // question ids with the number of the question first using them
+	for i, item := range items {
+		q, ok := item.(map[string]any)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("question %d: must be an object", i+1))
+			continue
+		}
+
+		_, isRef := q["questionRef"]
+		if text, _ := q["question"].(string); text == "" && !isRef {
+			problems = append(problems, fmt.Sprintf("question %d: missing \"question\" text", i+1))
+		}
+
+		if id, ok := q["id"]; ok {
+			if s, ok := id.(string); !ok || s == "" {
+				problems = append(problems, fmt.Sprintf("question %d: \"id\" must be a non-empty string", i+1))
+			} else if first, dup := seen[s]; dup {
+				problems = append(problems, fmt.Sprintf("question %d: \"id\" %q is already used by question %d", i+1, s, first))
+			} else {
+				seen[s] = i + 1
+			}
+		}
+		if tags, ok := q["tags"]; ok {
+			items, ok := tags.([]any)
+			if !ok || slices.ContainsFunc(items, func(t any) bool { s, ok := t.(string); return !ok || s == "" }) {
+				problems = append(problems, fmt.Sprintf("question %d: \"tags\" must be a list of non-empty strings", i+1))
+			}
+		}
+
+		// Referenced questions are checked in their bank, which may change independently of the exam
+		if isRef {
+			if ref, ok := q["questionRef"].(string); !ok {
+				problems = append(problems, fmt.Sprintf("question %d: \"questionRef\" must be a string", i+1))
+			} else if _, _, err := ParseQuestionRef(ref); err != nil {
+				problems = append(problems, fmt.Sprintf("question %d: %v", i+1, err))
+			}
+			continue
+		}
+
+		// A sealed answer key is checked once opened, or taken on trust without the secret to open it
+		sealed := false
+		if isSealed(q) {
+			open, err := OpenAnswerKey(q)
+			switch {
+			case err == nil:
+				q = open
+			case errors.Is(err, errNoAnswerKeySecret):
+				sealed = true
+			default:
+				problems = append(problems, fmt.Sprintf("question %d: %v", i+1, err))
+				continue
+			}
+		}
+
+		// Template questions are checked on a filled-in copy
+		if isTemplateQuestion(q) {
+			for _, p := range validateTemplateQuestion(q) {
+				problems = append(problems, fmt.Sprintf("question %d: %s", i+1, strings.TrimPrefix(p, "question 1: ")))
+			}
+			continue
+		}
+
+		// Custom question types are checked by their grader, if it knows how
+		if t, ok := q["type"]; ok {
+			if s, ok := t.(string); !ok || s == "" {
+				problems = append(problems, fmt.Sprintf("question %d: \"type\" must be a non-empty string", i+1))
+				continue
+			}
+		}
+		if QuestionType(q) != DefaultQuestionType {
+			g, err := graderFor(q)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("question %d: %v", i+1, err))
+			} else if c, ok := g.(questionChecker); ok && !sealed {
+				for _, p := range c.CheckQuestion(q) {
+					problems = append(problems, fmt.Sprintf("question %d: %s", i+1, p))
+				}
+			}
+			continue
+		}
+
+		choices, ok := q["choices"].([]any)
+		if !ok || len(choices) < 2 {
+			problems = append(problems, fmt.Sprintf("question %d: \"choices\" must list at least two options", i+1))
+			continue
+		}
+		for j, c := range choices {
+			if _, ok := c.(string); !ok {
+				problems = append(problems, fmt.Sprintf("question %d: choice %d must be a string", i+1, j+1))
+			}
+		}
+
+		if sealed {
+			continue
+		}
+		correct, ok := q["correct"].(float64)
+		if !ok || correct != float64(int(correct)) {
+			problems = append(problems, fmt.Sprintf("question %d: \"correct\" must be an integer index", i+1))
+		} else if int(correct) < 0 || int(correct) >= len(choices) {
+			problems = append(problems, fmt.Sprintf("question %d: \"correct\" index %d is out of range", i+1, int(correct)))
+		}
+	}
+	return problems
+}
+
+// CheckIDs reports the exam and questions of parsed exam file content that have no "id" field, for content
+// that must carry its own identifiers
+func CheckIDs(parsed any) []string {
+	meta, questions, err := splitExam(parsed, false)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	if meta == nil || meta.ID == "" {
+		problems = append(problems, "exam has no \"id\"")
+	}
+	for i, item := range Questions(questions) {
+		// Referenced questions carry the id of their bank question
+		if q, ok := item.(map[string]any); ok && q["questionRef"] == nil {
+			if id, _ := q["id"].(string); id == "" {
+				problems = append(problems, fmt.Sprintf("question %d: missing \"id\"", i+1))
+			}
+		}
+	}
+	return problems
+}
+
+// Questions returns the question list of parsed exam content, or nil if it is not a list
+func Questions(content any) []any {
+	items, _ := content.([]any)
+	return items
+}
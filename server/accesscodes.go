@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// accessCodeAlphabet leaves out characters that are easily confused when read aloud or from a board
+const accessCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// AccessCode is the code students must enter to start a session of an exam during the current sitting
+type AccessCode struct {
+	Code      string    `json:"code"`
+	Sitting   int       `json:"sitting"` // incremented every time the code is rotated
+	RotatedAt time.Time `json:"rotatedAt"`
+}
+
+// accessCodeStore keeps the access codes of exams in a JSON file
+type accessCodeStore struct {
+	path string
+
+	mu    sync.RWMutex
+	codes map[string]AccessCode // keyed by <subject>/<exam file name>
+}
+
+// openAccessCodeStore loads the access codes saved at path
+func openAccessCodeStore(path string) (*accessCodeStore, error) {
+	s := &accessCodeStore{path: path, codes: map[string]AccessCode{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read access codes: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.codes); err != nil {
+		return nil, fmt.Errorf("failed to parse access codes: %w", err)
+	}
+	return s, nil
+}
+
+// save writes the codes to the file; s.mu must be held
+func (s *accessCodeStore) save() error {
+	data, err := json.MarshalIndent(s.codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// Get returns the access code of an exam, if it requires one
+func (s *accessCodeStore) Get(subject, exam string) (AccessCode, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	code, ok := s.codes[subject+"/"+exam]
+	return code, ok
+}
+
+// Check reports whether code opens the exam; exams without an access code accept anything
+func (s *accessCodeStore) Check(subject, exam, code string) bool {
+	current, ok := s.Get(subject, exam)
+	if !ok {
+		return true
+	}
+	got := strings.ToUpper(strings.TrimSpace(code))
+	return subtle.ConstantTimeCompare([]byte(got), []byte(current.Code)) == 1
+}
+
+// Rotate starts a new sitting of an exam with code, or a random code when code is empty
+func (s *accessCodeStore) Rotate(subject, exam, code string) (AccessCode, error) {
+	if code == "" {
+		code = randomAccessCode(6)
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := subject + "/" + exam
+	next := AccessCode{Code: code, Sitting: s.codes[key].Sitting + 1, RotatedAt: time.Now().UTC()}
+	previous, existed := s.codes[key]
+	s.codes[key] = next
+	if err := s.save(); err != nil {
+		if existed {
+			s.codes[key] = previous
+		} else {
+			delete(s.codes, key)
+		}
+		return AccessCode{}, err
+	}
+	return next, nil
+}
+
+// Remove lets an exam be started without an access code again
+func (s *accessCodeStore) Remove(subject, exam string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := subject + "/" + exam
+	previous, ok := s.codes[key]
+	if !ok {
+		return nil
+	}
+	delete(s.codes, key)
+	if err := s.save(); err != nil {
+		s.codes[key] = previous
+		return err
+	}
+	return nil
+}
+
+// randomAccessCode returns a code of n characters from accessCodeAlphabet
+func randomAccessCode(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = accessCodeAlphabet[int(b[i])%len(accessCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// manageAccessCode returns a handler that shows (GET), rotates (PUT) or removes (DELETE) the access code of an exam
+func manageAccessCode(store *examStore, codes *accessCodeStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+
+		var code AccessCode
+		switch r.Method {
+		case http.MethodGet:
+			if code, ok = codes.Get(subject, e.Name); !ok {
+				http.Error(w, "Exam has no access code", http.StatusNotFound)
+				return
+			}
+		case http.MethodPut:
+			var req struct {
+				Code string `json:"code"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(strings.TrimSpace(req.Code)) > 64 {
+				http.Error(w, "Access code is too long", http.StatusBadRequest)
+				return
+			}
+			if code, err = codes.Rotate(subject, e.Name, req.Code); err != nil {
+				http.Error(w, "Failed to save access code: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodDelete:
+			if err := codes.Remove(subject, e.Name); err != nil {
+				http.Error(w, "Failed to remove access code: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(code)
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// requireAdmin returns middleware that only lets through requests carrying the admin bearer token
+func requireAdmin(token string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// registerAdminRoutes adds the admin API endpoints to admin, the group of routes under /api/v1/admin. The routes
+// of authoring, the same group opened to author tokens as well, edit and report on the exams of one subject at a
+// time.
+func registerAdminRoutes(admin, authoring *router, store *examStore, attempts *attemptStore, sessions *sessionStore, usage *usageStore, revisions *revisionStore, drafts *draftStore, live *liveConfig, jobs *scheduler, mediaDir string) {
+	authoring.HandleFunc("GET /exams/{subject}/{exam}/source", serveExamSource(store))
+	authoring.HandleFunc("PUT /exams/{subject}/{exam}", putExam(store, drafts))
+	authoring.HandleFunc("POST /exams/{subject}/{exam}/copy", copyExam(store, drafts))
+	authoring.HandleFunc("POST /exams/bulk", bulkUpload(store, drafts))
+	admin.HandleFunc("POST /media", uploadMedia(mediaDir))
+	authoring.HandleFunc("POST /import/sheet", importSheetUpload(store, drafts))
+	authoring.HandleFunc("GET /exams/{subject}/{exam}/scorm", exportSCORM(store))
+	admin.HandleFunc("GET /results/export", exportResults(attempts))
+	admin.HandleFunc("GET /config", live.serveConfig)
+	admin.HandleFunc("POST /config/reload", live.reload)
+	admin.HandleFunc("GET /jobs", listJobs(jobs))
+	admin.HandleFunc("POST /jobs/{name}/run", runJob(jobs))
+	admin.HandleFunc("GET /retention", retentionReport(live, sessions, attempts))
+	admin.HandleFunc("GET /usage", listUsage(usage))
+	authoring.HandleFunc("GET /exams/{subject}/{exam}/usage", examUsage(store, usage))
+	authoring.HandleFunc("GET /exams/{subject}/{exam}/revisions", listRevisions(store, revisions))
+	authoring.HandleFunc("GET /exams/{subject}/{exam}/diff", diffExam(store, revisions))
+	authoring.HandleFunc("GET /templates", listTemplates)
+	authoring.HandleFunc("POST /templates/{template}", createFromTemplate(store, drafts))
+}
+
+// findExamPath resolves an exam name, with or without its extension, to a file in the subject folder
+func findExamPath(dir, subject, examName string) (string, error) {
+	if !exam.ValidSubjectPath(subject) || !exam.ValidName(examName) {
+		return "", errors.New("invalid subject or exam name")
+	}
+
+	candidates := []string{examName}
+	if !exam.IsExamFile(examName) {
+		candidates = append(candidates, examName+".jsonc", examName+".json")
+	}
+	for _, name := range candidates {
+		path := filepath.Join(dir, filepath.FromSlash(subject), name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && exam.IsExamFile(path) {
+			return path, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// ExamRef identifies an exam by its subject and file name
+type ExamRef struct {
+	Subject string `json:"subject"`
+	Name    string `json:"name"`
+}
+
+// withoutExamID returns the content of the exam file at path without the "id" it declares, re-encoded as
+// indented JSON if it had one
+func withoutExamID(path string, content []byte) ([]byte, error) {
+	parsed, err := exam.ParseContent(path, content)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := parsed.(map[string]any)
+	if _, declared := doc["id"]; !ok || !declared {
+		return content, nil
+	}
+	delete(doc, "id")
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// copyExam returns a handler that clones an exam file, optionally into another subject, as a draft
+func copyExam(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, examName := r.PathValue("subject"), r.PathValue("exam")
+		src, err := findExamPath(store.dir, subject, examName)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The body optionally names the target subject and file name
+		var req ExamRef
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Subject == "" {
+			req.Subject = subject
+		}
+		if req.Name != "" && !exam.IsExamFile(req.Name) {
+			req.Name += filepath.Ext(src)
+		}
+		if !exam.ValidSubjectPath(req.Subject) || (req.Name != "" && !exam.ValidName(req.Name)) {
+			http.Error(w, "Invalid target subject or exam name", http.StatusBadRequest)
+			return
+		}
+		if !scopeOf(r).allows(req.Subject) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		content, err := os.ReadFile(src)
+		if err != nil {
+			http.Error(w, "Failed to read exam: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// A copy is a new exam, so the server gives it an identifier of its own rather than the source's
+		if content, err = withoutExamID(src, content); err != nil {
+			http.Error(w, "Invalid exam: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		targetDir := filepath.Join(store.dir, filepath.FromSlash(req.Subject))
+		if err := os.MkdirAll(targetDir, 0o755); err != nil {
+			http.Error(w, "Failed to create subject: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		name := req.Name
+		if name == "" {
+			name = nextCopyName(targetDir, filepath.Base(src))
+		}
+		target := filepath.Join(targetDir, name)
+
+		// O_EXCL makes sure an existing exam is never overwritten by a copy
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			http.Error(w, "An exam with that name already exists", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to create exam: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := drafts.Add(ExamRef{Subject: req.Subject, Name: name}); err != nil {
+			f.Close()
+			os.Remove(target)
+			http.Error(w, "Failed to save draft: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = f.Write(content)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(target)
+			drafts.Discard(ExamRef{Subject: req.Subject, Name: name})
+			http.Error(w, "Failed to write exam: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if store.cached {
+			if err := store.Reload(context.WithoutCancel(r.Context())); err != nil {
+				log.Printf("Failed to reload exams after copy: %v", err)
+			}
+		}
+		log.Printf("Copied exam %s to %s", src, target)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ExamRef{Subject: req.Subject, Name: name})
+	}
+}
+
+// nextCopyName picks an unused "<name>_copy" file name in dir for a copy of name
+func nextCopyName(dir, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_copy%s", base, ext)
+		if i > 1 {
+			candidate = fmt.Sprintf("%s_copy%d%s", base, i, ext)
+		}
+		if _, err := os.Stat(filepath.Join(dir, candidate)); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+func TestCopyExamGetsItsOwnID(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin"}, map[string]string{
+		"Math/algebra.json": `{"id": "algebra-1", "title": "Algebra", "questions": [{"question": "1 + 1?", "choices": ["1", "2"], "correct": 1}]}`,
+	})
+	rec := serveTest(s, "POST", "/api/v1/admin/exams/Math/algebra.json/copy", "admin", nil)
+	wantStatus(t, rec, http.StatusCreated)
+	var copied ExamRef
+	if err := json.Unmarshal(rec.Body.Bytes(), &copied); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serveTest(s, "GET", "/api/v1/exams?content=false", "admin", nil)
+	wantStatus(t, rec, http.StatusOK)
+	var subjects []exam.Subject
+	if err := json.Unmarshal(rec.Body.Bytes(), &subjects); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{}
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) { ids[subject+"/"+e.Name] = e.ID })
+	if ids["Math/algebra.json"] != "algebra-1" {
+		t.Errorf("source id = %q, want algebra-1", ids["Math/algebra.json"])
+	}
+	if id := ids[copied.Subject+"/"+copied.Name]; id == "" || id == "algebra-1" {
+		t.Errorf("copy id = %q, want one of its own", id)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// badgeProgress is the running tally of a user's attempts that achievements are evaluated against
+type badgeProgress struct {
+	Attempts int                  `json:"attempts"`
+	Streak90 int                  `json:"streak90"` // consecutive attempts scoring 90% or more
+	Perfect  int                  `json:"perfect"`
+	EarnedAt map[string]time.Time `json:"earnedAt"`
+}
+
+// achievement is a badge and the condition for earning it
+type achievement struct {
+	ID          string
+	Name        string
+	Description string
+	earned      func(p badgeProgress) bool
+}
+
+// achievements lists every badge in the order it is shown
+var achievements = []achievement{
+	{"first-exam", "First Steps", "Submit your first exam", func(p badgeProgress) bool { return p.Attempts >= 1 }},
+	{"ten-exams", "Dedicated", "Submit 10 exams", func(p badgeProgress) bool { return p.Attempts >= 10 }},
+	{"fifty-exams", "Marathoner", "Submit 50 exams", func(p badgeProgress) bool { return p.Attempts >= 50 }},
+	{"perfect-score", "Flawless", "Score 100% on an exam", func(p badgeProgress) bool { return p.Perfect >= 1 }},
+	{"streak-90-3", "On a Roll", "Score 90% or more on 3 exams in a row", func(p badgeProgress) bool { return p.Streak90 >= 3 }},
+	{"streak-90-5", "Unstoppable", "Score 90% or more on 5 exams in a row", func(p badgeProgress) bool { return p.Streak90 >= 5 }},
+}
+
+// Badge is an achievement and when the user earned it
+type Badge struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Earned      bool      `json:"earned"`
+	EarnedAt    time.Time `json:"earnedAt,omitzero"`
+}
+
+// badgeStore evaluates achievements as attempts are recorded and keeps every user's progress in a JSON file
+type badgeStore struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]*badgeProgress
+}
+
+// openBadgeStore loads the badge progress saved at path. A new store is backfilled from the attempts
+// recorded before badges existed.
+func openBadgeStore(path string, attempts *attemptStore) (*badgeStore, error) {
+	s := &badgeStore{path: path, users: map[string]*badgeProgress{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		for _, a := range attempts.List(nil) {
+			s.record(a)
+		}
+		if len(s.users) == 0 {
+			return s, nil
+		}
+		return s, s.save()
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read badges: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.users); err != nil {
+		return nil, fmt.Errorf("failed to parse badges: %w", err)
+	}
+	return s, nil
+}
+
+// save writes the progress of every user; the caller holds the lock
+func (s *badgeStore) save() error {
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// record adds an attempt to its user's progress and awards the achievements it completes; the caller holds the lock
+func (s *badgeStore) record(a Attempt) {
+	if a.UserID == "" {
+		return
+	}
+	p := s.users[a.UserID]
+	if p == nil {
+		p = &badgeProgress{EarnedAt: map[string]time.Time{}}
+		s.users[a.UserID] = p
+	}
+	p.Attempts++
+	if a.Percent >= 90 {
+		p.Streak90++
+	} else {
+		p.Streak90 = 0
+	}
+	if a.Total > 0 && a.Score == a.Total {
+		p.Perfect++
+	}
+	for _, ach := range achievements {
+		if _, ok := p.EarnedAt[ach.ID]; !ok && ach.earned(*p) {
+			p.EarnedAt[ach.ID] = a.SubmittedAt
+		}
+	}
+}
+
+// attemptRecorded evaluates achievements for a newly submitted attempt
+func (s *badgeStore) attemptRecorded(a Attempt) {
+	if a.UserID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(a)
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save badges for %s: %v", a.UserID, err)
+	}
+}
+
+// Badges returns every achievement with whether user has earned it
+func (s *badgeStore) Badges(user string) []Badge {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p := s.users[user]
+	out := make([]Badge, len(achievements))
+	for i, ach := range achievements {
+		out[i] = Badge{ID: ach.ID, Name: ach.Name, Description: ach.Description}
+		if p != nil {
+			out[i].EarnedAt, out[i].Earned = p.EarnedAt[ach.ID]
+		}
+	}
+	return out
+}
+
+// EraseUser deletes the progress and badges of a user
+func (s *badgeStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.users[user]
+	if !ok {
+		return nil
+	}
+	delete(s.users, user)
+	if err := s.save(); err != nil {
+		s.users[user] = p
+		return err
+	}
+	return nil
+}
+
+// serveBadges returns a handler that lists the achievements of a user
+func serveBadges(badges *badgeStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(badges.Badges(r.PathValue("id")))
+	}
+}
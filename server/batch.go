@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// maxBatchExams is the number of exams a batch request may ask for
+const maxBatchExams = 100
+
+// ExamBatchRequest lists the exams to fetch in one request; names may leave out the extension
+type ExamBatchRequest struct {
+	Exams []ExamRef `json:"exams"`
+}
+
+// SubjectExam is an exam together with the identifier of its subject, for responses listing exams without
+// their subject tree
+type SubjectExam struct {
+	Subject string `json:"subject"`
+	exam.ExamFile
+}
+
+// ExamBatch is the response of a batch request: the exams found, in request order, and the references that
+// matched no exam
+type ExamBatch struct {
+	Exams   []SubjectExam `json:"exams"`
+	Missing []ExamRef     `json:"missing"`
+}
+
+// fetchExamBatch returns a handler that returns several exams, as the listing serves them, in one response
+func fetchExamBatch(store *examStore, ratings *ratingStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExamBatchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Exams) == 0 {
+			http.Error(w, "No exams requested", http.StatusBadRequest)
+			return
+		}
+		if len(req.Exams) > maxBatchExams {
+			http.Error(w, fmt.Sprintf("Too many exams requested (at most %d)", maxBatchExams), http.StatusBadRequest)
+			return
+		}
+
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		summaries := ratings.Summaries()
+
+		batch := ExamBatch{Exams: []SubjectExam{}, Missing: []ExamRef{}}
+		seen := map[ExamRef]bool{}
+		for _, ref := range req.Exams {
+			// Exams the request may not see are missing, as if they did not exist
+			e, ok := exam.FindExam(subjects, ref.Subject, ref.Name)
+			if !ok || !access.canSee(r, r.URL.Query().Get("user"), ref.Subject, e) {
+				batch.Missing = append(batch.Missing, ref)
+				continue
+			}
+			// The same exam asked for twice, with and without its extension, is returned once
+			key := ExamRef{Subject: ref.Subject, Name: e.Name}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			// Questions are served as they are in the listing, templates filled in and the answer key redacted
+			e.Content = store.publicContent(ref.Subject, e)
+			if sum, ok := summaries[key]; ok {
+				e.Rating = &sum
+			}
+			batch.Exams = append(batch.Exams, SubjectExam{Subject: ref.Subject, ExamFile: e})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batch)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// newBenchServer returns a server of a generated tree of 5 subjects with 20 exams of 50 questions each, the
+// size the seed command makes by default
+func newBenchServer(b *testing.B) (*Server, []exam.Subject) {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := b.TempDir()
+	tree := exam.Seed(exam.SeedOptions{Subjects: 5, Exams: 20, Questions: 50, Seed: 1})
+	for _, s := range tree {
+		for _, e := range s.Exams {
+			data, err := json.Marshal(exam.Document(e.Meta, e.Content))
+			if err != nil {
+				b.Fatal(err)
+			}
+			path := filepath.Join(dir, s.Path, e.Name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				b.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	s, err := New(Config{Dir: dir, DataDir: b.TempDir(), MediaDir: b.TempDir(), ImageCache: b.TempDir()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s, tree
+}
+
+// serveBench sends a request to the handler of s and reports an error unless it succeeds
+func serveBench(b *testing.B, s *Server, method, path string, body []byte) {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code >= http.StatusBadRequest {
+		b.Errorf("%s %s: %d %s", method, path, rec.Code, rec.Body)
+	}
+}
+
+func BenchmarkListExams(b *testing.B) {
+	s, _ := newBenchServer(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		serveBench(b, s, http.MethodGet, "/api/v1/exams", nil)
+	}
+}
+
+func BenchmarkListExamsWithoutContent(b *testing.B) {
+	s, _ := newBenchServer(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		serveBench(b, s, http.MethodGet, "/api/v1/exams?content=false", nil)
+	}
+}
+
+func BenchmarkFetchExam(b *testing.B) {
+	s, tree := newBenchServer(b)
+	body, _ := json.Marshal(ExamBatchRequest{Exams: []ExamRef{{Subject: tree[0].Path, Name: tree[0].Exams[0].Name}}})
+	b.ReportAllocs()
+	for b.Loop() {
+		serveBench(b, s, http.MethodPost, "/api/v1/exams/batch", body)
+	}
+}
+
+func BenchmarkSubmitAttempt(b *testing.B) {
+	s, tree := newBenchServer(b)
+	e := tree[0].Exams[0]
+	answers := make([]exam.Answer, 50)
+	for i := range answers {
+		answers[i] = exam.ChoiceAnswer(i % 4)
+	}
+	body, _ := json.Marshal(map[string]any{"subject": tree[0].Path, "exam": e.Name, "userId": "bench", "answers": answers})
+	b.ReportAllocs()
+	for b.Loop() {
+		serveBench(b, s, http.MethodPost, "/api/v1/attempts", body)
+	}
+}
+
+// BenchmarkMixedRequests replays the default mix of the bench command from parallel clients: one in five
+// requests lists the exams, three fetch a single exam and one submits answers
+func BenchmarkMixedRequests(b *testing.B) {
+	s, tree := newBenchServer(b)
+	var fetches, submits [][]byte
+	for _, subject := range tree {
+		for _, e := range subject.Exams {
+			fetch, _ := json.Marshal(ExamBatchRequest{Exams: []ExamRef{{Subject: subject.Path, Name: e.Name}}})
+			fetches = append(fetches, fetch)
+			submit, _ := json.Marshal(map[string]any{"subject": subject.Path, "exam": e.Name, "userId": "bench", "answers": []exam.Answer{exam.ChoiceAnswer(0)}})
+			submits = append(submits, submit)
+		}
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			switch i % 5 {
+			case 0:
+				serveBench(b, s, http.MethodGet, "/api/v1/exams", nil)
+			case 4:
+				serveBench(b, s, http.MethodPost, "/api/v1/attempts", submits[i%len(submits)])
+			default:
+				serveBench(b, s, http.MethodPost, "/api/v1/exams/batch", fetches[i%len(fetches)])
+			}
+		}
+	})
+}
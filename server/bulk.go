@@ -0,0 +1,280 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// maxBulkUploadSize limits the size of an uploaded zip archive
+const maxBulkUploadSize = 32 << 20
+
+// maxBulkFileSize limits the uncompressed size of a single file inside an uploaded archive
+const maxBulkFileSize = 8 << 20
+
+// BulkFileReport describes the outcome for one file of a bulk upload
+type BulkFileReport struct {
+	Path     string   `json:"path"`
+	Subject  string   `json:"subject,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Status   string   `json:"status"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// BulkReport is the response of a bulk upload
+type BulkReport struct {
+	Installed bool             `json:"installed"`
+	Files     []BulkFileReport `json:"files"`
+}
+
+// bulkFile is a validated exam file waiting to be installed
+type bulkFile struct {
+	subject string
+	name    string
+	content []byte
+}
+
+// bulkUpload returns a handler that installs every exam in an uploaded zip archive, or none of them if any is
+// invalid. Exams new to the exam directory are installed as drafts.
+func bulkUpload(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBulkUploadSize))
+		if err != nil {
+			http.Error(w, "Failed to read upload: "+err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			http.Error(w, "Upload is not a valid zip archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		overwrite := r.URL.Query().Get("overwrite") == "true"
+		report, files := checkBulkArchive(archive, store.dir, overwrite, scopeOf(r))
+
+		status := http.StatusUnprocessableEntity
+		if report.Installed {
+			var added []ExamRef
+			for _, f := range files {
+				_, err := os.Stat(filepath.Join(store.dir, filepath.FromSlash(f.subject), f.name))
+				if errors.Is(err, os.ErrNotExist) && exam.IsExamFile(f.name) {
+					added = append(added, ExamRef{Subject: f.subject, Name: f.name})
+				}
+			}
+			if err := drafts.Add(added...); err != nil {
+				http.Error(w, "Failed to save drafts: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := installBulkFiles(store.dir, files); err != nil {
+				drafts.Discard(added...)
+				http.Error(w, "Failed to install exams: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if store.cached {
+				if err := store.Reload(context.WithoutCancel(r.Context())); err != nil {
+					log.Printf("Failed to reload exams after bulk upload: %v", err)
+				}
+			}
+			log.Printf("Bulk upload installed %d exam(s)", len(files))
+			status = http.StatusCreated
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// checkBulkArchive validates every file in archive and reports whether all of them can be installed in the
+// subjects of scope
+func checkBulkArchive(archive *zip.Reader, dir string, overwrite bool, scope subjectScope) (BulkReport, []bulkFile) {
+	report := BulkReport{Installed: true, Files: []BulkFileReport{}}
+	var files []bulkFile
+	seen := make(map[string]bool)
+
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		entry := BulkFileReport{Path: f.Name}
+		clean := path.Clean(f.Name)
+		if !exam.IsJSONFile(clean) {
+			entry.Status = "skipped"
+			report.Files = append(report.Files, entry)
+			continue
+		}
+
+		// Like ReadDir, the subject is the path of the folder containing the exam
+		entry.Subject = path.Dir(clean)
+		entry.Name = path.Base(clean)
+		problems := checkBulkFile(f, entry, dir, overwrite, seen)
+		if len(problems) == 0 && !scope.allows(entry.Subject) {
+			problems = []string{"subject is outside the scope of the token"}
+		}
+
+		var content []byte
+		if len(problems) == 0 {
+			var err error
+			content, err = readZipFile(f)
+			if err != nil {
+				problems = []string{err.Error()}
+			} else {
+				problems = exam.ValidateContent(clean, content)
+			}
+		}
+
+		if len(problems) > 0 {
+			entry.Status = "invalid"
+			entry.Problems = problems
+			report.Installed = false
+		} else {
+			entry.Status = "ok"
+			files = append(files, bulkFile{subject: entry.Subject, name: entry.Name, content: content})
+		}
+		seen[entry.Subject+"/"+entry.Name] = true
+		report.Files = append(report.Files, entry)
+	}
+
+	if len(files) == 0 && report.Installed {
+		report.Installed = false
+		report.Files = append(report.Files, BulkFileReport{Path: "", Status: "invalid", Problems: []string{"archive contains no exam files"}})
+	}
+	return report, files
+}
+
+// checkBulkFile checks the placement of an archive entry before its content is read
+func checkBulkFile(f *zip.File, entry BulkFileReport, dir string, overwrite bool, seen map[string]bool) []string {
+	if !exam.ValidSubjectPath(entry.Subject) || !exam.ValidName(entry.Name) {
+		return []string{"file must be inside a subject folder"}
+	}
+	if seen[entry.Subject+"/"+entry.Name] {
+		return []string{"duplicate exam in archive"}
+	}
+	if f.UncompressedSize64 > maxBulkFileSize {
+		return []string{fmt.Sprintf("file is larger than %d bytes", maxBulkFileSize)}
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(entry.Subject), entry.Name)); err == nil && !overwrite {
+		return []string{"exam already exists (use ?overwrite=true to replace it)"}
+	}
+	return nil
+}
+
+// readZipFile reads an archive entry, refusing to read more than maxBulkFileSize bytes
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, maxBulkFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	if len(content) > maxBulkFileSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxBulkFileSize)
+	}
+	if len(content) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	return content, nil
+}
+
+// installBulkFiles writes all files into place, restoring the previous state if any step fails
+func installBulkFiles(dir string, files []bulkFile) error {
+	type staged struct {
+		tmp, target, backup string
+	}
+	var stagedFiles []staged
+	var createdDirs []string
+
+	// rollback undoes the renames done so far and removes any leftovers
+	rollback := func(done int) {
+		for i := done - 1; i >= 0; i-- {
+			s := stagedFiles[i]
+			os.Remove(s.target)
+			if s.backup != "" {
+				os.Rename(s.backup, s.target)
+			}
+		}
+		for _, s := range stagedFiles {
+			os.Remove(s.tmp)
+		}
+		for i := len(createdDirs) - 1; i >= 0; i-- {
+			os.Remove(createdDirs[i])
+		}
+	}
+
+	// Stage every file next to its target first so the final renames stay on one filesystem
+	for _, f := range files {
+		subjectDir := filepath.Join(dir, filepath.FromSlash(f.subject))
+
+		// Remember every folder created, outermost first, so a rollback can remove them again
+		var missing []string
+		for d := subjectDir; d != filepath.Dir(d); d = filepath.Dir(d) {
+			if _, err := os.Stat(d); !errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			missing = append([]string{d}, missing...)
+		}
+		if err := os.MkdirAll(subjectDir, 0o755); err != nil {
+			rollback(0)
+			return fmt.Errorf("failed to create %s: %w", subjectDir, err)
+		}
+		createdDirs = append(createdDirs, missing...)
+
+		tmp, err := os.CreateTemp(subjectDir, ".bulk-*.tmp")
+		if err != nil {
+			rollback(0)
+			return fmt.Errorf("failed to stage %s/%s: %w", f.subject, f.name, err)
+		}
+		_, err = tmp.Write(f.content)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		stagedFiles = append(stagedFiles, staged{tmp: tmp.Name(), target: filepath.Join(subjectDir, f.name)})
+		if err != nil {
+			rollback(0)
+			return fmt.Errorf("failed to stage %s/%s: %w", f.subject, f.name, err)
+		}
+	}
+
+	for i := range stagedFiles {
+		s := &stagedFiles[i]
+		if _, err := os.Stat(s.target); err == nil {
+			s.backup = s.tmp + ".bak"
+			if err := os.Rename(s.target, s.backup); err != nil {
+				s.backup = ""
+				rollback(i)
+				return fmt.Errorf("failed to replace %s: %w", s.target, err)
+			}
+		}
+		if err := os.Rename(s.tmp, s.target); err != nil {
+			if s.backup != "" {
+				os.Rename(s.backup, s.target)
+				s.backup = ""
+			}
+			rollback(i)
+			return fmt.Errorf("failed to install %s: %w", s.target, err)
+		}
+	}
+
+	for _, s := range stagedFiles {
+		if s.backup != "" {
+			os.Remove(s.backup)
+		}
+	}
+	return nil
+}
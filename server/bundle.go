@@ -0,0 +1,126 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// BundleManifest is the index.json stored at the root of an offline bundle
+type BundleManifest struct {
+	Version   string         `json:"version"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Subjects  []exam.Subject `json:"subjects"`
+}
+
+// serveBundle returns a handler that streams a gzipped tar archive of the selected exams with an index manifest.
+// Exams are selected with repeated subject=<path> and exam=<subject>/<name> parameters; without either, all exams are included.
+func serveBundle(store *examStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		subjects = access.filter(r, q.Get("user"), subjects)
+		version := store.recordSnapshot(subjects)
+
+		wantSubjects, wantExams := q["subject"], q["exam"]
+		if len(wantSubjects) > 0 || len(wantExams) > 0 {
+			subjects = exam.FilterExams(subjects, func(subject string, e exam.ExamFile) bool {
+				for _, s := range wantSubjects {
+					if subject == s || strings.HasPrefix(subject, s+"/") {
+						return true
+					}
+				}
+				for _, ref := range wantExams {
+					if ref == subject+"/"+e.Name || ref == subject+"/"+strings.TrimSuffix(e.Name, path.Ext(e.Name)) {
+						return true
+					}
+				}
+				return false
+			})
+			if len(subjects) == 0 {
+				http.Error(w, "No exams match the selection", http.StatusNotFound)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="exams-`+version+`.tar.gz"`)
+		w.Header().Set("X-Catalog-Version", version)
+
+		// Headers are sent with the first write, so later failures can only be logged
+		if err := writeBundle(w, subjects, version); err != nil {
+			log.Printf("Failed to write exam bundle: %v", err)
+		}
+	}
+}
+
+// writeBundle writes the exams of subjects as exams/<subject>/<name> plus an index.json manifest to a gzipped tar stream
+func writeBundle(w http.ResponseWriter, subjects []exam.Subject, version string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now().UTC()
+
+	var werr error
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		if werr != nil {
+			return
+		}
+		data, err := json.Marshal(exam.Document(e.Meta, e.Content))
+		if err != nil {
+			werr = fmt.Errorf("failed to encode exam %s/%s: %w", subject, e.Name, err)
+			return
+		}
+		werr = writeTarFile(tw, path.Join("exams", subject, e.Name), data, e.ModTime)
+	})
+	if werr != nil {
+		return werr
+	}
+
+	// The manifest lists the same tree without the question payload
+	manifest := BundleManifest{
+		Version:   version,
+		CreatedAt: now,
+		Subjects:  mapExamContent(subjects, func(any) any { return nil }),
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	if err := writeTarFile(tw, "index.json", data, now); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeTarFile adds a regular file to a tar stream
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// cachedResponse is an encoded response body with the headers that depend on it
+type cachedResponse struct {
+	body    []byte
+	version string    // X-Catalog-Version of the listing
+	modTime time.Time // Last-Modified of the listing
+}
+
+// sharedResponse is a cached response as kept in Redis
+type sharedResponse struct {
+	Body    []byte    `json:"body"`
+	Version string    `json:"version"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// cacheCall is a response being built or built already
+type cacheCall struct {
+	done       chan struct{} // closed once resp and err are set
+	resp       cachedResponse
+	err        error
+	generation uint64 // exam store generation the response was built from
+
+	// Set under the cache lock once the build has finished
+	finished bool
+	expires  time.Time
+}
+
+// responseCache keeps built responses for ttl and makes concurrent requests for the same key wait for a single
+// build, so a burst of identical requests reads the exam directory once. A zero ttl still shares builds in flight
+// but keeps nothing afterwards. Failed builds are never kept. With a Redis shared by replicas, responses are also
+// kept there for ttl so one replica builds what the others serve.
+type responseCache struct {
+	ttl    time.Duration
+	shared *redisClient
+
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// newResponseCache creates a cache keeping responses for ttl, in shared as well unless it is nil
+func newResponseCache(ttl time.Duration, shared *redisClient) *responseCache {
+	return &responseCache{ttl: ttl, shared: shared, calls: map[string]*cacheCall{}}
+}
+
+// shareBuild returns build wrapped to first look for the response in the shared cache under key, and to keep
+// what it builds there. Responses are only shared when the cache keeps them, and a shared cache that cannot be
+// reached just leaves every replica building its own.
+func (c *responseCache) shareBuild(key string, build func() (cachedResponse, error)) func() (cachedResponse, error) {
+	if c.shared == nil || c.ttl <= 0 {
+		return build
+	}
+	key = c.shared.key("exams", key)
+	return func() (cachedResponse, error) {
+		data, err := c.shared.Get(key)
+		if err == nil {
+			var resp sharedResponse
+			if err := json.Unmarshal(data, &resp); err == nil {
+				return cachedResponse{body: resp.Body, version: resp.Version, modTime: resp.ModTime}, nil
+			}
+		} else if !errors.Is(err, errRedisNil) {
+			log.Printf("Failed to read shared response cache: %v", err)
+		}
+
+		resp, err := build()
+		if err != nil {
+			return resp, err
+		}
+		data, err = json.Marshal(sharedResponse{Body: resp.body, Version: resp.version, ModTime: resp.modTime})
+		if err == nil {
+			err = c.shared.Set(key, data, c.ttl)
+		}
+		if err != nil {
+			log.Printf("Failed to write shared response cache: %v", err)
+		}
+		return resp, nil
+	}
+}
+
+// Do returns the response for key: the one cached from the current generation of the exam content if it has not
+// expired, the one being built by another request, or else a new one from build
+func (c *responseCache) Do(key string, generation uint64, build func() (cachedResponse, error)) (cachedResponse, error) {
+	c.mu.Lock()
+	now := time.Now()
+	if call, ok := c.calls[key]; ok && call.generation == generation && (!call.finished || now.Before(call.expires)) {
+		c.mu.Unlock()
+		<-call.done
+		return call.resp, call.err
+	}
+	// Stale responses of other keys are dropped along the way so the cache does not grow without bound
+	for k, old := range c.calls {
+		if old.finished && (!now.Before(old.expires) || old.generation != generation) {
+			delete(c.calls, k)
+		}
+	}
+	call := &cacheCall{done: make(chan struct{}), generation: generation}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.resp, call.err = build()
+
+	c.mu.Lock()
+	call.finished = true
+	call.expires = time.Now().Add(c.ttl)
+	if call.err != nil && c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.resp, call.err
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Settings of the item response calibration
+const (
+	minCalibrationResponses = 30   // attempts a question needs before its parameters are estimated
+	calibrationIterations   = 100  // rounds of the joint estimation at most
+	calibrationTolerance    = 1e-4 // change of every parameter below which the estimation has converged
+	abilityRange            = 4.0  // abilities and difficulties are kept within ±abilityRange
+)
+
+// Difficulty bands of calibrated questions for ?difficulty=, on the ability scale
+var difficultyBands = map[string][2]float64{
+	"easy":   {-abilityRange, -0.5},
+	"medium": {-0.5, 0.5},
+	"hard":   {0.5, abilityRange},
+}
+
+// ItemParams are the estimated parameters of a question under the two-parameter logistic model, where a person
+// of ability θ answers it right with probability 1 / (1 + e^(-Discrimination·(θ - Difficulty))). Abilities are
+// scaled to a mean of 0 and a standard deviation of 1 across the attempts at the exam.
+type ItemParams struct {
+	Difficulty     float64 `json:"difficulty"`
+	Discrimination float64 `json:"discrimination"`
+	Responses      int     `json:"responses"` // attempts the parameters were estimated from
+}
+
+// probability returns the probability that a person of ability theta answers the question right
+func (p ItemParams) probability(theta float64) float64 {
+	return 1 / (1 + math.Exp(-p.Discrimination*(theta-p.Difficulty)))
+}
+
+// information returns the Fisher information of the question at ability theta, how much an answer to it tells
+// about a person of that ability
+func (p ItemParams) information(theta float64) float64 {
+	prob := p.probability(theta)
+	return p.Discrimination * p.Discrimination * prob * (1 - prob)
+}
+
+// calibrationStore keeps the parameters estimated by the calibration job by question identifier in a JSON file
+type calibrationStore struct {
+	path string
+
+	mu    sync.RWMutex
+	items map[string]ItemParams
+}
+
+// calibrationFile is the content of the calibration file
+type calibrationFile struct {
+	FittedAt time.Time             `json:"fittedAt,omitzero"`
+	Items    map[string]ItemParams `json:"items"`
+}
+
+// openCalibrationStore loads the question parameters saved at path
+func openCalibrationStore(path string) (*calibrationStore, error) {
+	s := &calibrationStore{path: path, items: map[string]ItemParams{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read calibration: %w", err)
+	}
+	var f calibrationFile
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse calibration: %w", err)
+	}
+	if f.Items != nil {
+		s.items = f.Items
+	}
+	return s, nil
+}
+
+// Replace saves the parameters of a new calibration in place of the previous one
+func (s *calibrationStore) Replace(items map[string]ItemParams, at time.Time) error {
+	data, err := json.MarshalIndent(calibrationFile{FittedAt: at, Items: items}, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := storage.WriteFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.items = items
+	return nil
+}
+
+// Item returns the parameters of a question, if it is calibrated
+func (s *calibrationStore) Item(id string) (ItemParams, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.items[id]
+	return p, ok
+}
+
+// examQuestions are the identifiers of the questions of an exam, with the subject/name of the exam
+type examQuestions struct {
+	exam string
+	ids  []string
+}
+
+// examQuestionIDs maps both the identifier and the subject/name of every exam to its questions, so attempts
+// can be matched to questions whichever way they name their exam
+func examQuestionIDs(subjects []exam.Subject) map[string]examQuestions {
+	out := map[string]examQuestions{}
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		questions := exam.WithQuestionIDs(exam.Questions(e.Content), subject, e.Name)
+		eq := examQuestions{exam: subject + "/" + e.Name, ids: make([]string, len(questions))}
+		for i, item := range questions {
+			if q, ok := item.(map[string]any); ok {
+				eq.ids[i], _ = q["id"].(string)
+			}
+		}
+		out[eq.exam] = eq
+		if e.ID != "" {
+			out[e.ID] = eq
+		}
+	})
+	return out
+}
+
+// attemptQuestions returns the questions of the exam of an attempt, or false if the exam is gone or no longer
+// has the number of questions the attempt answered
+func attemptQuestions(exams map[string]examQuestions, a Attempt) (examQuestions, bool) {
+	eq, ok := exams[a.ExamID]
+	if !ok || a.ExamID == "" {
+		eq, ok = exams[a.Subject+"/"+a.Exam]
+	}
+	return eq, ok && len(eq.ids) == len(a.Correct) && len(eq.ids) > 0
+}
+
+// calibrate estimates the parameters of every question answered in enough attempts and saves them, replacing
+// the previous calibration. Each exam is estimated on its own, with every attempt at it as a person.
+func calibrate(ctx context.Context, store *examStore, attempts *attemptStore, cal *calibrationStore, now time.Time) error {
+	subjects, err := store.Subjects(ctx)
+	if err != nil {
+		return err
+	}
+	exams := examQuestionIDs(subjects)
+	byExam := map[string][][]bool{}
+	for _, a := range attempts.List(nil) {
+		if eq, ok := attemptQuestions(exams, a); ok {
+			byExam[eq.exam] = append(byExam[eq.exam], a.Correct)
+		}
+	}
+
+	items := map[string]ItemParams{}
+	for key, responses := range byExam {
+		if len(responses) < minCalibrationResponses {
+			continue
+		}
+		for i, p := range fit2PL(responses) {
+			if id := exams[key].ids[i]; id != "" {
+				items[id] = p
+			}
+		}
+	}
+	if err := cal.Replace(items, now); err != nil {
+		return fmt.Errorf("failed to save calibration: %w", err)
+	}
+	log.Printf("Calibrated %d question(s) of %d exam(s) with attempts", len(items), len(byExam))
+	return nil
+}
+
+// fit2PL estimates the parameters of the two-parameter logistic model from the responses of persons (rows) to
+// questions (columns) by joint maximum likelihood, alternating Newton steps on the abilities and on the
+// question parameters.
+func fit2PL(responses [][]bool) []ItemParams {
+	persons, questions := len(responses), len(responses[0])
+	logit := func(p float64) float64 {
+		p = min(0.99, max(0.01, p))
+		return math.Log(p / (1 - p))
+	}
+	clamp := func(v, lo, hi float64) float64 { return min(hi, max(lo, v)) }
+
+	theta := make([]float64, persons)
+	for j, row := range responses {
+		right := 0
+		for _, y := range row {
+			if y {
+				right++
+			}
+		}
+		theta[j] = logit(float64(right) / float64(questions))
+	}
+	items := make([]ItemParams, questions)
+	for i := range items {
+		right := 0
+		for _, row := range responses {
+			if row[i] {
+				right++
+			}
+		}
+		items[i] = ItemParams{Difficulty: -logit(float64(right) / float64(persons)), Discrimination: 1, Responses: persons}
+	}
+
+	for range calibrationIterations {
+		change := 0.0
+		for j, row := range responses {
+			var gradient, curvature float64
+			for i, p := range items {
+				prob := p.probability(theta[j])
+				gradient += p.Discrimination * (boolFloat(row[i]) - prob)
+				curvature += p.information(theta[j])
+			}
+			step := clamp(gradient/(curvature+1e-9), -1, 1)
+			theta[j] = clamp(theta[j]+step, -abilityRange, abilityRange)
+		}
+		standardize(theta)
+
+		for i := range items {
+			p := &items[i]
+			var gradA, curvA, gradB, curvB float64
+			for j, row := range responses {
+				prob := p.probability(theta[j])
+				residual, spread := boolFloat(row[i])-prob, prob*(1-prob)
+				gradA += residual * (theta[j] - p.Difficulty)
+				curvA += spread * (theta[j] - p.Difficulty) * (theta[j] - p.Difficulty)
+				gradB -= residual * p.Discrimination
+				curvB += spread * p.Discrimination * p.Discrimination
+			}
+			// Weak priors of N(1, 1) on the discrimination and N(0, 2²) on the difficulty keep the estimates of
+			// exams with few questions or attempts from running off to the bounds
+			gradA, curvA = gradA-(p.Discrimination-1), curvA+1
+			gradB, curvB = gradB-p.Difficulty/4, curvB+0.25
+			stepA := clamp(gradA/curvA, -1, 1)
+			stepB := clamp(gradB/curvB, -1, 1)
+			nextA := clamp(p.Discrimination+stepA, 0.2, 4)
+			nextB := clamp(p.Difficulty+stepB, -abilityRange, abilityRange)
+			change = max(change, math.Abs(nextA-p.Discrimination), math.Abs(nextB-p.Difficulty))
+			p.Discrimination, p.Difficulty = nextA, nextB
+		}
+		if change < calibrationTolerance {
+			break
+		}
+	}
+	return items
+}
+
+// standardize rescales values in place to a mean of 0 and a standard deviation of 1
+func standardize(values []float64) {
+	var mean, variance float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	sd := math.Sqrt(variance / float64(len(values)))
+	for i := range values {
+		values[i] -= mean
+		if sd > 0 {
+			values[i] /= sd
+		}
+	}
+}
+
+// boolFloat returns 1 for true and 0 for false
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// estimateAbility returns the expected ability of a person given whether they answered calibrated questions
+// right, under a standard normal prior, so a person with no answers has an ability of 0
+func estimateAbility(items []ItemParams, correct []bool) float64 {
+	var weighted, total float64
+	for theta := -abilityRange; theta <= abilityRange; theta += 0.1 {
+		logLikelihood := -theta * theta / 2
+		for i, p := range items {
+			prob := p.probability(theta)
+			if correct[i] {
+				logLikelihood += math.Log(prob)
+			} else {
+				logLikelihood += math.Log(1 - prob)
+			}
+		}
+		w := math.Exp(logLikelihood)
+		weighted += theta * w
+		total += w
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+// userAbility estimates the ability of a user from their latest answer to every calibrated question
+func userAbility(subjects []exam.Subject, attempts *attemptStore, cal *calibrationStore, user string) float64 {
+	exams := examQuestionIDs(subjects)
+	latest := map[string]bool{}
+	// Attempts are listed oldest first, so later answers overwrite earlier ones
+	for _, a := range attempts.List(func(a Attempt) bool { return a.UserID == user }) {
+		eq, _ := attemptQuestions(exams, a)
+		for i, id := range eq.ids {
+			if i < len(a.Correct) {
+				latest[id] = a.Correct[i]
+			}
+		}
+	}
+	var items []ItemParams
+	var correct []bool
+	for id, right := range latest {
+		if p, ok := cal.Item(id); ok {
+			items = append(items, p)
+			correct = append(correct, right)
+		}
+	}
+	return estimateAbility(items, correct)
+}
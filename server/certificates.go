@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Certificate records a passed attempt; Token is the certificate signed as an RS256 JWT
+type Certificate struct {
+	ID        string    `json:"id"`
+	AttemptID string    `json:"attemptId"`
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	Exam      string    `json:"exam"`
+	Score     int       `json:"score"`
+	Total     int       `json:"total"`
+	Percent   float64   `json:"percent"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	Token     string    `json:"token,omitempty"`
+}
+
+// certificateStore issues certificates for passed attempts and keeps them in a JSON file
+type certificateStore struct {
+	path string
+	key  *rsa.PrivateKey
+	kid  string
+
+	mu        sync.RWMutex
+	certs     map[string]Certificate
+	byAttempt map[string]string
+}
+
+// openCertificateStore loads the certificates saved at path, signing new ones with the RSA key at keyPath
+func openCertificateStore(path, keyPath string) (*certificateStore, error) {
+	key, err := loadSigningKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	s := &certificateStore{path: path, key: key, kid: hex.EncodeToString(sum[:8]), certs: map[string]Certificate{}, byAttempt: map[string]string{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read certificates: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.certs); err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	for id, c := range s.certs {
+		s.byAttempt[c.AttemptID] = id
+	}
+	return s, nil
+}
+
+// attemptRecorded issues a certificate when an attempt meets the passing score of its exam
+func (s *certificateStore) attemptRecorded(a Attempt) {
+	if a.Passed == nil || !*a.Passed || a.UserID == "" {
+		return
+	}
+	c := Certificate{
+		ID:        newID(),
+		AttemptID: a.ID,
+		Name:      a.UserID,
+		Subject:   a.Subject,
+		Exam:      assignmentName(a),
+		Score:     a.Score,
+		Total:     a.Total,
+		Percent:   a.Percent,
+		IssuedAt:  a.SubmittedAt,
+	}
+	token, err := signJWT(s.key, s.kid, c)
+	if err != nil {
+		log.Printf("Failed to sign certificate for attempt %s: %v", a.ID, err)
+		return
+	}
+	c.Token = token
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs[c.ID] = c
+	s.byAttempt[a.ID] = c.ID
+	data, err := json.MarshalIndent(s.certs, "", "  ")
+	if err == nil {
+		err = storage.WriteFileAtomic(s.path, data)
+	}
+	if err != nil {
+		log.Printf("Failed to save certificate for attempt %s: %v", a.ID, err)
+	}
+}
+
+// Get returns a certificate by its ID
+func (s *certificateStore) Get(id string) (Certificate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.certs[id]
+	return c, ok
+}
+
+// ForAttempt returns the certificate issued for an attempt
+func (s *certificateStore) ForAttempt(attemptID string) (Certificate, bool) {
+	s.mu.RLock()
+	id, ok := s.byAttempt[attemptID]
+	s.mu.RUnlock()
+	if !ok {
+		return Certificate{}, false
+	}
+	return s.Get(id)
+}
+
+// ByUser returns the certificates issued to a user, oldest first
+func (s *certificateStore) ByUser(user string) []Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []Certificate{}
+	for _, c := range s.certs {
+		if c.Name == user {
+			out = append(out, c)
+		}
+	}
+	slices.SortFunc(out, func(a, b Certificate) int { return cmp.Or(a.IssuedAt.Compare(b.IssuedAt), cmp.Compare(a.ID, b.ID)) })
+	return out
+}
+
+// EraseUser revokes the certificates issued to a user, which carry their name, so they no longer verify
+func (s *certificateStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := maps.Clone(s.certs)
+	maps.DeleteFunc(next, func(_ string, c Certificate) bool { return c.Name == user })
+	if len(next) == len(s.certs) {
+		return nil
+	}
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	for id, c := range s.certs {
+		if _, ok := next[id]; !ok {
+			delete(s.byAttempt, c.AttemptID)
+		}
+	}
+	s.certs = next
+	return nil
+}
+
+// Verify reports whether the token of a certificate carries a valid signature matching the stored record
+func (s *certificateStore) Verify(c Certificate) bool {
+	var claims Certificate
+	header, signed, sig, err := parseJWT(c.Token, &claims)
+	if err != nil || header.Alg != "RS256" || verifyRS256(&s.key.PublicKey, signed, sig) != nil {
+		return false
+	}
+	c.Token = ""
+	return claims.ID == c.ID && claims.AttemptID == c.AttemptID && claims.Name == c.Name &&
+		claims.Subject == c.Subject && claims.Exam == c.Exam && claims.Score == c.Score &&
+		claims.Total == c.Total && claims.Percent == c.Percent && claims.IssuedAt.Equal(c.IssuedAt)
+}
+
+// certificateURL returns the absolute URL of a certificate endpoint for the host of r
+func certificateURL(r *http.Request, id, suffix string) string {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + apiPrefix + "/certificates/" + id + suffix
+}
+
+// registerCertificateRoutes adds the certificate download and verification endpoints to api, the group of routes under /api
+func registerCertificateRoutes(api *router, certs *certificateStore) {
+	api.HandleFunc("GET /attempts/{id}/certificate", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := certs.ForAttempt(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "No certificate for this attempt", http.StatusNotFound)
+			return
+		}
+		certs.servePDF(w, r, c)
+	})
+	api.HandleFunc("GET /certificates/{id}", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := certs.Get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+			return
+		}
+		certs.servePDF(w, r, c)
+	})
+	api.HandleFunc("GET /certificates/{id}/verify", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := certs.Get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"valid": certs.Verify(c), "certificate": c})
+	})
+	api.HandleFunc("GET /certificates/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{publicJWK(&certs.key.PublicKey, certs.kid)}})
+	})
+}
+
+// servePDF writes a certificate as a PDF download
+func (s *certificateStore) servePDF(w http.ResponseWriter, r *http.Request, c Certificate) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="certificate-`+c.ID+`.pdf"`)
+	w.Write(certificatePDF(c, certificateURL(r, c.ID, "/verify")))
+}
+
+// pdfText escapes s as a PDF literal string, replacing characters outside Latin-1
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		case r >= 0x80:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// certificatePDF renders a one-page landscape A4 certificate with a link to its verification URL
+func certificatePDF(c Certificate, verifyURL string) []byte {
+	const width, height = 842, 595
+	lines := []struct {
+		font string
+		size int
+		y    int
+		text string
+	}{
+		{"F2", 36, 430, "Certificate of Completion"},
+		{"F1", 16, 380, "This certifies that"},
+		{"F2", 28, 335, c.Name},
+		{"F1", 16, 290, "passed the exam"},
+		{"F2", 22, 250, c.Exam},
+		{"F1", 16, 205, fmt.Sprintf("with a score of %d/%d (%.1f%%) on %s", c.Score, c.Total, c.Percent, c.IssuedAt.Format("January 2, 2006"))},
+		{"F1", 10, 110, "Certificate ID: " + c.ID},
+		{"F1", 10, 94, "Verify at " + verifyURL},
+	}
+
+	var content bytes.Buffer
+	// Double border
+	fmt.Fprintf(&content, "2 w 30 30 %d %d re S 0.5 w 40 40 %d %d re S\n", width-60, height-60, width-80, height-80)
+	for _, l := range lines {
+		// Helvetica averages about half an em per character, which is close enough to centre a line
+		x := max((width-len(l.text)*l.size/2)/2, 50)
+		fmt.Fprintf(&content, "BT /%s %d Tf %d %d Td %s Tj ET\n", l.font, l.size, x, l.y, pdfText(l.text))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R /Annots [7 0 R] >>", width, height),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [50 88 %d 106] /Border [0 0 0] /A << /S /URI /URI %s >> >>", width-50, pdfText(verifyURL)),
+		fmt.Sprintf("<< /Title %s /Subject %s /Creator (Mock Exam) >>", pdfText("Certificate "+c.ID), pdfText(c.Token)),
+	}
+	return pdfDocument(objects)
+}
+
+// pdfDocument assembles numbered PDF objects into a document, the first being the catalog and the last the
+// document information
+func pdfDocument(objects []string) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, len(objects), xref)
+	return out.Bytes()
+}
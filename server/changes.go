@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// maxCatalogHistory is the number of catalog versions remembered for delta sync
+const maxCatalogHistory = 100
+
+// catalogSnapshot records the content hash of every exam at one version of the catalog
+type catalogSnapshot struct {
+	version string
+	at      time.Time
+	exams   map[ExamRef]string
+}
+
+// ExamChange is an added or modified exam in a delta sync response
+type ExamChange struct {
+	Subject string `json:"subject"`
+	exam.ExamFile
+}
+
+// ChangeSet is the response of the delta sync endpoint
+type ChangeSet struct {
+	Version  string       `json:"version"`
+	Complete bool         `json:"complete"`
+	Added    []ExamChange `json:"added"`
+	Modified []ExamChange `json:"modified"`
+	Removed  []ExamRef    `json:"removed"`
+}
+
+// newCatalogSnapshot captures the exams of subjects and derives the catalog version from their hashes
+func newCatalogSnapshot(subjects []exam.Subject, at time.Time) catalogSnapshot {
+	snap := catalogSnapshot{at: at, exams: make(map[ExamRef]string)}
+	var keys []string
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		snap.exams[ExamRef{Subject: subject, Name: e.Name}] = e.SHA256
+		keys = append(keys, subject+"/"+e.Name+"="+e.SHA256)
+	})
+
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{'\n'})
+	}
+	snap.version = hex.EncodeToString(h.Sum(nil))[:16]
+	return snap
+}
+
+// recordSnapshot remembers the catalog version of subjects if it differs from the latest one and returns the version
+func (s *examStore) recordSnapshot(subjects []exam.Subject) string {
+	snap := newCatalogSnapshot(subjects, time.Now())
+
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if n := len(s.history); n > 0 && s.history[n-1].version == snap.version {
+		return snap.version
+	}
+	s.history = append(s.history, snap)
+	if len(s.history) > maxCatalogHistory {
+		s.history = s.history[len(s.history)-maxCatalogHistory:]
+	}
+	return snap.version
+}
+
+// Version returns the catalog version of the content last read, or "" before it is first read
+func (s *examStore) Version() string {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if len(s.history) == 0 {
+		return ""
+	}
+	return s.history[len(s.history)-1].version
+}
+
+// baseSnapshot finds the remembered snapshot matching a since marker, either a catalog version or a timestamp
+func (s *examStore) baseSnapshot(since string) (catalogSnapshot, time.Time, bool) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	for _, snap := range s.history {
+		if snap.version == since {
+			return snap, snap.at, true
+		}
+	}
+
+	at, ok := parseSinceTime(since)
+	if !ok {
+		return catalogSnapshot{}, time.Time{}, false
+	}
+	// The newest snapshot taken at or before the timestamp describes what the client had then
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if !s.history[i].at.After(at) {
+			return s.history[i], at, true
+		}
+	}
+	return catalogSnapshot{}, at, false
+}
+
+// parseSinceTime parses a since marker given as RFC 3339 or Unix seconds
+func parseSinceTime(since string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Changes lists the exams added, modified, and removed since the given marker. When the marker is older
+// than the remembered history, exams modified after it are reported and Complete is false because removals are unknown.
+func (s *examStore) Changes(ctx context.Context, since string) (ChangeSet, error) {
+	subjects, err := s.Subjects(ctx)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	current := s.recordSnapshot(subjects)
+	base, at, ok := s.baseSnapshot(since)
+
+	changes := ChangeSet{
+		Version:  current,
+		Complete: ok,
+		Added:    []ExamChange{},
+		Modified: []ExamChange{},
+		Removed:  []ExamRef{},
+	}
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		ref := ExamRef{Subject: subject, Name: e.Name}
+		change := ExamChange{Subject: subject, ExamFile: e}
+		change.Content = s.publicContent(subject, e)
+		switch {
+		case !ok:
+			// Without a base snapshot everything changed after the timestamp is sent; an unknown version resends all
+			if at.IsZero() || e.ModTime.After(at) {
+				changes.Modified = append(changes.Modified, change)
+			}
+		case base.exams[ref] == "":
+			changes.Added = append(changes.Added, change)
+		case base.exams[ref] != e.SHA256:
+			changes.Modified = append(changes.Modified, change)
+		}
+	})
+	if ok {
+		currentExams := make(map[ExamRef]bool)
+		exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+			currentExams[ExamRef{Subject: subject, Name: e.Name}] = true
+		})
+		for ref := range base.exams {
+			if !currentExams[ref] {
+				changes.Removed = append(changes.Removed, ref)
+			}
+		}
+		sort.Slice(changes.Removed, func(i, j int) bool {
+			a, b := changes.Removed[i], changes.Removed[j]
+			return a.Subject < b.Subject || a.Subject == b.Subject && a.Name < b.Name
+		})
+	}
+	return changes, nil
+}
+
+// serveExamChanges returns a handler for delta sync of the exam catalog
+func serveExamChanges(store *examStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since := r.URL.Query().Get("since")
+		if since == "" {
+			http.Error(w, "Missing \"since\" parameter (catalog version or timestamp)", http.StatusBadRequest)
+			return
+		}
+
+		changes, err := store.Changes(r.Context(), since)
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Catalog-Version", changes.Version)
+		if err := json.NewEncoder(w).Encode(changes); err != nil {
+			http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
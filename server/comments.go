@@ -0,0 +1,276 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// maxCommentLength caps the length of a comment body in bytes
+const maxCommentLength = 4000
+
+var errCommentNotFound = errors.New("comment not found")
+
+// Comment is a post in the discussion of a question; replies name the comment they answer
+type Comment struct {
+	ID         string    `json:"id"`
+	QuestionID string    `json:"questionId"`
+	ParentID   string    `json:"parentId,omitempty"`
+	UserID     string    `json:"userId"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Deleted    bool      `json:"deleted,omitempty"`
+}
+
+// commentThread is a comment with its replies, oldest first
+type commentThread struct {
+	Comment
+	Replies []*commentThread `json:"replies"`
+}
+
+// commentStore keeps the discussions of every question in a JSON file
+type commentStore struct {
+	path string
+
+	mu       sync.RWMutex
+	comments map[string]*Comment
+}
+
+// openCommentStore loads the comments saved at path
+func openCommentStore(path string) (*commentStore, error) {
+	s := &commentStore{path: path, comments: map[string]*Comment{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read comments: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.comments); err != nil {
+		return nil, fmt.Errorf("failed to parse comments: %w", err)
+	}
+	return s, nil
+}
+
+// save writes every comment; the caller holds the lock
+func (s *commentStore) save() error {
+	data, err := json.MarshalIndent(s.comments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// Add posts a comment, checking that the comment it replies to belongs to the same question
+func (s *commentStore) Add(c Comment) (Comment, error) {
+	c.ID = newID()
+	c.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ParentID != "" {
+		if parent := s.comments[c.ParentID]; parent == nil || parent.QuestionID != c.QuestionID {
+			return Comment{}, errCommentNotFound
+		}
+	}
+	s.comments[c.ID] = &c
+	if err := s.save(); err != nil {
+		delete(s.comments, c.ID)
+		return Comment{}, err
+	}
+	return c, nil
+}
+
+// Delete hides the body of a comment while keeping its replies in place. Unless allow is nil, comments it refuses
+// are not found.
+func (s *commentStore) Delete(id string, allow func(Comment) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.comments[id]
+	if c == nil || (allow != nil && !allow(*c)) {
+		return errCommentNotFound
+	}
+	prev := *c
+	c.Deleted, c.Body = true, ""
+	if err := s.save(); err != nil {
+		*c = prev
+		return err
+	}
+	return nil
+}
+
+// ByUser returns the comments a user posted, oldest first
+func (s *commentStore) ByUser(user string) []Comment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []Comment{}
+	for _, c := range s.comments {
+		if c.UserID == user {
+			out = append(out, *c)
+		}
+	}
+	slices.SortFunc(out, func(a, b Comment) int { return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID)) })
+	return out
+}
+
+// EraseUser deletes the comments of a user as Delete does and removes their name from them
+func (s *commentStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var erased []*Comment
+	for _, c := range s.comments {
+		if c.UserID == user {
+			erased = append(erased, c)
+		}
+	}
+	if len(erased) == 0 {
+		return nil
+	}
+	prev := make([]Comment, len(erased))
+	for i, c := range erased {
+		prev[i] = *c
+		c.UserID, c.Body, c.Deleted = "", "", true
+	}
+	if err := s.save(); err != nil {
+		for i, c := range erased {
+			*c = prev[i]
+		}
+		return err
+	}
+	return nil
+}
+
+// Threads returns the discussion of a question as a tree of top-level comments and their replies
+func (s *commentStore) Threads(questionID string) []*commentThread {
+	s.mu.RLock()
+	var list []Comment
+	for _, c := range s.comments {
+		if c.QuestionID == questionID {
+			list = append(list, *c)
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(list, func(a, b Comment) int { return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID)) })
+
+	nodes := make(map[string]*commentThread, len(list))
+	for _, c := range list {
+		nodes[c.ID] = &commentThread{Comment: c, Replies: []*commentThread{}}
+	}
+	roots := []*commentThread{}
+	for _, c := range list {
+		if parent, ok := nodes[c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, nodes[c.ID])
+		} else {
+			roots = append(roots, nodes[c.ID])
+		}
+	}
+	return roots
+}
+
+// inReview reports whether a user may see the discussion of a question: instructors always may for the subjects
+// their token is limited to, test takers once they have submitted the question's exam
+func inReview(r *http.Request, tokens tokenRoles, attempts *attemptStore, user string, p exam.PoolQuestion) bool {
+	if grant := tokens.grantOf(r); slices.Contains(instructorRoles, grant.role) && grant.subjects.allows(p.Subject) {
+		return true
+	}
+	return user != "" && len(attempts.List(func(a Attempt) bool {
+		return a.UserID == user && a.isFor(p.Subject, exam.ExamFile{Name: p.Exam, ID: p.ExamID})
+	})) > 0
+}
+
+// questionComments returns a handler that lists (GET) or posts to (POST) the discussion of a question
+func questionComments(tokens tokenRoles, store *examStore, attempts *attemptStore, comments *commentStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserID   string `json:"userId"`
+			ParentID string `json:"parentId"`
+			Body     string `json:"body"`
+		}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.UserID = r.URL.Query().Get("user")
+		}
+
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, ok := exam.FindQuestion(subjects, r.PathValue("id"))
+		if !ok {
+			http.Error(w, "Question not found", http.StatusNotFound)
+			return
+		}
+		// Discussions would give answers away, so they open only once the exam has been submitted
+		if !inReview(r, tokens, attempts, req.UserID, p) {
+			http.Error(w, "Comments are available after you submit this exam", http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(comments.Threads(p.ID))
+			return
+		}
+		body := strings.TrimSpace(req.Body)
+		if body == "" || len(body) > maxCommentLength {
+			http.Error(w, fmt.Sprintf("Comment must be between 1 and %d characters", maxCommentLength), http.StatusBadRequest)
+			return
+		}
+		if req.UserID == "" {
+			http.Error(w, "Missing userId", http.StatusBadRequest)
+			return
+		}
+		c, err := comments.Add(Comment{QuestionID: p.ID, ParentID: req.ParentID, UserID: req.UserID, Body: body})
+		if errors.Is(err, errCommentNotFound) {
+			http.Error(w, "Parent comment not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save comment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// deleteComment returns a handler that lets instructors remove a comment on a question of their subjects
+func deleteComment(store *examStore, comments *commentStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allow func(Comment) bool
+		if scope := scopeOf(r); scope != nil {
+			subjects, err := store.Subjects(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			allow = func(c Comment) bool {
+				p, ok := exam.FindQuestion(subjects, c.QuestionID)
+				return ok && scope.allows(p.Subject)
+			}
+		}
+		err := comments.Delete(r.PathValue("id"), allow)
+		if errors.Is(err, errCommentNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save comment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// CohortStats summarizes the score distribution of a cohort of attempts
+type CohortStats struct {
+	Label     string            `json:"label"`
+	Attempts  int               `json:"attempts"`
+	Users     int               `json:"users"` // distinct signed-in users
+	Mean      float64           `json:"mean"`
+	Median    float64           `json:"median"`
+	StdDev    float64           `json:"stdDev"`
+	Min       float64           `json:"min"`
+	Max       float64           `json:"max"`
+	PassRate  *float64          `json:"passRate,omitempty"` // of the attempts at exams with a passing score
+	Histogram []HistogramBucket `json:"histogram"`
+}
+
+// CohortComparison compares the score distributions of two cohorts
+type CohortComparison struct {
+	A              CohortStats `json:"a"`
+	B              CohortStats `json:"b"`
+	MeanDifference float64     `json:"meanDifference"`       // mean of B less the mean of A, in points
+	EffectSize     *float64    `json:"effectSize,omitempty"` // Cohen's d of the difference, when both cohorts vary
+}
+
+// cohortStats summarizes the percentages of a cohort of attempts
+func cohortStats(label string, list []Attempt) CohortStats {
+	s := CohortStats{Label: label, Attempts: len(list), Histogram: make([]HistogramBucket, histogramBuckets)}
+	for i := range s.Histogram {
+		s.Histogram[i].From = i * 100 / histogramBuckets
+		s.Histogram[i].To = (i + 1) * 100 / histogramBuckets
+	}
+	if len(list) == 0 {
+		return s
+	}
+
+	percents := make([]float64, len(list))
+	users := map[string]bool{}
+	graded, passed := 0, 0
+	for i, a := range list {
+		percents[i] = a.Percent
+		s.Mean += a.Percent
+		if a.UserID != "" {
+			users[a.UserID] = true
+		}
+		if a.Passed != nil {
+			graded++
+			if *a.Passed {
+				passed++
+			}
+		}
+		s.Histogram[min(int(a.Percent)*histogramBuckets/100, histogramBuckets-1)].Count++
+	}
+	s.Users = len(users)
+	s.Mean /= float64(len(list))
+	for _, p := range percents {
+		s.StdDev += (p - s.Mean) * (p - s.Mean)
+	}
+	s.StdDev = math.Sqrt(s.StdDev / float64(len(list)))
+	slices.Sort(percents)
+	s.Min, s.Max = percents[0], percents[len(percents)-1]
+	s.Median = percents[len(percents)/2]
+	if len(percents)%2 == 0 {
+		s.Median = (percents[len(percents)/2-1] + s.Median) / 2
+	}
+	if graded > 0 {
+		rate := float64(passed) * 100 / float64(graded)
+		s.PassRate = &rate
+	}
+	return s
+}
+
+// compareCohorts compares the statistics of two cohorts
+func compareCohorts(a, b CohortStats) CohortComparison {
+	c := CohortComparison{A: a, B: b, MeanDifference: b.Mean - a.Mean}
+	if a.Attempts > 1 && b.Attempts > 1 {
+		pooled := math.Sqrt((a.StdDev*a.StdDev*float64(a.Attempts) + b.StdDev*b.StdDev*float64(b.Attempts)) / float64(a.Attempts+b.Attempts))
+		if pooled > 0 {
+			d := c.MeanDifference / pooled
+			c.EffectSize = &d
+		}
+	}
+	return c
+}
+
+// attemptVersion returns the version of an exam an attempt answered: the last one the server loaded before it
+// was submitted
+func attemptVersion(revisions []ExamRevision, a Attempt) string {
+	version := ""
+	for _, rev := range revisions {
+		if !rev.SeenAt.After(a.SubmittedAt) {
+			version = rev.SHA256
+		}
+	}
+	return version
+}
+
+// compareAnalytics returns a handler that compares the score distributions of two cohorts: the members of
+// two groups (?groupA=&groupB=), at the exam given by ?subject=&exam= or else at the exams assigned to each group,
+// or the attempts at two versions of an exam (?subject=&exam=&versionA=&versionB=, by content hash or a prefix
+// of it).
+func compareAnalytics(store *examStore, attempts *attemptStore, groups *groupStore, revs *revisionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		// An exam given by name is matched to its attempts however they name it
+		subject, name := q.Get("subject"), q.Get("exam")
+		scope := scopeOf(r)
+		if subject != "" && !scope.allows(subject) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var e exam.ExamFile
+		if subject != "" || name != "" {
+			subjects, err := store.Subjects(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			var ok bool
+			if e, ok = exam.FindExam(subjects, subject, name); !ok {
+				http.Error(w, "Exam not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		var a, b CohortStats
+		switch {
+		case q.Get("groupA") != "" && q.Get("groupB") != "":
+			var cohorts [2]CohortStats
+			for i, id := range []string{q.Get("groupA"), q.Get("groupB")} {
+				g, ok := groups.Group(id)
+				if !ok {
+					http.Error(w, "Group "+id+" not found", http.StatusNotFound)
+					return
+				}
+				list := attempts.List(func(a Attempt) bool {
+					if !slices.Contains(g.Members, a.UserID) || !scope.allows(a.Subject) {
+						return false
+					}
+					if e.Name != "" {
+						return a.isFor(subject, e)
+					}
+					return slices.Contains(g.Exams, ExamRef{Subject: a.Subject, Name: a.Exam})
+				})
+				cohorts[i] = cohortStats("group "+g.Name, list)
+			}
+			a, b = cohorts[0], cohorts[1]
+		case q.Get("versionA") != "" && q.Get("versionB") != "":
+			if e.Name == "" {
+				http.Error(w, "Comparing versions requires subject and exam", http.StatusBadRequest)
+				return
+			}
+			revisions := revs.List(subject, e.Name)
+			var cohorts [2]CohortStats
+			for i, sha := range []string{q.Get("versionA"), q.Get("versionB")} {
+				rev, ok := revs.Find(subject, e.Name, sha)
+				if !ok {
+					http.Error(w, "Version "+sha+" not found", http.StatusNotFound)
+					return
+				}
+				list := attempts.List(func(a Attempt) bool {
+					return a.isFor(subject, e) && attemptVersion(revisions, a) == rev.SHA256
+				})
+				cohorts[i] = cohortStats("version "+rev.SHA256[:7], list)
+			}
+			a, b = cohorts[0], cohorts[1]
+		default:
+			http.Error(w, "Give either groupA and groupB or versionA and versionB", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(compareCohorts(a, b))
+	}
+}
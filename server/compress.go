@@ -0,0 +1,179 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultGzipMinSize is the response size below which compression saves too little to be worth it
+const defaultGzipMinSize = 1024
+
+// incompressibleTypes are content type prefixes of formats that are compressed already, so gzipping them
+// only costs CPU time
+var incompressibleTypes = []string{
+	"image/", "audio/", "video/", "font/woff",
+	"application/gzip", "application/x-gzip", "application/zip", "application/pdf",
+	"application/vnd.openxmlformats-officedocument.", // XLSX and other zip based office formats
+}
+
+// compressible reports whether responses of contentType benefit from gzip
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return true
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressor gzips the responses of clients accepting it, leaving out small responses and
+// already compressed content types
+type compressor struct {
+	minSize int
+	writers *sync.Pool // gzip writers at the configured level, reused because each one allocates large buffers
+}
+
+// newCompressor checks the gzip level, 1 (fastest) to 9 (smallest) or 0 for the default of 6, and fills in the
+// default minimum size when minSize is not positive
+func newCompressor(level, minSize int) (compressor, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return compressor{}, fmt.Errorf("invalid gzip level %d (expected 1 to 9, or 0 for the default)", level)
+	}
+	if minSize <= 0 {
+		minSize = defaultGzipMinSize
+	}
+	writers := &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, level)
+		return gz
+	}}
+	return compressor{minSize: minSize, writers: writers}, nil
+}
+
+// middleware compresses the responses of next
+func (c compressor) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// Byte ranges refer to the uncompressed content, so they are served as they are
+		if !acceptsGzip(r) || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, compressor: c, status: http.StatusOK}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it knows whether to compress it: once the body
+// reaches the minimum size, it is flushed, or the handler returns
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	compressor
+
+	status      int
+	wroteHeader bool // the handler called WriteHeader
+	decided     bool // the header has been sent, compressed or not
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.decided {
+		return
+	}
+	w.status, w.wroteHeader = status, true
+	// Responses without a body, and bodies encoded by the handler, are passed on as they are
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		w.Header().Get("Content-Encoding") != "" {
+		w.decide(false)
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) >= w.minSize {
+			w.decide(true)
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing the body if compress is set and its content type benefits from it,
+// followed by the part of the body held back so far
+func (w *gzipResponseWriter) decide(compress bool) {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// Sniff the type from the held back body, as net/http would
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.status)
+		w.gz = w.writers.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+		w.gz.Write(w.buf)
+	} else {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+}
+
+// Flush sends what has been written so far, compressing it if the content type benefits from it
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Close sends a response that stayed below the minimum size uncompressed and finishes a compressed one,
+// returning its gzip writer to the pool
+func (w *gzipResponseWriter) Close() {
+	if !w.decided && (w.wroteHeader || len(w.buf) > 0) {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		// The pooled writer must not keep the finished response alive
+		w.gz.Reset(io.Discard)
+		w.writers.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
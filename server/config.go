@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevels maps the log levels of the configuration file to slog levels
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// runtimeConfig holds the settings of the configuration file, which can be reloaded without restarting the server
+type runtimeConfig struct {
+	LogLevel     string             `json:"logLevel"`
+	RateLimit    rateLimitConfig    `json:"rateLimit"`
+	CORS         corsConfig         `json:"cors"`
+	Availability availabilityConfig `json:"availability"`
+	Debug        debugConfig        `json:"debug"`
+	Jobs         jobsConfig         `json:"jobs"`
+	Retention    retentionConfig    `json:"retention"`
+
+	// CacheControl maps route patterns, such as /api/v1/exams or /api/v1/exams/{subject}/{exam}/variant, to the
+	// Cache-Control header of their successful responses
+	CacheControl map[string]string `json:"cacheControl"`
+
+	// Timeouts maps route patterns to how long their handlers may run, such as "5s" for /api/v1/exams or "30s"
+	// for /api/v1/admin/exams/bulk, before the request is answered with 503
+	Timeouts map[string]string `json:"timeouts"`
+	timeouts map[string]time.Duration
+}
+
+// rateLimitConfig limits the API requests of each client address; a zero rate disables the limit
+type rateLimitConfig struct {
+	RequestsPerMinute int `json:"requestsPerMinute"`
+	Burst             int `json:"burst"` // defaults to the per-minute rate
+}
+
+// corsConfig lists the origins allowed to call the API from a browser; "*" allows any origin
+type corsConfig struct {
+	AllowedOrigins []string `json:"allowedOrigins"`
+	MaxAgeSeconds  int      `json:"maxAgeSeconds"`
+}
+
+// availabilityConfig takes the API or single exams offline
+type availabilityConfig struct {
+	Maintenance bool     `json:"maintenance"` // answer every non-admin API request with 503
+	Message     string   `json:"message"`
+	ClosedExams []string `json:"closedExams"` // <subject>/<exam file name> patterns that cannot be started
+}
+
+// debugConfig opts in to the profiling endpoints under /debug/, which are only open to the admin token
+type debugConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// readRuntimeConfig reads and checks the configuration file; an empty file name gives the defaults
+func readRuntimeConfig(file string) (runtimeConfig, error) {
+	cfg := runtimeConfig{LogLevel: "info"}
+	if file == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	// Unknown fields are rejected so a misspelled setting does not go unnoticed
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if _, ok := logLevels[cfg.LogLevel]; !ok {
+		return cfg, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", cfg.LogLevel)
+	}
+	if cfg.RateLimit.RequestsPerMinute < 0 || cfg.RateLimit.Burst < 0 {
+		return cfg, fmt.Errorf("rate limit must not be negative")
+	}
+	for route, directives := range cfg.CacheControl {
+		if !strings.HasPrefix(route, "/") {
+			return cfg, fmt.Errorf("invalid cache control route %q (expected a path pattern starting with /)", route)
+		}
+		if strings.ContainsAny(directives, "\r\n") {
+			return cfg, fmt.Errorf("invalid cache control directives for %s", route)
+		}
+	}
+	if cfg.timeouts, err = parseTimeouts(cfg.Timeouts); err != nil {
+		return cfg, err
+	}
+	for name, job := range map[string]jobConfig{"analytics": cfg.Jobs.Analytics, "gitSync": cfg.Jobs.GitSync, "sessionSweep": cfg.Jobs.SessionSweep, "backup": cfg.Jobs.Backup, "retention": cfg.Jobs.Retention, "similarity": cfg.Jobs.Similarity, "calibration": cfg.Jobs.Calibration, "parquet": cfg.Jobs.Parquet, "publish": cfg.Jobs.Publish} {
+		if err := job.check(name); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.Retention.AnonymousSessionDays < 0 || cfg.Retention.SessionDays < 0 || cfg.Retention.ResultDays < 0 {
+		return cfg, fmt.Errorf("retention days must not be negative")
+	}
+	for _, pattern := range cfg.Availability.ClosedExams {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return cfg, fmt.Errorf("invalid closed exam pattern %q: %w", pattern, err)
+		}
+	}
+	return cfg, nil
+}
+
+// liveConfig applies the configuration file to the running server and reloads it on request
+type liveConfig struct {
+	path    string
+	level   *slog.LevelVar
+	limiter *rateLimiter
+	store   *examStore
+
+	mu  sync.RWMutex
+	cfg runtimeConfig
+}
+
+// newLiveConfig loads the configuration file, setting level to its log level if level is not nil
+func newLiveConfig(file string, level *slog.LevelVar, store *examStore) (*liveConfig, error) {
+	cfg, err := readRuntimeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if level == nil {
+		level = new(slog.LevelVar)
+	}
+	c := &liveConfig{path: file, level: level, limiter: newRateLimiter(), store: store}
+	c.apply(cfg)
+	return c, nil
+}
+
+// apply makes cfg the current configuration
+func (c *liveConfig) apply(cfg runtimeConfig) {
+	c.level.Set(logLevels[cfg.LogLevel])
+	c.limiter.configure(cfg.RateLimit)
+	c.store.SetClosed(cfg.Availability.ClosedExams)
+	c.mu.Lock()
+	c.cfg = cfg
+	c.mu.Unlock()
+}
+
+// current returns the configuration in effect
+func (c *liveConfig) current() runtimeConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cfg
+}
+
+// Reload re-reads the configuration file, keeping the current configuration if it is invalid
+func (c *liveConfig) Reload() (runtimeConfig, error) {
+	cfg, err := readRuntimeConfig(c.path)
+	if err != nil {
+		return c.current(), err
+	}
+	c.apply(cfg)
+	log.Printf("Reloaded configuration from %s", c.path)
+	return cfg, nil
+}
+
+// logRequests logs every request at debug level
+func (c *liveConfig) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors lets the configured origins call the API from a browser and answers their preflight requests
+func (c *liveConfig) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := c.current().CORS
+		origin := r.Header.Get("Origin")
+		if origin != "" && (slices.Contains(cfg.AllowedOrigins, "*") || slices.Contains(cfg.AllowedOrigins, origin)) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				if cfg.MaxAgeSeconds > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAgeSeconds))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenance answers requests with 503 while the server is in maintenance mode
+func (c *liveConfig) maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := c.current().Availability
+		if cfg.Maintenance {
+			msg := cfg.Message
+			if msg == "" {
+				msg = "The server is under maintenance, please try again later"
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// debugEndpoints hides the debug endpoints unless they are enabled in the configuration file
+func (c *liveConfig) debugEndpoints(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.current().Debug.Enabled {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheControl sets the Cache-Control header configured for the route of a request, unless the handler chose
+// one itself. Error responses are left without it so caches do not hold on to them.
+func (c *liveConfig) cacheControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The route pattern is known here, since the middleware of a route runs after routing
+		directives := c.current().CacheControl[routePattern(r)]
+		if directives == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, directives: directives}, r)
+	})
+}
+
+// cacheControlWriter adds Cache-Control to a response once its status shows it succeeded
+type cacheControlWriter struct {
+	http.ResponseWriter
+	directives  string
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if (status < http.StatusMultipleChoices || status == http.StatusNotModified) && h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", w.directives)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// rateLimit answers requests with 429 once their client has used up its rate limit
+func (c *liveConfig) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait, ok := c.limiter.allow(clientAddr(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientAddr returns the IP address a request came from
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateBucket is the token bucket of one client
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client address, in Redis when replicas share one so a client has one
+// limit across all of them
+type rateLimiter struct {
+	shared *redisClient // set before the first request, if replicas share a Redis
+
+	mu      sync.Mutex
+	rate    float64 // tokens per second; zero disables the limit
+	burst   float64
+	buckets map[string]*rateBucket
+}
+
+// rateBucketScript takes a token from the bucket kept in the hash KEYS[1], refilled at ARGV[1] tokens per second
+// up to ARGV[2] since the time in milliseconds ARGV[3], and returns whether it could with the tokens left.
+// Buckets expire once they would have refilled completely.
+const rateBucketScript = `
+local rate, burst, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(b[1]) or burst, tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`
+
+// newRateLimiter creates a limiter that lets every request through until it is configured
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*rateBucket{}}
+}
+
+// configure changes the limit; clients start over with a full bucket
+func (l *rateLimiter) configure(cfg rateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(cfg.RequestsPerMinute) / 60
+	l.burst = float64(cmp.Or(cfg.Burst, cfg.RequestsPerMinute))
+	clear(l.buckets)
+}
+
+// allow takes a token from the bucket of client, or returns how long until one is available. The shared
+// bucket is used when there is one; the local one takes over while Redis cannot be reached. l.mu only guards
+// the settings and the local buckets, so requests do not wait on each other's Redis round trips.
+func (l *rateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	rate, burst := l.rate, l.burst
+	l.mu.Unlock()
+	if rate == 0 {
+		return 0, true
+	}
+	if l.shared != nil {
+		wait, ok, err := l.allowShared(client, rate, burst, now)
+		if err == nil {
+			return wait, ok
+		}
+		log.Printf("Failed to use the shared rate limit, limiting locally: %v", err)
+	}
+	return l.allowLocal(client, now)
+}
+
+// allowLocal takes a token from the bucket of client kept in memory
+func (l *rateLimiter) allowLocal(client string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0, true
+	}
+	b := l.buckets[client]
+	if b == nil {
+		// Buckets that have refilled completely are equivalent to new ones and can be dropped
+		if len(l.buckets) >= 10000 {
+			for addr, old := range l.buckets {
+				if old.tokens+now.Sub(old.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, addr)
+				}
+			}
+		}
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// allowShared takes a token from the bucket of client kept in Redis, refilled at rate up to burst. The script
+// runs atomically in Redis, so no lock is held around it.
+func (l *rateLimiter) allowShared(client string, rate, burst float64, now time.Time) (time.Duration, bool, error) {
+	reply, err := l.shared.do("EVAL", rateBucketScript, "1", l.shared.key("ratelimit", client),
+		strconv.FormatFloat(rate, 'g', -1, 64), strconv.FormatFloat(burst, 'g', -1, 64), strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return 0, false, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return 0, false, fmt.Errorf("unexpected reply %v to the rate limit script", reply)
+	}
+	allowed, _ := items[0].(int64)
+	tokensText, _ := items[1].([]byte)
+	tokens, err := strconv.ParseFloat(string(tokensText), 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("unexpected tokens %q from the rate limit script", tokensText)
+	}
+	if allowed == 0 {
+		return time.Duration((1 - tokens) / rate * float64(time.Second)), false, nil
+	}
+	return 0, true, nil
+}
+
+// serveConfig returns a handler that returns the configuration in effect
+func (c *liveConfig) serveConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.current())
+}
+
+// reload is the handler of the admin endpoint that reloads the configuration file
+func (c *liveConfig) reload(w http.ResponseWriter, r *http.Request) {
+	cfg, err := c.Reload()
+	if err != nil {
+		http.Error(w, "Failed to reload configuration: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
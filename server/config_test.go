@@ -0,0 +1,39 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogLevelFollowsConfigFile(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"logLevel": "warn"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	level := new(slog.LevelVar)
+	s := newTestServer(t, Config{ConfigFile: file, LogLevel: level}, nil)
+	if slog.Default() != logger {
+		t.Error("creating a server replaced the default logger")
+	}
+	if level.Level() != slog.LevelWarn {
+		t.Errorf("log level = %v, want %v", level.Level(), slog.LevelWarn)
+	}
+
+	if err := os.WriteFile(file, []byte(`{"logLevel": "debug"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if level.Level() != slog.LevelDebug {
+		t.Errorf("log level after reload = %v, want %v", level.Level(), slog.LevelDebug)
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// dailyDateLayout is the format of the UTC date a daily challenge belongs to
+const dailyDateLayout = "2006-01-02"
+
+// redactPool strips the answer key from pooled questions
+func redactPool(pool []exam.PoolQuestion) []exam.PoolQuestion {
+	out := make([]exam.PoolQuestion, len(pool))
+	for i, p := range pool {
+		out[i] = p
+		out[i].Question = exam.Questions(redactAnswers([]any{p.Question}))[0]
+	}
+	return out
+}
+
+// dailyQuestions draws the challenge of a date from the published public exams; everyone gets the same questions
+// on the same day
+func dailyQuestions(subjects []exam.Subject, drafts *draftStore, date string, count int) []exam.PoolQuestion {
+	pool := exam.QuestionPool(subjects, func(subject string, e exam.ExamFile) bool {
+		return exam.Visibility(e) == exam.VisibilityPublic && !drafts.IsDraft(subject, e.Name)
+	})
+	seed := sha256.Sum256([]byte("daily\x00" + date))
+	perm := exam.VariantRand(seed, "questions").Perm(len(pool))
+	out := make([]exam.PoolQuestion, 0, min(count, len(pool)))
+	for _, i := range perm[:cap(out)] {
+		out = append(out, pool[i])
+	}
+	return out
+}
+
+// DailyResult is a user's score on the challenge of one day
+type DailyResult struct {
+	Date        string        `json:"date"`
+	Score       int           `json:"score"`
+	Total       int           `json:"total"`
+	Answers     []exam.Answer `json:"answers"`
+	Correct     []bool        `json:"correct"`
+	SubmittedAt time.Time     `json:"submittedAt"`
+}
+
+// dailyRecord is the challenge history of one user
+type dailyRecord struct {
+	Results    []DailyResult `json:"results"`
+	BestStreak int           `json:"bestStreak"`
+}
+
+// streak returns the number of consecutive days up to today with a result. A streak that ended
+// yesterday is still current until today's challenge is missed.
+func (d *dailyRecord) streak(today time.Time) int {
+	n := 0
+	day := today
+	for i := len(d.Results) - 1; i >= 0; i-- {
+		date := d.Results[i].Date
+		if n == 0 && date != day.Format(dailyDateLayout) {
+			day = day.AddDate(0, 0, -1)
+		}
+		if date != day.Format(dailyDateLayout) {
+			break
+		}
+		n++
+		day = day.AddDate(0, 0, -1)
+	}
+	return n
+}
+
+// errDailySubmitted is returned for a second submission of the same day's challenge
+var errDailySubmitted = errors.New("today's challenge was already submitted")
+
+// dailyStore keeps the daily challenge results of every user in a JSON file
+type dailyStore struct {
+	path  string
+	count int
+
+	mu    sync.RWMutex
+	users map[string]*dailyRecord
+}
+
+// openDailyStore loads the daily results saved at path; each challenge has count questions
+func openDailyStore(path string, count int) (*dailyStore, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("daily challenge needs at least one question, got %d", count)
+	}
+	s := &dailyStore{path: path, count: count, users: map[string]*dailyRecord{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read daily results: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.users); err != nil {
+		return nil, fmt.Errorf("failed to parse daily results: %w", err)
+	}
+	return s, nil
+}
+
+// Add records the result of a user's challenge and returns their streak including it
+func (s *dailyStore) Add(user string, result DailyResult, today time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.users[user]
+	if d == nil {
+		d = &dailyRecord{}
+		s.users[user] = d
+	}
+	if n := len(d.Results); n > 0 && d.Results[n-1].Date == result.Date {
+		return d.streak(today), errDailySubmitted
+	}
+	d.Results = append(d.Results, result)
+	streak := d.streak(today)
+	d.BestStreak = max(d.BestStreak, streak)
+
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return streak, err
+	}
+	return streak, storage.WriteFileAtomic(s.path, data)
+}
+
+// Status returns a user's current and best streak and their result of a date, if any
+func (s *dailyStore) Status(user string, today time.Time) (streak, best int, result *DailyResult) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := s.users[user]
+	if d == nil {
+		return 0, 0, nil
+	}
+	if n := len(d.Results); n > 0 && d.Results[n-1].Date == today.Format(dailyDateLayout) {
+		r := d.Results[n-1]
+		result = &r
+	}
+	return d.streak(today), d.BestStreak, result
+}
+
+// ByUser returns the challenge history of a user, or nil if they never took a challenge
+func (s *dailyStore) ByUser(user string) *dailyRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := s.users[user]
+	if d == nil {
+		return nil
+	}
+	return &dailyRecord{Results: slices.Clone(d.Results), BestStreak: d.BestStreak}
+}
+
+// EraseUser deletes the challenge history of a user
+func (s *dailyStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.users[user]
+	if !ok {
+		return nil
+	}
+	delete(s.users, user)
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err == nil {
+		err = storage.WriteFileAtomic(s.path, data)
+	}
+	if err != nil {
+		s.users[user] = d
+		return err
+	}
+	return nil
+}
+
+// registerDailyRoutes adds the daily challenge endpoints to api, the group of routes under /api
+func registerDailyRoutes(api *router, daily *dailyStore, store *examStore, drafts *draftStore) {
+	api.HandleFunc("GET /daily", daily.serveChallenge(store, drafts))
+	api.HandleFunc("POST /daily", daily.submit(store, drafts))
+}
+
+// serveChallenge returns a handler that returns today's questions and, with ?user=, the user's streak
+func (s *dailyStore) serveChallenge(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		today := time.Now().UTC()
+		date := today.Format(dailyDateLayout)
+		questions := dailyQuestions(subjects, drafts, date, s.count)
+		if store.redact {
+			questions = redactPool(questions)
+		}
+
+		resp := map[string]any{"date": date, "questions": questions}
+		if user := r.URL.Query().Get("user"); user != "" {
+			streak, best, result := s.Status(user, today)
+			resp["streak"], resp["bestStreak"], resp["result"] = streak, best, result
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// submit returns a handler that grades a user's answers to today's challenge, once per day
+func (s *dailyStore) submit(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserID  string        `json:"userId"`
+			Date    string        `json:"date"`
+			Answers []exam.Answer `json:"answers"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.UserID == "" {
+			http.Error(w, "Missing userId", http.StatusBadRequest)
+			return
+		}
+		today := time.Now().UTC()
+		date := today.Format(dailyDateLayout)
+		// A challenge started before midnight can no longer be submitted once the day has changed
+		if req.Date != "" && req.Date != date {
+			http.Error(w, "The challenge of "+req.Date+" is closed", http.StatusConflict)
+			return
+		}
+
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		drawn := dailyQuestions(subjects, drafts, date, s.count)
+		if len(req.Answers) > len(drawn) {
+			http.Error(w, errTooManyAnswers.Error(), http.StatusBadRequest)
+			return
+		}
+		questions := make([]any, len(drawn))
+		for i, p := range drawn {
+			questions[i] = p.Question
+		}
+		answers := make([]exam.Answer, len(drawn))
+		copy(answers, req.Answers)
+		correct, score, err := gradeAnswers(questions, answers)
+		if err != nil {
+			http.Error(w, "Failed to grade answers: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := DailyResult{Date: date, Score: score, Total: len(drawn), Answers: answers, Correct: correct, SubmittedAt: today}
+		streak, err := s.Add(req.UserID, result, today)
+		if errors.Is(err, errDailySubmitted) {
+			http.Error(w, "Today's challenge was already submitted", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to record daily result: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"result": result, "streak": streak})
+	}
+}
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"expvar"
+	"net/http/pprof"
+)
+
+// registerDebugRoutes adds the pprof profiles and the expvar variables, including the memory statistics,
+// to debug, the group of routes under /debug
+func registerDebugRoutes(debug *router) {
+	debug.HandleFunc("GET /pprof/", pprof.Index)
+	debug.HandleFunc("GET /pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("GET /pprof/profile", pprof.Profile)
+	debug.HandleFunc("GET /pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("POST /pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("GET /pprof/trace", pprof.Trace)
+	debug.Handle("GET /vars", expvar.Handler())
+}
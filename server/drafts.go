@@ -0,0 +1,397 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Statuses of an unpublished exam; exams that are neither are published
+const (
+	draftOpen     = "draft"
+	draftPending  = "pending"  // publication asked for, waiting for the approval of a second reviewer
+	draftApproved = "approved" // approved by a second reviewer, waiting for the time it is scheduled for
+)
+
+var errDraftNotFound = errors.New("exam is not a draft")
+
+// ExamDraft is an uploaded exam that is not published yet, so only instructors and admins see it
+type ExamDraft struct {
+	Subject     string    `json:"subject"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+	RequestedBy string    `json:"requestedBy,omitempty"` // reviewer who asked for publication, see reviewerOf
+	RequestedAt time.Time `json:"requestedAt,omitzero"`
+	PublishAt   time.Time `json:"publishAt,omitzero"` // when the publish job makes it public, if scheduled
+}
+
+// draftStore keeps the unpublished exams in a JSON file. Exams uploaded through the admin API start as drafts;
+// exams placed in the exam directory otherwise are published from the start.
+type draftStore struct {
+	path string
+
+	mu        sync.RWMutex
+	drafts    map[string]*ExamDraft // by <subject>/<name>
+	version   uint64                // incremented on every change, so listings built before are not reused
+	listeners []func(subject string, e exam.ExamFile)
+}
+
+// openDraftStore loads the drafts saved at path
+func openDraftStore(path string) (*draftStore, error) {
+	s := &draftStore{path: path, drafts: map[string]*ExamDraft{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read exam drafts: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.drafts); err != nil {
+		return nil, fmt.Errorf("failed to parse exam drafts: %w", err)
+	}
+	return s, nil
+}
+
+// save writes every draft; the caller holds the lock
+func (s *draftStore) save() error {
+	s.version++
+	data, err := json.MarshalIndent(s.drafts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// Add records new exams as drafts, keeping the state of those that already are
+func (s *draftStore) Add(refs ...ExamRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	for _, ref := range refs {
+		key := ref.Subject + "/" + ref.Name
+		if s.drafts[key] == nil {
+			s.drafts[key] = &ExamDraft{Subject: ref.Subject, Name: ref.Name, Status: draftOpen, CreatedAt: now}
+		}
+	}
+	return s.save()
+}
+
+// Discard forgets drafts of exams that could not be written after all
+func (s *draftStore) Discard(refs ...ExamRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ref := range refs {
+		delete(s.drafts, ref.Subject+"/"+ref.Name)
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save exam drafts: %v", err)
+	}
+}
+
+// IsDraft reports whether an exam is not published yet
+func (s *draftStore) IsDraft(subject, name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.drafts[subject+"/"+name] != nil
+}
+
+// Version returns a number that changes whenever an exam becomes a draft or is published
+func (s *draftStore) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// List returns every draft, oldest first
+func (s *draftStore) List() []ExamDraft {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []ExamDraft{}
+	for _, d := range s.drafts {
+		out = append(out, *d)
+	}
+	slices.SortFunc(out, func(a, b ExamDraft) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Name, b.Name))
+	})
+	return out
+}
+
+// update applies fn to a draft and saves it, leaving the draft unchanged if fn or saving fails
+func (s *draftStore) update(ref ExamRef, fn func(d *ExamDraft) error) (ExamDraft, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.drafts[ref.Subject+"/"+ref.Name]
+	if d == nil {
+		return ExamDraft{}, errDraftNotFound
+	}
+	prev := *d
+	if err := fn(d); err != nil {
+		return ExamDraft{}, err
+	}
+	if err := s.save(); err != nil {
+		*d = prev
+		return ExamDraft{}, err
+	}
+	return *d, nil
+}
+
+// Request marks a draft as waiting for a second reviewer to approve its publication, at publishAt unless zero
+func (s *draftStore) Request(ref ExamRef, reviewer string, publishAt time.Time) (ExamDraft, error) {
+	return s.update(ref, func(d *ExamDraft) error {
+		d.Status, d.RequestedBy, d.RequestedAt, d.PublishAt = draftPending, reviewer, time.Now().UTC(), publishAt
+		return nil
+	})
+}
+
+// Approve records the approval of a requested publication by a reviewer other than the one who asked for it
+func (s *draftStore) Approve(ref ExamRef, reviewer string) (ExamDraft, error) {
+	return s.update(ref, func(d *ExamDraft) error {
+		switch {
+		case d.Status != draftPending && d.Status != draftApproved:
+			return errNotRequested
+		case d.RequestedBy == reviewer:
+			return errSameReviewer
+		}
+		d.Status = draftApproved
+		return nil
+	})
+}
+
+// Schedule sets the time the publish job makes a draft public
+func (s *draftStore) Schedule(ref ExamRef, publishAt time.Time) (ExamDraft, error) {
+	return s.update(ref, func(d *ExamDraft) error {
+		d.PublishAt = publishAt
+		return nil
+	})
+}
+
+// OnPublish registers fn to be called with every draft published from now on
+func (s *draftStore) OnPublish(fn func(subject string, e exam.ExamFile)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Publish makes the draft of exam e of subject public. Unless approve is nil, it is called with the draft and may
+// refuse it.
+func (s *draftStore) Publish(subject string, e exam.ExamFile, approve func(ExamDraft) error) error {
+	s.mu.Lock()
+	key := subject + "/" + e.Name
+	d := s.drafts[key]
+	if d == nil {
+		s.mu.Unlock()
+		return errDraftNotFound
+	}
+	if approve != nil {
+		if err := approve(*d); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	delete(s.drafts, key)
+	if err := s.save(); err != nil {
+		s.drafts[key] = d
+		s.mu.Unlock()
+		return err
+	}
+	listeners := slices.Clone(s.listeners)
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(subject, e)
+	}
+	return nil
+}
+
+// reviewerOf identifies the instructor or admin making a request by a fingerprint of their bearer token, so
+// approvals can be told apart from requests without revealing the token
+func reviewerOf(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// listDrafts returns a handler that lists the exams of the subjects of the token waiting to be published
+func listDrafts(drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope := scopeOf(r)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(slices.DeleteFunc(drafts.List(), func(d ExamDraft) bool { return !scope.allows(d.Subject) }))
+	}
+}
+
+// draftExam finds the exam named by the path of a request, answering the request when it is not found
+func draftExam(w http.ResponseWriter, r *http.Request, store *examStore) (string, exam.ExamFile, bool) {
+	subjects, err := store.Subjects(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+		return "", exam.ExamFile{}, false
+	}
+	subject := r.PathValue("subject")
+	e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+	if !ok {
+		http.Error(w, "Exam not found", http.StatusNotFound)
+	}
+	return subject, e, ok
+}
+
+// publishExam returns a handler that publishes a draft exam, or schedules its publication for the publishAt
+// time of the request body. When publication needs approval, it asks for it instead, answering 202 until a second
+// reviewer approves through approveExam.
+func publishExam(store *examStore, drafts *draftStore, needsApproval bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			PublishAt time.Time `json:"publishAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !req.PublishAt.IsZero() && !req.PublishAt.After(time.Now()) {
+			http.Error(w, "publishAt must be in the future", http.StatusBadRequest)
+			return
+		}
+		subject, e, ok := draftExam(w, r, store)
+		if !ok {
+			return
+		}
+		ref := ExamRef{Subject: subject, Name: e.Name}
+		var d ExamDraft
+		var err error
+		switch {
+		case needsApproval:
+			d, err = drafts.Request(ref, reviewerOf(r), req.PublishAt.UTC())
+		case !req.PublishAt.IsZero():
+			d, err = drafts.Schedule(ref, req.PublishAt.UTC())
+		default:
+			publishDraft(w, drafts, subject, e, nil)
+			return
+		}
+		if draftError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// approveExam returns a handler that approves the publication of a draft another reviewer asked for, publishing
+// it unless it is scheduled for later
+func approveExam(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, e, ok := draftExam(w, r, store)
+		if !ok {
+			return
+		}
+		d, err := drafts.Approve(ExamRef{Subject: subject, Name: e.Name}, reviewerOf(r))
+		if draftError(w, err) {
+			return
+		}
+		if d.PublishAt.After(time.Now()) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(d)
+			return
+		}
+		publishDraft(w, drafts, subject, e, func(d ExamDraft) error {
+			if d.Status != draftApproved {
+				return errNotRequested
+			}
+			return nil
+		})
+	}
+}
+
+// Errors refusing the approval of a publication
+var (
+	errNotRequested = errors.New("publication of the exam has not been asked for")
+	errSameReviewer = errors.New("publication must be approved by another reviewer than the one who asked for it")
+)
+
+// draftError answers a request whose change to a draft failed, reporting whether err was one
+func draftError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errDraftNotFound):
+		http.Error(w, "Exam is already published", http.StatusConflict)
+	case errors.Is(err, errNotRequested):
+		http.Error(w, "Publication of the exam has not been asked for", http.StatusConflict)
+	case errors.Is(err, errSameReviewer):
+		http.Error(w, "Publication must be approved by another reviewer than the one who asked for it", http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to save draft: "+err.Error(), http.StatusInternalServerError)
+	}
+	return true
+}
+
+// publishDraft publishes a draft and answers with the published exam
+func publishDraft(w http.ResponseWriter, drafts *draftStore, subject string, e exam.ExamFile, approve func(ExamDraft) error) {
+	if draftError(w, drafts.Publish(subject, e, approve)) {
+		return
+	}
+	log.Printf("Exam %s/%s published", subject, e.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"subject": subject, "name": e.Name, "status": "published"})
+}
+
+// errNotDue skips drafts whose scheduled publication was changed since the publish job listed them
+var errNotDue = errors.New("publication of the exam is not due")
+
+// publishDue publishes the drafts scheduled for before now, of those needing approval only the approved ones.
+// Publication notifies the draft store hooks, as publishing through the API does.
+func publishDue(ctx context.Context, store *examStore, drafts *draftStore, needsApproval bool, now time.Time) error {
+	due := func(d ExamDraft) bool {
+		return !d.PublishAt.IsZero() && !d.PublishAt.After(now) && (!needsApproval || d.Status == draftApproved)
+	}
+	var list []ExamDraft
+	for _, d := range drafts.List() {
+		if due(d) {
+			list = append(list, d)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	subjects, err := store.Subjects(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, d := range list {
+		e, ok := exam.FindExam(subjects, d.Subject, d.Name)
+		if !ok {
+			log.Printf("Exam %s/%s scheduled for publication no longer exists", d.Subject, d.Name)
+			continue
+		}
+		err := drafts.Publish(d.Subject, e, func(d ExamDraft) error {
+			if !due(d) {
+				return errNotDue
+			}
+			return nil
+		})
+		switch {
+		case errors.Is(err, errDraftNotFound), errors.Is(err, errNotDue):
+		case err != nil:
+			errs = append(errs, fmt.Errorf("exam %s/%s: %w", d.Subject, d.Name, err))
+		default:
+			log.Printf("Exam %s/%s published as scheduled", d.Subject, d.Name)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// responseEncoding is a binary format JSON API responses can be sent in instead, for clients that are faster
+// at parsing it or short on bandwidth
+type responseEncoding struct {
+	contentType string
+	// encode encodes v, the decoded JSON response of a route, or reports that the route cannot be sent in the encoding
+	encode func(route string, v any) ([]byte, bool)
+}
+
+// schemaless adapts an encoding of any JSON value to responseEncoding.encode
+func schemaless(appendValue func(b []byte, v any) []byte) func(string, any) ([]byte, bool) {
+	return func(_ string, v any) ([]byte, bool) {
+		return appendValue(nil, v), true
+	}
+}
+
+// responseEncodings are the binary formats clients can ask for in the Accept header, by media type
+var responseEncodings = map[string]responseEncoding{
+	"application/msgpack":    {contentType: "application/msgpack", encode: schemaless(appendMsgpack)},
+	"application/x-msgpack":  {contentType: "application/msgpack", encode: schemaless(appendMsgpack)},
+	"application/cbor":       {contentType: "application/cbor", encode: schemaless(appendCBOR)},
+	"application/x-protobuf": {contentType: "application/x-protobuf", encode: encodeProtobuf},
+	"application/protobuf":   {contentType: "application/x-protobuf", encode: encodeProtobuf},
+}
+
+// negotiateEncoding returns the binary encoding the Accept header of r prefers over JSON, if there is one
+func negotiateEncoding(r *http.Request) (responseEncoding, bool) {
+	var best responseEncoding
+	bestQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if enc, ok := responseEncodings[mediaType]; ok && q > bestQ {
+			best, bestQ = enc, q
+		} else if mediaType == "application/json" || mediaType == "*/*" || mediaType == "application/*" {
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	// JSON wins ties, so clients listing both keep getting what they got before
+	return best, bestQ > 0 && bestQ > jsonQ
+}
+
+// binaryEncodings re-encodes the JSON responses of next in the binary format asked for in the Accept header.
+// Responses of other types, such as errors and streams, are sent as they are, as are those of routes without
+// a protobuf message when protobuf is asked for.
+func binaryEncodings(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		enc, ok := negotiateEncoding(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &encodingResponseWriter{ResponseWriter: w, enc: enc, route: routePattern(r)}
+		defer ew.Close()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// encodingResponseWriter holds back a JSON response until the handler returns, then sends it re-encoded
+type encodingResponseWriter struct {
+	http.ResponseWriter
+	enc   responseEncoding
+	route string
+
+	status      int
+	wroteHeader bool
+	buffering   bool // the response is JSON and held back in buf
+	buf         bytes.Buffer
+}
+
+func (w *encodingResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status, w.wroteHeader = status, true
+	// Partial content cannot be decoded on its own
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if mediaType == "application/json" && status != http.StatusPartialContent && w.Header().Get("Content-Encoding") == "" {
+		w.buffering = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *encodingResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Close sends the held back response, re-encoded if it is valid JSON
+func (w *encodingResponseWriter) Close() {
+	if !w.buffering {
+		return
+	}
+	body := w.buf.Bytes()
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err == nil {
+		if encoded, ok := w.enc.encode(w.route, v); ok {
+			body = encoded
+			w.Header().Set("Content-Type", w.enc.contentType)
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// Flush sends what has been written so far, unless the response is held back to be re-encoded
+func (w *encodingResponseWriter) Flush() {
+	if !w.buffering {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *encodingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// jsonInt returns the value of a JSON number that is an integer fitting 64 bits
+func jsonInt(n json.Number) (int64, bool) {
+	i, err := n.Int64()
+	return i, err == nil
+}
+
+// sortedKeys returns the keys of m in order, so equal values always encode to the same bytes
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// appendMsgpack appends v, a value decoded from JSON with UseNumber, in MessagePack
+func appendMsgpack(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if i, ok := jsonInt(v); ok {
+			return appendMsgpackInt(b, i)
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			b = append(b, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+		default:
+			b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+		}
+		return append(b, v...)
+	case []any:
+		b = appendMsgpackLength(b, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case map[string]any:
+		b = appendMsgpackLength(b, len(v), 0x80, 0xde)
+		for _, k := range sortedKeys(v) {
+			b = appendMsgpack(b, k)
+			b = appendMsgpack(b, v[k])
+		}
+		return b
+	}
+	return append(b, 0xc0)
+}
+
+// appendMsgpackInt appends i in the shortest MessagePack integer format holding it
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+// appendMsgpackLength appends the header of an array or map of n elements, given its fix and 16-bit formats;
+// the 32-bit format follows the 16-bit one
+func appendMsgpackLength(b []byte, n int, fix, format16 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, format16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, format16+1), uint32(n))
+}
+
+// CBOR major types
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+)
+
+// appendCBOR appends v, a value decoded from JSON with UseNumber, in CBOR
+func appendCBOR(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6)
+	case bool:
+		if v {
+			return append(b, 0xf5)
+		}
+		return append(b, 0xf4)
+	case json.Number:
+		if i, ok := jsonInt(v); ok {
+			if i < 0 {
+				return appendCBORHead(b, cborNegint, uint64(-1-i))
+			}
+			return appendCBORHead(b, cborUint, uint64(i))
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f))
+	case string:
+		return append(appendCBORHead(b, cborText, uint64(len(v))), v...)
+	case []any:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			b = appendCBOR(b, item)
+		}
+		return b
+	case map[string]any:
+		b = appendCBORHead(b, cborMap, uint64(len(v)))
+		for _, k := range sortedKeys(v) {
+			b = appendCBOR(b, k)
+			b = appendCBOR(b, v[k])
+		}
+		return b
+	}
+	return append(b, 0xf6)
+}
+
+// appendCBORHead appends the initial byte of a data item of a major type with its argument in the shortest form
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// Types of the domain events published to the event stream
+const (
+	eventExamPublished    = "exam.published"    // a new exam, or a new version of one, was loaded or a draft published
+	eventSessionStarted   = "session.started"   // a timed session was started
+	eventSessionSubmitted = "session.submitted" // a session was submitted and its attempt recorded
+	eventAttemptGraded    = "attempt.graded"    // an attempt was graded, whether submitted through a session or not
+)
+
+// Event is a domain event as published, as JSON, to the topic or subject <prefix>.<type>
+type Event struct {
+	ID        string    `json:"id"` // stable across redeliveries, so consumers can drop duplicates
+	Type      string    `json:"type"`
+	At        time.Time `json:"at"`
+	Subject   string    `json:"subject"`
+	Exam      string    `json:"exam"`
+	ExamID    string    `json:"examId,omitempty"`
+	Revision  string    `json:"revision,omitempty"` // content hash of a published exam version
+	Questions int       `json:"questions,omitempty"`
+	SessionID string    `json:"sessionId,omitempty"`
+	AttemptID string    `json:"attemptId,omitempty"`
+	UserID    string    `json:"userId,omitempty"`
+	Score     *int      `json:"score,omitempty"`
+	Total     *int      `json:"total,omitempty"`
+	Percent   *float64  `json:"percent,omitempty"`
+	Passed    *bool     `json:"passed,omitempty"`
+	Points    *float64  `json:"points,omitempty"`
+	Scaled    *float64  `json:"scaled,omitempty"`
+}
+
+// key returns the key events are partitioned by, so the events of one exam keep their order
+func (e Event) key() string {
+	return cmp.Or(e.ExamID, e.Subject+"/"+e.Exam)
+}
+
+// EventsConfig holds the connection settings of the event stream
+type EventsConfig struct {
+	URL    string // kafka://broker[,broker...] or nats://[user:password@]host[:port]; disabled if empty
+	Prefix string // prefix of the topics or subjects; defaults to mockexam
+}
+
+// eventPublisher sends messages to a broker
+type eventPublisher interface {
+	publish(topic, key string, value []byte) error
+}
+
+// eventEmitter publishes domain events from a background queue
+type eventEmitter struct {
+	prefix string
+	pub    eventPublisher
+	drafts *draftStore
+	queue  chan Event
+}
+
+// newEventEmitter starts the delivery worker of an emitter for the broker at cfg.URL. Exams are published when
+// loaded, or for drafts when their publication goes through.
+func newEventEmitter(cfg EventsConfig, drafts *draftStore) (*eventEmitter, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid events URL %q (expected kafka://broker[,broker...] or nats://host[:port])", cfg.URL)
+	}
+	e := &eventEmitter{prefix: cmp.Or(cfg.Prefix, "mockexam"), drafts: drafts, queue: make(chan Event, 1024)}
+	switch u.Scheme {
+	case "kafka":
+		e.pub = newKafkaProducer(strings.Split(u.Host, ","))
+	case "nats":
+		e.pub = newNATSPublisher(u)
+	default:
+		return nil, fmt.Errorf("unsupported events URL scheme %q (expected kafka or nats)", u.Scheme)
+	}
+	go e.deliver()
+	return e, nil
+}
+
+// emit queues an event, dropping it if the broker has fallen too far behind
+func (e *eventEmitter) emit(ev Event) {
+	select {
+	case e.queue <- ev:
+	default:
+		log.Printf("Event queue full, dropping %s event %s", ev.Type, ev.ID)
+	}
+}
+
+// examRecorded emits the publication of an exam version, for use as a revision store hook
+func (e *eventEmitter) examRecorded(subject string, f exam.ExamFile, rev ExamRevision) {
+	if e.drafts.IsDraft(subject, f.Name) {
+		return
+	}
+	e.emit(Event{
+		ID: xapiUUID(eventExamPublished, subject, f.Name, rev.SHA256), Type: eventExamPublished, At: rev.SeenAt,
+		Subject: subject, Exam: f.Name, ExamID: f.ID, Revision: rev.SHA256, Questions: rev.QuestionCount,
+	})
+}
+
+// draftPublished emits the publication of a draft exam, for use as a draft store hook
+func (e *eventEmitter) draftPublished(subject string, f exam.ExamFile) {
+	e.emit(Event{
+		ID: xapiUUID(eventExamPublished, subject, f.Name, f.SHA256), Type: eventExamPublished, At: time.Now().UTC(),
+		Subject: subject, Exam: f.Name, ExamID: f.ID, Revision: f.SHA256, Questions: f.QuestionCount,
+	})
+}
+
+// sessionStarted emits the start of a session, for use as a session store hook
+func (e *eventEmitter) sessionStarted(s Session) {
+	e.emit(Event{
+		ID: xapiUUID(eventSessionStarted, s.ID), Type: eventSessionStarted, At: s.StartedAt,
+		Subject: s.Subject, Exam: s.Exam, ExamID: s.ExamID, SessionID: s.ID, UserID: s.UserID,
+	})
+}
+
+// sessionSubmitted emits the submission of a session, for use as a session store hook
+func (e *eventEmitter) sessionSubmitted(s Session) {
+	e.emit(Event{
+		ID: xapiUUID(eventSessionSubmitted, s.ID), Type: eventSessionSubmitted, At: time.Now().UTC(),
+		Subject: s.Subject, Exam: s.Exam, ExamID: s.ExamID, SessionID: s.ID, AttemptID: s.AttemptID, UserID: s.UserID,
+	})
+}
+
+// attemptRecorded emits the grading of an attempt, for use as an attempt store hook
+func (e *eventEmitter) attemptRecorded(a Attempt) {
+	e.emit(Event{
+		ID: xapiUUID(eventAttemptGraded, a.ID), Type: eventAttemptGraded, At: a.SubmittedAt,
+		Subject: a.Subject, Exam: a.Exam, ExamID: a.ExamID, AttemptID: a.ID, UserID: a.UserID,
+		Score: &a.Score, Total: &a.Total, Percent: &a.Percent, Passed: a.Passed, Points: a.Points, Scaled: a.Scaled,
+	})
+}
+
+// deliver publishes queued events, retrying failures with backoff
+func (e *eventEmitter) deliver() {
+	for ev := range e.queue {
+		value, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("Failed to encode %s event %s: %v", ev.Type, ev.ID, err)
+			continue
+		}
+		for try, wait := 0, time.Second; try < 4; try, wait = try+1, wait*4 {
+			if try > 0 {
+				time.Sleep(wait)
+			}
+			if err = e.pub.publish(e.prefix+"."+ev.Type, ev.key(), value); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to publish %s event %s: %v", ev.Type, ev.ID, err)
+		}
+	}
+}
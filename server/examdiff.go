@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// QuestionChange is a question added, removed, changed or moved between two versions of an exam. Indexes are
+// positions in the question list of the version the question is in.
+type QuestionChange struct {
+	ID        string   `json:"id,omitempty"` // the question's own id, if it has one
+	FromIndex *int     `json:"fromIndex,omitempty"`
+	ToIndex   *int     `json:"toIndex,omitempty"`
+	Fields    []string `json:"fields,omitempty"` // fields whose value changed
+	From      any      `json:"from,omitempty"`
+	To        any      `json:"to,omitempty"`
+}
+
+// ExamDiff is the structural difference between two versions of an exam
+type ExamDiff struct {
+	Subject     string           `json:"subject"`
+	Exam        string           `json:"exam"`
+	From        ExamRevision     `json:"from"`
+	To          ExamRevision     `json:"to"`
+	MetaChanged []string         `json:"metaChanged"` // metadata fields whose value changed
+	Added       []QuestionChange `json:"added"`
+	Removed     []QuestionChange `json:"removed"`
+	Changed     []QuestionChange `json:"changed"`
+	Moved       []QuestionChange `json:"moved"`
+	Unchanged   int              `json:"unchanged"`
+}
+
+// diffQuestions compares two question lists. Questions are paired by their own id first, then identical
+// questions by order, then questions with the same text, and finally questions left at the same position; the
+// rest were added or removed.
+func diffQuestions(from, to []any) ExamDiff {
+	d := ExamDiff{Added: []QuestionChange{}, Removed: []QuestionChange{}, Changed: []QuestionChange{}, Moved: []QuestionChange{}}
+	pairedFrom := make([]int, len(from)) // index in to + 1, or 0 if unpaired
+	pairedTo := make([]bool, len(to))
+	pair := func(match func(a, b any) bool) {
+		for i, a := range from {
+			if pairedFrom[i] != 0 {
+				continue
+			}
+			for j, b := range to {
+				if !pairedTo[j] && match(a, b) {
+					pairedFrom[i], pairedTo[j] = j+1, true
+					break
+				}
+			}
+		}
+	}
+	field := func(q any, name string) (string, bool) {
+		m, _ := q.(map[string]any)
+		s, ok := m[name].(string)
+		return s, ok && s != ""
+	}
+	pair(func(a, b any) bool {
+		ida, oka := field(a, "id")
+		idb, okb := field(b, "id")
+		return oka && okb && ida == idb
+	})
+	pair(reflect.DeepEqual)
+	pair(func(a, b any) bool {
+		ta, oka := field(a, "question")
+		tb, okb := field(b, "question")
+		return oka && okb && ta == tb
+	})
+	for i := range from {
+		if pairedFrom[i] == 0 && i < len(to) && !pairedTo[i] {
+			pairedFrom[i], pairedTo[i] = i+1, true
+		}
+	}
+
+	for i, a := range from {
+		id, _ := field(a, "id")
+		fromIndex := i
+		if pairedFrom[i] == 0 {
+			d.Removed = append(d.Removed, QuestionChange{ID: id, FromIndex: &fromIndex, From: a})
+			continue
+		}
+		toIndex := pairedFrom[i] - 1
+		b := to[toIndex]
+		switch {
+		case !reflect.DeepEqual(a, b):
+			d.Changed = append(d.Changed, QuestionChange{ID: id, FromIndex: &fromIndex, ToIndex: &toIndex, Fields: changedFields(a, b), From: a, To: b})
+		case fromIndex != toIndex:
+			d.Moved = append(d.Moved, QuestionChange{ID: id, FromIndex: &fromIndex, ToIndex: &toIndex})
+		default:
+			d.Unchanged++
+		}
+	}
+	for j, b := range to {
+		if !pairedTo[j] {
+			id, _ := field(b, "id")
+			toIndex := j
+			d.Added = append(d.Added, QuestionChange{ID: id, ToIndex: &toIndex, To: b})
+		}
+	}
+	return d
+}
+
+// changedFields returns the names of the fields that differ between two JSON objects, in order
+func changedFields(a, b any) []string {
+	ma, _ := a.(map[string]any)
+	mb, _ := b.(map[string]any)
+	var fields []string
+	for k, v := range ma {
+		if w, ok := mb[k]; !ok || !reflect.DeepEqual(v, w) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range mb {
+		if _, ok := ma[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// toJSONValue converts v to the form it takes when decoded from JSON, so structs compare with decoded objects
+func toJSONValue(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// diffExam returns a handler that compares two recorded versions of an exam, given by the from and to hashes
+// (or prefixes of them). Without to the current version is used, and without from the version before it.
+func diffExam(store *examStore, revisions *revisionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, name := r.PathValue("subject"), r.PathValue("exam")
+		// Exams that still exist can be named without their extension
+		if subjects, err := store.Subjects(r.Context()); err == nil {
+			if e, ok := exam.FindExam(subjects, subject, name); ok {
+				name = e.Name
+			}
+		}
+		revs := revisions.List(subject, name)
+		if len(revs) == 0 {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+
+		q := r.URL.Query()
+		to := revs[len(revs)-1]
+		if sha := q.Get("to"); sha != "" {
+			var ok bool
+			if to, ok = revisions.Find(subject, name, sha); !ok {
+				http.Error(w, "Revision \"to\" not found or ambiguous", http.StatusNotFound)
+				return
+			}
+		}
+		var from ExamRevision
+		if sha := q.Get("from"); sha != "" {
+			var ok bool
+			if from, ok = revisions.Find(subject, name, sha); !ok {
+				http.Error(w, "Revision \"from\" not found or ambiguous", http.StatusNotFound)
+				return
+			}
+		} else {
+			// The version before is the newest one seen before to that differs from it
+			for _, rev := range revs {
+				if rev.SeenAt.Before(to.SeenAt) && rev.SHA256 != to.SHA256 {
+					from = rev
+				}
+			}
+			if from.SHA256 == "" {
+				http.Error(w, "No earlier revision to compare with", http.StatusNotFound)
+				return
+			}
+		}
+
+		fromContent, err := revisions.Content(from.SHA256)
+		if err != nil {
+			http.Error(w, "Failed to read revision: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		toContent, err := revisions.Content(to.SHA256)
+		if err != nil {
+			http.Error(w, "Failed to read revision: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		d := diffQuestions(exam.Questions(fromContent.Content), exam.Questions(toContent.Content))
+		d.Subject, d.Exam, d.From, d.To = subject, name, from, to
+		d.MetaChanged = changedFields(toJSONValue(fromContent.Meta), toJSONValue(toContent.Meta))
+		if d.MetaChanged == nil {
+			d.MetaChanged = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// listRevisions returns a handler that returns the recorded versions of an exam, oldest first
+func listRevisions(store *examStore, revisions *revisionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, name := r.PathValue("subject"), r.PathValue("exam")
+		if subjects, err := store.Subjects(r.Context()); err == nil {
+			if e, ok := exam.FindExam(subjects, subject, name); ok {
+				name = e.Name
+			}
+		}
+		revs := revisions.List(subject, name)
+		if len(revs) == 0 {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revs)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// maxExamEditSize bounds the exam file an admin PUT may write
+const maxExamEditSize = 8 << 20
+
+// examETag returns the entity tag of the content of an exam file, the quoted hash exam listings report as sha256
+func examETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists etag, or is "*". Weak tags never match
+// as edits need the exact version.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveExamSource returns a handler that serves the file of an exam as written, with its ETag for edits
+func serveExamSource(store *examStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := findExamPath(store.dir, r.PathValue("subject"), r.PathValue("exam"))
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			http.Error(w, "Failed to read exam: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", examETag(content))
+		w.Write(content)
+	}
+}
+
+// putExam returns a handler that replaces the file of an exam with the request body. Replacing requires an
+// If-Match header with the ETag of the version the edit started from, so concurrent edits are refused with 412
+// instead of overwriting each other; creating an exam requires If-None-Match: * and makes it a draft.
+func putExam(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject, name := r.PathValue("subject"), r.PathValue("exam")
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if ifMatch == "" && ifNoneMatch == "" {
+			http.Error(w, "If-Match with the ETag of the exam is required, or If-None-Match: * to create one", http.StatusPreconditionRequired)
+			return
+		}
+		content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxExamEditSize))
+		if err != nil {
+			http.Error(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		store.editMu.Lock()
+		defer store.editMu.Unlock()
+
+		path, err := findExamPath(store.dir, subject, name)
+		exists := err == nil
+		var current []byte
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if !exam.IsExamFile(name) {
+				name += ".json"
+			}
+			path = filepath.Join(store.dir, filepath.FromSlash(subject), name)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			if current, err = os.ReadFile(path); err != nil {
+				http.Error(w, "Failed to read exam: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// An exam deleted or created since the edit started fails the precondition as a changed one does
+		switch {
+		case ifMatch != "" && (!exists || !etagMatches(ifMatch, examETag(current))),
+			ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, examETag(current)):
+			if exists {
+				w.Header().Set("ETag", examETag(current))
+			}
+			http.Error(w, "Exam was changed since it was read", http.StatusPreconditionFailed)
+			return
+		}
+
+		// Problems name the exam as the bulk upload does, by its path in the exam directory
+		if problems := exam.ValidateContent(subject+"/"+filepath.Base(path), content); len(problems) > 0 {
+			http.Error(w, "Invalid exam: "+strings.Join(problems, "; "), http.StatusUnprocessableEntity)
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			http.Error(w, "Failed to create subject: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// New exams are drafts before their file appears, so no listing shows them in between
+		ref := ExamRef{Subject: subject, Name: filepath.Base(path)}
+		if !exists {
+			if err := drafts.Add(ref); err != nil {
+				http.Error(w, "Failed to save draft: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := storage.WriteFileAtomic(path, content); err != nil {
+			if !exists {
+				drafts.Discard(ref)
+			}
+			http.Error(w, "Failed to write exam: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if store.cached {
+			if err := store.Reload(context.WithoutCancel(r.Context())); err != nil {
+				log.Printf("Failed to reload exams after editing %s/%s: %v", subject, name, err)
+			}
+		}
+		status, verb := http.StatusCreated, "created"
+		if exists {
+			status, verb = http.StatusNoContent, "replaced"
+		}
+		log.Printf("Exam %s/%s %s", subject, filepath.Base(path), verb)
+		w.Header().Set("ETag", examETag(content))
+		w.WriteHeader(status)
+	}
+}
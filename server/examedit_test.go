@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPutExamRequiresPrecondition(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin"}, map[string]string{"Math/algebra.json": testExam})
+	rec := serveTest(s, "PUT", "/api/v1/admin/exams/Math/algebra.json", "admin", []byte(testExam))
+	wantStatus(t, rec, http.StatusPreconditionRequired)
+}
+
+func TestPutExamIfMatch(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin"}, map[string]string{"Math/algebra.json": testExam})
+	src := serveTest(s, "GET", "/api/v1/admin/exams/Math/algebra.json/source", "admin", nil)
+	wantStatus(t, src, http.StatusOK)
+	etag := src.Header().Get("ETag")
+	if etag != examETag([]byte(testExam)) {
+		t.Fatalf("source ETag = %s, want the hash of the file", etag)
+	}
+
+	edited := []byte(`{"title": "Edited", "questions": [{"question": "3 + 3?", "choices": ["6", "7"], "correct": 0}]}`)
+	rec := serveTest(s, "PUT", "/api/v1/admin/exams/Math/algebra.json", "admin", edited, "If-Match", etag)
+	wantStatus(t, rec, http.StatusNoContent)
+	if got := rec.Header().Get("ETag"); got != examETag(edited) {
+		t.Errorf("ETag after the edit = %s, want the hash of the new content", got)
+	}
+
+	// A second edit made from the version read before the first one is refused, reporting the current version
+	rec = serveTest(s, "PUT", "/api/v1/admin/exams/Math/algebra.json", "admin", []byte(testExam), "If-Match", etag)
+	wantStatus(t, rec, http.StatusPreconditionFailed)
+	if got := rec.Header().Get("ETag"); got != examETag(edited) {
+		t.Errorf("ETag of the refused edit = %s, want the current one", got)
+	}
+	src = serveTest(s, "GET", "/api/v1/admin/exams/Math/algebra.json/source", "admin", nil)
+	if src.Body.String() != string(edited) {
+		t.Errorf("refused edit overwrote the exam: %s", src.Body)
+	}
+
+	rec = serveTest(s, "PUT", "/api/v1/admin/exams/Math/algebra.json", "admin", []byte(testExam), "If-Match", "*")
+	wantStatus(t, rec, http.StatusNoContent)
+}
+
+func TestPutExamIfNoneMatch(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin"}, map[string]string{"Math/algebra.json": testExam})
+
+	rec := serveTest(s, "PUT", "/api/v1/admin/exams/Math/algebra.json", "admin", []byte(testExam), "If-None-Match", "*")
+	wantStatus(t, rec, http.StatusPreconditionFailed)
+
+	rec = serveTest(s, "PUT", "/api/v1/admin/exams/Math/geometry", "admin", []byte(testExam), "If-None-Match", "*")
+	wantStatus(t, rec, http.StatusCreated)
+	src := serveTest(s, "GET", "/api/v1/admin/exams/Math/geometry.json/source", "admin", nil)
+	wantStatus(t, src, http.StatusOK)
+
+	// An exam that does not exist cannot be replaced from a version of it
+	rec = serveTest(s, "PUT", "/api/v1/admin/exams/Math/calculus.json", "admin", []byte(testExam), "If-Match", examETag([]byte(testExam)))
+	wantStatus(t, rec, http.StatusPreconditionFailed)
+}
+
+func TestPutExamRejectsInvalidContent(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin"}, map[string]string{"Math/algebra.json": testExam})
+	rec := serveTest(s, "PUT", "/api/v1/admin/exams/Math/algebra.json", "admin", []byte(`{"questions": [{"choices": []}]}`), "If-Match", examETag([]byte(testExam)))
+	wantStatus(t, rec, http.StatusUnprocessableEntity)
+}
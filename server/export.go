@@ -0,0 +1,187 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rowWriter writes tabular data in a spreadsheet format
+type rowWriter interface {
+	WriteRow(cells []string) error
+	Close() error
+}
+
+// csvRowWriter writes rows as CSV
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) WriteRow(cells []string) error { return c.w.Write(cells) }
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// xlsxRowWriter streams rows into the single worksheet of an XLSX workbook
+type xlsxRowWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+// xlsxParts holds the fixed parts of a one-sheet workbook
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// newXLSXRowWriter starts a workbook on w
+func newXLSXRowWriter(w io.Writer) (*xlsxRowWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return &xlsxRowWriter{zw: zw, sheet: sheet}, err
+}
+
+// WriteRow writes numbers as numeric cells and everything else as inline strings
+func (x *xlsxRowWriter) WriteRow(cells []string) error {
+	x.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, x.row)
+	for _, c := range cells {
+		if _, err := strconv.ParseFloat(c, 64); err == nil && c != "" {
+			fmt.Fprintf(&b, `<c><v>%s</v></c>`, c)
+			continue
+		}
+		b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(&b, []byte(c))
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxRowWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// newRowWriter prepares the response headers for a download named base and returns a writer for format
+func newRowWriter(w http.ResponseWriter, format, base string) (rowWriter, error) {
+	switch format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.csv"`)
+		return &csvRowWriter{w: csv.NewWriter(w)}, nil
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+base+`.xlsx"`)
+		return newXLSXRowWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported format %q (expected csv, xlsx, canvas or moodle)", format)
+	}
+}
+
+// attemptMatcher returns a filter for attempts of the exam given as <subject>/<name>, or all attempts when exam is empty
+func attemptMatcher(exam string) func(Attempt) bool {
+	if exam == "" {
+		return nil
+	}
+	return func(a Attempt) bool {
+		ref := a.Subject + "/" + a.Exam
+		return ref == exam || strings.TrimSuffix(ref, path.Ext(a.Exam)) == exam
+	}
+}
+
+// exportResults returns a handler that streams all attempts, optionally of one exam, as CSV, XLSX or an LMS gradebook
+func exportResults(attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		list := attempts.List(attemptMatcher(q.Get("exam")))
+		if _, ok := gradebookLayouts[q.Get("format")]; ok {
+			writeGradebook(w, q.Get("format"), list)
+			return
+		}
+
+		questionColumns := 0
+		for _, a := range list {
+			questionColumns = max(questionColumns, len(a.Answers))
+		}
+
+		rw, err := newRowWriter(w, q.Get("format"), "results-"+time.Now().UTC().Format("20060102"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		header := []string{"attempt_id", "user_id", "subject", "exam", "started_at", "submitted_at", "score", "total", "percent"}
+		for i := 1; i <= questionColumns; i++ {
+			header = append(header, fmt.Sprintf("q%d", i))
+		}
+		if err := rw.WriteRow(header); err != nil {
+			return
+		}
+
+		for _, a := range list {
+			row := []string{
+				a.ID, a.UserID, a.Subject, a.Exam,
+				formatTime(a.StartedAt), formatTime(a.SubmittedAt),
+				strconv.Itoa(a.Score), strconv.Itoa(a.Total), strconv.FormatFloat(a.Percent, 'f', 1, 64),
+			}
+			for i := 0; i < questionColumns; i++ {
+				// Choices are exported 1-based as shown to students; blank means unanswered
+				cell := ""
+				if i < len(a.Answers) && a.Answers[i] != nil {
+					cell = a.Answers[i].String()
+					if choice, ok := a.Answers[i].Choice(); ok {
+						cell = strconv.Itoa(choice + 1)
+					}
+				}
+				row = append(row, cell)
+			}
+			if err := rw.WriteRow(row); err != nil {
+				return
+			}
+		}
+		rw.Close()
+	}
+}
+
+// formatTime formats a timestamp as RFC 3339, or "" for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
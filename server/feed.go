@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// feedEntries is the number of most recently added or updated exams listed in the feed
+const feedEntries = 20
+
+// atomFeed is an Atom 1.0 feed document
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomName    `xml:"author"` // of the entries that do not name their own
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published,omitempty"`
+	Author    *atomName  `xml:"author,omitempty"`
+	Category  []atomTerm `xml:"category"`
+	Link      atomLink   `xml:"link"`
+	Summary   string     `xml:"summary,omitempty"`
+}
+
+type atomName struct {
+	Name string `xml:"name"`
+}
+
+type atomTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+// feedExam is an exam listed in the feed with when it was first and last seen changed
+type feedExam struct {
+	subject   string
+	exam      exam.ExamFile
+	published time.Time
+	updated   time.Time
+}
+
+// examTitle returns the title of an exam, or a label made from its file name
+func examTitle(e exam.ExamFile) string {
+	if e.Meta != nil && e.Meta.Title != "" {
+		return e.Meta.Title
+	}
+	return strings.ReplaceAll(strings.TrimSuffix(e.Name, path.Ext(e.Name)), "_", " ")
+}
+
+// serveFeed returns a handler that publishes the most recently added or updated exams as an Atom feed linking
+// to the frontend. Exams assigned to groups or not public are left out, as anyone can read the feed.
+func serveFeed(store *examStore, revisions *revisionStore, groups *groupStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var exams []feedExam
+		exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+			if exam.Visibility(e) != exam.VisibilityPublic || drafts.IsDraft(subject, e.Name) || !groups.CanTake("", ExamRef{Subject: subject, Name: e.Name}) {
+				return
+			}
+			// The revisions tell when content last changed, as a file's own time can be touched without a
+			// change. Files already there when revisions started being recorded count from their own time.
+			fe := feedExam{subject: subject, exam: e, published: e.ModTime, updated: e.ModTime}
+			if revs := revisions.List(subject, e.Name); len(revs) > 0 {
+				if revs[0].SeenAt.Before(fe.published) {
+					fe.published = revs[0].SeenAt
+				}
+				fe.updated = fe.published
+				if len(revs) > 1 {
+					fe.updated = revs[len(revs)-1].SeenAt
+				}
+			}
+			exams = append(exams, fe)
+		})
+		slices.SortFunc(exams, func(a, b feedExam) int {
+			return cmp.Or(b.updated.Compare(a.updated), cmp.Compare(a.subject, b.subject), cmp.Compare(a.exam.Name, b.exam.Name))
+		})
+		exams = exams[:min(len(exams), feedEntries)]
+
+		scheme := "https"
+		if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+			scheme = "http"
+		}
+		base := scheme + "://" + r.Host
+		feed := atomFeed{
+			ID:     base + "/feed.xml",
+			Title:  "Mock exams",
+			Author: atomName{Name: "Mock Exam"},
+			Links: []atomLink{
+				{Rel: "self", Type: "application/atom+xml", Href: base + "/feed.xml"},
+				{Rel: "alternate", Type: "text/html", Href: base + "/"},
+			},
+		}
+		var newest time.Time
+		for _, fe := range exams {
+			e := fe.exam
+			entry := atomEntry{
+				ID:        base + apiPrefix + "/exams/" + url.PathEscape(fe.subject) + "/" + url.PathEscape(e.ID),
+				Title:     examTitle(e),
+				Updated:   fe.updated.UTC().Format(time.RFC3339),
+				Published: fe.published.UTC().Format(time.RFC3339),
+				Category:  []atomTerm{{Term: fe.subject}},
+				Link:      atomLink{Rel: "alternate", Type: "text/html", Href: base + "/?exam=" + url.QueryEscape(fe.subject+"/"+cmp.Or(e.Slug, e.Name))},
+				Summary:   fmt.Sprintf("%d question(s), about %d minute(s)", e.QuestionCount, e.EstimatedMinutes),
+			}
+			if e.Meta != nil {
+				if e.Meta.Author != "" {
+					entry.Author = &atomName{Name: e.Meta.Author}
+				}
+				if e.Meta.Description != "" {
+					entry.Summary = e.Meta.Description + " (" + entry.Summary + ")"
+				}
+			}
+			feed.Entries = append(feed.Entries, entry)
+			if fe.updated.After(newest) {
+				newest = fe.updated
+			}
+		}
+		if newest.IsZero() {
+			newest = time.Now()
+		}
+		feed.Updated = newest.UTC().Format(time.RFC3339)
+
+		var buf bytes.Buffer
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		if err := enc.Encode(feed); err != nil {
+			http.Error(w, "Failed to encode feed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		http.ServeContent(w, r, "", newest.Truncate(time.Second), bytes.NewReader(buf.Bytes()))
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// flagReasons lists the reasons a question can be flagged for
+var flagReasons = []string{"wrong", "ambiguous", "typo", "other"}
+
+// Statuses of a flag in the moderation queue
+const (
+	flagOpen      = "open"
+	flagResolved  = "resolved"
+	flagDismissed = "dismissed"
+)
+
+var errFlagNotFound = errors.New("flag not found")
+
+// QuestionFlag is a report that a question is wrong or unclear
+type QuestionFlag struct {
+	ID         string    `json:"id"`
+	QuestionID string    `json:"questionId"`
+	Subject    string    `json:"subject"`
+	Exam       string    `json:"exam"`
+	ExamID     string    `json:"examId,omitempty"`
+	Index      int       `json:"index"`
+	Question   string    `json:"question"` // question text when flagged, so the queue reads without the exam at hand
+	UserID     string    `json:"userId,omitempty"`
+	Reason     string    `json:"reason"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Status     string    `json:"status"`
+	ClosedAt   time.Time `json:"closedAt,omitzero"`
+	Note       string    `json:"note,omitempty"`
+}
+
+// flagStore keeps question flags in a JSON file
+type flagStore struct {
+	path string
+
+	mu    sync.RWMutex
+	flags map[string]*QuestionFlag
+}
+
+// openFlagStore loads the flags saved at path
+func openFlagStore(path string) (*flagStore, error) {
+	s := &flagStore{path: path, flags: map[string]*QuestionFlag{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read question flags: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.flags); err != nil {
+		return nil, fmt.Errorf("failed to parse question flags: %w", err)
+	}
+	return s, nil
+}
+
+// save writes every flag; the caller holds the lock
+func (s *flagStore) save() error {
+	data, err := json.MarshalIndent(s.flags, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// Add records a new open flag
+func (s *flagStore) Add(f QuestionFlag) (QuestionFlag, error) {
+	f.ID = newID()
+	f.CreatedAt = time.Now().UTC()
+	f.Status = flagOpen
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags[f.ID] = &f
+	if err := s.save(); err != nil {
+		delete(s.flags, f.ID)
+		return QuestionFlag{}, err
+	}
+	return f, nil
+}
+
+// List returns the flags with a status, or every flag when status is empty, newest first
+func (s *flagStore) List(status, questionID string) []QuestionFlag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []QuestionFlag{}
+	for _, f := range s.flags {
+		if (status == "" || f.Status == status) && (questionID == "" || f.QuestionID == questionID) {
+			out = append(out, *f)
+		}
+	}
+	slices.SortFunc(out, func(a, b QuestionFlag) int { return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID)) })
+	return out
+}
+
+// Close resolves or dismisses a flag with an optional note; flags outside scope are not found
+func (s *flagStore) Close(id, status, note string, scope subjectScope) (QuestionFlag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.flags[id]
+	if f == nil || !scope.allows(f.Subject) {
+		return QuestionFlag{}, errFlagNotFound
+	}
+	prev := *f
+	f.Status, f.Note, f.ClosedAt = status, note, time.Now().UTC()
+	if err := s.save(); err != nil {
+		*f = prev
+		return QuestionFlag{}, err
+	}
+	return *f, nil
+}
+
+// ByUser returns the flags a user raised, oldest first
+func (s *flagStore) ByUser(user string) []QuestionFlag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []QuestionFlag{}
+	for _, f := range s.flags {
+		if f.UserID == user {
+			out = append(out, *f)
+		}
+	}
+	slices.SortFunc(out, func(a, b QuestionFlag) int { return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID)) })
+	return out
+}
+
+// EraseUser removes a user and their comment from the flags they raised, which stay in the moderation queue
+func (s *flagStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var erased []*QuestionFlag
+	for _, f := range s.flags {
+		if f.UserID == user {
+			erased = append(erased, f)
+		}
+	}
+	if len(erased) == 0 {
+		return nil
+	}
+	prev := make([]QuestionFlag, len(erased))
+	for i, f := range erased {
+		prev[i] = *f
+		f.UserID, f.Comment = "", ""
+	}
+	if err := s.save(); err != nil {
+		for i, f := range erased {
+			*f = prev[i]
+		}
+		return err
+	}
+	return nil
+}
+
+// flagQuestion returns a handler that lets a test taker flag a question as wrong or ambiguous
+func flagQuestion(store *examStore, flags *flagStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserID  string `json:"userId"`
+			Reason  string `json:"reason"`
+			Comment string `json:"comment"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(flagReasons, req.Reason) {
+			http.Error(w, "reason must be one of "+strings.Join(flagReasons, ", "), http.StatusBadRequest)
+			return
+		}
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, ok := exam.FindQuestion(subjects, r.PathValue("id"))
+		if !ok {
+			http.Error(w, "Question not found", http.StatusNotFound)
+			return
+		}
+		text, _ := p.Question.(map[string]any)["question"].(string)
+
+		f, err := flags.Add(QuestionFlag{
+			QuestionID: p.ID,
+			Subject:    p.Subject,
+			Exam:       p.Exam,
+			ExamID:     p.ExamID,
+			Index:      p.Index,
+			Question:   text,
+			UserID:     req.UserID,
+			Reason:     req.Reason,
+			Comment:    strings.TrimSpace(req.Comment),
+		})
+		if err != nil {
+			http.Error(w, "Failed to save flag: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f)
+	}
+}
+
+// listFlags returns a handler that lists the moderation queue of the subjects of the token, open flags by default
+// (?status=open|resolved|dismissed|all)
+func listFlags(flags *flagStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := cmp.Or(r.URL.Query().Get("status"), flagOpen)
+		switch status {
+		case "all":
+			status = ""
+		case flagOpen, flagResolved, flagDismissed:
+		default:
+			http.Error(w, "Unknown status "+status, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		scope := scopeOf(r)
+		json.NewEncoder(w).Encode(slices.DeleteFunc(flags.List(status, r.URL.Query().Get("question")), func(f QuestionFlag) bool {
+			return !scope.allows(f.Subject)
+		}))
+	}
+}
+
+// closeFlag returns a handler that moves a flag out of the queue with the given status
+func closeFlag(flags *flagStore, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := flags.Close(r.PathValue("id"), status, req.Note, scopeOf(r))
+		if errors.Is(err, errFlagNotFound) {
+			http.Error(w, "Flag not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save flag: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/csv"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gradebookLayouts maps the LMS gradebook formats to the identifier columns their CSV import expects
+var gradebookLayouts = map[string][]string{
+	"canvas": {"Student", "ID", "SIS User ID", "SIS Login ID", "Section"},
+	"moodle": {"ID number"},
+}
+
+// gradebookRow is the best score of every assignment for one student
+type gradebookRow struct {
+	userID string
+	scores map[string]Attempt
+}
+
+// assignmentName returns the gradebook column name of the exam an attempt belongs to
+func assignmentName(a Attempt) string {
+	return a.Subject + " - " + strings.TrimSuffix(a.Exam, path.Ext(a.Exam))
+}
+
+// writeGradebook writes the best attempt of every student per exam in the import layout of an LMS gradebook
+func writeGradebook(w http.ResponseWriter, layout string, list []Attempt) {
+	var assignments []string
+	points := map[string]int{}
+	rows := map[string]*gradebookRow{}
+	var order []string
+	for _, a := range list {
+		// Gradebooks match rows to students, so anonymous attempts cannot be imported
+		if a.UserID == "" {
+			continue
+		}
+		name := assignmentName(a)
+		if _, ok := points[name]; !ok {
+			assignments = append(assignments, name)
+		}
+		points[name] = max(points[name], a.Total)
+
+		row := rows[a.UserID]
+		if row == nil {
+			row = &gradebookRow{userID: a.UserID, scores: map[string]Attempt{}}
+			rows[a.UserID] = row
+			order = append(order, a.UserID)
+		}
+		if best, ok := row.scores[name]; !ok || a.Percent > best.Percent {
+			row.scores[name] = a
+		}
+	}
+	slices.Sort(assignments)
+	slices.Sort(order)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="gradebook-`+layout+"-"+time.Now().UTC().Format("20060102")+`.csv"`)
+	cw := csv.NewWriter(w)
+
+	idColumns := gradebookLayouts[layout]
+	cw.Write(append(slices.Clone(idColumns), assignments...))
+	if layout == "canvas" {
+		// Canvas reads the maximum score of each assignment from a "Points Possible" row
+		row := make([]string, len(idColumns))
+		row[0] = "    Points Possible"
+		for _, name := range assignments {
+			row = append(row, strconv.Itoa(points[name]))
+		}
+		cw.Write(row)
+	}
+
+	for _, id := range order {
+		row := make([]string, len(idColumns))
+		switch layout {
+		case "canvas":
+			row[0], row[2] = id, id
+		default:
+			row[0] = id
+		}
+		for _, name := range assignments {
+			cell := ""
+			if a, ok := rows[id].scores[name]; ok {
+				cell = strconv.Itoa(a.Score)
+			}
+			row = append(row, cell)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Group is a class or cohort of users who are given the same exams
+type Group struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"`
+	Exams     []ExamRef `json:"exams"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Invite is a tokenized link that registers whoever redeems it into a group
+type Invite struct {
+	Token     string    `json:"token"`
+	GroupID   string    `json:"groupId"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+	MaxUses   int       `json:"maxUses,omitempty"` // unlimited when 0
+	Uses      int       `json:"uses"`
+}
+
+// Errors returned by the group store
+var (
+	errGroupNotFound  = errors.New("group not found")
+	errInviteNotFound = errors.New("invite not found")
+	errInviteExpired  = errors.New("invite has expired")
+	errInviteUsedUp   = errors.New("invite has been used up")
+)
+
+// groupData is the content of the groups file
+type groupData struct {
+	Groups  map[string]*Group  `json:"groups"`
+	Invites map[string]*Invite `json:"invites"`
+}
+
+// groupStore keeps groups and their invites in a JSON file
+type groupStore struct {
+	path string
+
+	mu   sync.RWMutex
+	data groupData
+}
+
+// openGroupStore loads the groups saved at path
+func openGroupStore(path string) (*groupStore, error) {
+	s := &groupStore{path: path, data: groupData{Groups: map[string]*Group{}, Invites: map[string]*Invite{}}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read groups: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse groups: %w", err)
+	}
+	if s.data.Groups == nil {
+		s.data.Groups = map[string]*Group{}
+	}
+	if s.data.Invites == nil {
+		s.data.Invites = map[string]*Invite{}
+	}
+	return s, nil
+}
+
+// update applies fn to the data under the lock and saves the result, discarding the change if fn or saving fails
+func (s *groupStore) update(fn func(d *groupData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// fn works on a deep copy so a failure leaves the stored data untouched
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	var next groupData
+	if err := json.Unmarshal(raw, &next); err != nil {
+		return err
+	}
+	if err := fn(&next); err != nil {
+		return err
+	}
+	raw, err = json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(s.path, raw); err != nil {
+		return err
+	}
+	s.data = next
+	return nil
+}
+
+// Groups returns copies of all groups, sorted by name
+func (s *groupStore) Groups() []Group {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Group, 0, len(s.data.Groups))
+	for _, g := range s.data.Groups {
+		out = append(out, cloneGroup(g))
+	}
+	slices.SortFunc(out, func(a, b Group) int {
+		if a.Name != b.Name {
+			if a.Name < b.Name {
+				return -1
+			}
+			return 1
+		}
+		if a.ID < b.ID {
+			return -1
+		}
+		return 1
+	})
+	return out
+}
+
+// Group returns a copy of the group with the given ID
+func (s *groupStore) Group(id string) (Group, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.data.Groups[id]
+	if !ok {
+		return Group{}, false
+	}
+	return cloneGroup(g), true
+}
+
+// cloneGroup copies a group so callers can not modify the stored slices
+func cloneGroup(g *Group) Group {
+	c := *g
+	c.Members = slices.Clone(g.Members)
+	c.Exams = slices.Clone(g.Exams)
+	return c
+}
+
+// CreateGroup adds an empty group
+func (s *groupStore) CreateGroup(name string) (Group, error) {
+	g := &Group{ID: newID(), Name: name, Members: []string{}, Exams: []ExamRef{}, CreatedAt: time.Now().UTC()}
+	err := s.update(func(d *groupData) error {
+		d.Groups[g.ID] = g
+		return nil
+	})
+	return cloneGroup(g), err
+}
+
+// CreateInvite adds an invite link to a group
+func (s *groupStore) CreateInvite(groupID string, ttl time.Duration, maxUses int) (Invite, error) {
+	inv := &Invite{Token: newID(), GroupID: groupID, CreatedAt: time.Now().UTC(), MaxUses: maxUses}
+	if ttl > 0 {
+		inv.ExpiresAt = inv.CreatedAt.Add(ttl)
+	}
+	err := s.update(func(d *groupData) error {
+		if d.Groups[groupID] == nil {
+			return errGroupNotFound
+		}
+		d.Invites[inv.Token] = inv
+		return nil
+	})
+	return *inv, err
+}
+
+// Invites returns the invites of a group, newest first
+func (s *groupStore) Invites(groupID string) []Invite {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Invite
+	for _, inv := range s.data.Invites {
+		if inv.GroupID == groupID {
+			out = append(out, *inv)
+		}
+	}
+	slices.SortFunc(out, func(a, b Invite) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return out
+}
+
+// RevokeInvite deletes an invite so it can no longer be redeemed
+func (s *groupStore) RevokeInvite(groupID, token string) error {
+	return s.update(func(d *groupData) error {
+		if inv := d.Invites[token]; inv == nil || inv.GroupID != groupID {
+			return errInviteNotFound
+		}
+		delete(d.Invites, token)
+		return nil
+	})
+}
+
+// Redeem registers user into the group of an invite. Redeeming again as a member does not use the invite up.
+func (s *groupStore) Redeem(token, user string) (Group, error) {
+	var group Group
+	err := s.update(func(d *groupData) error {
+		inv := d.Invites[token]
+		if inv == nil {
+			return errInviteNotFound
+		}
+		g := d.Groups[inv.GroupID]
+		if g == nil {
+			return errInviteNotFound
+		}
+		if !slices.Contains(g.Members, user) {
+			switch {
+			case !inv.ExpiresAt.IsZero() && time.Now().After(inv.ExpiresAt):
+				return errInviteExpired
+			case inv.MaxUses > 0 && inv.Uses >= inv.MaxUses:
+				return errInviteUsedUp
+			}
+			inv.Uses++
+			g.Members = append(g.Members, user)
+		}
+		group = cloneGroup(g)
+		return nil
+	})
+	return group, err
+}
+
+// RenameGroup changes the name of a group
+func (s *groupStore) RenameGroup(id, name string) (Group, error) {
+	var group Group
+	err := s.update(func(d *groupData) error {
+		g := d.Groups[id]
+		if g == nil {
+			return errGroupNotFound
+		}
+		g.Name = name
+		group = cloneGroup(g)
+		return nil
+	})
+	return group, err
+}
+
+// DeleteGroup removes a group and its invites
+func (s *groupStore) DeleteGroup(id string) error {
+	return s.update(func(d *groupData) error {
+		if d.Groups[id] == nil {
+			return errGroupNotFound
+		}
+		delete(d.Groups, id)
+		for token, inv := range d.Invites {
+			if inv.GroupID == id {
+				delete(d.Invites, token)
+			}
+		}
+		return nil
+	})
+}
+
+// SetMember adds user to a group, or removes them when member is false
+func (s *groupStore) SetMember(id, user string, member bool) (Group, error) {
+	var group Group
+	err := s.update(func(d *groupData) error {
+		g := d.Groups[id]
+		if g == nil {
+			return errGroupNotFound
+		}
+		i := slices.Index(g.Members, user)
+		switch {
+		case member && i < 0:
+			g.Members = append(g.Members, user)
+		case !member && i >= 0:
+			g.Members = slices.Delete(g.Members, i, i+1)
+		}
+		group = cloneGroup(g)
+		return nil
+	})
+	return group, err
+}
+
+// SetExam assigns an exam to a group, or unassigns it when assigned is false
+func (s *groupStore) SetExam(id string, exam ExamRef, assigned bool) (Group, error) {
+	var group Group
+	err := s.update(func(d *groupData) error {
+		g := d.Groups[id]
+		if g == nil {
+			return errGroupNotFound
+		}
+		i := slices.Index(g.Exams, exam)
+		switch {
+		case assigned && i < 0:
+			g.Exams = append(g.Exams, exam)
+		case !assigned && i >= 0:
+			g.Exams = slices.Delete(g.Exams, i, i+1)
+		}
+		group = cloneGroup(g)
+		return nil
+	})
+	return group, err
+}
+
+// MemberGroups returns the groups user belongs to
+func (s *groupStore) MemberGroups(user string) []Group {
+	var out []Group
+	for _, g := range s.Groups() {
+		if slices.Contains(g.Members, user) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// RemoveUser takes a user out of every group they belong to
+func (s *groupStore) RemoveUser(user string) error {
+	if len(s.MemberGroups(user)) == 0 {
+		return nil
+	}
+	return s.update(func(d *groupData) error {
+		for _, g := range d.Groups {
+			g.Members = slices.DeleteFunc(g.Members, func(m string) bool { return m == user })
+		}
+		return nil
+	})
+}
+
+// CanTake reports whether user may take an exam. Exams assigned to groups are reserved for their members;
+// exams that no group has been given are open to everyone.
+func (s *groupStore) CanTake(user string, exam ExamRef) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	assigned := false
+	for _, g := range s.data.Groups {
+		if slices.Contains(g.Exams, exam) {
+			if slices.Contains(g.Members, user) {
+				return true
+			}
+			assigned = true
+		}
+	}
+	return !assigned
+}
+
+// IsAssigned reports whether user belongs to a group the exam is assigned to
+func (s *groupStore) IsAssigned(user string, exam ExamRef) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, g := range s.data.Groups {
+		if slices.Contains(g.Exams, exam) && slices.Contains(g.Members, user) {
+			return true
+		}
+	}
+	return false
+}
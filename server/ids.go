@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// examIdentity is what the server remembers of an exam to keep its identifiers while its file changes
+type examIdentity struct {
+	ID        string             `json:"id"`
+	SHA256    string             `json:"sha256"`
+	Questions []questionIdentity `json:"questions,omitempty"` // questions without an id of their own, in order
+}
+
+// questionIdentity is the identifier given to a question, with what it is recognized by in a changed file
+type questionIdentity struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"` // of the question content
+	Text string `json:"text,omitempty"`
+}
+
+// idStore assigns stable identifiers to the exams and questions whose files do not declare an "id", and saves
+// them in a JSON file so sessions, attempts, flags and usage keep pointing at the same exam and question after
+// a file is renamed or moved with its content unchanged, or its questions are edited or reordered.
+type idStore struct {
+	path string
+
+	mu    sync.Mutex
+	exams map[string]*examIdentity // by <subject>/<exam file name>
+}
+
+// openIDStore loads the identifiers saved at path
+func openIDStore(path string) (*idStore, error) {
+	s := &idStore{path: path, exams: map[string]*examIdentity{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read exam ids: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.exams); err != nil {
+		return nil, fmt.Errorf("failed to parse exam ids: %w", err)
+	}
+	return s, nil
+}
+
+// assign sets the identifier of every exam of subjects and every question of their content that has none,
+// saving the identifiers given out for the first time
+func (s *idStore) assign(subjects []exam.Subject) error {
+	present := map[string]bool{}
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		present[subject+"/"+e.Name] = true
+	})
+	return s.assignWith(subjects, func(key string) bool { return present[key] })
+}
+
+// assignWith is assign for part of the exam directory, with present reporting whether the exam of a
+// <subject>/<exam file name> key is still in the directory
+func (s *idStore) assignWith(subjects []exam.Subject, present func(key string) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	var walk func([]exam.Subject)
+	walk = func(subjects []exam.Subject) {
+		for i := range subjects {
+			subject := exam.SubjectID(subjects[i])
+			for j := range subjects[i].Exams {
+				if s.assignExam(subject, &subjects[i].Exams[j], present) {
+					changed = true
+				}
+			}
+			walk(subjects[i].Subjects)
+		}
+	}
+	walk(subjects)
+	if !changed {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.exams, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// assignExam gives an exam and its questions their identifiers, reporting whether any identity changed.
+// An exam not seen before under its name takes the identity of a vanished one with the same content, which
+// is the same file renamed. s.mu must be held.
+func (s *idStore) assignExam(subject string, e *exam.ExamFile, present func(key string) bool) bool {
+	key := subject + "/" + e.Name
+	ident := s.exams[key]
+	changed := false
+	fresh := false
+	if ident == nil {
+		for old, candidate := range s.exams {
+			if !present(old) && candidate.SHA256 == e.SHA256 {
+				ident = candidate
+				delete(s.exams, old)
+				break
+			}
+		}
+		if ident == nil {
+			ident = &examIdentity{ID: exam.NewID()}
+			fresh = true
+		}
+		s.exams[key] = ident
+		changed = true
+	}
+	// An id declared by the file wins over the one given by the server
+	if e.ID != "" && e.ID != ident.ID {
+		ident.ID = e.ID
+		changed = true
+	}
+	e.ID = ident.ID
+
+	var questions []map[string]any
+	var positions []int
+	for i, item := range exam.Questions(e.Content) {
+		if q, ok := item.(map[string]any); ok {
+			if id, _ := q["id"].(string); id == "" {
+				questions = append(questions, q)
+				positions = append(positions, i)
+			}
+		}
+	}
+	if ident.SHA256 != e.SHA256 || len(ident.Questions) != len(questions) {
+		ident.Questions = pairQuestionIDs(ident.Questions, questions, func(i int) string {
+			// The questions of an exam first seen keep the identifiers they had by position, so flags and
+			// comments recorded before identifiers were assigned still point at them
+			if fresh {
+				return exam.PositionalQuestionID(subject, e.Name, positions[i])
+			}
+			return exam.NewID()
+		})
+		ident.SHA256 = e.SHA256
+		changed = true
+	}
+	for i, q := range questions {
+		q["id"] = ident.Questions[i].ID
+	}
+	return changed
+}
+
+// pairQuestionIDs returns the identities of the questions of a changed exam. Each question takes the
+// identifier of an earlier question with the same content, then one with the same text, then the one at the
+// same position; the rest get a new identifier from newID.
+func pairQuestionIDs(previous []questionIdentity, questions []map[string]any, newID func(i int) string) []questionIdentity {
+	out := make([]questionIdentity, len(questions))
+	for i, q := range questions {
+		out[i].Hash = questionHash(q)
+		out[i].Text, _ = q["question"].(string)
+	}
+	taken := make([]bool, len(previous))
+	pair := func(match func(prev, cur questionIdentity, i, j int) bool) {
+		for i := range out {
+			if out[i].ID != "" {
+				continue
+			}
+			for j, prev := range previous {
+				if !taken[j] && match(prev, out[i], i, j) {
+					out[i].ID, taken[j] = prev.ID, true
+					break
+				}
+			}
+		}
+	}
+	pair(func(prev, cur questionIdentity, _, _ int) bool { return prev.Hash == cur.Hash })
+	pair(func(prev, cur questionIdentity, _, _ int) bool { return cur.Text != "" && prev.Text == cur.Text })
+	pair(func(_, _ questionIdentity, i, j int) bool { return i == j })
+	for i := range out {
+		if out[i].ID == "" {
+			out[i].ID = newID(i)
+		}
+	}
+	return out
+}
+
+// questionHash returns a hash of the content of a question
+func questionHash(q map[string]any) string {
+	data, _ := json.Marshal(q)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/VanzPaul/Mock_Exam/storage"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// maxImageDimension caps the width and height that can be requested for a resized image
+const maxImageDimension = 4096
+
+// imageFormats maps the output formats of resized images to their MIME types
+var imageFormats = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
+// resizeOptions describes the transformation requested for an image
+type resizeOptions struct {
+	width, height int
+	format        string
+}
+
+// parseResizeOptions reads the w, h, and format query parameters, reporting whether any transformation was requested
+func parseResizeOptions(r *http.Request) (resizeOptions, bool, error) {
+	q := r.URL.Query()
+	var opts resizeOptions
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"w", &opts.width}, {"h", &opts.height}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxImageDimension {
+			return opts, false, fmt.Errorf("%s must be an integer between 1 and %d", p.name, maxImageDimension)
+		}
+		*p.dst = n
+	}
+
+	opts.format = q.Get("format")
+	if opts.format == "jpg" {
+		opts.format = "jpeg"
+	}
+	if _, ok := imageFormats[opts.format]; opts.format != "" && !ok {
+		return opts, false, fmt.Errorf("unsupported format %q", opts.format)
+	}
+	return opts, opts.width > 0 || opts.height > 0 || opts.format != "", nil
+}
+
+// serveResizedImage serves the image read from src transformed by opts, reusing a cached rendition from cacheDir when possible
+func serveResizedImage(w http.ResponseWriter, r *http.Request, name string, src io.Reader, info os.FileInfo, opts resizeOptions, cacheDir string) {
+	key := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%d|%d|%d|%s", name, info.ModTime().UnixNano(), info.Size(), opts.width, opts.height, opts.format))
+	cachePath := filepath.Join(cacheDir, hex.EncodeToString(key[:]))
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		data, err = renderImage(src, &opts)
+		if err != nil {
+			http.Error(w, "Failed to process image: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err := storage.WriteFileAtomic(cachePath, data); err != nil {
+			// A missing cache only costs CPU, so keep serving
+			fmt.Printf("Warning: failed to cache resized image %s: %v\n", cachePath, err)
+		}
+	}
+
+	contentType := http.DetectContentType(data)
+	if bytes.HasPrefix(data, []byte("RIFF")) && len(data) > 12 && string(data[8:12]) == "WEBP" {
+		contentType = "image/webp"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// renderImage decodes an image, scales it down to fit opts, and encodes it in the requested format
+func renderImage(r io.Reader, opts *resizeOptions) ([]byte, error) {
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.format == "" {
+		// GIF and WebP sources are re-encoded as PNG unless another format is requested
+		opts.format = format
+		if format != "jpeg" {
+			opts.format = "png"
+		}
+	}
+
+	dst := src
+	if w, h, ok := fitWithin(src.Bounds().Dx(), src.Bounds().Dy(), opts.width, opts.height); ok {
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Over, nil)
+		dst = scaled
+	}
+
+	var buf bytes.Buffer
+	switch opts.format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(&buf, dst)
+	case "webp":
+		err = nativewebp.Encode(&buf, dst, nil)
+	default:
+		err = errors.New("unsupported format " + opts.format)
+	}
+	return buf.Bytes(), err
+}
+
+// fitWithin computes the size of a w×h image scaled down to fit maxW×maxH, keeping its aspect ratio; zero means unbounded
+func fitWithin(w, h, maxW, maxH int) (int, int, bool) {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH {
+		scale = min(scale, float64(maxH)/float64(h))
+	}
+	if scale >= 1 {
+		// Never upscale, it only makes the file bigger
+		return w, h, false
+	}
+	return max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5)), true
+}
+
+// defaultImageCacheDir returns the directory used to cache resized images when none is configured
+func defaultImageCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "mockexam", "images")
+	}
+	return filepath.Join(os.TempDir(), "mockexam-images")
+}
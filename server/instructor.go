@@ -0,0 +1,42 @@
+package server
+
+// instructorRoles are the roles allowed to use the instructor API
+var instructorRoles = []string{roleInstructor, roleAdmin}
+
+// registerInstructorRoutes adds the instructor API endpoints to instructor, the group of routes under /api/v1/instructor
+func registerInstructorRoutes(instructor *router, store *examStore, attempts *attemptStore, codes *accessCodeStore, groups *groupStore, drafts *draftStore, publishApproval bool, flags *flagStore, similar *similarityStore, comments *commentStore, ratings *ratingStore, sessions *sessionStore, cal *calibrationStore) {
+	accessCode := manageAccessCode(store, codes)
+	instructor.HandleFunc("GET /exams/{subject}/{exam}/access-code", accessCode)
+	instructor.HandleFunc("PUT /exams/{subject}/{exam}/access-code", accessCode)
+	instructor.HandleFunc("DELETE /exams/{subject}/{exam}/access-code", accessCode)
+	instructor.HandleFunc("GET /exams/{subject}/{exam}/feedback", examFeedback(store, ratings))
+	instructor.HandleFunc("GET /exams/{subject}/{exam}/questions", examQuestionStats(store, attempts, cal))
+	instructor.HandleFunc("GET /drafts", listDrafts(drafts))
+	instructor.HandleFunc("POST /exams/{subject}/{exam}/publish", publishExam(store, drafts, publishApproval))
+	instructor.HandleFunc("POST /exams/{subject}/{exam}/approve", approveExam(store, drafts))
+
+	instructor.HandleFunc("GET /overview", instructorOverview(groups, attempts))
+	instructor.HandleFunc("GET /groups", listGroups(groups))
+	instructor.HandleFunc("POST /groups", createGroup(groups))
+	instructor.HandleFunc("GET /groups/{id}", getGroup(groups))
+	instructor.HandleFunc("PATCH /groups/{id}", renameGroup(groups))
+	instructor.HandleFunc("DELETE /groups/{id}", deleteGroup(groups))
+	instructor.HandleFunc("PUT /groups/{id}/members/{user}", setGroupMember(groups))
+	instructor.HandleFunc("DELETE /groups/{id}/members/{user}", setGroupMember(groups))
+	instructor.HandleFunc("PUT /groups/{id}/exams/{subject}/{exam}", setGroupExam(store, groups))
+	instructor.HandleFunc("DELETE /groups/{id}/exams/{subject}/{exam}", setGroupExam(store, groups))
+	instructor.HandleFunc("GET /groups/{id}/results", groupResults(groups, attempts))
+	instructor.HandleFunc("GET /groups/{id}/invites", listInvites(groups))
+	instructor.HandleFunc("POST /groups/{id}/invites", createInvite(groups))
+	instructor.HandleFunc("DELETE /groups/{id}/invites/{token}", revokeInvite(groups))
+
+	instructor.HandleFunc("GET /sessions/{id}/integrity", sessionIntegrity(sessions))
+
+	instructor.HandleFunc("GET /flags", listFlags(flags))
+	instructor.HandleFunc("POST /flags/{id}/resolve", closeFlag(flags, flagResolved))
+	instructor.HandleFunc("POST /flags/{id}/dismiss", closeFlag(flags, flagDismissed))
+	instructor.HandleFunc("GET /similarity", listSimilarity(similar))
+	instructor.HandleFunc("POST /similarity/{id}/confirm", closeSimilarity(similar, similarityConfirmed))
+	instructor.HandleFunc("POST /similarity/{id}/dismiss", closeSimilarity(similar, similarityDismissed))
+	instructor.HandleFunc("DELETE /comments/{id}", deleteComment(store, comments))
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// listGroups returns a handler that lists all groups
+func listGroups(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups.Groups())
+	}
+}
+
+// createGroup returns a handler that adds a group
+func createGroup(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "Missing group name", http.StatusBadRequest)
+			return
+		}
+		g, err := groups.CreateGroup(req.Name)
+		if err != nil {
+			http.Error(w, "Failed to save group: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(g)
+	}
+}
+
+// groupResponse writes a group, or the error of a group store operation
+func groupResponse(w http.ResponseWriter, g Group, err error) {
+	if errors.Is(err, errGroupNotFound) {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// getGroup returns a handler that returns one group
+func getGroup(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := groups.Group(r.PathValue("id"))
+		if !ok {
+			groupResponse(w, g, errGroupNotFound)
+			return
+		}
+		groupResponse(w, g, nil)
+	}
+}
+
+// renameGroup returns a handler that changes the name of a group
+func renameGroup(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "Missing group name", http.StatusBadRequest)
+			return
+		}
+		g, err := groups.RenameGroup(r.PathValue("id"), req.Name)
+		groupResponse(w, g, err)
+	}
+}
+
+// deleteGroup returns a handler that removes a group
+func deleteGroup(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := groups.DeleteGroup(r.PathValue("id")); err != nil {
+			groupResponse(w, Group{}, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setGroupMember returns a handler that adds (PUT) or removes (DELETE) a member of a group
+func setGroupMember(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, err := groups.SetMember(r.PathValue("id"), r.PathValue("user"), r.Method == http.MethodPut)
+		groupResponse(w, g, err)
+	}
+}
+
+// setGroupExam returns a handler that assigns (PUT) or unassigns (DELETE) an exam of a group
+func setGroupExam(store *examStore, groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref := ExamRef{Subject: r.PathValue("subject"), Name: r.PathValue("exam")}
+		if r.Method == http.MethodPut {
+			// Assignments name the exam file so they match however the exam is referenced later
+			subjects, err := store.Subjects(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			e, ok := exam.FindExam(subjects, ref.Subject, ref.Name)
+			if !ok {
+				http.Error(w, "Exam not found", http.StatusNotFound)
+				return
+			}
+			ref.Name = e.Name
+		} else if g, ok := groups.Group(r.PathValue("id")); ok {
+			for _, assigned := range g.Exams {
+				if assigned.Subject == ref.Subject && strings.TrimSuffix(assigned.Name, path.Ext(assigned.Name)) == ref.Name {
+					ref.Name = assigned.Name
+				}
+			}
+		}
+		g, err := groups.SetExam(r.PathValue("id"), ref, r.Method == http.MethodPut)
+		groupResponse(w, g, err)
+	}
+}
+
+// groupResults returns a handler that lists the attempts of a group's members at the group's exams
+func groupResults(groups *groupStore, attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := groups.Group(r.PathValue("id"))
+		if !ok {
+			groupResponse(w, g, errGroupNotFound)
+			return
+		}
+		scope := scopeOf(r)
+		list := attempts.List(func(a Attempt) bool {
+			return slices.Contains(g.Members, a.UserID) && slices.Contains(g.Exams, ExamRef{Subject: a.Subject, Name: a.Exam}) && scope.allows(a.Subject)
+		})
+		if list == nil {
+			list = []Attempt{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// userGroups returns a handler that lists the groups of a user with the exams assigned to them
+func userGroups(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := []map[string]any{}
+		for _, g := range groups.MemberGroups(r.PathValue("id")) {
+			out = append(out, map[string]any{"id": g.ID, "name": g.Name, "exams": g.Exams})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// inviteResponse is an invite together with the link that redeems it
+type inviteResponse struct {
+	Invite
+	URL string `json:"url"`
+}
+
+// inviteURL returns the link students open to redeem an invite
+func inviteURL(r *http.Request, token string) string {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + "/?invite=" + token
+}
+
+// createInvite returns a handler that generates an invite link for a group
+func createInvite(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ExpiresIn string `json:"expiresIn"` // Go duration, e.g. "168h"; never expires when empty
+			MaxUses   int    `json:"maxUses"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if req.ExpiresIn != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+				http.Error(w, "Invalid expiresIn duration", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.MaxUses < 0 {
+			http.Error(w, "Invalid maxUses", http.StatusBadRequest)
+			return
+		}
+
+		inv, err := groups.CreateInvite(r.PathValue("id"), ttl, req.MaxUses)
+		if errors.Is(err, errGroupNotFound) {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save invite: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(inviteResponse{Invite: inv, URL: inviteURL(r, inv.Token)})
+	}
+}
+
+// listInvites returns a handler that lists the invites of a group
+func listInvites(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := groups.Group(r.PathValue("id")); !ok {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		invites := []inviteResponse{}
+		for _, inv := range groups.Invites(r.PathValue("id")) {
+			invites = append(invites, inviteResponse{Invite: inv, URL: inviteURL(r, inv.Token)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invites)
+	}
+}
+
+// revokeInvite returns a handler that deletes an invite of a group
+func revokeInvite(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := groups.RevokeInvite(r.PathValue("id"), r.PathValue("token"))
+		if errors.Is(err, errInviteNotFound) {
+			http.Error(w, "Invite not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to revoke invite: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// redeemInvite returns a handler that registers a user into the group of an invite
+func redeemInvite(groups *groupStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserID string `json:"userId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.UserID == "" {
+			http.Error(w, "Missing userId", http.StatusBadRequest)
+			return
+		}
+
+		g, err := groups.Redeem(r.PathValue("token"), req.UserID)
+		switch {
+		case errors.Is(err, errInviteNotFound):
+			http.Error(w, "Invite not found", http.StatusNotFound)
+			return
+		case errors.Is(err, errInviteExpired), errors.Is(err, errInviteUsedUp):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case err != nil:
+			http.Error(w, "Failed to redeem invite: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Members only learn the group's name and exams, not who else is in it
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"id": g.ID, "name": g.Name, "exams": g.Exams})
+	}
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Defaults of the job settings of the configuration file
+const (
+	defaultSessionMaxIdle  = 72 * time.Hour
+	defaultBackupsKept     = 7
+	defaultPublishSchedule = "@every 1m"
+)
+
+// jobsConfig schedules the background jobs of the configuration file; a job without a schedule only runs when
+// started through the admin API
+type jobsConfig struct {
+	Analytics    jobConfig `json:"analytics"`    // aggregates the attempts of the day before into data/analytics
+	GitSync      jobConfig `json:"gitSync"`      // pulls the exam directory from its Git remote
+	SessionSweep jobConfig `json:"sessionSweep"` // expires sessions left idle
+	Backup       jobConfig `json:"backup"`       // snapshots the data directory into data/backups
+	Retention    jobConfig `json:"retention"`    // purges the records older than the retention settings keep them
+	Similarity   jobConfig `json:"similarity"`   // flags pairs of suspiciously alike submissions to the same exam sitting
+	Calibration  jobConfig `json:"calibration"`  // estimates the difficulty and discrimination of questions from the attempts
+	Parquet      jobConfig `json:"parquet"`      // exports results and integrity events as Parquet files for analysis
+	Publish      jobConfig `json:"publish"`      // publishes the drafts scheduled for publication; defaults to every minute
+}
+
+// jobConfig holds the schedule of a job, a cron expression of minute hour day-of-month month day-of-week in
+// local time, @hourly, @daily, @weekly, @monthly or "@every <duration>", with the settings some jobs take
+type jobConfig struct {
+	Schedule     string  `json:"schedule"`
+	MaxIdleHours int     `json:"maxIdleHours,omitempty"` // sessionSweep: idle time after which sessions expire; defaults to 72
+	Keep         int     `json:"keep,omitempty"`         // backup: number of snapshots kept; defaults to 7
+	Threshold    float64 `json:"threshold,omitempty"`    // similarity: score from 0 to 1 from which pairs are flagged; defaults to 0.8
+	Destination  string  `json:"destination,omitempty"`  // parquet: directory or s3://bucket/prefix URL of the files; defaults to data/exports
+}
+
+// check reports an invalid schedule or setting of a job
+func (c jobConfig) check(name string) error {
+	if c.Schedule != "" {
+		if _, err := parseSchedule(c.Schedule); err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+	}
+	if c.MaxIdleHours < 0 || c.Keep < 0 {
+		return fmt.Errorf("job %s: settings must not be negative", name)
+	}
+	if c.Threshold < 0 || c.Threshold > 1 {
+		return fmt.Errorf("job %s: threshold must be between 0 and 1", name)
+	}
+	return nil
+}
+
+// serverJobs returns the background jobs of the server
+func (s *Server) serverJobs(cfg Config) []*job {
+	return []*job{
+		{name: "analytics", schedule: func(c jobsConfig) string { return c.Analytics.Schedule }, run: func(ctx context.Context, _ jobsConfig) error {
+			return aggregateAnalytics(s.attempts, filepath.Join(cfg.DataDir, "analytics"), time.Now())
+		}},
+		{name: "gitSync", schedule: func(c jobsConfig) string { return c.GitSync.Schedule }, run: func(ctx context.Context, _ jobsConfig) error {
+			return pullExamDir(ctx, s.store, cfg.Dir)
+		}},
+		{name: "sessionSweep", schedule: func(c jobsConfig) string { return c.SessionSweep.Schedule }, run: func(ctx context.Context, c jobsConfig) error {
+			maxIdle := cmp.Or(time.Duration(c.SessionSweep.MaxIdleHours)*time.Hour, defaultSessionMaxIdle)
+			return s.sessions.expireIdle(time.Now().Add(-maxIdle))
+		}},
+		{name: "backup", schedule: func(c jobsConfig) string { return c.Backup.Schedule }, run: func(ctx context.Context, c jobsConfig) error {
+			return backupDataDir(ctx, cfg.DataDir, cmp.Or(c.Backup.Keep, defaultBackupsKept), time.Now())
+		}},
+		{name: "retention", schedule: func(c jobsConfig) string { return c.Retention.Schedule }, run: func(ctx context.Context, _ jobsConfig) error {
+			_, err := applyRetention(s.live.current().Retention, s.sessions, s.attempts, time.Now().UTC(), false)
+			return err
+		}},
+		{name: "similarity", schedule: func(c jobsConfig) string { return c.Similarity.Schedule }, run: func(ctx context.Context, c jobsConfig) error {
+			return analyzeSimilarity(s.sessions, s.attempts, s.similar, cmp.Or(c.Similarity.Threshold, defaultSimilarityThreshold), time.Now().UTC())
+		}},
+		{name: "calibration", schedule: func(c jobsConfig) string { return c.Calibration.Schedule }, run: func(ctx context.Context, _ jobsConfig) error {
+			return calibrate(ctx, s.store, s.attempts, s.cal, time.Now().UTC())
+		}},
+		{name: "parquet", schedule: func(c jobsConfig) string { return c.Parquet.Schedule }, run: func(ctx context.Context, c jobsConfig) error {
+			return exportParquet(ctx, s.attempts, s.sessions, c.Parquet.Destination, cfg.DataDir, time.Now())
+		}},
+		{name: "publish", schedule: func(c jobsConfig) string { return cmp.Or(c.Publish.Schedule, defaultPublishSchedule) }, run: func(ctx context.Context, _ jobsConfig) error {
+			return publishDue(ctx, s.store, s.drafts, cfg.PublishApproval, time.Now())
+		}},
+	}
+}
+
+// ExamAnalytics aggregates the attempts at one exam over a day
+type ExamAnalytics struct {
+	Subject        string   `json:"subject"`
+	Exam           string   `json:"exam"`
+	ExamID         string   `json:"examId,omitempty"`
+	Attempts       int      `json:"attempts"`
+	Users          int      `json:"users"` // distinct signed-in users
+	AveragePercent float64  `json:"averagePercent"`
+	PassRate       *float64 `json:"passRate,omitempty"` // of the attempts at exams with a passing score
+}
+
+// aggregateAnalytics writes the aggregates of the attempts submitted on the day before now, in local time, to
+// <dir>/<date>.json, replacing the file of an earlier run for the same day
+func aggregateAnalytics(attempts *attemptStore, dir string, now time.Time) error {
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := end.AddDate(0, 0, -1)
+	list := attempts.List(func(a Attempt) bool {
+		return !a.SubmittedAt.Before(start) && a.SubmittedAt.Before(end)
+	})
+
+	type aggregate struct {
+		ExamAnalytics
+		users          map[string]bool
+		percent        float64
+		graded, passed int
+	}
+	byExam := map[string]*aggregate{}
+	for _, a := range list {
+		key := cmp.Or(a.ExamID, a.Subject+"/"+a.Exam)
+		agg := byExam[key]
+		if agg == nil {
+			agg = &aggregate{ExamAnalytics: ExamAnalytics{Subject: a.Subject, Exam: a.Exam, ExamID: a.ExamID}, users: map[string]bool{}}
+			byExam[key] = agg
+		}
+		agg.Attempts++
+		agg.percent += a.Percent
+		if a.UserID != "" {
+			agg.users[a.UserID] = true
+		}
+		if a.Passed != nil {
+			agg.graded++
+			if *a.Passed {
+				agg.passed++
+			}
+		}
+	}
+	out := make([]ExamAnalytics, 0, len(byExam))
+	for _, agg := range byExam {
+		agg.Users = len(agg.users)
+		agg.AveragePercent = agg.percent / float64(agg.Attempts)
+		if agg.graded > 0 {
+			rate := float64(agg.passed) / float64(agg.graded)
+			agg.PassRate = &rate
+		}
+		out = append(out, agg.ExamAnalytics)
+	}
+	slices.SortFunc(out, func(a, b ExamAnalytics) int {
+		return cmp.Or(cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Exam, b.Exam))
+	})
+
+	data, err := json.MarshalIndent(map[string]any{"date": start.Format(time.DateOnly), "exams": out}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(filepath.Join(dir, start.Format(time.DateOnly)+".json"), data); err != nil {
+		return fmt.Errorf("failed to write analytics: %w", err)
+	}
+	log.Printf("Aggregated %d attempt(s) of %s", len(list), start.Format(time.DateOnly))
+	return nil
+}
+
+// pullExamDir fast-forwards the Git checkout holding the exam directory and reloads the cached exam content
+func pullExamDir(ctx context.Context, store *examStore, dir string) error {
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "pull", "--ff-only").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git pull failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if !store.cached {
+		return nil
+	}
+	return store.Reload(ctx)
+}
+
+// expireIdle marks the sessions in progress and not paused without any activity since before as expired, so
+// they can no longer be resumed or submitted
+func (s *sessionStore) expireIdle(before time.Time) error {
+	sessions, err := s.backend.InProgress()
+	if err != nil {
+		return err
+	}
+	expired := 0
+	for _, session := range sessions {
+		// Paused sessions are meant to be resumed later, however much later
+		if session.paused() || !sessionIdleSince(session, before) {
+			continue
+		}
+		// A session used again in the meantime is left alone
+		_, err := s.update(session.ID, func(session *Session) error {
+			if session.Status != sessionInProgress || session.paused() || !sessionIdleSince(session, before) {
+				return errSessionConflict
+			}
+			session.Status = sessionExpired
+			return nil
+		})
+		if err == nil {
+			expired++
+		} else if !errors.Is(err, errSessionConflict) && !errors.Is(err, errSessionNotFound) {
+			return err
+		}
+	}
+	log.Printf("Expired %d idle session(s)", expired)
+	return nil
+}
+
+// sessionIdleSince reports whether a session was last used before the given time
+func sessionIdleSince(session *Session, before time.Time) bool {
+	last := session.StartedAt
+	for _, t := range []time.Time{session.SavedAt, session.LastSeen} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last.Before(before)
+}
+
+// backupDataDir writes a gzipped tar snapshot of the data directory to <dataDir>/backups/<time>.tar.gz and removes
+// all but the keep newest snapshots. Sessions kept in Redis are not part of the data directory.
+func backupDataDir(ctx context.Context, dataDir string, keep int, now time.Time) error {
+	backups := filepath.Join(dataDir, "backups")
+	if err := os.MkdirAll(backups, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(backups, ".backup-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Earlier snapshots and files being written are left out
+		if path == backups {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		return addTarFile(tw, dataDir, path)
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	target := filepath.Join(backups, now.UTC().Format("20060102T150405Z")+".tar.gz")
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(backups)
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tar.gz") {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	// Snapshot names sort by time
+	slices.Sort(snapshots)
+	for _, name := range snapshots[:max(0, len(snapshots)-keep)] {
+		if err := os.Remove(filepath.Join(backups, name)); err != nil {
+			return err
+		}
+	}
+	log.Printf("Backed up %s to %s", dataDir, target)
+	return nil
+}
+
+// addTarFile adds the file at path to tw under its path relative to root
+func addTarFile(tw *tar.Writer, root, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// Files appended to while they are copied are cut at the size they had
+	_, err = io.CopyN(tw, f, header.Size)
+	return err
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// jwtHeader is the JOSE header of a signed JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// jwk is an RSA public key in JSON Web Key form
+type jwk struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwkSet is a JSON Web Key Set document
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// signJWT returns claims as a compact RS256 JWT signed with key
+func signJWT(key *rsa.PrivateKey, kid string, claims any) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "RS256", Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + b64.EncodeToString(sig), nil
+}
+
+// parseJWT splits a compact JWT and decodes its header and claims without verifying it
+func parseJWT(token string, claims any) (jwtHeader, []byte, []byte, error) {
+	var header jwtHeader
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, errors.New("malformed token")
+	}
+	raw, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return header, nil, nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	raw, err = b64.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return header, nil, nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	return header, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+// verifyRS256 checks an RS256 signature over signed
+func verifyRS256(key *rsa.PublicKey, signed, sig []byte) error {
+	sum := sha256.Sum256(signed)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return errors.New("invalid token signature")
+	}
+	return nil
+}
+
+// publicJWK returns the JSON Web Key of an RSA public key
+func publicJWK(key *rsa.PublicKey, kid string) jwk {
+	return jwk{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: kid,
+		N:   b64.EncodeToString(key.N.Bytes()),
+		E:   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// rsaKey converts a JSON Web Key to an RSA public key
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	n, err := b64.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key modulus: %w", err)
+	}
+	e, err := b64.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exponent: %w", err)
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid key exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
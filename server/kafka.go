@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// kafkaTimeout bounds every request to a Kafka broker
+const kafkaTimeout = 5 * time.Second
+
+// Kafka API keys and the versions of them the producer speaks
+const (
+	kafkaProduce         = 0
+	kafkaProduceVersion  = 3 // the first version with record batches (message format v2)
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 1
+)
+
+// kafkaCRC is the checksum table of record batches, CRC-32C
+var kafkaCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaError is an error code returned by a broker
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+// kafkaProducer produces messages to Kafka over its binary protocol, without TLS or SASL. Messages are sent
+// one record batch at a time to the leader of the partition their key hashes to, as Kafka's default
+// partitioner would place them, and acknowledged by all in-sync replicas.
+type kafkaProducer struct {
+	bootstrap []string
+
+	mu          sync.Mutex
+	conns       map[string]*kafkaConn // open connections by broker address
+	leaders     map[string][]string   // broker address of the leader of every partition, by topic
+	correlation int32
+}
+
+// kafkaConn is one connection to a broker
+type kafkaConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newKafkaProducer creates a producer for a cluster reachable through any of the bootstrap brokers; it connects
+// on the first message
+func newKafkaProducer(bootstrap []string) *kafkaProducer {
+	for i, addr := range bootstrap {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			bootstrap[i] = net.JoinHostPort(addr, "9092")
+		}
+	}
+	return &kafkaProducer{bootstrap: bootstrap, conns: map[string]*kafkaConn{}, leaders: map[string][]string{}}
+}
+
+// publish produces a message to the partition of topic its key belongs to
+func (p *kafkaProducer) publish(topic, key string, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	leaders, ok := p.leaders[topic]
+	if !ok {
+		var err error
+		if leaders, err = p.metadata(topic); err != nil {
+			return err
+		}
+		p.leaders[topic] = leaders
+	}
+	partition := int32((murmur2([]byte(key)) & 0x7fffffff) % uint32(len(leaders)))
+
+	var req kafkaBuffer
+	req.i16(-1) // no transaction
+	req.i16(-1) // acknowledged by all in-sync replicas
+	req.i32(int32(kafkaTimeout / time.Millisecond))
+	req.i32(1)
+	req.str(topic)
+	req.i32(1)
+	req.i32(partition)
+	batch := recordBatch([]byte(key), value, time.Now())
+	req.i32(int32(len(batch)))
+	req = append(req, batch...)
+
+	resp, err := p.roundTrip(leaders[partition], kafkaProduce, kafkaProduceVersion, req)
+	if err != nil {
+		delete(p.leaders, topic)
+		return err
+	}
+	r := kafkaReader{b: resp}
+	for range r.count() {
+		r.str()
+		for range r.count() {
+			r.i32()
+			if code := r.i16(); code != 0 {
+				// Leadership may have moved, so partitions are looked up again before the next try
+				delete(p.leaders, topic)
+				return kafkaError(code)
+			}
+			r.i64()
+			r.i64()
+		}
+	}
+	return r.err
+}
+
+// metadata looks up the broker address of the leader of every partition of a topic, which the broker creates if
+// it is configured to
+func (p *kafkaProducer) metadata(topic string) ([]string, error) {
+	var req kafkaBuffer
+	req.i32(1)
+	req.str(topic)
+	var lastErr error
+	for _, addr := range p.bootstrap {
+		resp, err := p.roundTrip(addr, kafkaMetadata, kafkaMetadataVersion, req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		r := kafkaReader{b: resp}
+		brokers := map[int32]string{}
+		for range r.count() {
+			id := r.i32()
+			host := r.str()
+			port := r.i32()
+			r.str() // rack
+			brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+		r.i32() // controller
+		var leaders []string
+		for range r.count() {
+			code := r.i16()
+			r.str()
+			r.i8() // internal
+			if code != 0 {
+				return nil, fmt.Errorf("topic %s: %w", topic, kafkaError(code))
+			}
+			partitions := r.count()
+			leaders = make([]string, partitions)
+			for range partitions {
+				r.i16()
+				id, leader := r.i32(), r.i32()
+				for range 2 { // replicas and in-sync replicas
+					for range r.count() {
+						r.i32()
+					}
+				}
+				addr, ok := brokers[leader]
+				if id < 0 || int(id) >= len(leaders) || !ok {
+					return nil, fmt.Errorf("topic %s: partition %d has no leader", topic, id)
+				}
+				leaders[id] = addr
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(leaders) == 0 {
+			return nil, fmt.Errorf("topic %s has no partitions", topic)
+		}
+		return leaders, nil
+	}
+	return nil, lastErr
+}
+
+// roundTrip sends a request to a broker and returns the body of its response, with p.mu held
+func (p *kafkaProducer) roundTrip(addr string, apiKey, version int16, body []byte) ([]byte, error) {
+	c, ok := p.conns[addr]
+	if !ok {
+		conn, err := net.DialTimeout("tcp", addr, kafkaTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c = &kafkaConn{conn: conn, r: bufio.NewReader(conn)}
+		p.conns[addr] = c
+	}
+	resp, err := p.exchange(c, apiKey, version, body)
+	if err != nil {
+		c.conn.Close()
+		delete(p.conns, addr)
+	}
+	return resp, err
+}
+
+// exchange writes a request frame on a connection and reads the response frame
+func (p *kafkaProducer) exchange(c *kafkaConn, apiKey, version int16, body []byte) ([]byte, error) {
+	p.correlation++
+	var req kafkaBuffer
+	req.i32(0) // size, set below
+	req.i16(apiKey)
+	req.i16(version)
+	req.i32(p.correlation)
+	req.str("mock-exam")
+	req = append(req, body...)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+
+	c.conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	if _, err := c.conn.Write(req); err != nil {
+		return nil, err
+	}
+	var size uint32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("kafka: invalid response size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != p.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, p.correlation)
+	}
+	return resp[4:], nil
+}
+
+// recordBatch encodes a record batch (message format v2) of one uncompressed record
+func recordBatch(key, value []byte, at time.Time) []byte {
+	var record kafkaBuffer
+	record.i8(0)     // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	record.varint(int64(len(key)))
+	record = append(record, key...)
+	record.varint(int64(len(value)))
+	record = append(record, value...)
+	record.varint(0) // headers
+
+	var tail kafkaBuffer // the part of the batch its checksum covers
+	tail.i16(0)          // attributes: no compression, creation timestamps
+	tail.i32(0)          // last offset delta
+	tail.i64(at.UnixMilli())
+	tail.i64(at.UnixMilli())
+	tail.i64(-1) // producer ID
+	tail.i16(-1) // producer epoch
+	tail.i32(-1) // base sequence
+	tail.i32(1)
+	tail.varint(int64(len(record)))
+	tail = append(tail, record...)
+
+	var b kafkaBuffer
+	b.i64(0)                            // base offset
+	b.i32(int32(4 + 1 + 4 + len(tail))) // length of the rest of the batch
+	b.i32(-1)                           // partition leader epoch
+	b.i8(2)                             // magic
+	b.i32(int32(crc32.Checksum(tail, kafkaCRC)))
+	return append(b, tail...)
+}
+
+// murmur2 is the hash Kafka's default partitioner places keyed messages with
+func murmur2(data []byte) uint32 {
+	const m, r = 0x5bd1e995, 24
+	h := uint32(0x9747b28c) ^ uint32(len(data))
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h = h*m ^ k
+		data = data[4:]
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// kafkaBuffer builds a request in the big-endian encoding of the Kafka protocol
+type kafkaBuffer []byte
+
+func (b *kafkaBuffer) i8(v int8)   { *b = append(*b, byte(v)) }
+func (b *kafkaBuffer) i16(v int16) { *b = binary.BigEndian.AppendUint16(*b, uint16(v)) }
+func (b *kafkaBuffer) i32(v int32) { *b = binary.BigEndian.AppendUint32(*b, uint32(v)) }
+func (b *kafkaBuffer) i64(v int64) { *b = binary.BigEndian.AppendUint64(*b, uint64(v)) }
+
+// str appends a string with its 16-bit length
+func (b *kafkaBuffer) str(s string) {
+	b.i16(int16(len(s)))
+	*b = append(*b, s...)
+}
+
+// varint appends a zigzag varint, as in records
+func (b *kafkaBuffer) varint(v int64) { *b = binary.AppendVarint(*b, v) }
+
+// kafkaReader decodes a response, remembering the first read past its end
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes of the response, or zeros once it is exhausted
+func (r *kafkaReader) next(n int) []byte {
+	if r.err == nil && n > len(r.b) {
+		r.err = errors.New("kafka: truncated response")
+	}
+	if r.err != nil {
+		return make([]byte, max(n, 0))
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaReader) i8() int8   { return int8(r.next(1)[0]) }
+func (r *kafkaReader) i16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) i32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+func (r *kafkaReader) i64() int64 { return int64(binary.BigEndian.Uint64(r.next(8))) }
+
+// count reads the length of an array, which cannot exceed the bytes left as no element is empty
+func (r *kafkaReader) count() int {
+	n := int(r.i32())
+	if n > len(r.b) {
+		r.err = cmp.Or(r.err, errors.New("kafka: truncated response"))
+	}
+	if r.err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// str reads a string with its 16-bit length, a negative length being null
+func (r *kafkaReader) str() string {
+	n := r.i16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// leaderboardWindows maps the accepted time windows to how far back they reach; zero means all time
+var leaderboardWindows = map[string]time.Duration{
+	"weekly":   7 * 24 * time.Hour,
+	"all-time": 0,
+}
+
+// aliasAdjectives and aliasAnimals make up the anonymous names shown on leaderboards
+var (
+	aliasAdjectives = []string{"Swift", "Clever", "Brave", "Calm", "Bright", "Bold", "Quiet", "Lucky", "Keen", "Nimble", "Witty", "Steady", "Sunny", "Gentle", "Mighty", "Curious"}
+	aliasAnimals    = []string{"Otter", "Falcon", "Panda", "Fox", "Heron", "Lynx", "Koala", "Badger", "Dolphin", "Owl", "Tiger", "Gecko", "Raven", "Bison", "Marten", "Puffin"}
+)
+
+// leaderboardAlias returns the anonymous display name of a user; the same user always gets the same name
+func leaderboardAlias(user string) string {
+	sum := sha256.Sum256([]byte("leaderboard:" + user))
+	n := binary.BigEndian.Uint32(sum[:4])
+	return fmt.Sprintf("%s %s %02d", aliasAdjectives[sum[4]%byte(len(aliasAdjectives))], aliasAnimals[sum[5]%byte(len(aliasAnimals))], n%100)
+}
+
+// LeaderboardEntry is the standing of one opted-in user
+type LeaderboardEntry struct {
+	Rank    int     `json:"rank"`
+	Name    string  `json:"name"`
+	Exams   int     `json:"exams"`
+	Score   int     `json:"score"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+	You     bool    `json:"you,omitempty"`
+
+	user string
+	last time.Time
+}
+
+// leaderboardStore keeps the set of users who opted in to leaderboards in a JSON file
+type leaderboardStore struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]time.Time // user ID -> when they opted in
+}
+
+// openLeaderboardStore loads the opt-ins saved at path
+func openLeaderboardStore(path string) (*leaderboardStore, error) {
+	s := &leaderboardStore{path: path, users: map[string]time.Time{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read leaderboard opt-ins: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.users); err != nil {
+		return nil, fmt.Errorf("failed to parse leaderboard opt-ins: %w", err)
+	}
+	return s, nil
+}
+
+// SetOptIn adds a user to or removes them from the leaderboards
+func (s *leaderboardStore) SetOptIn(user string, optIn bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user]; ok == optIn {
+		return nil
+	}
+	if optIn {
+		s.users[user] = time.Now().UTC()
+	} else {
+		delete(s.users, user)
+	}
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// OptedIn reports whether a user appears on leaderboards
+func (s *leaderboardStore) OptedIn(user string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.users[user]
+	return ok
+}
+
+// OptedInAt returns when a user opted in to leaderboards, or the zero time if they are not on them
+func (s *leaderboardStore) OptedInAt(user string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[user]
+}
+
+// rankAttempts totals the best attempt of each opted-in user per exam and ranks users by score, then percent,
+// then who got there first
+func (s *leaderboardStore) rankAttempts(list []Attempt) []LeaderboardEntry {
+	type key struct{ user, subject, exam string }
+	best := map[key]Attempt{}
+	for _, a := range list {
+		if !s.OptedIn(a.UserID) {
+			continue
+		}
+		k := key{a.UserID, a.Subject, a.Exam}
+		if b, ok := best[k]; !ok || a.Score > b.Score {
+			best[k] = a
+		}
+	}
+
+	byUser := map[string]*LeaderboardEntry{}
+	for k, a := range best {
+		e := byUser[k.user]
+		if e == nil {
+			e = &LeaderboardEntry{Name: leaderboardAlias(k.user), user: k.user}
+			byUser[k.user] = e
+		}
+		e.Exams++
+		e.Score += a.Score
+		e.Total += a.Total
+		if a.SubmittedAt.After(e.last) {
+			e.last = a.SubmittedAt
+		}
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(byUser))
+	for _, e := range byUser {
+		if e.Total > 0 {
+			e.Percent = float64(e.Score) * 100 / float64(e.Total)
+		}
+		entries = append(entries, *e)
+	}
+	slices.SortFunc(entries, func(a, b LeaderboardEntry) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Percent, a.Percent), a.last.Compare(b.last), strings.Compare(a.Name, b.Name))
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// serveLeaderboard returns a handler that ranks opted-in users on one exam (?subject=&exam=) or across every
+// exam of a subject (?subject=), within a time window (?window=weekly|all-time)
+func serveLeaderboard(store *examStore, attempts *attemptStore, boards *leaderboardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		subject, examName := q.Get("subject"), q.Get("exam")
+		if subject == "" {
+			http.Error(w, "Missing subject", http.StatusBadRequest)
+			return
+		}
+		window := cmp.Or(q.Get("window"), "all-time")
+		span, ok := leaderboardWindows[window]
+		if !ok {
+			http.Error(w, "Unknown window "+window, http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		var found exam.ExamFile
+		if examName != "" {
+			subjects, err := store.Subjects(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			e, ok := exam.FindExam(subjects, subject, examName)
+			if !ok {
+				http.Error(w, "Exam not found", http.StatusNotFound)
+				return
+			}
+			found = e
+		}
+		var since time.Time
+		if span > 0 {
+			since = time.Now().Add(-span)
+		}
+		list := attempts.List(func(a Attempt) bool {
+			if examName != "" && !a.isFor(subject, found) {
+				return false
+			}
+			if a.Subject != subject && !strings.HasPrefix(a.Subject, subject+"/") {
+				return false
+			}
+			return a.SubmittedAt.After(since)
+		})
+
+		entries := boards.rankAttempts(list)
+		// The requesting user sees their own standing even when it is below the cut
+		var you *LeaderboardEntry
+		if user := q.Get("user"); user != "" {
+			for i := range entries {
+				if entries[i].user == user {
+					entries[i].You = true
+					you = &entries[i]
+				}
+			}
+		}
+		top := entries[:min(limit, len(entries))]
+		if you != nil && you.Rank <= len(top) {
+			you = nil
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"subject": subject,
+			"exam":    examName,
+			"window":  window,
+			"entries": top,
+			"you":     you,
+		})
+	}
+}
+
+// leaderboardOptIn returns a handler that reports (GET), opts a user in to (PUT) or out of (DELETE) the leaderboards
+func leaderboardOptIn(boards *leaderboardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("id")
+		optIn := r.Method == http.MethodPut
+		if r.Method == http.MethodGet {
+			optIn = boards.OptedIn(user)
+		} else if err := boards.SetOptIn(user, optIn); err != nil {
+			http.Error(w, "Failed to save leaderboard opt-in: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"optedIn": optIn, "name": leaderboardAlias(user)})
+	}
+}
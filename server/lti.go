@@ -0,0 +1,547 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ltiScopeScore is the AGS scope for posting scores
+const ltiScopeScore = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+
+// ltiPlatform is an LMS registered to launch the tool
+type ltiPlatform struct {
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientId"`
+	DeploymentIDs []string `json:"deploymentIds,omitempty"` // any deployment is accepted when empty
+	AuthLoginURL  string   `json:"authLoginUrl"`
+	AuthTokenURL  string   `json:"authTokenUrl"`
+	JWKSURL       string   `json:"jwksUrl"`
+}
+
+// ltiConfig is the LTI configuration file
+type ltiConfig struct {
+	Platforms []ltiPlatform `json:"platforms"`
+}
+
+// ltiState is a pending OIDC login, waiting for the platform to post the launch
+type ltiState struct {
+	nonce    string
+	platform *ltiPlatform
+	expires  time.Time
+}
+
+// ltiLaunch is a completed resource link launch that grades can be passed back to
+type ltiLaunch struct {
+	platform *ltiPlatform
+	userID   string
+	exam     string
+	lineItem string
+	expires  time.Time
+}
+
+// ltiToken is a cached AGS access token
+type ltiToken struct {
+	value   string
+	expires time.Time
+}
+
+// ltiTool implements the tool side of LTI 1.3 launches and Assignment and Grade Services
+type ltiTool struct {
+	config ltiConfig
+	key    *rsa.PrivateKey
+	kid    string
+	client *http.Client
+
+	mu       sync.Mutex
+	states   map[string]ltiState
+	launches map[string]ltiLaunch
+	jwks     map[string]jwkSet
+	jwksAt   map[string]time.Time
+	tokens   map[string]ltiToken
+}
+
+const (
+	ltiStateTTL  = 10 * time.Minute
+	ltiLaunchTTL = 24 * time.Hour
+)
+
+// newLTITool reads the platform registrations from configPath and loads, or creates, the tool key in dataDir
+func newLTITool(configPath, dataDir string) (*ltiTool, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LTI config: %w", err)
+	}
+	var cfg ltiConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse LTI config: %w", err)
+	}
+	for i, p := range cfg.Platforms {
+		if p.Issuer == "" || p.ClientID == "" || p.AuthLoginURL == "" || p.AuthTokenURL == "" || p.JWKSURL == "" {
+			return nil, fmt.Errorf("LTI platform %d needs issuer, clientId, authLoginUrl, authTokenUrl and jwksUrl", i+1)
+		}
+	}
+
+	key, err := loadSigningKey(filepath.Join(dataDir, "lti-key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &ltiTool{
+		config:   cfg,
+		key:      key,
+		kid:      hex.EncodeToString(sum[:8]),
+		client:   &http.Client{Timeout: 15 * time.Second},
+		states:   map[string]ltiState{},
+		launches: map[string]ltiLaunch{},
+		jwks:     map[string]jwkSet{},
+		jwksAt:   map[string]time.Time{},
+		tokens:   map[string]ltiToken{},
+	}, nil
+}
+
+// loadSigningKey reads an RSA signing key, generating it on first use
+func loadSigningKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write signing key: %w", err)
+		}
+		log.Printf("Generated signing key %s", path)
+		return key, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA key", path)
+	}
+	return key, nil
+}
+
+// registerLTIRoutes adds the LTI login, launch and key set endpoints to lti, the group of routes under /lti
+func registerLTIRoutes(lti *router, tool *ltiTool) {
+	lti.HandleFunc("/login", tool.login)
+	lti.HandleFunc("POST /launch", tool.launch)
+	lti.HandleFunc("GET /jwks", tool.serveJWKS)
+}
+
+// platform returns the registration of an issuer and client ID; an empty client ID matches the only registration of the issuer
+func (t *ltiTool) platform(issuer, clientID string) *ltiPlatform {
+	var match *ltiPlatform
+	for i, p := range t.config.Platforms {
+		if p.Issuer != issuer {
+			continue
+		}
+		if p.ClientID == clientID {
+			return &t.config.Platforms[i]
+		}
+		if clientID == "" {
+			if match != nil {
+				return nil
+			}
+			match = &t.config.Platforms[i]
+		}
+	}
+	return match
+}
+
+// login handles OIDC third-party login initiation by redirecting back to the platform's authorization endpoint
+func (t *ltiTool) login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid login request", http.StatusBadRequest)
+		return
+	}
+	p := t.platform(r.Form.Get("iss"), r.Form.Get("client_id"))
+	if p == nil {
+		http.Error(w, "Unknown LTI platform", http.StatusBadRequest)
+		return
+	}
+	target := r.Form.Get("target_link_uri")
+	if target == "" || r.Form.Get("login_hint") == "" {
+		http.Error(w, "Missing target_link_uri or login_hint", http.StatusBadRequest)
+		return
+	}
+
+	state, nonce := newID(), newID()
+	t.mu.Lock()
+	t.expire(time.Now())
+	t.states[state] = ltiState{nonce: nonce, platform: p, expires: time.Now().Add(ltiStateTTL)}
+	t.mu.Unlock()
+
+	q := url.Values{
+		"scope":            {"openid"},
+		"response_type":    {"id_token"},
+		"response_mode":    {"form_post"},
+		"prompt":           {"none"},
+		"client_id":        {p.ClientID},
+		"redirect_uri":     {launchURL(r)},
+		"login_hint":       {r.Form.Get("login_hint")},
+		"state":            {state},
+		"nonce":            {nonce},
+		"lti_message_hint": {r.Form.Get("lti_message_hint")},
+	}
+	if q.Get("lti_message_hint") == "" {
+		q.Del("lti_message_hint")
+	}
+	sep := "?"
+	if strings.Contains(p.AuthLoginURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthLoginURL+sep+q.Encode(), http.StatusFound)
+}
+
+// launchURL returns the absolute URL of the launch endpoint on the host the request arrived at
+func launchURL(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + "/lti/launch"
+}
+
+// expire drops pending logins and launches past their lifetime; t.mu must be held
+func (t *ltiTool) expire(now time.Time) {
+	for k, s := range t.states {
+		if now.After(s.expires) {
+			delete(t.states, k)
+		}
+	}
+	for k, l := range t.launches {
+		if now.After(l.expires) {
+			delete(t.launches, k)
+		}
+	}
+}
+
+// ltiClaims are the id_token claims the tool reads
+type ltiClaims struct {
+	Issuer       string            `json:"iss"`
+	Subject      string            `json:"sub"`
+	Audience     ltiAudience       `json:"aud"`
+	AuthParty    string            `json:"azp"`
+	Expires      int64             `json:"exp"`
+	Nonce        string            `json:"nonce"`
+	MessageType  string            `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version      string            `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID string            `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLink   string            `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	Custom       map[string]any    `json:"https://purl.imsglobal.org/spec/lti/claim/custom"`
+	AGS          *ltiEndpointClaim `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"`
+}
+
+// ltiEndpointClaim is the Assignment and Grade Services claim of a launch
+type ltiEndpointClaim struct {
+	Scope    []string `json:"scope"`
+	LineItem string   `json:"lineitem"`
+}
+
+// ltiAudience accepts the aud claim as a string or an array of strings
+type ltiAudience []string
+
+func (a *ltiAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = ltiAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// launch validates the id_token posted by the platform and opens the exam in the app
+func (t *ltiTool) launch(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid launch request", http.StatusBadRequest)
+		return
+	}
+
+	t.mu.Lock()
+	state, ok := t.states[r.PostForm.Get("state")]
+	delete(t.states, r.PostForm.Get("state"))
+	t.mu.Unlock()
+	if !ok || time.Now().After(state.expires) {
+		http.Error(w, "Unknown or expired launch state", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := t.verifyIDToken(r.PostForm.Get("id_token"), state)
+	if err != nil {
+		http.Error(w, "Invalid launch: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims.MessageType != "LtiResourceLinkRequest" {
+		http.Error(w, "Unsupported LTI message type "+claims.MessageType, http.StatusBadRequest)
+		return
+	}
+
+	// The exam comes from the custom parameters of the link, or from the target link itself
+	exam, _ := claims.Custom["exam"].(string)
+	if exam == "" {
+		if u, err := url.Parse(claims.TargetLink); err == nil {
+			exam = u.Query().Get("exam")
+		}
+	}
+
+	l := ltiLaunch{platform: state.platform, userID: claims.Subject, exam: exam, expires: time.Now().Add(ltiLaunchTTL)}
+	if claims.AGS != nil && slices.Contains(claims.AGS.Scope, ltiScopeScore) {
+		l.lineItem = claims.AGS.LineItem
+	}
+	id := newID()
+	t.mu.Lock()
+	t.launches[id] = l
+	t.mu.Unlock()
+
+	q := url.Values{"lti": {id}, "user": {claims.Subject}}
+	if exam != "" {
+		q.Set("exam", exam)
+	}
+	http.Redirect(w, r, "/?"+q.Encode(), http.StatusSeeOther)
+}
+
+// verifyIDToken checks the signature and the registration claims of a launch id_token
+func (t *ltiTool) verifyIDToken(token string, state ltiState) (*ltiClaims, error) {
+	var claims ltiClaims
+	header, signed, sig, err := parseJWT(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+	key, err := t.platformKey(state.platform, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyRS256(key, signed, sig); err != nil {
+		return nil, err
+	}
+
+	p := state.platform
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, errors.New("issuer mismatch")
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return nil, errors.New("audience mismatch")
+	case len(claims.Audience) > 1 && claims.AuthParty != p.ClientID:
+		return nil, errors.New("authorized party mismatch")
+	case time.Now().Unix() > claims.Expires:
+		return nil, errors.New("token expired")
+	case claims.Nonce != state.nonce:
+		return nil, errors.New("nonce mismatch")
+	case claims.Version != "1.3.0":
+		return nil, fmt.Errorf("unsupported LTI version %q", claims.Version)
+	case len(p.DeploymentIDs) > 0 && !slices.Contains(p.DeploymentIDs, claims.DeploymentID):
+		return nil, fmt.Errorf("unknown deployment %q", claims.DeploymentID)
+	}
+	return &claims, nil
+}
+
+// platformKey returns the platform's signing key with the given ID, refetching the key set at most once a minute
+func (t *ltiTool) platformKey(p *ltiPlatform, kid string) (*rsa.PublicKey, error) {
+	t.mu.Lock()
+	set, fetched := t.jwks[p.JWKSURL], t.jwksAt[p.JWKSURL]
+	t.mu.Unlock()
+
+	if key := findJWK(set, kid); key != nil {
+		return key.rsaKey()
+	}
+	if time.Since(fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	resp, err := t.client.Get(p.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch platform keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch platform keys: %s", resp.Status)
+	}
+	set = jwkSet{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to parse platform keys: %w", err)
+	}
+	t.mu.Lock()
+	t.jwks[p.JWKSURL], t.jwksAt[p.JWKSURL] = set, time.Now()
+	t.mu.Unlock()
+
+	if key := findJWK(set, kid); key != nil {
+		return key.rsaKey()
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// findJWK returns the key with the given ID, or the only key of a set when the token names none
+func findJWK(set jwkSet, kid string) *jwk {
+	for i, k := range set.Keys {
+		if k.Kid == kid {
+			return &set.Keys[i]
+		}
+	}
+	if kid == "" && len(set.Keys) == 1 {
+		return &set.Keys[0]
+	}
+	return nil
+}
+
+// serveJWKS publishes the tool's public key for the platforms to verify its client assertions
+func (t *ltiTool) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{publicJWK(&t.key.PublicKey, t.kid)}})
+}
+
+// attemptRecorded passes the score of an attempt made from an LTI launch back to the platform gradebook
+func (t *ltiTool) attemptRecorded(a Attempt) {
+	if a.LTILaunch == "" {
+		return
+	}
+	t.mu.Lock()
+	l, ok := t.launches[a.LTILaunch]
+	t.mu.Unlock()
+	if !ok || l.lineItem == "" || l.userID != a.UserID {
+		return
+	}
+	go func() {
+		if err := t.postScore(l, a); err != nil {
+			log.Printf("Failed to pass back grade of attempt %s: %v", a.ID, err)
+		}
+	}()
+}
+
+// postScore publishes an attempt's score to the line item of its launch
+func (t *ltiTool) postScore(l ltiLaunch, a Attempt) error {
+	token, err := t.accessToken(l.platform)
+	if err != nil {
+		return err
+	}
+
+	// The scores service lives under the line item URL, before any query string
+	scoresURL := l.lineItem
+	query := ""
+	if i := strings.IndexByte(scoresURL, '?'); i >= 0 {
+		scoresURL, query = scoresURL[:i], scoresURL[i:]
+	}
+	scoresURL = strings.TrimSuffix(scoresURL, "/") + "/scores" + query
+
+	body, err := json.Marshal(map[string]any{
+		"userId":           l.userID,
+		"scoreGiven":       a.Score,
+		"scoreMaximum":     a.Total,
+		"activityProgress": "Completed",
+		"gradingProgress":  "FullyGraded",
+		"timestamp":        a.SubmittedAt.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, scoresURL, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/vnd.ims.lis.v1.score+json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("score service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// accessToken returns an AGS access token for the platform, requesting one with a signed client assertion when needed
+func (t *ltiTool) accessToken(p *ltiPlatform) (string, error) {
+	t.mu.Lock()
+	cached, ok := t.tokens[p.Issuer+" "+p.ClientID]
+	t.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(t.key, t.kid, map[string]any{
+		"iss": p.ClientID,
+		"sub": p.ClientID,
+		"aud": p.AuthTokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"jti": newID(),
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.PostForm(p.AuthTokenURL, url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {ltiScopeScore},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to request access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.AccessToken == "" {
+		return "", errors.New("invalid token endpoint response")
+	}
+
+	// Renew a little before the platform expires the token
+	lifetime := time.Duration(max(tok.ExpiresIn-30, 0)) * time.Second
+	t.mu.Lock()
+	t.tokens[p.Issuer+" "+p.ClientID] = ltiToken{value: tok.AccessToken, expires: now.Add(lifetime)}
+	t.mu.Unlock()
+	return tok.AccessToken, nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownFields lists the question fields that may be written in Markdown
+var markdownFields = []string{"question", "explanation"}
+
+// markdown converts Markdown to HTML; raw HTML in the source is dropped by goldmark's default renderer
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownPolicy is the sanitizer applied to rendered Markdown
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// renderMarkdown converts Markdown source to sanitized HTML, passing LaTeX formulas through untouched
+func renderMarkdown(src string) (string, error) {
+	src, spans := protectMath(src)
+
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+	return restoreMath(markdownPolicy.Sanitize(buf.String()), spans), nil
+}
+
+// renderExamMarkdown returns a copy of exam content where every Markdown field of a question gets a rendered "<field>Html" sibling
+func renderExamMarkdown(content any) any {
+	return mapQuestions(content, func(q map[string]any) {
+		for _, field := range markdownFields {
+			if src, ok := q[field].(string); ok {
+				if html, err := renderMarkdown(src); err == nil {
+					q[field+"Html"] = html
+				}
+			}
+		}
+	})
+}
+
+// mapQuestions returns a copy of exam content with fn applied to a shallow copy of every question,
+// so the cached content is never modified
+func mapQuestions(content any, fn func(q map[string]any)) any {
+	items := exam.Questions(content)
+	if items == nil {
+		return content
+	}
+
+	out := make([]any, len(items))
+	for i, item := range items {
+		q, ok := item.(map[string]any)
+		if !ok {
+			out[i] = item
+			continue
+		}
+		copied := make(map[string]any, len(q)+2)
+		for k, v := range q {
+			copied[k] = v
+		}
+		fn(copied)
+		out[i] = copied
+	}
+	return out
+}
+
+// mapSubjectExams returns a copy of the subject tree with the content of every exam replaced by fn,
+// which also receives the identifier of the exam's subject
+func mapSubjectExams(subjects []exam.Subject, fn func(subject string, e exam.ExamFile) any) []exam.Subject {
+	if subjects == nil {
+		return nil
+	}
+	out := make([]exam.Subject, len(subjects))
+	for i, s := range subjects {
+		out[i] = s
+		out[i].Exams = make([]exam.ExamFile, len(s.Exams))
+		for j, e := range s.Exams {
+			out[i].Exams[j] = e
+			out[i].Exams[j].Content = fn(exam.SubjectID(s), e)
+		}
+		out[i].Subjects = mapSubjectExams(s.Subjects, fn)
+	}
+	return out
+}
+
+// mapExamContent returns a copy of the subject tree with fn applied to the content of every exam
+func mapExamContent(subjects []exam.Subject, fn func(any) any) []exam.Subject {
+	if subjects == nil {
+		return nil
+	}
+	out := make([]exam.Subject, len(subjects))
+	for i, s := range subjects {
+		out[i] = s
+		out[i].Exams = make([]exam.ExamFile, len(s.Exams))
+		for j, e := range s.Exams {
+			out[i].Exams[j] = e
+			out[i].Exams[j].Content = fn(e.Content)
+		}
+		out[i].Subjects = mapExamContent(s.Subjects, fn)
+	}
+	return out
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MathSpan locates a LaTeX formula inside a text field
+type MathSpan struct {
+	TeX     string `json:"tex"`
+	Display bool   `json:"display"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+}
+
+// mathDelimiters lists the supported LaTeX delimiters, longest first so "$$" wins over "$"
+var mathDelimiters = []struct {
+	open, close string
+	display     bool
+}{
+	{"$$", "$$", true},
+	{`\[`, `\]`, true},
+	{`\(`, `\)`, false},
+	{"$", "$", false},
+}
+
+// findMath returns the LaTeX formulas in s, using pandoc's rules for single dollars so prices like "$5 and $10" are not treated as math
+func findMath(s string) []MathSpan {
+	var spans []MathSpan
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '$' {
+			i++
+			continue
+		}
+
+		for _, d := range mathDelimiters {
+			if !strings.HasPrefix(s[i:], d.open) {
+				continue
+			}
+			start := i + len(d.open)
+			end := findClosing(s, start, d.close, d.open == "$")
+			if end < 0 {
+				continue
+			}
+			spans = append(spans, MathSpan{
+				TeX:     s[start:end],
+				Display: d.display,
+				Start:   i,
+				End:     end + len(d.close),
+			})
+			i = end + len(d.close) - 1
+			break
+		}
+	}
+	return spans
+}
+
+// findClosing returns the index of the closing delimiter for a formula starting at start, or -1
+func findClosing(s string, start int, closing string, single bool) int {
+	if single && (start >= len(s) || unicode.IsSpace(rune(s[start]))) {
+		return -1
+	}
+	for j := start; j < len(s); j++ {
+		if !strings.HasPrefix(s[j:], closing) {
+			if s[j] == '\\' {
+				// Skip escaped characters such as \$ and \{
+				j++
+			}
+			continue
+		}
+		if j == start {
+			return -1
+		}
+		if single {
+			// A "$$" is display math of its own, never the end of inline math
+			if j+1 < len(s) && s[j+1] == '$' {
+				j++
+				continue
+			}
+			// The closing dollar must follow a non-space and must not be followed by a digit
+			if unicode.IsSpace(rune(s[j-1])) || (j+1 < len(s) && s[j+1] >= '0' && s[j+1] <= '9') {
+				continue
+			}
+		}
+		return j
+	}
+	return -1
+}
+
+// annotateExamMath returns a copy of exam content where questions containing LaTeX get a "math" field locating each formula by field
+func annotateExamMath(content any) any {
+	return mapQuestions(content, func(q map[string]any) {
+		found := make(map[string][]MathSpan)
+		for _, field := range markdownFields {
+			if text, ok := q[field].(string); ok {
+				if spans := findMath(text); len(spans) > 0 {
+					found[field] = spans
+				}
+			}
+		}
+		if choices, ok := q["choices"].([]any); ok {
+			for i, c := range choices {
+				if text, ok := c.(string); ok {
+					if spans := findMath(text); len(spans) > 0 {
+						found["choices."+strconv.Itoa(i)] = spans
+					}
+				}
+			}
+		}
+		if len(found) > 0 {
+			q["math"] = found
+		}
+	})
+}
+
+// protectMath replaces the formulas in src with placeholders so Markdown rendering cannot mangle them
+func protectMath(src string) (string, []MathSpan) {
+	spans := findMath(src)
+	if len(spans) == 0 {
+		return src, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, span := range spans {
+		b.WriteString(src[last:span.Start])
+		b.WriteString(mathPlaceholder(i))
+		last = span.End
+	}
+	b.WriteString(src[last:])
+	return b.String(), spans
+}
+
+// restoreMath replaces the placeholders left by protectMath with spans that client-side KaTeX or MathJax auto-render picks up
+func restoreMath(rendered string, spans []MathSpan) string {
+	for i, span := range spans {
+		class, open, close := "math inline", `\(`, `\)`
+		if span.Display {
+			class, open, close = "math display", `\[`, `\]`
+		}
+		tag := `<span class="` + class + `">` + html.EscapeString(open+span.TeX+close) + `</span>`
+		rendered = strings.Replace(rendered, mathPlaceholder(i), tag, 1)
+	}
+	return rendered
+}
+
+// mathPlaceholder returns the placeholder text for the i-th formula
+func mathPlaceholder(i int) string {
+	return "MATHPLACEHOLDER" + strconv.Itoa(i) + "X"
+}
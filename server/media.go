@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// serveMedia returns a handler that serves files from dir/<subject path>/ with their MIME type and caching headers,
+// resizing or converting raster images on request with renditions cached in cacheDir
+func serveMedia(dir, cacheDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("path")
+		contentType, ok := exam.MediaTypes[strings.ToLower(path.Ext(name))]
+		if !filepath.IsLocal(filepath.FromSlash(name)) || !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer root.Close()
+
+		f, err := root.Open(filepath.FromSlash(name))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		if isRasterImage(contentType) {
+			opts, resize, err := parseResizeOptions(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if resize {
+				serveResizedImage(w, r, name, f, info, opts, cacheDir)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// SVG files can carry scripts, so never let them run in the page's origin
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	}
+}
+
+// isRasterImage reports whether contentType is an image format that can be decoded and resized
+func isRasterImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml"
+}
+
+// maxMediaUploadSize limits the size of an uploaded media file
+const maxMediaUploadSize = 16 << 20
+
+// MediaUpload is the response of a media upload
+type MediaUpload struct {
+	URL          string `json:"url"`
+	SHA256       string `json:"sha256"`
+	Size         int64  `json:"size"`
+	ContentType  string `json:"contentType"`
+	Deduplicated bool   `json:"deduplicated"`
+}
+
+// uploadMedia returns a handler that stores an uploaded media file under its content hash, reusing an identical existing file
+func uploadMedia(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxMediaUploadSize)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing \"file\" form field: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		subject := r.FormValue("subject")
+		if !exam.ValidSubjectPath(subject) {
+			http.Error(w, "Missing or invalid \"subject\" form field", http.StatusBadRequest)
+			return
+		}
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Failed to read upload: "+err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		ext := strings.ToLower(path.Ext(header.Filename))
+		if ext == ".jpeg" {
+			ext = ".jpg"
+		}
+		contentType, ok := exam.MediaTypes[ext]
+		if !ok {
+			http.Error(w, "Unsupported media type "+ext, http.StatusUnsupportedMediaType)
+			return
+		}
+		// Raster images are sniffable, so reject files whose content does not match their extension
+		if isRasterImage(contentType) {
+			if sniffed := http.DetectContentType(data); sniffed != contentType {
+				http.Error(w, fmt.Sprintf("File content (%s) does not match its extension %s", sniffed, ext), http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		name := hash + ext
+		subjectDir := filepath.Join(dir, filepath.FromSlash(subject))
+		target := filepath.Join(subjectDir, name)
+
+		result := MediaUpload{
+			URL:         exam.MediaURLPrefix + subject + "/" + name,
+			SHA256:      hash,
+			Size:        int64(len(data)),
+			ContentType: contentType,
+		}
+
+		status := http.StatusCreated
+		if _, err := os.Stat(target); err == nil {
+			result.Deduplicated = true
+			status = http.StatusOK
+		} else {
+			if err := storage.WriteFileAtomic(target, data); err != nil {
+				http.Error(w, "Failed to store media: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Stored media %s", target)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
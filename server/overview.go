@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// histogramBuckets is the number of equal-width percent ranges in a score distribution
+const histogramBuckets = 10
+
+// HistogramBucket counts the members whose best score falls in [From, To) percent; the last bucket includes 100
+type HistogramBucket struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Count int `json:"count"`
+}
+
+// ExamOverview aggregates the best attempt of every member of a group at one exam
+type ExamOverview struct {
+	ExamRef
+	Members        int               `json:"members"`
+	Completed      int               `json:"completed"`
+	Attempts       int               `json:"attempts"`
+	CompletionRate float64           `json:"completionRate"`
+	AverageScore   float64           `json:"averageScore"`
+	PassRate       *float64          `json:"passRate,omitempty"`
+	Histogram      []HistogramBucket `json:"histogram"`
+}
+
+// GroupOverview holds the exam aggregates of one group
+type GroupOverview struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Members int            `json:"members"`
+	Exams   []ExamOverview `json:"exams"`
+}
+
+// examOverview aggregates the attempts of members at an exam, using each member's best attempt
+func examOverview(exam ExamRef, members []string, list []Attempt) ExamOverview {
+	o := ExamOverview{ExamRef: exam, Members: len(members), Histogram: make([]HistogramBucket, histogramBuckets)}
+	for i := range o.Histogram {
+		o.Histogram[i].From = i * 100 / histogramBuckets
+		o.Histogram[i].To = (i + 1) * 100 / histogramBuckets
+	}
+
+	best := map[string]Attempt{}
+	for _, a := range list {
+		if a.Subject != exam.Subject || a.Exam != exam.Name || !slices.Contains(members, a.UserID) {
+			continue
+		}
+		o.Attempts++
+		if b, ok := best[a.UserID]; !ok || a.Percent > b.Percent {
+			best[a.UserID] = a
+		}
+	}
+
+	o.Completed = len(best)
+	if o.Members > 0 {
+		o.CompletionRate = float64(o.Completed) * 100 / float64(o.Members)
+	}
+	passed, graded := 0, 0
+	for _, a := range best {
+		o.AverageScore += a.Percent
+		o.Histogram[min(int(a.Percent)*histogramBuckets/100, histogramBuckets-1)].Count++
+		if a.Passed != nil {
+			graded++
+			if *a.Passed {
+				passed++
+			}
+		}
+	}
+	if o.Completed > 0 {
+		o.AverageScore /= float64(o.Completed)
+	}
+	if graded > 0 {
+		rate := float64(passed) * 100 / float64(graded)
+		o.PassRate = &rate
+	}
+	return o
+}
+
+// instructorOverview returns a handler that aggregates the results of every group at each of its assigned exams,
+// of the subjects of the token
+func instructorOverview(groups *groupStore, attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list := attempts.List(nil)
+		scope := scopeOf(r)
+		out := []GroupOverview{}
+		for _, g := range groups.Groups() {
+			if id := r.URL.Query().Get("group"); id != "" && id != g.ID {
+				continue
+			}
+			o := GroupOverview{ID: g.ID, Name: g.Name, Members: len(g.Members), Exams: []ExamOverview{}}
+			for _, exam := range g.Exams {
+				if !scope.allows(exam.Subject) {
+					continue
+				}
+				o.Exams = append(o.Exams, examOverview(exam, g.Members, list))
+			}
+			out = append(out, o)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
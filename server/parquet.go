@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Physical types of Parquet columns, and parquetTimestamp for timestamps stored as milliseconds since the epoch
+// in an int64
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+	parquetTimestamp = -1
+)
+
+// Converted types annotating Parquet columns
+const (
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// parquetColumn is a column of a Parquet table being written. Values are appended one per row, nil for a null
+// value of an optional column.
+type parquetColumn struct {
+	name     string
+	kind     int  // physical type
+	convert  int  // converted type, or -1 for none
+	optional bool // whether values may be null
+	values   []any
+}
+
+// parquetTable is a table written as a Parquet file of one row group, with each column in a single GZIP
+// compressed data page of PLAIN encoded values
+type parquetTable struct {
+	columns []*parquetColumn
+	rows    int
+}
+
+// column adds a column to the table, before any row is added
+func (t *parquetTable) column(name string, kind int, optional bool) *parquetColumn {
+	c := &parquetColumn{name: name, kind: kind, convert: -1, optional: optional}
+	switch kind {
+	case parquetByteArray:
+		c.convert = parquetUTF8
+	case parquetTimestamp:
+		c.kind, c.convert = parquetInt64, parquetTimestampMillis
+	}
+	t.columns = append(t.columns, c)
+	return c
+}
+
+// add appends a row, with one value per column in the order the columns were added: a bool, int, int64,
+// float64, string or time.Time, or nil for null. Zero times, empty strings and nil pointers are null as well.
+func (t *parquetTable) add(values ...any) {
+	for i, v := range values {
+		switch x := v.(type) {
+		case *float64:
+			v = nil
+			if x != nil {
+				v = *x
+			}
+		case *bool:
+			v = nil
+			if x != nil {
+				v = *x
+			}
+		case *int:
+			v = nil
+			if x != nil {
+				v = *x
+			}
+		case time.Time:
+			v = nil
+			if !x.IsZero() {
+				v = x.UnixMilli()
+			}
+		case string:
+			if x == "" {
+				v = nil
+			}
+		case int:
+			v = int64(x)
+		}
+		t.columns[i].values = append(t.columns[i].values, v)
+	}
+	t.rows++
+}
+
+// WriteTo writes the table as a Parquet file
+func (t *parquetTable) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+	type chunk struct {
+		offset, uncompressed, compressed int64
+		values                           int
+	}
+	chunks := make([]chunk, len(t.columns))
+	for i, c := range t.columns {
+		page, err := c.page()
+		if err != nil {
+			return 0, fmt.Errorf("column %s: %w", c.name, err)
+		}
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(page)
+		gz.Close()
+
+		var header thriftWriter
+		header.i32(1, 0) // data page
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(compressed.Len()))
+		header.structField(5, func(h *thriftWriter) {
+			h.i32(1, int32(len(c.values)))
+			h.i32(2, 0) // plain values
+			h.i32(3, 3) // definition levels in the RLE hybrid encoding
+			h.i32(4, 3)
+		})
+		header.stop()
+
+		chunks[i] = chunk{offset: int64(out.Len()), uncompressed: int64(header.Len() + len(page)), compressed: int64(header.Len() + compressed.Len()), values: len(c.values)}
+		out.Write(header.Bytes())
+		out.Write(compressed.Bytes())
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(t.columns)+1, func(m *thriftWriter, i int) {
+		if i == 0 {
+			m.str(4, "schema")
+			m.i32(5, int32(len(t.columns)))
+			return
+		}
+		c := t.columns[i-1]
+		m.i32(1, int32(c.kind))
+		m.i32(3, map[bool]int32{false: 0, true: 1}[c.optional])
+		m.str(4, c.name)
+		if c.convert >= 0 {
+			m.i32(6, int32(c.convert))
+		}
+	})
+	meta.i64(3, int64(t.rows))
+	var total int64
+	for _, c := range chunks {
+		total += c.uncompressed
+	}
+	meta.list(4, thriftStruct, 1, func(rg *thriftWriter, _ int) {
+		rg.list(1, thriftStruct, len(t.columns), func(cc *thriftWriter, i int) {
+			c := t.columns[i]
+			cc.i64(2, chunks[i].offset)
+			cc.structField(3, func(md *thriftWriter) {
+				md.i32(1, int32(c.kind))
+				md.list(2, thriftI32, 2, func(e *thriftWriter, j int) { e.varint(zigzag(int64([]int{0, 3}[j]))) })
+				md.list(3, thriftBinary, 1, func(e *thriftWriter, _ int) { e.binary(c.name) })
+				md.i32(4, 2) // GZIP
+				md.i64(5, int64(chunks[i].values))
+				md.i64(6, chunks[i].uncompressed)
+				md.i64(7, chunks[i].compressed)
+				md.i64(9, chunks[i].offset)
+			})
+		})
+		rg.i64(2, total)
+		rg.i64(3, int64(t.rows))
+	})
+	meta.str(6, "Mock Exam")
+	meta.stop()
+
+	out.Write(meta.Bytes())
+	binary.Write(&out, binary.LittleEndian, uint32(meta.Len()))
+	out.WriteString("PAR1")
+	return out.WriteTo(w)
+}
+
+// page encodes the values of a column as the content of a data page: the definition levels of an optional
+// column, then its non-null values
+func (c *parquetColumn) page() ([]byte, error) {
+	var b bytes.Buffer
+	if c.optional {
+		levels := rleLevels(c.values)
+		binary.Write(&b, binary.LittleEndian, uint32(len(levels)))
+		b.Write(levels)
+	}
+	var bits, nbits byte
+	for _, v := range c.values {
+		if v == nil {
+			if !c.optional {
+				return nil, fmt.Errorf("null value in a required column")
+			}
+			continue
+		}
+		switch c.kind {
+		case parquetBoolean:
+			if v.(bool) {
+				bits |= 1 << nbits
+			}
+			if nbits++; nbits == 8 {
+				b.WriteByte(bits)
+				bits, nbits = 0, 0
+			}
+		case parquetInt64:
+			binary.Write(&b, binary.LittleEndian, v.(int64))
+		case parquetDouble:
+			binary.Write(&b, binary.LittleEndian, math.Float64bits(v.(float64)))
+		case parquetByteArray:
+			s := v.(string)
+			binary.Write(&b, binary.LittleEndian, uint32(len(s)))
+			b.WriteString(s)
+		}
+	}
+	if nbits > 0 {
+		b.WriteByte(bits)
+	}
+	return b.Bytes(), nil
+}
+
+// rleLevels encodes the definition levels of values, 1 for a value and 0 for null, as runs of the RLE hybrid
+// encoding with a bit width of 1
+func rleLevels(values []any) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(values); {
+		level := values[i] != nil
+		n := 1
+		for i+n < len(values) && (values[i+n] != nil) == level {
+			n++
+		}
+		b.Write(binary.AppendUvarint(nil, uint64(n)<<1))
+		b.WriteByte(map[bool]byte{false: 0, true: 1}[level])
+		i += n
+	}
+	return b.Bytes()
+}
+
+// Types of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes a struct in the Thrift compact protocol, the encoding of Parquet metadata
+type thriftWriter struct {
+	bytes.Buffer
+	last int16 // id of the last field written in the current struct
+}
+
+// zigzag maps signed integers to unsigned ones with small magnitudes staying small
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+// field writes the header of a field
+func (t *thriftWriter) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// structField writes a struct field with the fields written by fn
+func (t *thriftWriter) structField(id int16, fn func(*thriftWriter)) {
+	t.field(id, thriftStruct)
+	t.nested(fn)
+}
+
+// nested writes a struct, whose field ids start over, with the fields written by fn
+func (t *thriftWriter) nested(fn func(*thriftWriter)) {
+	last := t.last
+	t.last = 0
+	fn(t)
+	t.stop()
+	t.last = last
+}
+
+// list writes a list field of n elements of a type, each written by fn; struct elements are closed by list
+func (t *thriftWriter) list(id int16, kind byte, n int, fn func(*thriftWriter, int)) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | kind)
+	} else {
+		t.WriteByte(0xf0 | kind)
+		t.varint(uint64(n))
+	}
+	for i := range n {
+		if kind == thriftStruct {
+			t.nested(func(t *thriftWriter) { fn(t, i) })
+		} else {
+			fn(t, i)
+		}
+	}
+}
+
+// stop ends the current struct
+func (t *thriftWriter) stop() {
+	t.WriteByte(0)
+}
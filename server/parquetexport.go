@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// exportTables builds the tables of the analytics export: one row per attempt, per answer of an attempt and per
+// integrity event of a session
+func exportTables(attempts *attemptStore, sessions *sessionStore) (map[string]*parquetTable, error) {
+	all, err := sessions.backend.All()
+	if err != nil {
+		return nil, err
+	}
+
+	results := &parquetTable{}
+	for _, c := range []struct {
+		name     string
+		kind     int
+		optional bool
+	}{
+		{"id", parquetByteArray, false}, {"user_id", parquetByteArray, true}, {"subject", parquetByteArray, false},
+		{"exam", parquetByteArray, false}, {"exam_id", parquetByteArray, true}, {"started_at", parquetTimestamp, true},
+		{"submitted_at", parquetTimestamp, false}, {"score", parquetInt64, false}, {"total", parquetInt64, false},
+		{"percent", parquetDouble, false}, {"passed", parquetBoolean, true}, {"points", parquetDouble, true},
+		{"max_points", parquetDouble, true}, {"scaled", parquetDouble, true}, {"percentile", parquetDouble, true},
+		{"variant", parquetByteArray, true},
+	} {
+		results.column(c.name, c.kind, c.optional)
+	}
+	answers := &parquetTable{}
+	answers.column("attempt_id", parquetByteArray, false)
+	answers.column("question", parquetInt64, false)
+	answers.column("answered", parquetBoolean, false)
+	answers.column("correct", parquetBoolean, false)
+	answers.column("time_spent", parquetDouble, true)
+	for _, a := range attempts.List(nil) {
+		results.add(a.ID, a.UserID, a.Subject, a.Exam, a.ExamID, a.StartedAt, a.SubmittedAt, a.Score, a.Total, a.Percent,
+			a.Passed, a.Points, a.MaxPoints, a.Scaled, a.Percentile, a.Variant)
+		for i, correct := range a.Correct {
+			var spent *float64
+			if i < len(a.TimeSpent) {
+				spent = &a.TimeSpent[i]
+			}
+			answers.add(a.ID, i, i < len(a.Answers) && a.Answers[i] != nil, correct, spent)
+		}
+	}
+
+	events := &parquetTable{}
+	events.column("session_id", parquetByteArray, false)
+	events.column("attempt_id", parquetByteArray, true)
+	events.column("user_id", parquetByteArray, true)
+	events.column("subject", parquetByteArray, false)
+	events.column("exam", parquetByteArray, false)
+	events.column("type", parquetByteArray, false)
+	events.column("at", parquetTimestamp, false)
+	events.column("duration_ms", parquetInt64, true)
+	events.column("question", parquetInt64, true)
+	for _, s := range all {
+		for _, e := range s.Events {
+			var duration *int
+			if e.DurationMs > 0 {
+				d := int(e.DurationMs)
+				duration = &d
+			}
+			events.add(s.ID, s.AttemptID, s.UserID, s.Subject, s.Exam, e.Type, e.At, duration, e.Question)
+		}
+	}
+	return map[string]*parquetTable{"results": results, "answers": answers, "events": events}, nil
+}
+
+// exportParquet writes the analytics tables as Parquet files under <destination>/<date>/, replacing those
+// of an earlier run on the same day. The destination is a local directory, <dataDir>/exports when empty, or an
+// s3://bucket/prefix URL. The files hold user ids, which erasing a user leaves in the files already written.
+func exportParquet(ctx context.Context, attempts *attemptStore, sessions *sessionStore, destination, dataDir string, now time.Time) error {
+	tables, err := exportTables(attempts, sessions)
+	if err != nil {
+		return err
+	}
+	destination = cmp.Or(destination, filepath.Join(dataDir, "exports"))
+	day := now.UTC().Format(time.DateOnly)
+	for name, t := range tables {
+		var buf bytes.Buffer
+		if _, err := t.WriteTo(&buf); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		if bucket, ok := strings.CutPrefix(destination, "s3://"); ok {
+			bucket, prefix, _ := strings.Cut(bucket, "/")
+			key := strings.Trim(prefix+"/"+day+"/"+name+".parquet", "/")
+			if err := putS3Object(ctx, bucket, key, buf.Bytes(), now); err != nil {
+				return fmt.Errorf("failed to upload %s: %w", name, err)
+			}
+			continue
+		}
+		dir := filepath.Join(destination, day)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := storage.WriteFileAtomic(filepath.Join(dir, name+".parquet"), buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	log.Printf("Exported %d result(s) and %d event(s) to %s", tables["results"].rows, tables["events"].rows, destination)
+	return nil
+}
+
+// putS3Object uploads an object to S3 with a request signed by Signature Version 4. Credentials and the region
+// come from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION variables;
+// AWS_ENDPOINT_URL points it at an S3-compatible store, addressing buckets by path.
+func putS3Object(ctx context.Context, bucket, key string, body []byte, now time.Time) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	region := cmp.Or(os.Getenv("AWS_REGION"), "us-east-1")
+	target := "https://" + bucket + ".s3." + region + ".amazonaws.com/" + s3Escape(key)
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		target = strings.TrimSuffix(endpoint, "/") + "/" + s3Escape(bucket) + "/" + s3Escape(key)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	stamp, day := now.UTC().Format("20060102T150405Z"), now.UTC().Format("20060102")
+	payload := sha256.Sum256(body)
+	headers := [][2]string{{"host", u.Host}, {"x-amz-content-sha256", hex.EncodeToString(payload[:])}, {"x-amz-date", stamp}}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		headers = append(headers, [2]string{"x-amz-security-token", token})
+	}
+	var canonical, signed strings.Builder
+	for i, h := range headers {
+		fmt.Fprintf(&canonical, "%s:%s\n", h[0], h[1])
+		if i > 0 {
+			signed.WriteByte(';')
+		}
+		signed.WriteString(h[0])
+	}
+	request := strings.Join([]string{http.MethodPut, u.EscapedPath(), "", canonical.String(), signed.String(), headers[1][1]}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	signingKey := mac(mac(mac(mac([]byte("AWS4"+secretKey), day), region), "s3"), "aws4_request")
+	signature := hex.EncodeToString(mac(signingKey, toSign))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for _, h := range headers[1:] {
+		req.Header.Set(h[0], h[1])
+	}
+	req.Header.Set("Content-Type", "application/vnd.apache.parquet")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signed.String(), signature))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("S3 responded %s", resp.Status)
+	}
+	return nil
+}
+
+// s3Escape escapes an object key for an S3 URL, keeping its slashes
+func s3Escape(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// SessionPause is a stretch of time a session was paused for
+type SessionPause struct {
+	PausedAt  time.Time `json:"pausedAt"`
+	ResumedAt time.Time `json:"resumedAt,omitzero"` // zero while the session is still paused
+}
+
+// Errors returned when pausing and resuming sessions
+var (
+	errSessionPaused = errors.New("session is paused")
+	errNotPaused     = errors.New("session is not paused")
+	errTimedSession  = errors.New("timed exams cannot be paused")
+)
+
+// paused reports whether the session is paused now
+func (s *Session) paused() bool {
+	return len(s.Pauses) > 0 && s.Pauses[len(s.Pauses)-1].ResumedAt.IsZero()
+}
+
+// lastActive returns when the clock of the session last started running: when it started, was last saved or
+// last resumed
+func (s *Session) lastActive() time.Time {
+	last := s.StartedAt
+	if s.SavedAt.After(last) {
+		last = s.SavedAt
+	}
+	if len(s.Pauses) > 0 && s.Pauses[len(s.Pauses)-1].ResumedAt.After(last) {
+		last = s.Pauses[len(s.Pauses)-1].ResumedAt
+	}
+	return last
+}
+
+// elapsed returns how long the session has been taken for at now, not counting the time it was paused
+func (s *Session) elapsed(now time.Time) time.Duration {
+	d := now.Sub(s.StartedAt)
+	for _, p := range s.Pauses {
+		end := p.ResumedAt
+		if end.IsZero() {
+			end = now
+		}
+		d -= end.Sub(p.PausedAt)
+	}
+	return max(d, 0)
+}
+
+// checkAnswerable returns why the answers of a session cannot be changed or submitted, or nil if they can
+func checkAnswerable(session *Session) error {
+	if err := checkOpen(session); err != nil {
+		return err
+	}
+	if session.paused() {
+		return errSessionPaused
+	}
+	return nil
+}
+
+// pause returns a handler that pauses (POST .../pause) or resumes (POST .../resume) a session. Only sessions of
+// untimed exams can be paused; while paused, their clock stops and their answers are locked.
+func (s *sessionStore) pause(store *examStore, pausing bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := s.backend.Load(r.PathValue("id"))
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+		if pausing {
+			subjects, err := store.Subjects(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			sub := submission{Subject: session.Subject, Exam: session.Exam, ExamID: session.ExamID}
+			if e, ok := findSubmittedExam(subjects, &sub); ok && !isUntimed(e) {
+				sessionError(w, errTimedSession)
+				return
+			}
+		}
+
+		session, err = s.update(session.ID, func(session *Session) error {
+			if err := checkOpen(session); err != nil {
+				return err
+			}
+			if err := s.claim(session, r.Header.Get("X-Session-Client"), false); err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			switch {
+			case pausing && session.paused():
+				return errSessionPaused
+			case pausing:
+				session.Pauses = append(session.Pauses, SessionPause{PausedAt: now})
+			case !session.paused():
+				return errNotPaused
+			default:
+				session.Pauses[len(session.Pauses)-1].ResumedAt = now
+			}
+			return nil
+		})
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+		s.respond(w, http.StatusOK, session)
+	}
+}
+
+// isUntimed reports whether exam e has no time limit, so its sessions can be paused
+func isUntimed(e exam.ExamFile) bool {
+	return e.Meta == nil || e.Meta.Duration <= 0
+}
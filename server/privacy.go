@@ -0,0 +1,178 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserData is all personal data kept about a user, as exported on request under the GDPR
+type UserData struct {
+	UserID       string         `json:"userId"`
+	ExportedAt   time.Time      `json:"exportedAt"`
+	Attempts     []Attempt      `json:"attempts"`
+	Sessions     []*Session     `json:"sessions"`
+	Groups       []UserGroup    `json:"groups"`
+	Badges       []Badge        `json:"badges"` // earned ones only
+	Certificates []Certificate  `json:"certificates"`
+	Leaderboard  time.Time      `json:"leaderboardOptIn,omitzero"`
+	Daily        *dailyRecord   `json:"daily,omitempty"`
+	Flags        []QuestionFlag `json:"flags"`
+	Comments     []Comment      `json:"comments"`
+	Ratings      []ExamRating   `json:"ratings"`
+	Retained     []string       `json:"retained"` // copies outside the stores exported here, which erasure does not reach
+}
+
+// Erasure is the response to erasing the personal data of a user
+type Erasure struct {
+	UserID   string   `json:"userId"`
+	Retained []string `json:"retained"` // copies made before the erasure that it did not reach
+}
+
+// UserGroup is a group a user belongs to, without its other members
+type UserGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// personalData gathers the stores holding data about users, for exporting and erasing everything about one
+type personalData struct {
+	attempts *attemptStore
+	sessions *sessionStore
+	groups   *groupStore
+	badges   *badgeStore
+	certs    *certificateStore
+	boards   *leaderboardStore
+	daily    *dailyStore
+	flags    *flagStore
+	similar  *similarityStore
+	comments *commentStore
+	ratings  *ratingStore
+
+	live    *liveConfig
+	dataDir string
+}
+
+// retained describes the copies of personal data kept outside the stores, which are neither exported nor erased:
+// the snapshots of the backup job until it rotates them out, and the Parquet files of the analytics export, which
+// hold user ids until deleted. Attempts are anonymized by erasure, so exports written after it leave the user out.
+func (p personalData) retained() []string {
+	copies := []string{}
+	jobs := p.live.current().Jobs
+	backups := filepath.Join(p.dataDir, "backups")
+	if _, err := os.Stat(backups); err == nil {
+		copies = append(copies, "data directory snapshots in "+backups+", until the backup job rotates them out, keeping the "+
+			strconv.Itoa(cmp.Or(jobs.Backup.Keep, defaultBackupsKept))+" newest")
+	}
+	exports := cmp.Or(jobs.Parquet.Destination, filepath.Join(p.dataDir, "exports"))
+	if _, err := os.Stat(exports); err == nil || strings.HasPrefix(exports, "s3://") {
+		copies = append(copies, "Parquet exports in "+exports+", until they are deleted")
+	}
+	return copies
+}
+
+// Export collects the data kept about a user
+func (p personalData) Export(user string) (UserData, error) {
+	sessions, err := p.sessions.ByUser(user)
+	if err != nil {
+		return UserData{}, err
+	}
+	data := UserData{
+		UserID:       user,
+		ExportedAt:   time.Now().UTC(),
+		Attempts:     p.attempts.List(func(a Attempt) bool { return a.UserID == user }),
+		Sessions:     sessions,
+		Groups:       []UserGroup{},
+		Badges:       []Badge{},
+		Certificates: p.certs.ByUser(user),
+		Leaderboard:  p.boards.OptedInAt(user),
+		Daily:        p.daily.ByUser(user),
+		Flags:        p.flags.ByUser(user),
+		Comments:     p.comments.ByUser(user),
+		Ratings:      p.ratings.ByUser(user),
+		Retained:     p.retained(),
+	}
+	if data.Attempts == nil {
+		data.Attempts = []Attempt{}
+	}
+	if data.Sessions == nil {
+		data.Sessions = []*Session{}
+	}
+	for _, g := range p.groups.MemberGroups(user) {
+		data.Groups = append(data.Groups, UserGroup{ID: g.ID, Name: g.Name})
+	}
+	for _, b := range p.badges.Badges(user) {
+		if b.Earned {
+			data.Badges = append(data.Badges, b)
+		}
+	}
+	return data, nil
+}
+
+// Erase deletes the personal data kept about a user. Their attempts, ratings, flags and similarity flags are kept without their
+// name so exam statistics and the moderation queue stay intact; data already sent to an LRS or LTI platform is
+// out of reach, as are the backup snapshots and Parquet exports made before, which retained lists. A failed
+// erasure can be run again.
+func (p personalData) Erase(user string) error {
+	// Sessions go first so an open one cannot be submitted as a new attempt in between
+	if err := p.sessions.EraseUser(user); err != nil {
+		return err
+	}
+	if _, err := p.attempts.Anonymize(user); err != nil {
+		return err
+	}
+	if err := p.boards.SetOptIn(user, false); err != nil {
+		return err
+	}
+	for _, erase := range []func(string) error{
+		p.certs.EraseUser,
+		p.badges.EraseUser,
+		p.groups.RemoveUser,
+		p.daily.EraseUser,
+		p.flags.EraseUser,
+		p.similar.EraseUser,
+		p.comments.EraseUser,
+		p.ratings.EraseUser,
+	} {
+		if err := erase(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportUserData returns a handler that sends all personal data kept about a user as JSON
+func exportUserData(p personalData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := p.Export(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Failed to read user data: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="user-data.json"`)
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// eraseUserData returns a handler that erases the personal data kept about a user and lists the copies it did not
+// reach, which the operator deletes or lets expire
+func eraseUserData(p personalData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("id")
+		if err := p.Erase(user); err != nil {
+			http.Error(w, "Failed to erase user data: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		erasure := Erasure{UserID: user, Retained: p.retained()}
+		log.Printf("Erased the personal data of user %s; copies retained: %s", user, cmp.Or(strings.Join(erasure.Retained, "; "), "none"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(erasure)
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEraseListsRetainedCopies(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin"}, map[string]string{"Math/algebra.json": testExam})
+	rec := serveTest(s, "DELETE", "/api/v1/users/ann", "admin", nil)
+	wantStatus(t, rec, http.StatusOK)
+	var erasure Erasure
+	if err := json.Unmarshal(rec.Body.Bytes(), &erasure); err != nil {
+		t.Fatal(err)
+	}
+	if erasure.UserID != "ann" || len(erasure.Retained) != 0 {
+		t.Errorf("erasure without backups or exports = %+v, want nothing retained", erasure)
+	}
+
+	dir := t.TempDir()
+	p := personalData{live: s.live, dataDir: dir}
+	if err := os.MkdirAll(filepath.Join(dir, "backups"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.retained(); len(got) != 1 || !strings.Contains(got[0], "backups") {
+		t.Errorf("retained = %q, want the backup snapshots", got)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "exports"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.retained(); len(got) != 2 || !strings.Contains(got[1], "Parquet") {
+		t.Errorf("retained = %q, want the backup snapshots and the Parquet exports", got)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// proctorEventTypes lists the integrity events clients report while an exam is taken
+var proctorEventTypes = []string{"focus-loss", "fullscreen-exit", "copy", "paste"}
+
+// maxProctorEvents bounds the events kept per session, so a misbehaving client cannot grow it without end
+const maxProctorEvents = 1000
+
+// ProctorEvent is an integrity event a client reported during a session
+type ProctorEvent struct {
+	Type       string    `json:"type"`                 // one of proctorEventTypes
+	At         time.Time `json:"at"`                   // when the client saw it, or when it was received if the client did not say
+	DurationMs int64     `json:"durationMs,omitempty"` // how long the window was left, for focus losses
+	Question   *int      `json:"question,omitempty"`   // question index the event happened on, if known
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// IntegrityReport sums up the integrity events of a session for instructors
+type IntegrityReport struct {
+	SessionID   string         `json:"sessionId"`
+	UserID      string         `json:"userId,omitempty"`
+	Subject     string         `json:"subject"`
+	Exam        string         `json:"exam"`
+	Status      string         `json:"status"`
+	StartedAt   time.Time      `json:"startedAt"`
+	Counts      map[string]int `json:"counts"`      // events by type, with every type listed
+	FocusLostMs int64          `json:"focusLostMs"` // total time the window was left
+	Events      []ProctorEvent `json:"events"`      // oldest first
+	Truncated   bool           `json:"truncated,omitempty"`
+}
+
+// integrityReport sums up the integrity events of a session
+func integrityReport(session *Session) IntegrityReport {
+	report := IntegrityReport{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		Subject:   session.Subject,
+		Exam:      session.Exam,
+		Status:    session.Status,
+		StartedAt: session.StartedAt,
+		Counts:    make(map[string]int, len(proctorEventTypes)),
+		Events:    slices.Clone(session.Events),
+		Truncated: len(session.Events) >= maxProctorEvents,
+	}
+	if report.Events == nil {
+		report.Events = []ProctorEvent{}
+	}
+	for _, t := range proctorEventTypes {
+		report.Counts[t] = 0
+	}
+	for _, e := range session.Events {
+		report.Counts[e.Type]++
+		if e.Type == "focus-loss" {
+			report.FocusLostMs += e.DurationMs
+		}
+	}
+	return report
+}
+
+// recordEvents appends the integrity events reported by a client to its session. Events past the limit of a
+// session are dropped rather than refused, so clients do not retry them.
+func (s *sessionStore) recordEvents(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Events []ProctorEvent `json:"events"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	for i := range req.Events {
+		e := &req.Events[i]
+		if !slices.Contains(proctorEventTypes, e.Type) {
+			http.Error(w, fmt.Sprintf("Unknown event type %q (expected %s)", e.Type, strings.Join(proctorEventTypes, ", ")), http.StatusBadRequest)
+			return
+		}
+		if e.DurationMs < 0 || e.Question != nil && *e.Question < 0 {
+			http.Error(w, fmt.Sprintf("Invalid %s event", e.Type), http.StatusBadRequest)
+			return
+		}
+		// Clocks of clients cannot be trusted to be in the future
+		if e.At.IsZero() || e.At.After(now) {
+			e.At = now
+		}
+		e.ReceivedAt = now
+	}
+
+	_, err := s.update(r.PathValue("id"), func(session *Session) error {
+		if err := checkOpen(session); err != nil {
+			return err
+		}
+		room := max(maxProctorEvents-len(session.Events), 0)
+		session.Events = append(session.Events, req.Events[:min(room, len(req.Events))]...)
+		return nil
+	})
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionIntegrity returns a handler that returns the integrity report of a session for instructors
+func sessionIntegrity(sessions *sessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := sessions.backend.Load(r.PathValue("id"))
+		if err == nil && !scopeOf(r).allows(session.Subject) {
+			err = errSessionNotFound
+		}
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(integrityReport(session))
+	}
+}
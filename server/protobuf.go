@@ -0,0 +1,344 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufSchema is the .proto definition of the messages the API can be served as
+//
+//go:embed proto/exam.proto
+var protobufSchema []byte
+
+// protoKind is the type of a message field, which decides its wire encoding
+type protoKind int
+
+const (
+	protoString protoKind = iota
+	protoInt
+	protoDouble
+	protoBool
+	protoMessage   // a message of protoMessages
+	protoValue     // google.protobuf.Value, any JSON value
+	protoStruct    // google.protobuf.Struct, any JSON object
+	protoTimestamp // google.protobuf.Timestamp, from an RFC 3339 string
+)
+
+// protoField is a field of a message, keyed by its JSON name in protoMessageType.fields
+type protoField struct {
+	num      protowire.Number
+	kind     protoKind
+	repeated bool
+	msg      string // message type of protoMessage fields
+}
+
+// protoMessageType maps the keys of a JSON object to the fields of a message. Keys without a field go to the
+// extra Struct field if the message has one, and are dropped otherwise.
+type protoMessageType struct {
+	fields map[string]protoField
+	extra  protowire.Number
+}
+
+// protoMessages are the messages of proto/exam.proto, which they must be kept in line with
+var protoMessages = map[string]protoMessageType{
+	"SubjectList": {fields: map[string]protoField{
+		"subjects": {num: 1, kind: protoMessage, repeated: true, msg: "Subject"},
+	}},
+	"Subject": {fields: map[string]protoField{
+		"name":        {num: 1, kind: protoString},
+		"path":        {num: 2, kind: protoString},
+		"displayName": {num: 3, kind: protoString},
+		"description": {num: 4, kind: protoString},
+		"icon":        {num: 5, kind: protoString},
+		"order":       {num: 6, kind: protoDouble},
+		"exams":       {num: 7, kind: protoMessage, repeated: true, msg: "Exam"},
+		"subjects":    {num: 8, kind: protoMessage, repeated: true, msg: "Subject"},
+	}},
+	"Exam": {fields: map[string]protoField{
+		"name":             {num: 1, kind: protoString},
+		"meta":             {num: 2, kind: protoMessage, msg: "ExamMeta"},
+		"questionCount":    {num: 3, kind: protoInt},
+		"estimatedMinutes": {num: 4, kind: protoInt},
+		"size":             {num: 5, kind: protoInt},
+		"sha256":           {num: 6, kind: protoString},
+		"content":          {num: 7, kind: protoMessage, repeated: true, msg: "Question"},
+		"rating":           {num: 8, kind: protoMessage, msg: "RatingSummary"},
+		"subject":          {num: 9, kind: protoString},
+		"id":               {num: 10, kind: protoString},
+		"slug":             {num: 11, kind: protoString},
+	}},
+	"ExamMeta": {fields: map[string]protoField{
+		"title":        {num: 1, kind: protoString},
+		"description":  {num: 2, kind: protoString},
+		"instructions": {num: 3, kind: protoString},
+		"author":       {num: 4, kind: protoString},
+		"difficulty":   {num: 5, kind: protoString},
+		"duration":     {num: 6, kind: protoInt},
+		"passingScore": {num: 7, kind: protoDouble},
+		"order":        {num: 8, kind: protoDouble},
+		"scoring":      {num: 9, kind: protoStruct},
+		"id":           {num: 10, kind: protoString},
+		"sections":     {num: 11, kind: protoMessage, repeated: true, msg: "Section"},
+		"scale":        {num: 12, kind: protoStruct},
+		"report":       {num: 13, kind: protoStruct},
+	}},
+	"Section": {fields: map[string]protoField{
+		"name":         {num: 1, kind: protoString},
+		"weight":       {num: 2, kind: protoDouble},
+		"passingScore": {num: 3, kind: protoDouble},
+	}},
+	"RatingSummary": {fields: map[string]protoField{
+		"average": {num: 1, kind: protoDouble},
+		"count":   {num: 2, kind: protoInt},
+		"stars":   {num: 3, kind: protoInt, repeated: true},
+	}},
+	"Question": {extra: 15, fields: map[string]protoField{
+		"id":          {num: 1, kind: protoString},
+		"type":        {num: 2, kind: protoString},
+		"question":    {num: 3, kind: protoString},
+		"choices":     {num: 4, kind: protoString, repeated: true},
+		"correct":     {num: 5, kind: protoValue},
+		"explanation": {num: 6, kind: protoString},
+		"tags":        {num: 7, kind: protoString, repeated: true},
+		"image":       {num: 8, kind: protoString},
+		"audio":       {num: 9, kind: protoString},
+		"section":     {num: 10, kind: protoString},
+	}},
+	"ExamRef": {fields: map[string]protoField{
+		"subject": {num: 1, kind: protoString},
+		"name":    {num: 2, kind: protoString},
+	}},
+	"ExamBatch": {fields: map[string]protoField{
+		"exams":   {num: 1, kind: protoMessage, repeated: true, msg: "Exam"},
+		"missing": {num: 2, kind: protoMessage, repeated: true, msg: "ExamRef"},
+	}},
+	"Session": {fields: map[string]protoField{
+		"id":                 {num: 1, kind: protoString},
+		"userId":             {num: 2, kind: protoString},
+		"subject":            {num: 3, kind: protoString},
+		"exam":               {num: 4, kind: protoString},
+		"variant":            {num: 5, kind: protoStruct},
+		"ltiLaunch":          {num: 6, kind: protoString},
+		"status":             {num: 7, kind: protoString},
+		"startedAt":          {num: 8, kind: protoTimestamp},
+		"savedAt":            {num: 9, kind: protoTimestamp},
+		"answers":            {num: 10, kind: protoValue, repeated: true},
+		"attemptId":          {num: 11, kind: protoString},
+		"sitting":            {num: 12, kind: protoInt},
+		"client":             {num: 13, kind: protoString},
+		"lastSeen":           {num: 14, kind: protoTimestamp},
+		"autosaveDebounceMs": {num: 15, kind: protoInt},
+		"examId":             {num: 16, kind: protoString},
+		"timeSpent":          {num: 17, kind: protoDouble, repeated: true},
+		"pauses":             {num: 18, kind: protoMessage, repeated: true, msg: "SessionPause"},
+		"paused":             {num: 19, kind: protoBool},
+		"elapsedSeconds":     {num: 20, kind: protoDouble},
+		"events":             {num: 21, kind: protoMessage, repeated: true, msg: "ProctorEvent"},
+	}},
+	"ProctorEvent": {fields: map[string]protoField{
+		"type":       {num: 1, kind: protoString},
+		"at":         {num: 2, kind: protoTimestamp},
+		"durationMs": {num: 3, kind: protoInt},
+		"question":   {num: 4, kind: protoInt},
+		"receivedAt": {num: 5, kind: protoTimestamp},
+	}},
+	"SessionPause": {fields: map[string]protoField{
+		"pausedAt":  {num: 1, kind: protoTimestamp},
+		"resumedAt": {num: 2, kind: protoTimestamp},
+	}},
+}
+
+// protoRoute is the message a route responds with; list names the field a JSON array response is the value of
+type protoRoute struct {
+	msg  string
+	list string
+}
+
+// protoRoutes are the routes that can respond in protobuf; the others send JSON to clients asking for it
+var protoRoutes = map[string]protoRoute{
+	apiPrefix + "/exams":                 {msg: "SubjectList", list: "subjects"},
+	apiPrefix + "/exams/batch":           {msg: "ExamBatch"},
+	apiPrefix + "/sessions":              {msg: "Session"},
+	apiPrefix + "/sessions/{id}":         {msg: "Session"},
+	apiPrefix + "/sessions/{id}/answers": {msg: "Session"},
+	apiPrefix + "/sessions/{id}/submit":  {msg: "Session"},
+}
+
+// encodeProtobuf encodes v, the decoded JSON response of route, as the message of the route
+func encodeProtobuf(route string, v any) ([]byte, bool) {
+	pr, ok := protoRoutes[route]
+	if !ok {
+		return nil, false
+	}
+	if pr.list != "" {
+		v = map[string]any{pr.list: v}
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return appendProtoMessage(nil, protoMessages[pr.msg], obj)
+}
+
+// appendProtoMessage appends the fields of obj as a message of type m. It fails if a value does not fit its
+// field and m has no extra field to keep it in.
+func appendProtoMessage(b []byte, m protoMessageType, obj map[string]any) ([]byte, bool) {
+	extra := map[string]any{}
+	for _, key := range sortedKeys(obj) {
+		f, known := m.fields[key]
+		if known {
+			if next, ok := appendProtoField(b, f, obj[key]); ok {
+				b = next
+				continue
+			}
+			if m.extra == 0 {
+				return nil, false
+			}
+		}
+		if m.extra != 0 {
+			extra[key] = obj[key]
+		}
+	}
+	if len(extra) > 0 {
+		b = protowire.AppendTag(b, m.extra, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendProtoStruct(nil, extra))
+	}
+	return b, true
+}
+
+// appendProtoField appends v as field f; null leaves the field out
+func appendProtoField(b []byte, f protoField, v any) ([]byte, bool) {
+	if v == nil {
+		return b, true
+	}
+	if !f.repeated {
+		return appendProtoSingle(b, f, v)
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+	for _, item := range items {
+		if b, ok = appendProtoSingle(b, f, item); !ok {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// appendProtoSingle appends one value of field f
+func appendProtoSingle(b []byte, f protoField, v any) ([]byte, bool) {
+	switch f.kind {
+	case protoString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		return protowire.AppendString(protowire.AppendTag(b, f.num, protowire.BytesType), s), true
+	case protoInt:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		i, ok := jsonInt(n)
+		if !ok {
+			return nil, false
+		}
+		return protowire.AppendVarint(protowire.AppendTag(b, f.num, protowire.VarintType), uint64(i)), true
+	case protoDouble:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		x, err := n.Float64()
+		if err != nil {
+			return nil, false
+		}
+		return protowire.AppendFixed64(protowire.AppendTag(b, f.num, protowire.Fixed64Type), math.Float64bits(x)), true
+	case protoBool:
+		x, ok := v.(bool)
+		if !ok {
+			return nil, false
+		}
+		return protowire.AppendVarint(protowire.AppendTag(b, f.num, protowire.VarintType), protowire.EncodeBool(x)), true
+	case protoMessage:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		msg, ok := appendProtoMessage(nil, protoMessages[f.msg], obj)
+		if !ok {
+			return nil, false
+		}
+		return protowire.AppendBytes(protowire.AppendTag(b, f.num, protowire.BytesType), msg), true
+	case protoValue:
+		return protowire.AppendBytes(protowire.AppendTag(b, f.num, protowire.BytesType), appendProtoValue(nil, v)), true
+	case protoStruct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		return protowire.AppendBytes(protowire.AppendTag(b, f.num, protowire.BytesType), appendProtoStruct(nil, obj)), true
+	case protoTimestamp:
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, false
+		}
+		var ts []byte
+		ts = protowire.AppendVarint(protowire.AppendTag(ts, 1, protowire.VarintType), uint64(t.Unix()))
+		if nanos := t.Nanosecond(); nanos != 0 {
+			ts = protowire.AppendVarint(protowire.AppendTag(ts, 2, protowire.VarintType), uint64(nanos))
+		}
+		return protowire.AppendBytes(protowire.AppendTag(b, f.num, protowire.BytesType), ts), true
+	}
+	return nil, false
+}
+
+// appendProtoValue appends a JSON value as the fields of a google.protobuf.Value
+func appendProtoValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case json.Number:
+		x, _ := v.Float64()
+		return protowire.AppendFixed64(protowire.AppendTag(b, 2, protowire.Fixed64Type), math.Float64bits(x))
+	case string:
+		return protowire.AppendString(protowire.AppendTag(b, 3, protowire.BytesType), v)
+	case bool:
+		return protowire.AppendVarint(protowire.AppendTag(b, 4, protowire.VarintType), protowire.EncodeBool(v))
+	case map[string]any:
+		return protowire.AppendBytes(protowire.AppendTag(b, 5, protowire.BytesType), appendProtoStruct(nil, v))
+	case []any:
+		var list []byte
+		for _, item := range v {
+			list = protowire.AppendBytes(protowire.AppendTag(list, 1, protowire.BytesType), appendProtoValue(nil, item))
+		}
+		return protowire.AppendBytes(protowire.AppendTag(b, 6, protowire.BytesType), list)
+	}
+	// NULL_VALUE is the zero of its enum, but the value must still be set to tell null from no value
+	return protowire.AppendVarint(protowire.AppendTag(b, 1, protowire.VarintType), 0)
+}
+
+// appendProtoStruct appends a JSON object as the fields of a google.protobuf.Struct
+func appendProtoStruct(b []byte, obj map[string]any) []byte {
+	for _, key := range sortedKeys(obj) {
+		var entry []byte
+		entry = protowire.AppendString(protowire.AppendTag(entry, 1, protowire.BytesType), key)
+		entry = protowire.AppendBytes(protowire.AppendTag(entry, 2, protowire.BytesType), appendProtoValue(nil, obj[key]))
+		b = protowire.AppendBytes(protowire.AppendTag(b, 1, protowire.BytesType), entry)
+	}
+	return b
+}
+
+// serveProtobufSchema is the handler returning the .proto definition of the protobuf responses
+func serveProtobufSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(protobufSchema)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// QuestionStats aggregates the attempts at an exam for one of its questions
+type QuestionStats struct {
+	Index          int      `json:"index"` // position in the exam, from 0
+	ID             string   `json:"id,omitempty"`
+	Question       string   `json:"question"`
+	Attempts       int      `json:"attempts"`
+	Answered       int      `json:"answered"`
+	CorrectRate    float64  `json:"correctRate"`              // percent of the attempts answering it right
+	Timed          int      `json:"timed"`                    // attempts that reported the time spent on it
+	AverageSeconds *float64 `json:"averageSeconds,omitempty"` // set when any attempt was timed
+	MedianSeconds  *float64 `json:"medianSeconds,omitempty"`
+	Difficulty     *float64 `json:"difficulty,omitempty"` // item response parameters, set once the question is calibrated
+	Discrimination *float64 `json:"discrimination,omitempty"`
+}
+
+// questionStats aggregates attempts at exam e per question. Attempts made before the exam changed its number of
+// questions cannot be matched to its questions and are left out.
+func questionStats(e exam.ExamFile, list []Attempt) []QuestionStats {
+	questions := exam.Questions(e.Content)
+	stats := make([]QuestionStats, len(questions))
+	times := make([][]float64, len(questions))
+	for i, item := range questions {
+		stats[i].Index = i
+		if q, ok := item.(map[string]any); ok {
+			stats[i].ID, _ = q["id"].(string)
+			stats[i].Question, _ = q["question"].(string)
+		}
+	}
+	for _, a := range list {
+		if len(a.Correct) != len(questions) {
+			continue
+		}
+		for i := range stats {
+			stats[i].Attempts++
+			if i < len(a.Answers) && a.Answers[i] != nil {
+				stats[i].Answered++
+			}
+			if a.Correct[i] {
+				stats[i].CorrectRate++
+			}
+			if i < len(a.TimeSpent) && a.TimeSpent[i] > 0 {
+				times[i] = append(times[i], a.TimeSpent[i])
+			}
+		}
+	}
+	for i := range stats {
+		s := &stats[i]
+		if s.Attempts > 0 {
+			s.CorrectRate = s.CorrectRate * 100 / float64(s.Attempts)
+		}
+		if s.Timed = len(times[i]); s.Timed > 0 {
+			slices.Sort(times[i])
+			sum := 0.0
+			for _, t := range times[i] {
+				sum += t
+			}
+			average, median := sum/float64(s.Timed), times[i][s.Timed/2]
+			if s.Timed%2 == 0 {
+				median = (times[i][s.Timed/2-1] + median) / 2
+			}
+			s.AverageSeconds, s.MedianSeconds = &average, &median
+		}
+	}
+	return stats
+}
+
+// examQuestionStats returns a handler that returns how every question of an exam fared across its attempts,
+// including the time taken on it where clients reported it and its calibrated parameters
+func examQuestionStats(store *examStore, attempts *attemptStore, cal *calibrationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+
+		stats := questionStats(e, attempts.List(func(a Attempt) bool { return a.isFor(subject, e) }))
+		for i, item := range exam.WithQuestionIDs(exam.Questions(e.Content), subject, e.Name) {
+			q, _ := item.(map[string]any)
+			id, _ := q["id"].(string)
+			if p, ok := cal.Item(id); ok {
+				stats[i].Difficulty, stats[i].Discrimination = &p.Difficulty, &p.Discrimination
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
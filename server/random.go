@@ -0,0 +1,170 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// Limits of the questions /api/random remembers per user to avoid repeating them
+const (
+	recentWindow = 24 * time.Hour
+	recentMax    = 500
+)
+
+// seenQuestion is a question served to a user and when
+type seenQuestion struct {
+	key string
+	at  time.Time
+}
+
+// recentQuestions remembers which questions were recently served to each user. It is kept in memory only,
+// since forgetting it on restart merely allows a repeat.
+type recentQuestions struct {
+	mu    sync.Mutex
+	users map[string][]seenQuestion
+}
+
+// poolKey identifies a pooled question across requests
+func poolKey(p exam.PoolQuestion) string {
+	return p.Subject + "\x00" + p.Exam + "\x00" + strconv.Itoa(p.Index)
+}
+
+// seen returns the keys of the questions served to user within the recent window
+func (s *recentQuestions) seen(user string, now time.Time) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := slices.DeleteFunc(s.users[user], func(q seenQuestion) bool { return now.Sub(q.at) > recentWindow })
+	s.users[user] = list
+	out := make(map[string]bool, len(list))
+	for _, q := range list {
+		out[q.key] = true
+	}
+	return out
+}
+
+// add remembers that questions were served to user, dropping the oldest beyond the limit
+func (s *recentQuestions) add(user string, questions []exam.PoolQuestion, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.users[user]
+	for _, q := range questions {
+		list = append(list, seenQuestion{key: poolKey(q), at: now})
+	}
+	if len(list) > recentMax {
+		list = slices.Clone(list[len(list)-recentMax:])
+	}
+	s.users[user] = list
+}
+
+// serveRandomQuestions returns a handler that samples questions for quick quizzes. ?subject= limits the
+// sample to a subject and its children, ?tags= to questions with any of the comma-separated tags, and
+// ?user= with ?excludeSeen=true avoids questions served to that user in the last day while enough others remain.
+// ?difficulty=easy|medium|hard limits it to calibrated questions of that difficulty, and ?adaptive=true with
+// ?user= prefers the calibrated questions telling the most about a user of the ability their attempts show.
+// Questions are only drawn from exams visible to the request.
+func serveRandomQuestions(store *examStore, access examAccess, attempts *attemptStore, cal *calibrationStore) http.HandlerFunc {
+	recent := &recentQuestions{users: map[string][]seenQuestion{}}
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		count := 5
+		if v := q.Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 50 {
+				http.Error(w, "count must be between 1 and 50", http.StatusBadRequest)
+				return
+			}
+			count = n
+		}
+		subject := q.Get("subject")
+		var tags []string
+		for _, tag := range strings.Split(q.Get("tags"), ",") {
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		user := q.Get("user")
+		excludeSeen := user != "" && q.Get("excludeSeen") == "true"
+		band, banded := difficultyBands[q.Get("difficulty")]
+		if q.Get("difficulty") != "" && !banded {
+			http.Error(w, "difficulty must be easy, medium or hard", http.StatusBadRequest)
+			return
+		}
+		adaptive := q.Get("adaptive") == "true"
+		if adaptive && user == "" {
+			http.Error(w, "adaptive requires a user", http.StatusBadRequest)
+			return
+		}
+
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pool := exam.QuestionPool(access.filter(r, user, subjects), func(s string, e exam.ExamFile) bool {
+			return subject == "" || s == subject || strings.HasPrefix(s, subject+"/")
+		})
+		if len(tags) > 0 {
+			pool = slices.DeleteFunc(pool, func(p exam.PoolQuestion) bool {
+				qt := exam.QuestionTags(p.Question.(map[string]any))
+				return !slices.ContainsFunc(tags, func(t string) bool { return slices.Contains(qt, t) })
+			})
+		}
+
+		if banded {
+			pool = slices.DeleteFunc(pool, func(p exam.PoolQuestion) bool {
+				params, ok := cal.Item(p.ID)
+				return !ok || params.Difficulty < band[0] || params.Difficulty > band[1]
+			})
+		}
+
+		rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		if adaptive {
+			// Uncalibrated questions only fill in after every calibrated one
+			theta := userAbility(subjects, attempts, cal, user)
+			information := make(map[string]float64, len(pool))
+			for _, p := range pool {
+				information[p.ID] = -1
+				if params, ok := cal.Item(p.ID); ok {
+					information[p.ID] = params.information(theta)
+				}
+			}
+			slices.SortStableFunc(pool, func(a, b exam.PoolQuestion) int {
+				return cmp.Compare(information[b.ID], information[a.ID])
+			})
+		}
+		// Unseen questions come first so seen ones only fill in when the pool runs short
+		now := time.Now()
+		if excludeSeen {
+			seen := recent.seen(user, now)
+			slices.SortStableFunc(pool, func(a, b exam.PoolQuestion) int {
+				switch sa, sb := seen[poolKey(a)], seen[poolKey(b)]; {
+				case sa == sb:
+					return 0
+				case sb:
+					return -1
+				default:
+					return 1
+				}
+			})
+		}
+		sample := append([]exam.PoolQuestion{}, pool[:min(count, len(pool))]...)
+		if user != "" {
+			recent.add(user, sample, now)
+		}
+		if store.redact {
+			sample = redactPool(sample)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sample)
+	}
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Ratings are ranked by a Bayesian average that pulls exams with few ratings towards a neutral prior,
+// so a single five-star rating does not outrank a well-reviewed exam
+const (
+	ratingPrior       = 3.0
+	ratingPriorWeight = 5.0
+	maxFeedbackLength = 2000
+)
+
+// ExamRating is one user's rating of an exam, with optional written feedback
+type ExamRating struct {
+	UserID    string    `json:"userId"`
+	Subject   string    `json:"subject"`
+	Exam      string    `json:"exam"`
+	Stars     int       `json:"stars"`
+	Feedback  string    `json:"feedback,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ratingScore returns the Bayesian average the listing is ranked by
+func ratingScore(s exam.RatingSummary) float64 {
+	return (ratingPrior*ratingPriorWeight + s.Average*float64(s.Count)) / (ratingPriorWeight + float64(s.Count))
+}
+
+// ratingStore keeps exam ratings in a JSON file; a user has at most one rating per exam
+type ratingStore struct {
+	path string
+
+	mu      sync.RWMutex
+	ratings []ExamRating
+}
+
+// openRatingStore loads the ratings saved at path
+func openRatingStore(path string) (*ratingStore, error) {
+	s := &ratingStore{path: path}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read ratings: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.ratings); err != nil {
+		return nil, fmt.Errorf("failed to parse ratings: %w", err)
+	}
+	return s, nil
+}
+
+// Set records a rating, replacing the user's earlier rating of the exam
+func (s *ratingStore) Set(r ExamRating) error {
+	r.UpdatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := slices.DeleteFunc(slices.Clone(s.ratings), func(o ExamRating) bool {
+		return o.UserID == r.UserID && o.Subject == r.Subject && o.Exam == r.Exam
+	})
+	return s.replace(append(next, r))
+}
+
+// ByUser returns the ratings a user gave
+func (s *ratingStore) ByUser(user string) []ExamRating {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []ExamRating{}
+	for _, r := range s.ratings {
+		if r.UserID == user {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// EraseUser removes a user and their written feedback from their ratings, whose stars still count towards the
+// summaries of the exams
+func (s *ratingStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := slices.Clone(s.ratings)
+	changed := false
+	for i := range next {
+		if next[i].UserID == user {
+			next[i].UserID, next[i].Feedback = "", ""
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.replace(next)
+}
+
+// replace saves the given ratings and keeps them; the caller holds the lock
+func (s *ratingStore) replace(ratings []ExamRating) error {
+	data, err := json.MarshalIndent(ratings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.ratings = ratings
+	return nil
+}
+
+// LastUpdated returns when the newest rating was given
+func (s *ratingStore) LastUpdated() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var newest time.Time
+	for _, r := range s.ratings {
+		if r.UpdatedAt.After(newest) {
+			newest = r.UpdatedAt
+		}
+	}
+	return newest
+}
+
+// Summaries aggregates the ratings of every rated exam
+func (s *ratingStore) Summaries() map[ExamRef]exam.RatingSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := map[ExamRef]exam.RatingSummary{}
+	for _, r := range s.ratings {
+		ref := ExamRef{Subject: r.Subject, Name: r.Exam}
+		sum := out[ref]
+		sum.Average = (sum.Average*float64(sum.Count) + float64(r.Stars)) / float64(sum.Count+1)
+		sum.Count++
+		sum.Stars[r.Stars-1]++
+		out[ref] = sum
+	}
+	return out
+}
+
+// Feedback returns the ratings of an exam that came with written feedback, newest first
+func (s *ratingStore) Feedback(subject, exam string) []ExamRating {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []ExamRating{}
+	for _, r := range s.ratings {
+		if r.Subject == subject && r.Exam == exam && r.Feedback != "" {
+			out = append(out, r)
+		}
+	}
+	slices.SortFunc(out, func(a, b ExamRating) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return out
+}
+
+// withRatings returns a copy of the subject tree with the rating summary of every rated exam filled in
+func withRatings(subjects []exam.Subject, summaries map[ExamRef]exam.RatingSummary) []exam.Subject {
+	if subjects == nil {
+		return nil
+	}
+	out := make([]exam.Subject, len(subjects))
+	for i, s := range subjects {
+		out[i] = s
+		out[i].Exams = make([]exam.ExamFile, len(s.Exams))
+		for j, e := range s.Exams {
+			out[i].Exams[j] = e
+			if sum, ok := summaries[ExamRef{Subject: exam.SubjectID(s), Name: e.Name}]; ok {
+				out[i].Exams[j].Rating = &sum
+			}
+		}
+		out[i].Subjects = withRatings(s.Subjects, summaries)
+	}
+	return out
+}
+
+// examRatings returns a handler that records a user's rating of an exam they have submitted (POST)
+// or returns the exam's rating summary (GET)
+func examRatings(store *examStore, attempts *attemptStore, ratings *ratingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		ref := ExamRef{Subject: subject, Name: e.Name}
+
+		if r.Method == http.MethodPost {
+			var req ExamRating
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Stars < 1 || req.Stars > 5 {
+				http.Error(w, "stars must be between 1 and 5", http.StatusBadRequest)
+				return
+			}
+			req.Feedback = strings.TrimSpace(req.Feedback)
+			if len(req.Feedback) > maxFeedbackLength {
+				http.Error(w, fmt.Sprintf("Feedback must be at most %d characters", maxFeedbackLength), http.StatusBadRequest)
+				return
+			}
+			// Only exams the user has actually sat can be rated
+			if req.UserID == "" || len(attempts.List(func(a Attempt) bool {
+				return a.UserID == req.UserID && a.isFor(ref.Subject, e)
+			})) == 0 {
+				http.Error(w, "Exams can be rated after you submit them", http.StatusForbidden)
+				return
+			}
+			req.Subject, req.Exam = ref.Subject, ref.Name
+			if err := ratings.Set(req); err != nil {
+				http.Error(w, "Failed to save rating: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ratings.Summaries()[ref])
+	}
+}
+
+// examFeedback returns a handler that lists the written feedback on an exam for instructors
+func examFeedback(store *examStore, ratings *ratingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ratings.Feedback(subject, e.Name))
+	}
+}
+
+// sortByRating orders the exams of every subject best rated first, keeping the existing order among equals
+func sortByRating(subjects []exam.Subject) {
+	for i := range subjects {
+		slices.SortStableFunc(subjects[i].Exams, func(a, b exam.ExamFile) int {
+			var sa, sb float64 = ratingPrior, ratingPrior
+			if a.Rating != nil {
+				sa = ratingScore(*a.Rating)
+			}
+			if b.Rating != nil {
+				sb = ratingScore(*b.Rating)
+			}
+			return cmp.Compare(sb, sa)
+		})
+		sortByRating(subjects[i].Subjects)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// Thresholds of the practice recommendations
+const (
+	weakTopicPercent  = 70 // topics answered less accurately than this are suggested for practice
+	weakTopicMinCount = 3  // answers needed before a topic's score is trusted
+	maxRecommended    = 5
+)
+
+// TopicScore is how accurately a user answered the questions of one topic
+type TopicScore struct {
+	Topic    string  `json:"topic"`
+	Answered int     `json:"answered"`
+	Correct  int     `json:"correct"`
+	Percent  float64 `json:"percent"`
+}
+
+// Recommendation suggests a topic or an exam to practice next
+type Recommendation struct {
+	Kind    string   `json:"kind"` // "topic" or "exam"
+	Topic   string   `json:"topic,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	Exam    string   `json:"exam,omitempty"`
+	Exams   []string `json:"exams,omitempty"` // exams with the most questions on a topic, as subject/exam
+	Percent *float64 `json:"percent,omitempty"`
+	Reason  string   `json:"reason"`
+}
+
+// questionTopics returns the topics a question counts towards: its tags, or the subject of its exam if it has none
+func questionTopics(q map[string]any, subject string) []string {
+	if tags := exam.QuestionTags(q); len(tags) > 0 {
+		return tags
+	}
+	return []string{subject}
+}
+
+// recommend scores a user's topics from their attempts and suggests what to practice: the weakest topics,
+// exams whose latest attempt failed, and exams not yet taken in the subjects the user practices
+func recommend(subjects []exam.Subject, list []Attempt) ([]TopicScore, []Recommendation) {
+	exams := map[ExamRef]exam.ExamFile{}
+	topicExams := map[string]map[string]int{}
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		exams[ExamRef{subject, e.Name}] = e
+		for _, item := range exam.Questions(e.Content) {
+			if q, ok := item.(map[string]any); ok {
+				for _, t := range questionTopics(q, subject) {
+					if topicExams[t] == nil {
+						topicExams[t] = map[string]int{}
+					}
+					topicExams[t][subject+"/"+e.Name]++
+				}
+			}
+		}
+	})
+
+	scores := map[string]*TopicScore{}
+	latest := map[ExamRef]Attempt{}
+	practiced := map[string]bool{}
+	for _, a := range list {
+		ref := ExamRef{a.Subject, a.Exam}
+		e, ok := exams[ref]
+		if !ok {
+			continue
+		}
+		practiced[a.Subject] = true
+		if l, ok := latest[ref]; !ok || a.SubmittedAt.After(l.SubmittedAt) {
+			latest[ref] = a
+		}
+		questions := exam.Questions(e.Content)
+		for i, answer := range a.Answers {
+			if answer == nil || i >= len(questions) {
+				continue
+			}
+			q, ok := questions[i].(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, t := range questionTopics(q, a.Subject) {
+				s := scores[t]
+				if s == nil {
+					s = &TopicScore{Topic: t}
+					scores[t] = s
+				}
+				s.Answered++
+				if i < len(a.Correct) && a.Correct[i] {
+					s.Correct++
+				}
+			}
+		}
+	}
+
+	topics := make([]TopicScore, 0, len(scores))
+	for _, s := range scores {
+		s.Percent = float64(s.Correct) * 100 / float64(s.Answered)
+		topics = append(topics, *s)
+	}
+	slices.SortFunc(topics, func(a, b TopicScore) int {
+		return cmp.Or(cmp.Compare(a.Percent, b.Percent), cmp.Compare(b.Answered, a.Answered), cmp.Compare(a.Topic, b.Topic))
+	})
+
+	recs := []Recommendation{}
+	for _, t := range topics {
+		if len(recs) == maxRecommended {
+			break
+		}
+		if t.Answered < weakTopicMinCount || t.Percent >= weakTopicPercent {
+			continue
+		}
+		ranked := make([]string, 0, len(topicExams[t.Topic]))
+		for ref := range topicExams[t.Topic] {
+			ranked = append(ranked, ref)
+		}
+		slices.SortFunc(ranked, func(a, b string) int {
+			return cmp.Or(cmp.Compare(topicExams[t.Topic][b], topicExams[t.Topic][a]), cmp.Compare(a, b))
+		})
+		recs = append(recs, Recommendation{
+			Kind:    "topic",
+			Topic:   t.Topic,
+			Exams:   ranked[:min(3, len(ranked))],
+			Percent: &t.Percent,
+			Reason:  "Only " + strconv.Itoa(t.Correct) + " of " + strconv.Itoa(t.Answered) + " answers on this topic were correct",
+		})
+	}
+
+	var retake []Attempt
+	for _, a := range latest {
+		if a.Passed != nil && !*a.Passed || a.Passed == nil && a.Percent < weakTopicPercent {
+			retake = append(retake, a)
+		}
+	}
+	slices.SortFunc(retake, func(a, b Attempt) int { return cmp.Compare(a.Percent, b.Percent) })
+	for _, a := range retake[:min(len(retake), maxRecommended)] {
+		reason := "Your latest attempt did not pass"
+		if a.Passed == nil {
+			reason = "Your latest attempt scored below " + strconv.Itoa(weakTopicPercent) + "%"
+		}
+		recs = append(recs, Recommendation{Kind: "exam", Subject: a.Subject, Exam: a.Exam, Percent: &a.Percent, Reason: reason})
+	}
+
+	var untaken []ExamRef
+	for ref := range exams {
+		if _, taken := latest[ref]; !taken && practiced[ref.Subject] {
+			untaken = append(untaken, ref)
+		}
+	}
+	slices.SortFunc(untaken, func(a, b ExamRef) int { return cmp.Or(cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Name, b.Name)) })
+	for _, ref := range untaken[:min(len(untaken), maxRecommended)] {
+		recs = append(recs, Recommendation{Kind: "exam", Subject: ref.Subject, Exam: ref.Name, Reason: "You have not taken this exam yet"})
+	}
+	return topics, recs
+}
+
+// serveRecommendations returns a handler that suggests what a user (?user=) should practice next,
+// together with their score per topic
+func serveRecommendations(store *examStore, attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		if user == "" {
+			http.Error(w, "Missing user", http.StatusBadRequest)
+			return
+		}
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		topics, recs := recommend(subjects, attempts.List(func(a Attempt) bool { return a.UserID == user }))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"topics": topics, "recommendations": recs})
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// answerKeyFields are the question fields that give the answer away
+var answerKeyFields = []string{"answer", "correct", "explanation", "explanationHtml", exam.SealedKeyField}
+
+// redactAnswers returns a copy of exam content without the answer key
+func redactAnswers(content any) any {
+	return mapQuestions(content, func(q map[string]any) {
+		for _, field := range answerKeyFields {
+			delete(q, field)
+		}
+	})
+}
+
+// openAnswerKeys returns a copy of exam content with the sealed answer keys of its questions opened
+func openAnswerKeys(content any) (any, error) {
+	items := exam.Questions(content)
+	if items == nil {
+		return content, nil
+	}
+	out := make([]any, len(items))
+	for i, item := range items {
+		out[i] = item
+		if q, ok := item.(map[string]any); ok {
+			open, err := exam.OpenAnswerKey(q)
+			if err != nil {
+				return nil, fmt.Errorf("question %d: %w", i+1, err)
+			}
+			out[i] = open
+		}
+	}
+	return out, nil
+}
+
+// publicContent returns the question list of an exam the way students receive it:
+// templates filled in with the shared paper, question IDs set and, if the store redacts, without the answer key
+func (s *examStore) publicContent(subject string, e exam.ExamFile) any {
+	questions := exam.Questions(e.Content)
+	if questions == nil {
+		return e.Content
+	}
+	content := any(exam.WithQuestionIDs(exam.InstantiateQuestions(questions, exam.VariantSeed("", subject, e.Name)), subject, e.Name))
+	if s.redact {
+		content = redactAnswers(content)
+	}
+	return content
+}
+
+// AnswerCheck is the request to grade a single answer for immediate feedback
+type AnswerCheck struct {
+	Subject  string      `json:"subject"`
+	Exam     string      `json:"exam"`
+	Question int         `json:"question"` // index in the exam, or on the paper for variants
+	Answer   exam.Answer `json:"answer"`   // choice index as served, or the answer to a custom question type
+
+	UserID     string          `json:"userId"`
+	AccessCode string          `json:"accessCode"` // of the current sitting, for exams with an access code
+	Variant    *VariantOptions `json:"variant"`
+}
+
+// AnswerFeedback tells whether a single answer is right, revealing the key of that question only
+type AnswerFeedback struct {
+	Correct       bool   `json:"correct"`
+	CorrectChoice *int   `json:"correctChoice,omitempty"`
+	Explanation   string `json:"explanation,omitempty"`
+}
+
+// checkAnswer returns a handler that grades one answer, so clients can give feedback without holding the answer key.
+// Answers are only checked for the exams the user could start or resume a session of, so the key is no easier to
+// come by.
+func checkAnswer(store *examStore, sessions *sessionStore, codes *accessCodeStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AnswerCheck
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e, ok := exam.FindExam(subjects, req.Subject, req.Exam)
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		if status, msg := takeError(r, access, codes, req.UserID, req.Subject, e, req.AccessCode); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		if store.Closed(req.Subject, e.Name) && !sessions.resumable(req.UserID, req.Subject, e) {
+			http.Error(w, "Exam is closed", http.StatusForbidden)
+			return
+		}
+
+		var questions []any
+		if req.Variant != nil {
+			questions = newExamVariant(req.UserID, req.Subject, e, *req.Variant).Questions
+		} else {
+			questions = exam.InstantiateQuestions(exam.Questions(e.Content), exam.VariantSeed("", req.Subject, e.Name))
+		}
+		if req.Question < 0 || req.Question >= len(questions) {
+			http.Error(w, "Question index out of range", http.StatusBadRequest)
+			return
+		}
+		q, _ := questions[req.Question].(map[string]any)
+
+		var feedback AnswerFeedback
+		if feedback.Correct, err = exam.GradeQuestion(q, req.Answer); err != nil {
+			http.Error(w, "Failed to grade answer: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Grading opened the key already
+		q, _ = exam.OpenAnswerKey(q)
+		if key, ok := q["correct"].(float64); ok && exam.QuestionType(q) == exam.DefaultQuestionType {
+			choice := int(key)
+			feedback.CorrectChoice = &choice
+		}
+		feedback.Explanation, _ = q["explanation"].(string)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(feedback)
+	}
+}
+
+// serveAnswerKey returns a handler that returns an exam with its answer key, as authored or, with ?user=, as drawn for that user's paper
+func serveAnswerKey(store *examStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		if e.Content, err = openAnswerKeys(e.Content); err != nil {
+			http.Error(w, "Failed to open answer key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		q := r.URL.Query()
+		if user := q.Get("user"); user != "" {
+			var opts VariantOptions
+			if n := q.Get("questions"); n != "" {
+				count, err := strconv.Atoi(n)
+				if err != nil || count < 0 {
+					http.Error(w, "Invalid questions count", http.StatusBadRequest)
+					return
+				}
+				opts.Questions = count
+			}
+			json.NewEncoder(w).Encode(newExamVariant(user, subject, e, opts))
+			return
+		}
+		json.NewEncoder(w).Encode(e)
+	}
+}
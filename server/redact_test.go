@@ -0,0 +1,38 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+func TestCheckAnswerOnlyForTakeableExams(t *testing.T) {
+	hidden := `{"title": "Finals", "visibility": "instructor", "questions": [{"question": "?", "choices": ["a", "b"], "correct": 0}]}`
+	s := newTestServer(t, Config{RedactAnswers: true}, map[string]string{
+		"Math/algebra.json": testExam,
+		"Math/finals.json":  hidden,
+	})
+	check := func(name, code string) *http.Response {
+		body, _ := json.Marshal(AnswerCheck{Subject: "Math", Exam: name, Question: 0, Answer: exam.Answer("1"), UserID: "ann", AccessCode: code})
+		return serveTest(s, "POST", "/api/v1/answers/check", "", body).Result()
+	}
+
+	if got := check("algebra.json", "").StatusCode; got != http.StatusOK {
+		t.Fatalf("check of a public exam: status %d, want 200", got)
+	}
+	if got := check("finals.json", "").StatusCode; got != http.StatusNotFound {
+		t.Errorf("check of an instructor-only exam: status %d, want 404", got)
+	}
+
+	if _, err := s.codes.Rotate("Math", "algebra.json", "OPEN"); err != nil {
+		t.Fatal(err)
+	}
+	if got := check("algebra.json", "").StatusCode; got != http.StatusForbidden {
+		t.Errorf("check without the access code: status %d, want 403", got)
+	}
+	if got := check("algebra.json", "open").StatusCode; got != http.StatusOK {
+		t.Errorf("check with the access code: status %d, want 200", got)
+	}
+}
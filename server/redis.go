@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTimeout bounds every Redis command, so a Redis that stops answering fails requests instead of hanging them
+const redisTimeout = 2 * time.Second
+
+// redisMaxIdle is the number of connections kept open between commands
+const redisMaxIdle = 8
+
+// errRedisNil is the reply to commands that found no value
+var errRedisNil = errors.New("redis: nil")
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient is a client of the Redis protocol (RESP2) for the state replicas share: cached exam listings,
+// sessions and rate limit buckets. Keys are prefixed so several deployments can share one Redis.
+type redisClient struct {
+	addr     string
+	host     string // server name checked by TLS
+	tls      bool
+	username string
+	password string
+	db       int
+	prefix   string
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// redisConn is one connection to the server
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient creates a client for a redis:// or rediss:// (TLS) URL of the form
+// redis://[[user]:password@]host[:port][/db], checking that the server answers
+func newRedisClient(rawURL, prefix string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis URL %q (expected redis://[:password@]host[:port][/db])", rawURL)
+	}
+	c := &redisClient{addr: u.Host, host: u.Hostname(), tls: u.Scheme == "rediss", prefix: prefix}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	if _, err := c.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", c.addr, err)
+	}
+	return c, nil
+}
+
+// key returns the full name of a key of the shared state
+func (c *redisClient) key(parts ...string) string {
+	return c.prefix + strings.Join(parts, ":")
+}
+
+// dial opens and prepares a new connection
+func (c *redisClient) dial() (*redisConn, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	if c.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, &tls.Config{ServerName: c.host})
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := rc.do(args); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends one command and returns its reply: a string, an int64, a []byte, nil for a nil reply, or a []any
+func (c *redisClient) do(args ...string) (any, error) {
+	c.mu.Lock()
+	var rc *redisConn
+	if n := len(c.idle); n > 0 {
+		rc, c.idle = c.idle[n-1], c.idle[:n-1]
+	}
+	c.mu.Unlock()
+	if rc == nil {
+		var err error
+		if rc, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := rc.do(args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state after a network error
+		rc.conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	if len(c.idle) < redisMaxIdle {
+		c.idle = append(c.idle, rc)
+		rc = nil
+	}
+	c.mu.Unlock()
+	if rc != nil {
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// Get returns the value of a key, or errRedisNil if it has none
+func (c *redisClient) Get(key string) ([]byte, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, errRedisNil
+	}
+	return value, nil
+}
+
+// Set sets the value of a key, expiring after ttl unless ttl is zero
+func (c *redisClient) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	_, err := c.do(args...)
+	return err
+}
+
+// claim sets a key that expires after ttl unless it is set already, reporting whether it was set
+func (c *redisClient) claim(key string, ttl time.Duration) (bool, error) {
+	reply, err := c.do("SET", key, "1", "NX", "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// Del removes keys
+func (c *redisClient) Del(keys ...string) error {
+	_, err := c.do(append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// do writes a command and reads its reply
+func (rc *redisConn) do(args []string) (any, error) {
+	rc.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+// readReply reads one reply of the server
+func (rc *redisConn) readReply() (any, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			// Errors inside an array, such as those of a script, are returned as values
+			if items[i], err = rc.readReply(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Attempt is a graded submission of answers to an exam
+type Attempt struct {
+	ID          string               `json:"id"`
+	UserID      string               `json:"userId,omitempty"`
+	Subject     string               `json:"subject"`
+	Exam        string               `json:"exam"`
+	ExamID      string               `json:"examId,omitempty"`
+	StartedAt   time.Time            `json:"startedAt,omitzero"`
+	SubmittedAt time.Time            `json:"submittedAt"`
+	Answers     []exam.Answer        `json:"answers"` // chosen index in the original choice order, or the answer to a custom question type; null if unanswered
+	Correct     []bool               `json:"correct"`
+	Score       int                  `json:"score"`
+	Total       int                  `json:"total"`
+	Percent     float64              `json:"percent"`
+	Passed      *bool                `json:"passed,omitempty"` // set when the exam has a passing score
+	Points      *float64             `json:"points,omitempty"` // set when the exam has scoring rules
+	MaxPoints   *float64             `json:"maxPoints,omitempty"`
+	Sections    []exam.SectionResult `json:"sections,omitempty"`   // set when the exam has sections
+	Scaled      *float64             `json:"scaled,omitempty"`     // set when the exam has a score scale with a table
+	Percentile  *float64             `json:"percentile,omitempty"` // rank among the earlier attempts, set when the score scale asks for it
+	TimeSpent   []float64            `json:"timeSpent,omitempty"`  // seconds spent on each question, in exam order; set when the client reported them
+	LTILaunch   string               `json:"ltiLaunch,omitempty"`
+	Variant     string               `json:"variant,omitempty"` // seed of the per-user paper, if one was answered
+}
+
+// attemptStore keeps graded attempts in memory and appends each one to a JSON Lines file
+type attemptStore struct {
+	path string
+
+	mu        sync.RWMutex
+	attempts  []Attempt
+	listeners []func(Attempt)
+}
+
+// openAttemptStore loads the attempts recorded in the file at path, creating its directory if needed
+func openAttemptStore(path string) (*attemptStore, error) {
+	s := &attemptStore{path: path}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var a Attempt
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("failed to parse %s line %d: %w", path, line, err)
+		}
+		s.attempts = append(s.attempts, a)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Add records an attempt and appends it to the file
+func (s *attemptStore) Add(a Attempt) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.path, err)
+	}
+	s.attempts = append(s.attempts, a)
+	for _, fn := range s.listeners {
+		fn(a)
+	}
+	return nil
+}
+
+// Remove deletes the attempts for which drop returns true, rewriting the file, and returns how many it deleted
+func (s *attemptStore) Remove(drop func(Attempt) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kept []Attempt
+	for _, a := range s.attempts {
+		if !drop(a) {
+			kept = append(kept, a)
+		}
+	}
+	removed := len(s.attempts) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.replace(kept)
+}
+
+// Anonymize removes the user from their attempts, which still count towards the statistics of their exams, and
+// returns how many it changed
+func (s *attemptStore) Anonymize(user string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := slices.Clone(s.attempts)
+	changed := 0
+	for i := range next {
+		if next[i].UserID == user {
+			next[i].UserID, next[i].LTILaunch = "", ""
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	return changed, s.replace(next)
+}
+
+// replace rewrites the file with the given attempts and keeps them; the caller holds the lock
+func (s *attemptStore) replace(attempts []Attempt) error {
+	var buf bytes.Buffer
+	for _, a := range attempts {
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if err := storage.WriteFileAtomic(s.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.path, err)
+	}
+	s.attempts = attempts
+	return nil
+}
+
+// OnAdd registers fn to be called with every attempt recorded from now on
+func (s *attemptStore) OnAdd(fn func(Attempt)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// List returns the attempts for which keep returns true, oldest first; a nil keep returns all of them
+func (s *attemptStore) List(keep func(Attempt) bool) []Attempt {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []Attempt
+	for _, a := range s.attempts {
+		if keep == nil || keep(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// newID returns a random identifier for stored records
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// gradeAnswers grades the answer to every question with the grader of its type
+func gradeAnswers(questions []any, answers []exam.Answer) (correct []bool, score int, err error) {
+	correct = make([]bool, len(questions))
+	for i, item := range questions {
+		q, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		var answer exam.Answer
+		if i < len(answers) {
+			answer = answers[i]
+		}
+		if correct[i], err = exam.GradeQuestion(q, answer); err != nil {
+			return nil, 0, fmt.Errorf("question %d: %w", i+1, err)
+		}
+		if correct[i] {
+			score++
+		}
+	}
+	return correct, score, nil
+}
+
+// submission is the body of an attempt submission
+type submission struct {
+	Subject   string        `json:"subject"`
+	Exam      string        `json:"exam"`
+	ExamID    string        `json:"examId"` // takes precedence over subject and exam, which it survives renames of
+	UserID    string        `json:"userId"`
+	StartedAt time.Time     `json:"startedAt"`
+	Answers   []exam.Answer `json:"answers"`
+	TimeSpent []float64     `json:"timeSpent"` // seconds spent on each question, in the order of the answers
+	LTILaunch string        `json:"ltiLaunch"`
+
+	// Exams with an access code are only graded with the code of the current sitting
+	AccessCode string `json:"accessCode"`
+
+	// Answers to a per-user paper are given in the order of the paper
+	Variant *VariantOptions `json:"variant"`
+}
+
+// Errors returned by gradeSubmission
+var (
+	errExamNotFound   = errors.New("exam not found")
+	errTooManyAnswers = errors.New("more answers than questions")
+	errInvalidTimes   = errors.New("timeSpent must hold a non-negative number of seconds per question")
+	errGrading        = errors.New("failed to grade answers")
+)
+
+// gradeSubmission grades submitted answers against the key of the exam they were given for
+func gradeSubmission(subjects []exam.Subject, sub submission) (Attempt, error) {
+	e, ok := findSubmittedExam(subjects, &sub)
+	if !ok {
+		return Attempt{}, errExamNotFound
+	}
+
+	questions := exam.Questions(e.Content)
+	if len(sub.Answers) > len(questions) {
+		return Attempt{}, errTooManyAnswers
+	}
+	if len(sub.TimeSpent) > len(questions) || slices.ContainsFunc(sub.TimeSpent, func(t float64) bool { return !(t >= 0) || math.IsInf(t, 0) }) {
+		return Attempt{}, errInvalidTimes
+	}
+	timeSpent := sub.TimeSpent
+	answers := make([]exam.Answer, len(questions))
+	copy(answers, sub.Answers)
+	total := len(questions)
+
+	// Template questions are graded against the values of the paper that was answered
+	var variant *examVariant
+	if sub.Variant != nil {
+		variant = newExamVariant(sub.UserID, sub.Subject, e, *sub.Variant)
+		questions = variant.source
+		answers = variant.originalAnswers(sub.Answers, len(questions))
+		timeSpent = variant.originalTimes(sub.TimeSpent, len(questions))
+		total = len(variant.order)
+	} else {
+		questions = exam.InstantiateQuestions(questions, exam.VariantSeed("", sub.Subject, e.Name))
+	}
+
+	correct, score, err := gradeAnswers(questions, answers)
+	if err != nil {
+		return Attempt{}, fmt.Errorf("%w: %w", errGrading, err)
+	}
+	a := Attempt{
+		ID:          newID(),
+		UserID:      sub.UserID,
+		Subject:     sub.Subject,
+		Exam:        e.Name,
+		ExamID:      e.ID,
+		StartedAt:   sub.StartedAt,
+		SubmittedAt: time.Now().UTC(),
+		Answers:     answers,
+		Correct:     correct,
+		Score:       score,
+		Total:       total,
+		LTILaunch:   sub.LTILaunch,
+	}
+	if slices.ContainsFunc(timeSpent, func(t float64) bool { return t > 0 }) {
+		a.TimeSpent = make([]float64, len(questions))
+		copy(a.TimeSpent, timeSpent)
+	}
+	if variant != nil {
+		a.Variant = variant.Seed
+	}
+	if a.Total > 0 {
+		a.Percent = float64(score) * 100 / float64(a.Total)
+	}
+	if e.Meta != nil && (e.Meta.Scoring != nil || len(e.Meta.Sections) > 0) {
+		// Only the questions on the answered paper count towards the points of a variant
+		scored := questions
+		if variant != nil {
+			scored = make([]any, len(questions))
+			for _, i := range variant.order {
+				scored[i] = questions[i]
+			}
+		}
+		var rules exam.ScoringRules
+		if e.Meta.Scoring != nil {
+			rules = *e.Meta.Scoring
+		}
+		res, err := exam.ApplyScoringRules(rules, e.Meta.Sections, scored, answers, correct)
+		if err != nil {
+			return Attempt{}, fmt.Errorf("%w: %w", errGrading, err)
+		}
+		a.Percent, a.Sections = res.Percent, res.Sections
+		if e.Meta.Scoring != nil {
+			a.Points, a.MaxPoints = &res.Points, &res.MaxPoints
+		}
+	}
+	// An exam with passing scores per section is only passed by reaching all of them as well as its own
+	sectionRules := slices.ContainsFunc(a.Sections, func(s exam.SectionResult) bool { return s.Passed != nil })
+	if e.Meta != nil && (e.Meta.PassingScore != nil || sectionRules) {
+		passed := (e.Meta.PassingScore == nil || a.Percent >= *e.Meta.PassingScore) && exam.SectionsPassed(a.Sections)
+		a.Passed = &passed
+	}
+	if e.Meta != nil && e.Meta.Scale != nil {
+		if scaled, ok := e.Meta.Scale.Scaled(e.Meta.Scale.RawResult(a.Score, a.Points, a.Percent)); ok {
+			a.Scaled = &scaled
+		}
+	}
+	return a, nil
+}
+
+// rankAttempt sets the percentile rank of a graded attempt among the earlier attempts at its exam, if the exam
+// has a score scale asking for it
+func rankAttempt(subjects []exam.Subject, attempts *attemptStore, a *Attempt) {
+	sub := submission{Subject: a.Subject, Exam: a.Exam, ExamID: a.ExamID}
+	e, ok := findSubmittedExam(subjects, &sub)
+	if !ok || e.Meta == nil || e.Meta.Scale == nil || !e.Meta.Scale.Percentile {
+		return
+	}
+	scale := e.Meta.Scale
+	var earlier []float64
+	for _, prev := range attempts.List(func(prev Attempt) bool { return prev.isFor(sub.Subject, e) }) {
+		earlier = append(earlier, scale.RawResult(prev.Score, prev.Points, prev.Percent))
+	}
+	if rank, ok := exam.PercentileRank(scale.RawResult(a.Score, a.Points, a.Percent), earlier); ok {
+		a.Percentile = &rank
+	}
+}
+
+// GradeAttempt grades answers to the exam name of subject in subjects as the server would, without recording the
+// attempt, for taking exams offline against an exam directory
+func GradeAttempt(subjects []exam.Subject, subject, name string, startedAt time.Time, answers []exam.Answer) (Attempt, error) {
+	return gradeSubmission(subjects, submission{Subject: subject, Exam: name, StartedAt: startedAt, Answers: answers})
+}
+
+// findSubmittedExam looks up the exam a submission is for, by its id if it has one, and updates the subject of
+// the submission to the one the exam is in now
+func findSubmittedExam(subjects []exam.Subject, sub *submission) (exam.ExamFile, bool) {
+	if sub.ExamID != "" {
+		if subject, e, ok := exam.FindExamByID(subjects, sub.ExamID); ok {
+			sub.Subject = subject
+			return e, true
+		}
+	}
+	return exam.FindExam(subjects, sub.Subject, sub.Exam)
+}
+
+// isFor reports whether the attempt was made at exam e of subject, under its current name or, if the file was
+// renamed since, by its id
+func (a Attempt) isFor(subject string, e exam.ExamFile) bool {
+	return (a.ExamID != "" && a.ExamID == e.ID) || (a.Subject == subject && a.Exam == e.Name)
+}
+
+// recordSubmission grades a submission, records the attempt and writes it as the response
+func recordSubmission(w http.ResponseWriter, r *http.Request, store *examStore, attempts *attemptStore, sub submission) (Attempt, bool) {
+	subjects, err := store.Subjects(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+		return Attempt{}, false
+	}
+	a, err := gradeSubmission(subjects, sub)
+	switch {
+	case errors.Is(err, errExamNotFound):
+		http.Error(w, "Exam not found", http.StatusNotFound)
+		return Attempt{}, false
+	case errors.Is(err, errGrading):
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return Attempt{}, false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Attempt{}, false
+	}
+	rankAttempt(subjects, attempts, &a)
+	if err := attempts.Add(a); err != nil {
+		http.Error(w, "Failed to record attempt: "+err.Error(), http.StatusInternalServerError)
+		return Attempt{}, false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+	return a, true
+}
+
+// submitAttempt returns a handler that grades submitted answers against the exam key and records the attempt. The
+// exam is resolved once, so the access checks are made on the exam the answers are graded against, the same checks
+// as starting a session of it.
+func submitAttempt(store *examStore, attempts *attemptStore, codes *accessCodeStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sub submission
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&sub); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e, ok := findSubmittedExam(subjects, &sub)
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		if status, msg := takeError(r, access, codes, sub.UserID, sub.Subject, e, sub.AccessCode); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		if store.Closed(sub.Subject, e.Name) {
+			http.Error(w, "Exam is closed", http.StatusForbidden)
+			return
+		}
+		// Grading looks the exam up again, and must find the one checked
+		sub.Exam, sub.ExamID = e.Name, e.ID
+		recordSubmission(w, r, store, attempts, sub)
+	}
+}
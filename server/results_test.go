@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSubmitAttemptChecksTheGradedExam(t *testing.T) {
+	hidden := `{"id": "finals", "title": "Finals", "visibility": "instructor", "questions": [{"question": "?", "choices": ["a", "b"], "correct": 0}]}`
+	s := newTestServer(t, Config{}, map[string]string{
+		"Math/algebra.json": testExam,
+		"Math/finals.json":  hidden,
+	})
+
+	// The id takes precedence over the name, so the name of a public exam does not open a hidden one
+	rec := serveTest(s, "POST", "/api/v1/attempts", "", []byte(`{"subject": "Math", "exam": "algebra.json", "examId": "finals", "userId": "ann", "answers": [0]}`))
+	wantStatus(t, rec, http.StatusNotFound)
+
+	rec = serveTest(s, "POST", "/api/v1/attempts", "", []byte(`{"subject": "Math", "exam": "algebra.json", "userId": "ann", "answers": [1, 0]}`))
+	wantStatus(t, rec, http.StatusCreated)
+}
+
+func TestSubmitAttemptNeedsAccessCode(t *testing.T) {
+	s := newTestServer(t, Config{}, map[string]string{"Math/algebra.json": testExam})
+	if _, err := s.codes.Rotate("Math", "algebra.json", "OPEN"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveTest(s, "POST", "/api/v1/attempts", "", []byte(`{"subject": "Math", "exam": "algebra.json", "userId": "ann", "answers": [1, 0]}`))
+	wantStatus(t, rec, http.StatusForbidden)
+	rec = serveTest(s, "POST", "/api/v1/attempts", "", []byte(`{"subject": "Math", "exam": "algebra.json", "userId": "ann", "accessCode": "open", "answers": [1, 0]}`))
+	wantStatus(t, rec, http.StatusCreated)
+}
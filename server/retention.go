@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// retentionConfig sets how many days records are kept before the retention job purges them; zero keeps them
+// forever. Certificates, badges and leaderboard entries earned by purged results are kept.
+type retentionConfig struct {
+	AnonymousSessionDays int `json:"anonymousSessionDays"` // sessions without a user, in any state, after their last activity
+	SessionDays          int `json:"sessionDays"`          // submitted and expired sessions of users, after their last activity
+	ResultDays           int `json:"resultDays"`           // attempts, after they were submitted
+}
+
+// RetentionReport lists the records a retention run purges, or would purge in a dry run
+type RetentionReport struct {
+	DryRun            bool      `json:"dryRun"`
+	At                time.Time `json:"at"`
+	AnonymousSessions []string  `json:"anonymousSessions"` // ids
+	Sessions          []string  `json:"sessions"`
+	Results           []string  `json:"results"`
+}
+
+// retentionCutoff returns the time before which records kept for days are purged, or the zero time if they are
+// kept forever
+func retentionCutoff(now time.Time, days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -days)
+}
+
+// applyRetention purges the sessions and attempts that are older than cfg keeps them, or only lists them with
+// dryRun set
+func applyRetention(cfg retentionConfig, sessions *sessionStore, attempts *attemptStore, now time.Time, dryRun bool) (RetentionReport, error) {
+	report := RetentionReport{DryRun: dryRun, At: now, AnonymousSessions: []string{}, Sessions: []string{}, Results: []string{}}
+	anonymousBefore := retentionCutoff(now, cfg.AnonymousSessionDays)
+	sessionsBefore := retentionCutoff(now, cfg.SessionDays)
+	resultsBefore := retentionCutoff(now, cfg.ResultDays)
+
+	if !anonymousBefore.IsZero() || !sessionsBefore.IsZero() {
+		all, err := sessions.backend.All()
+		if err != nil {
+			return report, err
+		}
+		for _, session := range all {
+			var list *[]string
+			switch {
+			case session.UserID == "" && !anonymousBefore.IsZero() && sessionIdleSince(session, anonymousBefore):
+				list = &report.AnonymousSessions
+			case session.UserID != "" && session.Status != sessionInProgress && !sessionsBefore.IsZero() && sessionIdleSince(session, sessionsBefore):
+				list = &report.Sessions
+			default:
+				continue
+			}
+			if !dryRun {
+				if err := sessions.backend.Delete(session); err != nil {
+					return report, err
+				}
+			}
+			*list = append(*list, session.ID)
+		}
+	}
+
+	if !resultsBefore.IsZero() {
+		expired := func(a Attempt) bool { return a.SubmittedAt.Before(resultsBefore) }
+		for _, a := range attempts.List(expired) {
+			report.Results = append(report.Results, a.ID)
+		}
+		if !dryRun {
+			if _, err := attempts.Remove(expired); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if !dryRun {
+		log.Printf("Retention purged %d anonymous session(s), %d session(s) and %d result(s)", len(report.AnonymousSessions), len(report.Sessions), len(report.Results))
+	}
+	return report, nil
+}
+
+// retentionReport returns a handler that reports what a retention run would purge now, without purging it
+func retentionReport(live *liveConfig, sessions *sessionStore, attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := applyRetention(live.current().Retention, sessions, attempts, time.Now().UTC(), true)
+		if err != nil {
+			http.Error(w, "Failed to read records: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
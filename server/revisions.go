@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// ExamRevision is one version of an exam file, identified by the hash of its content
+type ExamRevision struct {
+	SHA256        string    `json:"sha256"`
+	SeenAt        time.Time `json:"seenAt"` // when the server first loaded this version
+	QuestionCount int       `json:"questionCount"`
+}
+
+// revisionContent is the content of an exam revision as saved
+type revisionContent struct {
+	Meta    *exam.ExamMeta `json:"meta,omitempty"`
+	Content any            `json:"content"`
+}
+
+// revisionStore remembers every version of the exams the server has loaded, so content updates can be reviewed.
+// The content of each version is saved once under its hash in dir, next to an index of the versions of every exam.
+type revisionStore struct {
+	dir string
+
+	mu        sync.Mutex
+	revisions map[string][]ExamRevision // by <subject>/<exam>, oldest first
+	listeners []func(subject string, e exam.ExamFile, rev ExamRevision)
+}
+
+// openRevisionStore loads the revision index kept in dir
+func openRevisionStore(dir string) (*revisionStore, error) {
+	s := &revisionStore{dir: dir, revisions: map[string][]ExamRevision{}}
+	content, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read exam revisions: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.revisions); err != nil {
+		return nil, fmt.Errorf("failed to parse exam revisions: %w", err)
+	}
+	return s, nil
+}
+
+func (s *revisionStore) indexPath() string {
+	return filepath.Join(s.dir, "index.json")
+}
+
+func (s *revisionStore) contentPath(sha string) string {
+	return filepath.Join(s.dir, sha+".json")
+}
+
+// OnRecord registers fn to be called with every exam version recorded from now on, new exams included
+func (s *revisionStore) OnRecord(fn func(subject string, e exam.ExamFile, rev ExamRevision)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// record saves the exams of subjects whose current version has not been seen before
+func (s *revisionStore) record(subjects []exam.Subject) error {
+	type recorded struct {
+		subject string
+		e       exam.ExamFile
+		rev     ExamRevision
+	}
+	var added []recorded
+	s.mu.Lock()
+	err := s.save(subjects, func(subject string, e exam.ExamFile, rev ExamRevision) {
+		added = append(added, recorded{subject, e, rev})
+	})
+	listeners := slices.Clone(s.listeners)
+	s.mu.Unlock()
+	for _, r := range added {
+		for _, fn := range listeners {
+			fn(r.subject, r.e, r.rev)
+		}
+	}
+	return err
+}
+
+// save saves the new versions of the exams of subjects and adds them to the index, calling added with each.
+// The caller holds the lock.
+func (s *revisionStore) save(subjects []exam.Subject, added func(subject string, e exam.ExamFile, rev ExamRevision)) error {
+	now := time.Now().UTC()
+	changed := false
+	var werr error
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		key := subject + "/" + e.Name
+		revs := s.revisions[key]
+		if werr != nil || (len(revs) > 0 && revs[len(revs)-1].SHA256 == e.SHA256) {
+			return
+		}
+		// Identical files share one saved copy
+		if _, err := os.Stat(s.contentPath(e.SHA256)); errors.Is(err, os.ErrNotExist) {
+			data, err := json.Marshal(revisionContent{Meta: e.Meta, Content: e.Content})
+			if err != nil {
+				werr = err
+				return
+			}
+			if err := os.MkdirAll(s.dir, 0o755); err != nil {
+				werr = err
+				return
+			}
+			if werr = storage.WriteFileAtomic(s.contentPath(e.SHA256), data); werr != nil {
+				return
+			}
+		}
+		rev := ExamRevision{SHA256: e.SHA256, SeenAt: now, QuestionCount: e.QuestionCount}
+		s.revisions[key] = append(revs, rev)
+		added(subject, e, rev)
+		changed = true
+	})
+	if !changed {
+		return werr
+	}
+
+	data, err := json.MarshalIndent(s.revisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(s.indexPath(), data); err != nil {
+		return err
+	}
+	return werr
+}
+
+// subjectsLoaded records the exams of subjects, logging failures, for use as an exam store load hook
+func (s *revisionStore) subjectsLoaded(subjects []exam.Subject) {
+	if err := s.record(subjects); err != nil {
+		log.Printf("Failed to record exam revisions: %v", err)
+	}
+}
+
+// List returns the versions of an exam, oldest first
+func (s *revisionStore) List(subject, name string) []ExamRevision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ExamRevision(nil), s.revisions[subject+"/"+name]...)
+}
+
+// Find resolves a version of an exam from its hash or a prefix of at least 7 characters of it
+func (s *revisionStore) Find(subject, name, sha string) (ExamRevision, bool) {
+	if len(sha) < 7 {
+		return ExamRevision{}, false
+	}
+	var found ExamRevision
+	for _, rev := range s.List(subject, name) {
+		if !strings.HasPrefix(rev.SHA256, sha) {
+			continue
+		}
+		// The same content may come back after a revert, which is still one version, but a prefix shared
+		// by two versions is ambiguous
+		if found.SHA256 != "" && found.SHA256 != rev.SHA256 {
+			return ExamRevision{}, false
+		}
+		found = rev
+	}
+	return found, found.SHA256 != ""
+}
+
+// Content reads the saved content of a version
+func (s *revisionStore) Content(sha string) (revisionContent, error) {
+	var rc revisionContent
+	data, err := os.ReadFile(s.contentPath(sha))
+	if err != nil {
+		return rc, fmt.Errorf("failed to read exam revision: %w", err)
+	}
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return rc, fmt.Errorf("failed to parse exam revision: %w", err)
+	}
+	return rc, nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Roles granted by bearer tokens
+const (
+	roleAdmin      = "admin"
+	roleInstructor = "instructor"
+	roleAuthor     = "author"
+)
+
+// subjectScope lists the subjects, with their subdirectories, a token is limited to; nil allows every subject
+type subjectScope []string
+
+// allows reports whether subject is one of the scope or a subdirectory of one
+func (s subjectScope) allows(subject string) bool {
+	if s == nil {
+		return true
+	}
+	for _, prefix := range s {
+		if subject == prefix || strings.HasPrefix(subject, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenGrant is the role a bearer token grants and the subjects it is limited to
+type tokenGrant struct {
+	role     string
+	subjects subjectScope
+}
+
+// tokenRoles maps bearer tokens to what they grant
+type tokenRoles map[string]tokenGrant
+
+// newTokenRoles combines the admin token, the instructor tokens and the author tokens, ignoring empty ones. An
+// instructor or author token followed by =<subject>[:<subject>...] is limited to those subjects and their
+// subdirectories.
+func newTokenRoles(adminToken string, instructorTokens, authorTokens []string) tokenRoles {
+	tokens := tokenRoles{}
+	tokens.add(roleInstructor, instructorTokens)
+	tokens.add(roleAuthor, authorTokens)
+	if adminToken != "" {
+		tokens[adminToken] = tokenGrant{role: roleAdmin}
+	}
+	return tokens
+}
+
+// add grants role to each of tokens, limited to the subjects following it if any
+func (t tokenRoles) add(role string, tokens []string) {
+	for _, token := range tokens {
+		token, subjects, scoped := strings.Cut(strings.TrimSpace(token), "=")
+		if token == "" {
+			continue
+		}
+		grant := tokenGrant{role: role}
+		if scoped {
+			grant.subjects = subjectScope{}
+			for _, s := range strings.Split(subjects, ":") {
+				if s = strings.Trim(path.Clean("/"+strings.TrimSpace(s)), "/"); s != "" {
+					grant.subjects = append(grant.subjects, s)
+				}
+			}
+		}
+		t[token] = grant
+	}
+}
+
+// grantOf returns what the bearer token of a request grants, the zero grant if it grants nothing.
+// Every token is compared in constant time so the response time does not reveal partial matches.
+func (t tokenRoles) grantOf(r *http.Request) tokenGrant {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return tokenGrant{}
+	}
+	var grant tokenGrant
+	for token, granted := range t {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			grant = granted
+		}
+	}
+	return grant
+}
+
+// roleOf returns the role granted by the bearer token of a request, or "" if it has none
+func (t tokenRoles) roleOf(r *http.Request) string {
+	return t.grantOf(r).role
+}
+
+// scopeKey is the context key of the subject scope of a request let through by requireGrant
+type scopeKey struct{}
+
+// scopeOf returns the subjects the token of a request let through by requireGrant is limited to, nil for every
+// subject
+func scopeOf(r *http.Request) subjectScope {
+	scope, _ := r.Context().Value(scopeKey{}).(subjectScope)
+	return scope
+}
+
+// requireRole returns middleware that only lets through requests whose bearer token grants one of roles
+func requireRole(tokens tokenRoles, roles []string) middleware {
+	return requireGrant(tokens, func(g tokenGrant) bool { return slices.Contains(roles, g.role) })
+}
+
+// requireAuthor returns middleware that only lets through the requests of admins and authors, authors limited to
+// some subjects only for those
+func requireAuthor(tokens tokenRoles) middleware {
+	return requireRole(tokens, []string{roleAuthor, roleAdmin})
+}
+
+// requireGrant returns middleware that only lets through requests whose bearer token grants what allow accepts,
+// for the subject of the route if it has one. Handlers find the subjects the token is limited to with scopeOf.
+func requireGrant(tokens tokenRoles, allow func(tokenGrant) bool) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grant := tokens.grantOf(r)
+			if grant.role == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mock-exam"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if subject := r.PathValue("subject"); !allow(grant) || (subject != "" && !grant.subjects.allows(subject)) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if grant.subjects != nil {
+				r = r.WithContext(context.WithValue(r.Context(), scopeKey{}, grant.subjects))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestNewTokenRolesScopes(t *testing.T) {
+	tokens := newTokenRoles("admin", []string{" all ", "math=Math", "sci=/Science/Physics/ : Chemistry", ""}, []string{"editor=Math"})
+
+	if g := tokens["admin"]; g.role != roleAdmin || g.subjects != nil {
+		t.Errorf("admin grant = %+v, want admin of every subject", g)
+	}
+	if g := tokens["all"]; g.role != roleInstructor || g.subjects != nil {
+		t.Errorf("unscoped instructor grant = %+v, want instructor of every subject", g)
+	}
+	if g := tokens["sci"]; g.role != roleInstructor || !slices.Equal(g.subjects, subjectScope{"Science/Physics", "Chemistry"}) {
+		t.Errorf("scoped grant = %+v, want instructor of Science/Physics and Chemistry", g)
+	}
+	if g := tokens["editor"]; g.role != roleAuthor || !slices.Equal(g.subjects, subjectScope{"Math"}) {
+		t.Errorf("author grant = %+v, want author of Math", g)
+	}
+	if _, ok := tokens[""]; ok {
+		t.Error("empty token grants a role")
+	}
+}
+
+func TestSubjectScopeAllows(t *testing.T) {
+	scope := subjectScope{"Math", "Science/Physics"}
+	for subject, want := range map[string]bool{
+		"Math":              true,
+		"Math/Algebra":      true,
+		"Mathematics":       false,
+		"Science":           false,
+		"Science/Physics":   true,
+		"Science/Physics/1": true,
+		"Science/Biology":   false,
+	} {
+		if got := scope.allows(subject); got != want {
+			t.Errorf("allows(%q) = %v, want %v", subject, got, want)
+		}
+	}
+	if !subjectScope(nil).allows("Anything") {
+		t.Error("nil scope does not allow every subject")
+	}
+	if (subjectScope{}).allows("Math") {
+		t.Error("empty scope allows a subject")
+	}
+}
+
+func TestScopedAuthoring(t *testing.T) {
+	s := newTestServer(t, Config{AdminToken: "admin", InstructorTokens: []string{"math=Math", "all"}, AuthorTokens: []string{"editor=Math", "writer"}}, map[string]string{
+		"Math/algebra.json":      testExam,
+		"Math/Algebra/long.json": testExam,
+		"History/wars.json":      testExam,
+	})
+	for _, tc := range []struct {
+		token, path string
+		want        int
+	}{
+		{"editor", "/api/v1/admin/exams/Math/algebra.json/source", http.StatusOK},
+		{"editor", "/api/v1/admin/exams/History/wars.json/source", http.StatusForbidden},
+		{"writer", "/api/v1/admin/exams/History/wars.json/source", http.StatusOK},
+		{"admin", "/api/v1/admin/exams/History/wars.json/source", http.StatusOK},
+		// A scope only narrows what a token grants, and instructor tokens grant no authoring route
+		{"math", "/api/v1/admin/exams/Math/algebra.json/source", http.StatusForbidden},
+		{"all", "/api/v1/admin/exams/Math/algebra.json/source", http.StatusForbidden},
+		{"editor", "/api/v1/admin/config", http.StatusUnauthorized},
+		{"", "/api/v1/admin/exams/Math/algebra.json/source", http.StatusUnauthorized},
+		{"wrong", "/api/v1/admin/exams/Math/algebra.json/source", http.StatusUnauthorized},
+	} {
+		if rec := serveTest(s, "GET", tc.path, tc.token, nil); rec.Code != tc.want {
+			t.Errorf("GET %s as %q: status %d, want %d", tc.path, tc.token, rec.Code, tc.want)
+		}
+	}
+
+	rec := serveTest(s, "PUT", "/api/v1/admin/exams/History/wars.json", "editor", []byte(testExam), "If-Match", "*")
+	wantStatus(t, rec, http.StatusForbidden)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// middleware wraps a handler with behaviour shared by a group of routes
+type middleware func(http.Handler) http.Handler
+
+// router registers routes on a ServeMux under the path prefix of its group, wrapped in the group's middleware
+type router struct {
+	mux    *http.ServeMux
+	serve  http.Handler // the mux wrapped in the middleware that runs before routing
+	prefix string
+	stack  []middleware
+}
+
+// newRouter creates a router whose requests all go through mw, outermost first. This middleware runs before
+// routing, so it also sees requests that match no route, such as CORS preflights.
+func newRouter(mw ...middleware) *router {
+	mux := http.NewServeMux()
+	return &router{mux: mux, serve: wrap(mux, mw)}
+}
+
+// wrap returns h wrapped in mw, the first middleware outermost
+func wrap(h http.Handler, mw []middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Group returns a router for the routes under prefix, which run through the middleware of r and then mw.
+// The middleware of a group applies to its routes only, so routes outside the group are not affected.
+func (r *router) Group(prefix string, mw ...middleware) *router {
+	return &router{mux: r.mux, serve: r.serve, prefix: r.prefix + prefix, stack: append(slices.Clip(r.stack), mw...)}
+}
+
+// With returns a router for the same prefix with mw added, for routes that need extra middleware
+func (r *router) With(mw ...middleware) *router {
+	return r.Group("", mw...)
+}
+
+// Handle registers h for a ServeMux pattern, "[METHOD ]path", whose path is relative to the group prefix
+func (r *router) Handle(pattern string, h http.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	h = wrap(h, r.stack)
+	pattern = r.prefix + path
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	r.mux.Handle(pattern, h)
+}
+
+// HandleFunc registers h for a pattern like Handle
+func (r *router) HandleFunc(pattern string, h http.HandlerFunc) {
+	r.Handle(pattern, h)
+}
+
+// routePattern returns the path pattern of the route a request was dispatched to, without its method
+func routePattern(r *http.Request) string {
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
+
+// ServeHTTP dispatches a request to the route matching it
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.serve.ServeHTTP(w, req)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// SanitizeModes lists the accepted values of the -sanitize flag
+var SanitizeModes = []string{"ugc", "strict", "off"}
+
+// newSanitizer returns the HTML policy for a sanitize mode, or nil when sanitization is off
+func newSanitizer(mode string) (*bluemonday.Policy, error) {
+	switch mode {
+	case "ugc":
+		// Keep harmless formatting such as <b>, <br>, and <code> but drop scripts, handlers, and unsafe URLs
+		return bluemonday.UGCPolicy(), nil
+	case "strict":
+		// Remove every tag and keep only the text
+		return bluemonday.StrictPolicy(), nil
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown sanitize mode %q (expected one of %s)", mode, strings.Join(SanitizeModes, ", "))
+	}
+}
+
+// sanitizeSubjects returns a copy of subjects with every string in the exam content run through policy
+func sanitizeSubjects(subjects []exam.Subject, policy *bluemonday.Policy) []exam.Subject {
+	if policy == nil {
+		return subjects
+	}
+	return mapExamContent(subjects, func(content any) any {
+		return sanitizeValue(content, policy)
+	})
+}
+
+// sanitizeValue returns a copy of a parsed JSON value with every string that may contain markup sanitized
+func sanitizeValue(v any, policy *bluemonday.Policy) any {
+	switch v := v.(type) {
+	case string:
+		// Strings without a tag opener cannot carry markup, so leave them byte-for-byte intact
+		if !strings.Contains(v, "<") {
+			return v
+		}
+		return policy.Sanitize(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = sanitizeValue(item, policy)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = sanitizeValue(item, policy)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobMetrics publishes the runs of the background jobs under "jobs" in the expvar variables at /debug/vars
+var jobMetrics = expvar.NewMap("jobs")
+
+// cronSchedule is a parsed job schedule: either a fixed interval or the five fields of a cron expression
+type cronSchedule struct {
+	every  time.Duration
+	fields [5]uint64 // bit sets of the minutes, hours, days of the month, months and days of the week that match
+	anyDay [2]bool   // whether the day of the month and the day of the week are unrestricted
+}
+
+// cronFieldRanges are the values of minute, hour, day of the month, month and day of the week
+var cronFieldRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// cronMacros are the named schedules accepted in place of a cron expression
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule parses a cron expression of minute, hour, day of the month, month and day of the week, in
+// local time, with *, lists, ranges and steps; one of the cronMacros; or "@every <duration>"
+func parseSchedule(spec string) (cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || every < time.Minute {
+			return cronSchedule{}, fmt.Errorf("invalid schedule %q (expected @every with a duration of at least 1m)", spec)
+		}
+		return cronSchedule{every: every}, nil
+	}
+	if expr, ok := cronMacros[spec]; ok {
+		spec = expr
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return cronSchedule{}, fmt.Errorf("invalid schedule %q (expected minute hour day-of-month month day-of-week)", spec)
+	}
+	var s cronSchedule
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronFieldRanges[i][0], cronFieldRanges[i][1], i == 4)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		s.fields[i] = bits
+	}
+	s.anyDay = [2]bool{parts[2] == "*", parts[4] == "*"}
+	return s, nil
+}
+
+// parseCronField parses one comma-separated field of a cron expression into the set of values from lo to hi it
+// matches. Days of the week also accept 7 for Sunday.
+func parseCronField(field string, lo, hi int, weekday bool) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, stepped := strings.Cut(item, "/")
+		step := 1
+		if stepped {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			} else if stepped {
+				to = hi
+			}
+		}
+		top := hi
+		if weekday {
+			top = 7
+		}
+		if from < lo || to > top || from > to {
+			return 0, fmt.Errorf("value %q out of range %d-%d", rangePart, lo, top)
+		}
+		for v := from; v <= to; v += step {
+			bit := v
+			if weekday {
+				bit %= 7
+			}
+			bits |= 1 << bit
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether field i of the schedule includes v
+func (s cronSchedule) matches(i, v int) bool {
+	return s.fields[i]&(1<<v) != 0
+}
+
+// matchesDay reports whether the schedule includes the day of t. As in cron, a day matches either restricted
+// field when both the day of the month and the day of the week are restricted.
+func (s cronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := s.matches(2, t.Day()), s.matches(4, int(t.Weekday()))
+	switch {
+	case s.anyDay[0] && s.anyDay[1]:
+		return true
+	case s.anyDay[0]:
+		return dow
+	case s.anyDay[1]:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the schedule is due, or the zero time if it never is
+func (s cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case !s.matches(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.matches(1, t.Hour()):
+			// Truncate counts from the zero time in UTC, which is off by the half hour of some zones
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.matches(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// job is a unit of periodic work run by the scheduler
+type job struct {
+	name     string
+	schedule func(jobsConfig) string // selects the schedule of the job from the configuration
+	run      func(ctx context.Context, cfg jobsConfig) error
+
+	// Published in jobMetrics
+	runs, failures expvar.Int
+	lastRun        expvar.String
+	lastDuration   expvar.Float // seconds
+	lastError      expvar.String
+	nextRun        expvar.String
+	running        expvar.Int
+
+	// Used by the scheduler loop only
+	spec string
+	due  time.Time
+}
+
+// JobStatus describes a background job in the admin API
+type JobStatus struct {
+	Name            string  `json:"name"`
+	Schedule        string  `json:"schedule,omitempty"`
+	NextRun         string  `json:"nextRun,omitempty"`
+	LastRun         string  `json:"lastRun,omitempty"`
+	LastDurationSec float64 `json:"lastDurationSeconds"`
+	LastError       string  `json:"lastError,omitempty"`
+	Runs            int64   `json:"runs"`
+	Failures        int64   `json:"failures"`
+	Running         bool    `json:"running"`
+}
+
+// scheduler runs the background jobs on the schedules of the configuration file, which are picked up within a
+// minute of a reload. Replicas sharing a Redis agree on which of them runs each scheduled run.
+type scheduler struct {
+	live   *liveConfig
+	shared *redisClient
+	jobs   []*job
+
+	mu sync.Mutex // serializes the start of runs
+}
+
+// newScheduler creates a scheduler for jobs and publishes their metrics
+func newScheduler(live *liveConfig, shared *redisClient, jobs ...*job) *scheduler {
+	for _, j := range jobs {
+		m := new(expvar.Map).Init()
+		m.Set("runs", &j.runs)
+		m.Set("failures", &j.failures)
+		m.Set("lastRun", &j.lastRun)
+		m.Set("lastDurationSeconds", &j.lastDuration)
+		m.Set("lastError", &j.lastError)
+		m.Set("nextRun", &j.nextRun)
+		m.Set("running", &j.running)
+		jobMetrics.Set(j.name, m)
+	}
+	return &scheduler{live: live, shared: shared, jobs: jobs}
+}
+
+// run starts the jobs when they are due until ctx is done
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		now := time.Now()
+		cfg := s.live.current().Jobs
+		wake := now.Add(time.Minute)
+		for _, j := range s.jobs {
+			// A changed schedule counts from now, so a new schedule never starts a run at once
+			if spec := j.schedule(cfg); spec != j.spec {
+				j.spec, j.due = spec, time.Time{}
+				if sched, err := parseSchedule(spec); spec != "" && err == nil {
+					j.due = sched.next(now)
+				}
+				j.nextRun.Set(formatJobTime(j.due))
+			}
+			if j.due.IsZero() {
+				continue
+			}
+			if !j.due.After(now) {
+				s.start(ctx, j, cfg, j.due)
+				sched, _ := parseSchedule(j.spec)
+				j.due = sched.next(now)
+				j.nextRun.Set(formatJobTime(j.due))
+			}
+			if j.due.Before(wake) {
+				wake = j.due
+			}
+		}
+		select {
+		case <-time.After(time.Until(wake)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// start runs a job in the background unless it is still running. With a shared Redis, a scheduled run is
+// claimed first so only one replica runs it; slot is the time it was due, the zero time for runs on request.
+func (s *scheduler) start(ctx context.Context, j *job, cfg jobsConfig, slot time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j.running.Value() != 0 {
+		log.Printf("Skipped job %s: still running", j.name)
+		return false
+	}
+	if s.shared != nil && !slot.IsZero() {
+		claimed, err := s.shared.claim(s.shared.key("job", j.name, strconv.FormatInt(slot.Unix(), 10)), 24*time.Hour)
+		if err != nil {
+			log.Printf("Failed to claim job %s, running it here: %v", j.name, err)
+		} else if !claimed {
+			return false
+		}
+	}
+	j.running.Set(1)
+	go func() {
+		started := time.Now()
+		err := j.run(ctx, cfg)
+		j.runs.Add(1)
+		j.lastRun.Set(formatJobTime(started))
+		j.lastDuration.Set(time.Since(started).Seconds())
+		j.lastError.Set("")
+		if err != nil {
+			j.failures.Add(1)
+			j.lastError.Set(err.Error())
+			log.Printf("Job %s failed: %v", j.name, err)
+		}
+		j.running.Set(0)
+	}()
+	return true
+}
+
+// formatJobTime formats a time of a job, or returns "" for the zero time
+func formatJobTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// status returns the state of every job
+func (s *scheduler) status() []JobStatus {
+	cfg := s.live.current().Jobs
+	out := make([]JobStatus, len(s.jobs))
+	for i, j := range s.jobs {
+		out[i] = JobStatus{
+			Name:            j.name,
+			Schedule:        j.schedule(cfg),
+			NextRun:         j.nextRun.Value(),
+			LastRun:         j.lastRun.Value(),
+			LastDurationSec: j.lastDuration.Value(),
+			LastError:       j.lastError.Value(),
+			Runs:            j.runs.Value(),
+			Failures:        j.failures.Value(),
+			Running:         j.running.Value() != 0,
+		}
+	}
+	return out
+}
+
+// listJobs returns a handler that lists the background jobs with their schedules and last runs
+func listJobs(s *scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.status())
+	}
+}
+
+// runJob returns a handler that starts a background job at once, whether or not it is scheduled
+func runJob(s *scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, j := range s.jobs {
+			if j.name != r.PathValue("name") {
+				continue
+			}
+			if !s.start(context.WithoutCancel(r.Context()), j, s.live.current().Jobs, time.Time{}) {
+				http.Error(w, "Job is already running", http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		http.Error(w, "Job not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNextInHalfHourZone(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*60*60+30*60)
+	s, err := parseSchedule("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, from := range []time.Time{
+		time.Date(2026, 10, 14, 1, 10, 0, 0, kolkata),
+		time.Date(2026, 10, 13, 3, 0, 0, 0, kolkata),
+	} {
+		want := time.Date(2026, 10, 14, 3, 0, 0, 0, kolkata)
+		if got := s.next(from); !got.Equal(want) {
+			t.Errorf("next(%v) = %v, want %v", from, got, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// Thresholds of the strengths and weaknesses of a score report
+const (
+	strongTopicPercent = 80 // sections and tags scored at least this well are strengths
+	reportMinQuestions = 2  // questions a section or tag needs before it is called a strength or weakness
+)
+
+// TagResult is the score of an attempt on the questions with one tag
+type TagResult struct {
+	Tag     string  `json:"tag"`
+	Correct int     `json:"correct"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+// ScoreReport is the score report of a submitted session, worded after the exam it mocks when the exam declares
+// a report format
+type ScoreReport struct {
+	Title       string               `json:"title"`
+	SessionID   string               `json:"sessionId"`
+	AttemptID   string               `json:"attemptId"`
+	UserID      string               `json:"userId,omitempty"`
+	Subject     string               `json:"subject"`
+	Exam        string               `json:"exam"`
+	SubmittedAt time.Time            `json:"submittedAt"`
+	Score       int                  `json:"score"`
+	Total       int                  `json:"total"`
+	Percent     float64              `json:"percent"`
+	Points      *float64             `json:"points,omitempty"`
+	MaxPoints   *float64             `json:"maxPoints,omitempty"`
+	Passed      *bool                `json:"passed,omitempty"`
+	ScoreLabel  string               `json:"scoreLabel,omitempty"` // name of the scaled score
+	Scaled      *float64             `json:"scaled,omitempty"`
+	Percentile  *float64             `json:"percentile,omitempty"`
+	Sections    []exam.SectionResult `json:"sections"`
+	Tags        []TagResult          `json:"tags"`
+	Strengths   []string             `json:"strengths"`  // sections and tags, best first
+	Weaknesses  []string             `json:"weaknesses"` // sections and tags, worst first
+	Footer      string               `json:"footer,omitempty"`
+}
+
+// scoreReport builds the score report of a session from its attempt at exam e, which is nil if the exam is gone
+func scoreReport(session *Session, a Attempt, e *exam.ExamFile) ScoreReport {
+	report := ScoreReport{
+		Title:       a.Exam,
+		SessionID:   session.ID,
+		AttemptID:   a.ID,
+		UserID:      a.UserID,
+		Subject:     a.Subject,
+		Exam:        a.Exam,
+		SubmittedAt: a.SubmittedAt,
+		Score:       a.Score,
+		Total:       a.Total,
+		Percent:     a.Percent,
+		Points:      a.Points,
+		MaxPoints:   a.MaxPoints,
+		Passed:      a.Passed,
+		Scaled:      a.Scaled,
+		Percentile:  a.Percentile,
+		Sections:    a.Sections,
+		Tags:        []TagResult{},
+		Strengths:   []string{},
+		Weaknesses:  []string{},
+	}
+	if report.Sections == nil {
+		report.Sections = []exam.SectionResult{}
+	}
+	if report.Scaled != nil {
+		report.ScoreLabel = "Scaled score"
+	}
+	if e == nil {
+		return report
+	}
+	if e.Meta != nil {
+		report.Title = cmp.Or(e.Meta.Title, report.Title)
+		if f := e.Meta.Report; f != nil {
+			report.Title, report.Footer = cmp.Or(f.Title, report.Title), f.Footer
+			report.ScoreLabel = cmp.Or(f.ScoreLabel, report.ScoreLabel)
+		}
+	}
+
+	// Attempts made before the exam changed its number of questions cannot be matched to its tags
+	questions := exam.Questions(e.Content)
+	if len(questions) == len(a.Correct) {
+		byTag := map[string]*TagResult{}
+		for i, item := range questions {
+			q, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, t := range exam.QuestionTags(q) {
+				if byTag[t] == nil {
+					byTag[t] = &TagResult{Tag: t}
+				}
+				byTag[t].Total++
+				if a.Correct[i] {
+					byTag[t].Correct++
+				}
+			}
+		}
+		for _, t := range byTag {
+			t.Percent = float64(t.Correct) * 100 / float64(t.Total)
+			report.Tags = append(report.Tags, *t)
+		}
+		slices.SortFunc(report.Tags, func(a, b TagResult) int { return cmp.Compare(a.Tag, b.Tag) })
+	}
+
+	type area struct {
+		name    string
+		percent float64
+	}
+	var strong, weak []area
+	judge := func(name string, total int, percent float64) {
+		switch {
+		case total < reportMinQuestions:
+		case percent >= strongTopicPercent:
+			strong = append(strong, area{name, percent})
+		case percent < weakTopicPercent:
+			weak = append(weak, area{name, percent})
+		}
+	}
+	for _, s := range report.Sections {
+		judge(s.Name, s.Total, s.Percent)
+	}
+	for _, t := range report.Tags {
+		judge(t.Tag, t.Total, t.Percent)
+	}
+	slices.SortStableFunc(strong, func(a, b area) int { return cmp.Compare(b.percent, a.percent) })
+	slices.SortStableFunc(weak, func(a, b area) int { return cmp.Compare(a.percent, b.percent) })
+	for _, s := range strong {
+		report.Strengths = append(report.Strengths, s.name)
+	}
+	for _, w := range weak {
+		report.Weaknesses = append(report.Weaknesses, w.name)
+	}
+	return report
+}
+
+// serveScoreReport returns a handler that returns the score report of a submitted session, as JSON or, with
+// pdf set, as a PDF download
+func serveScoreReport(store *examStore, sessions *sessionStore, attempts *attemptStore, pdf bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := sessions.backend.Load(r.PathValue("id"))
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+		if session.AttemptID == "" {
+			http.Error(w, "Session is not submitted yet", http.StatusConflict)
+			return
+		}
+		list := attempts.List(func(a Attempt) bool { return a.ID == session.AttemptID })
+		if len(list) == 0 {
+			http.Error(w, "Attempt not found", http.StatusNotFound)
+			return
+		}
+		a := list[0]
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var e *exam.ExamFile
+		sub := submission{Subject: a.Subject, Exam: a.Exam, ExamID: a.ExamID}
+		if found, ok := findSubmittedExam(subjects, &sub); ok {
+			e = &found
+		}
+
+		report := scoreReport(session, a, e)
+		if pdf {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="score-report-`+session.ID+`.pdf"`)
+			w.Write(scoreReportPDF(report))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// scoreReportPDF renders a score report on as many portrait A4 pages as it takes
+func scoreReportPDF(report ScoreReport) []byte {
+	const width, height, margin, lineHeight = 595, 842, 60, 18
+	type line struct {
+		font string
+		size int
+		text string
+	}
+	lines := []line{{"F2", 22, report.Title}, {"F1", 11, "Score report - " + report.SubmittedAt.Format("January 2, 2006")}}
+	if report.UserID != "" {
+		lines = append(lines, line{"F1", 11, "Candidate: " + report.UserID})
+	}
+	lines = append(lines, line{"F1", 11, ""})
+
+	overall := fmt.Sprintf("Correct answers: %d/%d (%.1f%%)", report.Score, report.Total, report.Percent)
+	if report.Points != nil && report.MaxPoints != nil {
+		overall = fmt.Sprintf("Points: %g/%g (%.1f%%)", *report.Points, *report.MaxPoints, report.Percent)
+	}
+	if report.Scaled != nil {
+		lines = append(lines, line{"F2", 16, fmt.Sprintf("%s: %g", report.ScoreLabel, *report.Scaled)})
+	}
+	lines = append(lines, line{"F1", 12, overall})
+	if report.Percentile != nil {
+		lines = append(lines, line{"F1", 12, fmt.Sprintf("Percentile rank: %.0f", *report.Percentile)})
+	}
+	if report.Passed != nil {
+		lines = append(lines, line{"F1", 12, "Result: " + map[bool]string{true: "Passed", false: "Not passed"}[*report.Passed]})
+	}
+
+	if len(report.Sections) > 0 {
+		lines = append(lines, line{"F1", 11, ""}, line{"F2", 14, "Sections"})
+		for _, s := range report.Sections {
+			text := fmt.Sprintf("%s: %d/%d (%.1f%%)", s.Name, s.Correct, s.Total, s.Percent)
+			if s.Passed != nil {
+				text += map[bool]string{true: " - passed", false: " - not passed"}[*s.Passed]
+			}
+			lines = append(lines, line{"F1", 11, text})
+		}
+	}
+	if len(report.Tags) > 0 {
+		lines = append(lines, line{"F1", 11, ""}, line{"F2", 14, "Topics"})
+		for _, t := range report.Tags {
+			lines = append(lines, line{"F1", 11, fmt.Sprintf("%s: %d/%d (%.1f%%)", t.Tag, t.Correct, t.Total, t.Percent)})
+		}
+	}
+	for _, group := range []struct {
+		title string
+		names []string
+	}{{"Strengths", report.Strengths}, {"Areas to improve", report.Weaknesses}} {
+		if len(group.names) > 0 {
+			lines = append(lines, line{"F1", 11, ""}, line{"F2", 14, group.title}, line{"F1", 11, strings.Join(group.names, ", ")})
+		}
+	}
+	if report.Footer != "" {
+		lines = append(lines, line{"F1", 11, ""}, line{"F1", 9, report.Footer})
+	}
+
+	// Objects 1 to 4 are the catalog, the page tree and the fonts, followed by a page and its content per page
+	perPage := (height - 2*margin) / lineHeight
+	pages := (len(lines) + perPage - 1) / perPage
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	var kids []string
+	for p := range pages {
+		var content bytes.Buffer
+		y := height - margin
+		for _, l := range lines[p*perPage : min((p+1)*perPage, len(lines))] {
+			fmt.Fprintf(&content, "BT /%s %d Tf %d %d Td %s Tj ET\n", l.font, l.size, margin, y, pdfText(l.text))
+			y -= lineHeight
+		}
+		fmt.Fprintf(&content, "BT /F1 9 Tf %d %d Td %s Tj ET\n", width-margin-40, margin/2, pdfText(fmt.Sprintf("%d / %d", p+1, pages)))
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objects)+1))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", width, height, len(objects)+2),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages)
+	objects = append(objects, fmt.Sprintf("<< /Title %s /Creator (Mock Exam) >>", pdfText(report.Title+" score report")))
+	return pdfDocument(objects)
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// SCORMVersions lists the SCORM editions a package can target
+var SCORMVersions = []string{"1.2", "2004"}
+
+// scormManifests are the imsmanifest.xml templates of each SCORM version
+var scormManifests = map[string]*texttemplate.Template{
+	"1.2": texttemplate.Must(texttemplate.New("1.2").Funcs(texttemplate.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<manifest identifier="{{xml .ID}}" version="1.0"
+    xmlns="http://www.imsproject.org/xsd/imscp_rootv1p1p2"
+    xmlns:adlcp="http://www.adlnet.org/xsd/adlcp_rootv1p2"
+    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+    xsi:schemaLocation="http://www.imsproject.org/xsd/imscp_rootv1p1p2 imscp_rootv1p1p2.xsd http://www.adlnet.org/xsd/adlcp_rootv1p2 adlcp_rootv1p2.xsd">
+  <metadata>
+    <schema>ADL SCORM</schema>
+    <schemaversion>1.2</schemaversion>
+  </metadata>
+  <organizations default="org">
+    <organization identifier="org">
+      <title>{{xml .Title}}</title>
+      <item identifier="item" identifierref="sco">
+        <title>{{xml .Title}}</title>{{if .Passing}}
+        <adlcp:masteryscore>{{.Passing}}</adlcp:masteryscore>{{end}}
+      </item>
+    </organization>
+  </organizations>
+  <resources>
+    <resource identifier="sco" type="webcontent" adlcp:scormtype="sco" href="index.html">
+      <file href="index.html"/>
+    </resource>
+  </resources>
+</manifest>
+`)),
+	"2004": texttemplate.Must(texttemplate.New("2004").Funcs(texttemplate.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<manifest identifier="{{xml .ID}}" version="1.0"
+    xmlns="http://www.imsglobal.org/xsd/imscp_v1p1"
+    xmlns:adlcp="http://www.adlnet.org/xsd/adlcp_v1p3"
+    xmlns:imsss="http://www.imsglobal.org/xsd/imsss"
+    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+    xsi:schemaLocation="http://www.imsglobal.org/xsd/imscp_v1p1 imscp_v1p1.xsd http://www.adlnet.org/xsd/adlcp_v1p3 adlcp_v1p3.xsd http://www.imsglobal.org/xsd/imsss imsss_v1p0.xsd">
+  <metadata>
+    <schema>ADL SCORM</schema>
+    <schemaversion>2004 3rd Edition</schemaversion>
+  </metadata>
+  <organizations default="org">
+    <organization identifier="org">
+      <title>{{xml .Title}}</title>
+      <item identifier="item" identifierref="sco">
+        <title>{{xml .Title}}</title>{{if .Passing}}
+        <imsss:sequencing>
+          <imsss:objectives>
+            <imsss:primaryObjective objectiveID="primary" satisfiedByMeasure="true">
+              <imsss:minNormalizedMeasure>{{.PassingMeasure}}</imsss:minNormalizedMeasure>
+            </imsss:primaryObjective>
+          </imsss:objectives>
+        </imsss:sequencing>{{end}}
+      </item>
+    </organization>
+  </organizations>
+  <resources>
+    <resource identifier="sco" type="webcontent" adlcp:scormType="sco" href="index.html">
+      <file href="index.html"/>
+    </resource>
+  </resources>
+</manifest>
+`)),
+}
+
+// xmlEscape escapes text for XML character data and attribute values
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// scormIdentifier replaces the characters not allowed in manifest identifiers and file names with dashes
+func scormIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+// scormPlayer is the single-page SCO that runs the exam and reports the score through the LMS API
+var scormPlayer = template.Must(template.New("player").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+.question { margin-bottom: 24px; }
+.question-text { font-weight: bold; white-space: pre-wrap; margin-bottom: 8px; }
+label { display: block; padding: 6px; white-space: pre-wrap; }
+.correct { background: #d4edda; }
+.incorrect { background: #f8d7da; }
+#result { font-size: 1.3em; font-weight: bold; margin-top: 20px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Instructions}}<p>{{.Instructions}}</p>{{end}}
+<form id="exam"></form>
+<button id="submit" type="button">Submit</button>
+<div id="result"></div>
+<script>
+const version = {{.Version}};
+const questions = {{.Questions}};
+const passing = {{.Passing}};
+
+// Find the LMS API object in the window hierarchy as described by the SCORM runtime specification
+function findAPI(win) {
+    const name = version === "1.2" ? "API" : "API_1484_11";
+    for (let depth = 0; win && depth < 10; depth++) {
+        if (win[name]) return win[name];
+        if (win.parent === win) break;
+        win = win.parent;
+    }
+    return window.opener ? findAPI(window.opener) : null;
+}
+
+const api = findAPI(window);
+const lms = {
+    init() { if (api) version === "1.2" ? api.LMSInitialize("") : api.Initialize(""); },
+    set(key, value) { if (api) version === "1.2" ? api.LMSSetValue(key, String(value)) : api.SetValue(key, String(value)); },
+    finish() {
+        if (!api) return;
+        if (version === "1.2") { api.LMSCommit(""); api.LMSFinish(""); } else { api.Commit(""); api.Terminate(""); }
+    }
+};
+
+const form = document.getElementById("exam");
+questions.forEach((q, i) => {
+    const div = document.createElement("div");
+    div.className = "question";
+    const text = document.createElement("div");
+    text.className = "question-text";
+    text.textContent = (i + 1) + ". " + q.question;
+    div.appendChild(text);
+    q.choices.forEach((choice, j) => {
+        const label = document.createElement("label");
+        const input = document.createElement("input");
+        input.type = "radio";
+        input.name = "q" + i;
+        input.value = j;
+        label.appendChild(input);
+        label.appendChild(document.createTextNode(" " + choice));
+        div.appendChild(label);
+    });
+    form.appendChild(div);
+});
+
+lms.init();
+if (version === "1.2") lms.set("cmi.core.lesson_status", "incomplete");
+else lms.set("cmi.completion_status", "incomplete");
+
+document.getElementById("submit").addEventListener("click", () => {
+    let score = 0;
+    questions.forEach((q, i) => {
+        const chosen = form.querySelector('input[name="q' + i + '"]:checked');
+        const labels = form.children[i].querySelectorAll("label");
+        if (q.correct >= 0 && q.correct < labels.length) labels[q.correct].classList.add("correct");
+        if (chosen && Number(chosen.value) === q.correct) score++;
+        else if (chosen) chosen.parentNode.classList.add("incorrect");
+        form.querySelectorAll('input[name="q' + i + '"]').forEach(input => input.disabled = true);
+    });
+    const percent = questions.length ? Math.round(score * 100 / questions.length) : 0;
+    const passed = passing === null || percent >= passing;
+
+    if (version === "1.2") {
+        lms.set("cmi.core.score.raw", percent);
+        lms.set("cmi.core.score.min", 0);
+        lms.set("cmi.core.score.max", 100);
+        lms.set("cmi.core.lesson_status", passing === null ? "completed" : passed ? "passed" : "failed");
+    } else {
+        lms.set("cmi.score.raw", score);
+        lms.set("cmi.score.min", 0);
+        lms.set("cmi.score.max", questions.length);
+        lms.set("cmi.score.scaled", questions.length ? (score / questions.length).toFixed(4) : 0);
+        lms.set("cmi.completion_status", "completed");
+        if (passing !== null) lms.set("cmi.success_status", passed ? "passed" : "failed");
+    }
+    lms.finish();
+
+    document.getElementById("submit").disabled = true;
+    document.getElementById("result").textContent = "Score: " + score + "/" + questions.length + " (" + percent + "%)";
+});
+</script>
+</body>
+</html>
+`))
+
+// scormPackage holds the values the manifest and player templates are filled with
+type scormPackage struct {
+	ID             string
+	Version        string
+	Title          string
+	Instructions   string
+	Questions      []any
+	Passing        any // percent, or nil when the exam has no passing score
+	PassingMeasure string
+}
+
+// WriteSCORMPackage writes the exam e as a SCORM content package zip for the given SCORM version
+func WriteSCORMPackage(w io.Writer, subject string, e exam.ExamFile, version string) error {
+	manifest, ok := scormManifests[version]
+	if !ok {
+		return fmt.Errorf("unsupported SCORM version %q (expected %s)", version, strings.Join(SCORMVersions, " or "))
+	}
+
+	title := strings.TrimSuffix(e.Name, path.Ext(e.Name))
+	pkg := scormPackage{
+		ID:        scormIdentifier("mock-exam-" + subject + "-" + title),
+		Version:   version,
+		Title:     title,
+		Questions: exam.InstantiateQuestions(exam.Questions(e.Content), exam.VariantSeed("", subject, e.Name)),
+	}
+	if e.Meta != nil {
+		if e.Meta.Title != "" {
+			pkg.Title = e.Meta.Title
+		}
+		pkg.Instructions = e.Meta.Instructions
+		if e.Meta.PassingScore != nil {
+			pkg.Passing = *e.Meta.PassingScore
+			pkg.PassingMeasure = fmt.Sprintf("%.4f", *e.Meta.PassingScore/100)
+		}
+	}
+	if pkg.Questions == nil {
+		pkg.Questions = []any{}
+	}
+
+	var manifestXML, player bytes.Buffer
+	if err := manifest.Execute(&manifestXML, pkg); err != nil {
+		return err
+	}
+	if err := scormPlayer.Execute(&player, pkg); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"imsmanifest.xml", manifestXML.Bytes()}, {"index.html", player.Bytes()}} {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// exportSCORM returns a handler that downloads an exam as a SCORM package
+func exportSCORM(store *examStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+
+		version := r.URL.Query().Get("version")
+		if version == "" {
+			version = "1.2"
+		}
+		var buf bytes.Buffer
+		if err := WriteSCORMPackage(&buf, subject, e, version); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		name := scormIdentifier(subject+"-"+strings.TrimSuffix(e.Name, path.Ext(e.Name))) + "-scorm" + strings.ReplaceAll(version, ".", "") + ".zip"
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Write(buf.Bytes())
+	}
+}
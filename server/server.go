@@ -0,0 +1,595 @@
+// Package server serves the exam API and the frontend over HTTP. Programs embedding it create a Server with
+// New and mount its Handler, under a prefix if they like.
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// Config holds the settings of an exam server. Zero values select the defaults noted on each field.
+type Config struct {
+	Dir        string // directory containing the subject folders; defaults to json
+	DataDir    string // directory where attempts and other server state are stored; defaults to data
+	Static     string // directory the frontend is served from; none is served if empty
+	MediaDir   string // directory containing the per-subject media folders; defaults to media
+	ImageCache string // directory where resized images are cached; defaults to the user cache directory
+
+	AdminToken       string   // bearer token of the admin API, which is disabled if empty
+	InstructorTokens []string // bearer tokens granting the instructor role; token=<subject>[:<subject>...] limits one to those subjects
+	AuthorTokens     []string // bearer tokens granting the exam editing routes of the admin API, limited to subjects as instructor tokens
+	PublishApproval  bool     // publishing an uploaded draft exam needs the approval of a second instructor or admin
+
+	Sanitize           string        // HTML sanitization of exam content, one of SanitizeModes; defaults to ugc
+	Sort               string        // ordering of subjects and exams, one of exam.SortModes; defaults to name
+	RedactAnswers      bool          // strip the answer key from public exam responses
+	SigningKeys        string        // file of the minisign public keys exam files are signed with; signatures are not checked if empty
+	RequireSignatures  bool          // leave out exams that are unsigned or whose signature does not verify, instead of marking them
+	AutosaveDebounce   time.Duration // how long clients wait after an answer before autosaving; defaults to 2s
+	ConcurrentSessions string        // handling of a session opened in a second window, one of ConcurrentModes; defaults to allow
+	DailyQuestions     int           // number of questions in the daily challenge; defaults to 5
+
+	ConfigFile    string         // file of the settings that can be reloaded at runtime, see ReloadConfig
+	LogLevel      *slog.LevelVar // set to the log level of ConfigFile on start and every reload, for the caller's log handler
+	GzipLevel     int            // gzip level of compressed responses, 1 (fastest) to 9 (smallest); 0 selects the default, 6
+	GzipMinSize   int            // responses smaller than this many bytes are sent uncompressed; defaults to 1024
+	ExamsCacheTTL time.Duration  // how long exam listings are reused; concurrent identical requests always share one
+	Watch         bool           // cache exam content and reload it when files change
+	WatchInterval time.Duration  // how often Watch checks for changed files; defaults to 1s
+
+	RedisURL    string // Redis shared by replicas for exam listings, sessions and rate limits; disabled if empty
+	RedisPrefix string // prefix of the Redis keys; defaults to mockexam:
+
+	LTIConfig string       // file of LTI 1.3 platform registrations; LTI is disabled if empty
+	XAPI      XAPIConfig   // LRS that attempt statements are sent to; disabled if the endpoint is empty
+	Events    EventsConfig // Kafka or NATS that domain events are published to; disabled if the URL is empty
+}
+
+// Server is an exam server with its stores, ready to be mounted into an HTTP server
+type Server struct {
+	handler  http.Handler
+	tokens   tokenRoles
+	live     *liveConfig
+	compress compressor
+	redis    *redisClient // nil unless replicas share a Redis
+
+	store    *examStore
+	attempts *attemptStore
+	sessions *sessionStore
+	codes    *accessCodeStore
+	groups   *groupStore
+	drafts   *draftStore
+	certs    *certificateStore
+	badges   *badgeStore
+	boards   *leaderboardStore
+	daily    *dailyStore
+	flags    *flagStore
+	similar  *similarityStore
+	cal      *calibrationStore
+	comments *commentStore
+	ratings  *ratingStore
+	usage    *usageStore
+	revs     *revisionStore
+	jobs     *scheduler
+	lti      *ltiTool
+}
+
+// New opens the exam directory and the stores of cfg and creates a server for them. A background goroutine runs
+// the jobs scheduled in the configuration file and, with Watch set, another reloads the exam content, both for
+// the lifetime of the process.
+func New(cfg Config) (*Server, error) {
+	cfg.Dir = cmp.Or(cfg.Dir, "json")
+	cfg.DataDir = cmp.Or(cfg.DataDir, "data")
+	cfg.MediaDir = cmp.Or(cfg.MediaDir, "media")
+	cfg.ImageCache = cmp.Or(cfg.ImageCache, defaultImageCacheDir())
+	cfg.Sanitize = cmp.Or(cfg.Sanitize, "ugc")
+	cfg.Sort = cmp.Or(cfg.Sort, "name")
+	cfg.AutosaveDebounce = cmp.Or(cfg.AutosaveDebounce, 2*time.Second)
+	cfg.ConcurrentSessions = cmp.Or(cfg.ConcurrentSessions, "allow")
+	cfg.DailyQuestions = cmp.Or(cfg.DailyQuestions, 5)
+	cfg.WatchInterval = cmp.Or(cfg.WatchInterval, time.Second)
+	cfg.RedisPrefix = cmp.Or(cfg.RedisPrefix, "mockexam:")
+
+	policy, err := newSanitizer(cfg.Sanitize)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStaticRoot(cfg); err != nil {
+		return nil, err
+	}
+	s := &Server{tokens: newTokenRoles(cfg.AdminToken, cfg.InstructorTokens, cfg.AuthorTokens)}
+	if s.compress, err = newCompressor(cfg.GzipLevel, cfg.GzipMinSize); err != nil {
+		return nil, err
+	}
+	if cfg.RedisURL != "" {
+		if s.redis, err = newRedisClient(cfg.RedisURL, cfg.RedisPrefix); err != nil {
+			return nil, err
+		}
+	}
+	ids, err := openIDStore(filepath.Join(cfg.DataDir, "ids.json"))
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := loadSignatureCheck(cfg.SigningKeys, cfg.RequireSignatures)
+	if err != nil {
+		return nil, err
+	}
+	if s.store, err = newExamStore(cfg.Dir, cfg.Watch, policy, cfg.Sort, cfg.RedactAnswers, signatures, ids); err != nil {
+		return nil, err
+	}
+	if cfg.Watch {
+		go watchExamDir(s.store, cfg.WatchInterval, nil)
+	}
+	if s.live, err = newLiveConfig(cfg.ConfigFile, cfg.LogLevel, s.store); err != nil {
+		return nil, err
+	}
+	s.live.limiter.shared = s.redis
+	if err := s.openStores(cfg); err != nil {
+		return nil, err
+	}
+	s.jobs = newScheduler(s.live, s.redis, s.serverJobs(cfg)...)
+	go s.jobs.run(context.Background())
+	s.handler = s.routes(cfg)
+	return s, nil
+}
+
+// openStores opens the stores kept in the data directory and connects the ones that follow recorded attempts
+func (s *Server) openStores(cfg Config) error {
+	dataDir := cfg.DataDir
+	var err error
+	if s.attempts, err = openAttemptStore(filepath.Join(dataDir, "attempts.jsonl")); err != nil {
+		return err
+	}
+	// Sessions are kept in the Redis shared by replicas if there is one
+	var sessions sessionBackend
+	if s.redis != nil {
+		sessions, err = openRedisSessions(s.redis, filepath.Join(dataDir, "sessions"))
+	} else {
+		sessions, err = openFileSessions(filepath.Join(dataDir, "sessions"))
+	}
+	if err != nil {
+		return err
+	}
+	if s.sessions, err = openSessionStore(sessions, cfg.AutosaveDebounce, cfg.ConcurrentSessions); err != nil {
+		return err
+	}
+	if s.codes, err = openAccessCodeStore(filepath.Join(dataDir, "access-codes.json")); err != nil {
+		return err
+	}
+	if s.groups, err = openGroupStore(filepath.Join(dataDir, "groups.json")); err != nil {
+		return err
+	}
+	if s.drafts, err = openDraftStore(filepath.Join(dataDir, "drafts.json")); err != nil {
+		return err
+	}
+
+	if s.certs, err = openCertificateStore(filepath.Join(dataDir, "certificates.json"), filepath.Join(dataDir, "certificate-key.pem")); err != nil {
+		return err
+	}
+	s.attempts.OnAdd(s.certs.attemptRecorded)
+
+	if s.badges, err = openBadgeStore(filepath.Join(dataDir, "badges.json"), s.attempts); err != nil {
+		return err
+	}
+	s.attempts.OnAdd(s.badges.attemptRecorded)
+
+	if s.boards, err = openLeaderboardStore(filepath.Join(dataDir, "leaderboard.json")); err != nil {
+		return err
+	}
+	if s.flags, err = openFlagStore(filepath.Join(dataDir, "flags.json")); err != nil {
+		return err
+	}
+	if s.similar, err = openSimilarityStore(filepath.Join(dataDir, "similarity.json")); err != nil {
+		return err
+	}
+	if s.cal, err = openCalibrationStore(filepath.Join(dataDir, "calibration.json")); err != nil {
+		return err
+	}
+	if s.comments, err = openCommentStore(filepath.Join(dataDir, "comments.json")); err != nil {
+		return err
+	}
+
+	if s.usage, err = openUsageStore(filepath.Join(dataDir, "usage.json"), s.attempts); err != nil {
+		return err
+	}
+	s.sessions.OnStart(s.usage.sessionStarted)
+	s.attempts.OnAdd(s.usage.attemptRecorded)
+
+	if s.ratings, err = openRatingStore(filepath.Join(dataDir, "ratings.json")); err != nil {
+		return err
+	}
+	if s.daily, err = openDailyStore(filepath.Join(dataDir, "daily.json"), cfg.DailyQuestions); err != nil {
+		return err
+	}
+
+	// Every version of an exam the server loads is kept so updates can be reviewed, starting with the current one
+	if s.revs, err = openRevisionStore(filepath.Join(dataDir, "revisions")); err != nil {
+		return err
+	}
+	if cfg.Events.URL != "" {
+		events, err := newEventEmitter(cfg.Events, s.drafts)
+		if err != nil {
+			return err
+		}
+		s.revs.OnRecord(events.examRecorded)
+		s.drafts.OnPublish(events.draftPublished)
+		s.sessions.OnStart(events.sessionStarted)
+		s.sessions.OnSubmit(events.sessionSubmitted)
+		s.attempts.OnAdd(events.attemptRecorded)
+	}
+	s.store.OnLoad(s.revs.subjectsLoaded)
+	subjects, err := s.store.Subjects(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := s.revs.record(subjects); err != nil {
+		return err
+	}
+
+	if cfg.XAPI.Endpoint != "" {
+		xapi := newXAPIEmitter(cfg.XAPI)
+		s.attempts.OnAdd(xapi.attemptRecorded)
+	}
+
+	if cfg.LTIConfig != "" {
+		if s.lti, err = newLTITool(cfg.LTIConfig, dataDir); err != nil {
+			return err
+		}
+		s.attempts.OnAdd(s.lti.attemptRecorded)
+	}
+	return nil
+}
+
+// Handler returns the handler serving the API and the frontend. Its routes are absolute, so to mount the
+// server under a prefix strip the prefix first, as in http.StripPrefix("/exams", srv.Handler()).
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// ReloadConfig re-reads the configuration file, keeping the current settings if it is invalid
+func (s *Server) ReloadConfig() error {
+	_, err := s.live.Reload()
+	return err
+}
+
+// routes registers the HTTP handlers serving the exam content and the stores of s
+func (s *Server) routes(cfg Config) http.Handler {
+	// Every request is logged and may come from another origin, so these run before routing. Responses
+	// of any route are compressed when that pays off.
+	root := newRouter(s.live.logRequests, s.live.cors, s.compress.middleware)
+
+	// The API is rate limited per client and closed during maintenance. Its responses get the Cache-Control
+	// directives and handler timeout configured for their route, and are sent as MessagePack or CBOR to clients
+	// asking for it.
+	api := root.Group(apiPrefix, s.live.maintenance, s.live.rateLimit, s.live.cacheControl, s.live.timeout, binaryEncodings)
+	instructor := api.Group("/instructor", requireRole(s.tokens, instructorRoles))
+
+	// Clients written before the API was versioned keep using the unversioned paths
+	root.Handle("/api/", serveUnversioned(root.mux))
+	// Students subscribe to the feed of new and updated exams in a feed reader
+	root.With(s.live.cacheControl).HandleFunc("GET /feed.xml", serveFeed(s.store, s.revs, s.groups, s.drafts))
+	// Serve the frontend from the static directory, if there is one
+	if cfg.Static != "" {
+		root.With(s.live.cacheControl).Handle("/", http.FileServer(http.Dir(cfg.Static)))
+	}
+
+	// Exams are listed and fetched by those their metadata makes them visible to
+	access := examAccess{tokens: s.tokens, groups: s.groups, drafts: s.drafts}
+
+	// Add API endpoint to serve JSON files from the json directory
+	api.Handle("/exams", serveExamFiles(s.store, s.ratings, access, newResponseCache(cfg.ExamsCacheTTL, s.redis)))
+	api.Handle("/exams/changes", serveExamChanges(s.store))
+	api.HandleFunc("GET /exams/{subject}/{exam}", serveExam(s.store, s.ratings, access))
+
+	// Clients syncing a few exams fetch them in one request instead of one each or the whole listing
+	api.HandleFunc("POST /exams/batch", fetchExamBatch(s.store, s.ratings, access))
+
+	// Typed clients generate their protobuf messages from the schema of the protobuf responses
+	api.HandleFunc("GET /schema/exam.proto", serveProtobufSchema)
+
+	// The offline bundle is compressed already, so the compressor passes it through
+	api.HandleFunc("GET /bundle.tar.gz", serveBundle(s.store, access))
+
+	// Serve question media files with their MIME types
+	api.HandleFunc("GET "+strings.TrimPrefix(exam.MediaURLPrefix, "/api")+"{path...}", serveMedia(cfg.MediaDir, cfg.ImageCache))
+
+	// Per-user papers are drawn deterministically so reloading returns the same one
+	api.Handle("GET /exams/{subject}/{exam}/variant", serveExamVariant(s.store, access))
+
+	// Clients report the exams they open so usage can be tracked from views through completions
+	api.HandleFunc("POST /exams/{subject}/{exam}/views", recordView(s.store, s.usage))
+
+	// Sessions save answers as they are given so an interrupted exam can be resumed
+	registerSessionRoutes(api, s.sessions, s.store, s.attempts, s.codes, s.groups, access)
+
+	// Invite links register students into a group
+	api.HandleFunc("POST /invites/{token}/redeem", redeemInvite(s.groups))
+	api.HandleFunc("GET /users/{id}/groups", userGroups(s.groups))
+
+	// Passed attempts earn a signed certificate that anyone can verify
+	registerCertificateRoutes(api, s.certs)
+	api.HandleFunc("GET /users/{id}/badges", serveBadges(s.badges))
+
+	// Schools under the GDPR export and erase everything kept about a user; user IDs are not authenticated,
+	// so both are reserved to admin tokens
+	people := personalData{s.attempts, s.sessions, s.groups, s.badges, s.certs, s.boards, s.daily, s.flags, s.similar, s.comments, s.ratings, s.live, cfg.DataDir}
+	adminOnly := requireRole(s.tokens, []string{roleAdmin})
+	api.With(adminOnly).HandleFunc("GET /users/{id}/export", exportUserData(people))
+	api.With(adminOnly).HandleFunc("DELETE /users/{id}", eraseUserData(people))
+
+	// Leaderboards only list users who opted in, under anonymous names
+	api.HandleFunc("GET /leaderboard", serveLeaderboard(s.store, s.attempts, s.boards))
+	optIn := leaderboardOptIn(s.boards)
+	api.HandleFunc("GET /users/{id}/leaderboard", optIn)
+	api.HandleFunc("PUT /users/{id}/leaderboard", optIn)
+	api.HandleFunc("DELETE /users/{id}/leaderboard", optIn)
+
+	// The daily challenge draws the same questions for everyone and tracks streaks of consecutive days
+	registerDailyRoutes(api, s.daily, s.store, s.drafts)
+	api.Handle("GET /random", serveRandomQuestions(s.store, access, s.attempts, s.cal))
+	api.HandleFunc("GET /recommendations", serveRecommendations(s.store, s.attempts))
+	api.HandleFunc("GET /users/{id}/trends", serveTrends(s.attempts))
+
+	// Flagged questions go to the instructor moderation queue
+	api.HandleFunc("POST /questions/{id}/flag", flagQuestion(s.store, s.flags))
+
+	// Question discussions open to a test taker once they have submitted the exam
+	discussion := questionComments(s.tokens, s.store, s.attempts, s.comments)
+	api.HandleFunc("GET /questions/{id}/comments", discussion)
+	api.HandleFunc("POST /questions/{id}/comments", discussion)
+
+	// Exams can be rated once submitted
+	rate := examRatings(s.store, s.attempts, s.ratings)
+	api.HandleFunc("GET /exams/{subject}/{exam}/ratings", rate)
+	api.HandleFunc("POST /exams/{subject}/{exam}/ratings", rate)
+
+	// Single answers can be checked for immediate feedback without downloading the answer key
+	api.HandleFunc("POST /answers/check", checkAnswer(s.store, s.sessions, s.codes, access))
+
+	// Answers are graded on the server so the attempt can be recorded
+	api.HandleFunc("POST /attempts", submitAttempt(s.store, s.attempts, s.codes, access))
+
+	// LTI launches are only accepted from registered platforms
+	if s.lti != nil {
+		registerLTIRoutes(root.Group("/lti"), s.lti)
+	}
+
+	// The answer key is only served to instructors and admins
+	api.With(requireRole(s.tokens, instructorRoles)).HandleFunc("GET /exams/{subject}/{exam}/key", serveAnswerKey(s.store))
+	// So are comparisons of the scores of cohorts
+	api.With(requireRole(s.tokens, instructorRoles)).HandleFunc("GET /analytics/compare", compareAnalytics(s.store, s.attempts, s.groups, s.revs))
+
+	// The instructor API is open to instructor and admin tokens
+	registerInstructorRoutes(instructor, s.store, s.attempts, s.codes, s.groups, s.drafts, cfg.PublishApproval, s.flags, s.similar, s.comments, s.ratings, s.sessions, s.cal)
+
+	// The admin API is only available when an admin token is configured. It stays open during
+	// maintenance so maintenance mode can be turned off again.
+	if cfg.AdminToken != "" {
+		admin := root.Group(apiPrefix+"/admin", s.live.rateLimit, requireAdmin(cfg.AdminToken), s.live.timeout, binaryEncodings)
+		authoring := root.Group(apiPrefix+"/admin", s.live.rateLimit, requireAuthor(s.tokens), s.live.timeout, binaryEncodings)
+		registerAdminRoutes(admin, authoring, s.store, s.attempts, s.sessions, s.usage, s.revs, s.drafts, s.live, s.jobs, cfg.MediaDir)
+
+		// Profiles of the running server can be taken once enabled in the configuration file
+		registerDebugRoutes(root.Group("/debug", s.live.debugEndpoints, requireAdmin(cfg.AdminToken)))
+	} else {
+		log.Printf("Admin API disabled: no admin token configured")
+	}
+
+	return root
+}
+
+// checkStaticRoot refuses a static directory holding the exam, data or media directory, whose answer keys,
+// server state and signing keys the file server would hand to anyone
+func checkStaticRoot(cfg Config) error {
+	if cfg.Static == "" {
+		return nil
+	}
+	static, err := filepath.Abs(cfg.Static)
+	if err != nil {
+		return fmt.Errorf("static directory: %w", err)
+	}
+	for _, d := range []struct{ name, dir string }{{"exam", cfg.Dir}, {"data", cfg.DataDir}, {"media", cfg.MediaDir}} {
+		dir, err := filepath.Abs(d.dir)
+		if err != nil {
+			return fmt.Errorf("%s directory: %w", d.name, err)
+		}
+		if rel, err := filepath.Rel(static, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("static directory %s contains the %s directory %s, which would be served publicly", cfg.Static, d.name, d.dir)
+		}
+	}
+	return nil
+}
+
+// serveExamFiles returns a handler that returns the subjects of store with their exams. Responses are shared
+// through cache by requests with the same query that see the same exams, and carry the newest exam file time as
+// Last-Modified.
+func serveExamFiles(store *examStore, ratings *ratingStore, access examAccess, cache *responseCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch query.Get("format") {
+		case "", "json":
+		case "ndjson":
+			streamExamListing(w, r, store, ratings, access)
+			return
+		default:
+			http.Error(w, "Unknown format (expected json or ndjson)", http.StatusBadRequest)
+			return
+		}
+
+		// The listing is built for every request waiting on it, so it must not stop when the first one goes away
+		ctx := context.WithoutCancel(r.Context())
+		visible := func(subjects []exam.Subject) []exam.Subject {
+			return access.filter(r, query.Get("user"), subjects)
+		}
+		key := access.audience(r, query.Get("user")) + ":" + query.Encode()
+		// Replicas sharing a cache share listings of the same catalog version and ratings
+		sharedKey := store.Version() + ":" + strconv.FormatInt(ratings.LastUpdated().UnixNano(), 36) + ":" + key
+		resp, err := cache.Do(key, store.Generation(), cache.shareBuild(sharedKey, func() (cachedResponse, error) {
+			return buildExamListing(ctx, store, ratings, visible, query)
+		}))
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The catalog version is the marker clients pass to the delta sync endpoint
+		w.Header().Set("X-Catalog-Version", resp.version)
+		w.Header().Set("Content-Type", "application/json")
+		// ServeContent answers If-Modified-Since with 304 when nothing changed since
+		http.ServeContent(w, r, "", resp.modTime, bytes.NewReader(resp.body))
+	}
+}
+
+// serveExam returns a handler that returns one exam, by file name or slug, as the listing serves it
+func serveExam(store *examStore, ratings *ratingStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok || !access.canSee(r, r.URL.Query().Get("user"), subject, e) {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+
+		e.Content = store.publicContent(subject, e)
+		if sum, ok := ratings.Summaries()[ExamRef{Subject: subject, Name: e.Name}]; ok {
+			e.Rating = &sum
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SubjectExam{Subject: subject, ExamFile: e})
+	}
+}
+
+// buildExamListing reads the subjects of store and encodes them as the exam listing shaped by the query parameters
+func buildExamListing(ctx context.Context, store *examStore, ratings *ratingStore, visible func([]exam.Subject) []exam.Subject, query url.Values) (cachedResponse, error) {
+	subjects, modTime, err := listSubjects(ctx, store, ratings, visible, query)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+
+	// Clients that do not understand nested subjects can ask for a flat list
+	if query.Get("layout") == "flat" {
+		subjects = exam.FlattenSubjects(subjects)
+	}
+
+	body, err := json.Marshal(subjects)
+	if err != nil {
+		return cachedResponse{}, fmt.Errorf("failed to encode response: %w", err)
+	}
+	return cachedResponse{body: append(body, '\n'), version: store.recordSnapshot(subjects), modTime: modTime}, nil
+}
+
+// listSubjects reads the subjects of store, keeps the exams visible leaves and shapes them by the query parameters
+// of the listing. It also returns when the listing last changed.
+func listSubjects(ctx context.Context, store *examStore, ratings *ratingStore, visible func([]exam.Subject) []exam.Subject, query url.Values) ([]exam.Subject, time.Time, error) {
+	// Read all files from the json directory organized by subjects
+	subjects, err := store.Subjects(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	subjects = visible(subjects)
+
+	// The listing changes with the exam files and the ratings aggregated into it
+	modTime := exam.LastModified(subjects)
+	if rated := ratings.LastUpdated(); rated.After(modTime) {
+		modTime = rated
+	}
+
+	return shapeListing(store, ratings.Summaries(), query, subjects), modTime, nil
+}
+
+// shapeListing turns visible subjects into the listing: exams as students see them with their ratings, shaped
+// by the query parameters of the listing
+func shapeListing(store *examStore, summaries map[ExamRef]exam.RatingSummary, query url.Values, subjects []exam.Subject) []exam.Subject {
+	// Questions are served as students see them, templates filled in and without the answer key
+	subjects = mapSubjectExams(subjects, store.publicContent)
+
+	// Ratings are aggregated into the listing so well-reviewed mocks can surface first
+	subjects = withRatings(subjects, summaries)
+	if query.Get("sort") == "rating" || store.sortMode == "rating" {
+		sortByRating(subjects)
+	}
+
+	// Optionally render Markdown question text to sanitized HTML
+	if query.Get("render") == "html" {
+		subjects = mapExamContent(subjects, renderExamMarkdown)
+	}
+
+	// Optionally locate LaTeX formulas so clients can typeset them
+	if query.Get("math") == "structured" {
+		subjects = mapExamContent(subjects, annotateExamMath)
+	}
+
+	// Listing pages can skip the question payload and rely on the computed counts
+	if query.Get("content") == "false" {
+		subjects = mapExamContent(subjects, func(any) any { return nil })
+	}
+	return subjects
+}
+
+// streamExamListing writes the exam listing as newline-delimited JSON, one exam with its subject per line. Each
+// subject is shaped and written as soon as the store has read it, flushing after every line, so clients can show
+// the first exams before the rest of the exam directory is read. Subjects come in directory order, the exams of
+// each sorted. The catalog version and modification time are only known at the end and are sent as trailers.
+func streamExamListing(w http.ResponseWriter, r *http.Request, store *examStore, ratings *ratingStore, access examAccess) {
+	query := r.URL.Query()
+	summaries := ratings.Summaries()
+	modTime := ratings.LastUpdated()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", "X-Catalog-Version, Last-Modified")
+	enc := json.NewEncoder(w)
+	flusher := http.NewResponseController(w)
+	started := false
+	err := store.Stream(r.Context(), func(subject exam.Subject) error {
+		// Once the client has gone away there is nobody left to write to
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		visible := access.filter(r, query.Get("user"), []exam.Subject{subject})
+		if t := exam.LastModified(visible); t.After(modTime) {
+			modTime = t
+		}
+		var werr error
+		exam.WalkExams(shapeListing(store, summaries, query, visible), func(subject string, e exam.ExamFile) {
+			if werr != nil {
+				return
+			}
+			started = true
+			if werr = enc.Encode(SubjectExam{Subject: subject, ExamFile: e}); werr == nil {
+				flusher.Flush()
+			}
+		})
+		return werr
+	})
+	if err != nil && !started {
+		w.Header().Del("Trailer")
+		http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Headers are sent with the first line, so later failures can only be logged
+	if err != nil {
+		log.Printf("Failed to stream exam listing: %v", err)
+		return
+	}
+	w.Header().Set("X-Catalog-Version", store.Version())
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer returns a server of an exam directory holding files, by their slash-separated path in it
+func newTestServer(t *testing.T, cfg Config, files map[string]string) *Server {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cfg.Dir = t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(cfg.Dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.DataDir, cfg.MediaDir, cfg.ImageCache = t.TempDir(), t.TempDir(), t.TempDir()
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serveTest sends a request with the bearer token, if any, and the headers given as name, value pairs to the
+// handler of s
+func serveTest(s *Server, method, path, token string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+// wantStatus fails the test unless a response has the status want
+func wantStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status %d, want %d: %s", rec.Code, want, rec.Body)
+	}
+}
+
+// testExam is a valid exam file of two multiple-choice questions
+const testExam = `{"title": "Test", "questions": [
+	{"question": "1 + 1?", "choices": ["1", "2"], "correct": 1},
+	{"question": "2 + 2?", "choices": ["4", "5"], "correct": 0}
+]}`
+
+func TestServeExamBySlug(t *testing.T) {
+	hidden := `{"title": "Finals", "visibility": "instructor", "questions": [{"question": "?", "choices": ["a", "b"], "correct": 0}]}`
+	s := newTestServer(t, Config{RedactAnswers: true, InstructorTokens: []string{"staff"}}, map[string]string{
+		"Math/algebra_1.json": testExam,
+		"Math/finals.json":    hidden,
+	})
+
+	rec := serveTest(s, "GET", "/api/v1/exams/Math/test", "", nil)
+	wantStatus(t, rec, http.StatusOK)
+	var got struct {
+		Subject string           `json:"subject"`
+		Name    string           `json:"name"`
+		Content []map[string]any `json:"content"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "Math" || got.Name != "algebra_1.json" || len(got.Content) != 2 {
+		t.Fatalf("got %s, want the two questions of Math/algebra_1.json", rec.Body)
+	}
+	if _, ok := got.Content[0]["correct"]; ok {
+		t.Errorf("answer key served: %s", rec.Body)
+	}
+
+	wantStatus(t, serveTest(s, "GET", "/api/v1/exams/Math/algebra_1", "", nil), http.StatusOK)
+	wantStatus(t, serveTest(s, "GET", "/api/v1/exams/Math/finals", "", nil), http.StatusNotFound)
+	wantStatus(t, serveTest(s, "GET", "/api/v1/exams/Math/finals", "staff", nil), http.StatusOK)
+}
+
+func TestStaticRootMustNotHoldState(t *testing.T) {
+	root := t.TempDir()
+	web := filepath.Join(root, "web")
+	cfg := Config{Dir: filepath.Join(root, "json"), DataDir: filepath.Join(root, "data"), MediaDir: filepath.Join(root, "media")}
+	for _, static := range []string{root, cfg.DataDir, root + "/./"} {
+		cfg.Static = static
+		if err := checkStaticRoot(cfg); err == nil {
+			t.Errorf("static directory %s holding the server's directories accepted", static)
+		}
+	}
+	cfg.Static = web
+	if err := checkStaticRoot(cfg); err != nil {
+		t.Errorf("dedicated static directory refused: %v", err)
+	}
+	cfg.Static, cfg.DataDir = root, filepath.Join(root, "..", "data")
+	if err := checkStaticRoot(cfg); err == nil {
+		t.Error("static directory holding the exam and media directories accepted")
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Errors returned by session backends
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionConflict = errors.New("session was changed concurrently")
+)
+
+// sessionBackend keeps the state of sessions outside of the server process, so any replica can serve any
+// session. Changes are saved with optimistic locking: a session is only saved over the version it was loaded at.
+type sessionBackend interface {
+	// Load returns the stored session with the given id, or errSessionNotFound
+	Load(id string) (*Session, error)
+	// Save stores session if the stored version is still session.Version, which it then increments, and
+	// returns errSessionConflict otherwise. New sessions have version zero.
+	Save(session *Session) error
+	// Unfinished returns the sessions of a user that are still in progress
+	Unfinished(userID string) ([]*Session, error)
+	// InProgress returns every session still in progress, of all users and anonymous ones
+	InProgress() ([]*Session, error)
+	// All returns every stored session
+	All() ([]*Session, error)
+	// Delete removes a stored session
+	Delete(session *Session) error
+}
+
+// fileSessions keeps each session as a JSON file in a directory, for a single server or replicas sharing the
+// directory through one process at a time
+type fileSessions struct {
+	dir string
+
+	mu         sync.Mutex
+	unfinished map[string]map[string]bool // ids of the sessions in progress by user, "" for anonymous ones
+}
+
+// openFileSessions indexes the sessions saved in dir, creating it if needed
+func openFileSessions(dir string) (*fileSessions, error) {
+	sessions, err := readSessionDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	f := &fileSessions{dir: dir, unfinished: map[string]map[string]bool{}}
+	for _, session := range sessions {
+		f.index(session)
+	}
+	return f, nil
+}
+
+// readSessionDir reads the sessions saved as JSON files in dir, creating it if needed
+func readSessionDir(dir string) ([]*Session, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions directory: %w", err)
+	}
+	var sessions []*Session
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, fmt.Errorf("failed to parse session %s: %w", entry.Name(), err)
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+// index records whether a session is in progress; f.mu must be held unless f is being opened
+func (f *fileSessions) index(session *Session) {
+	ids := f.unfinished[session.UserID]
+	if session.Status == sessionInProgress {
+		if ids == nil {
+			ids = map[string]bool{}
+			f.unfinished[session.UserID] = ids
+		}
+		ids[session.ID] = true
+		return
+	}
+	delete(ids, session.ID)
+	if len(ids) == 0 {
+		delete(f.unfinished, session.UserID)
+	}
+}
+
+// path returns the file of a session
+func (f *fileSessions) path(id string) string {
+	return filepath.Join(f.dir, filepath.Base(id)+".json")
+}
+
+func (f *fileSessions) Load(id string) (*Session, error) {
+	data, err := os.ReadFile(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+func (f *fileSessions) Save(session *Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var stored int64
+	if current, err := f.Load(session.ID); err == nil {
+		stored = current.Version
+	} else if !errors.Is(err, errSessionNotFound) {
+		return err
+	}
+	if stored != session.Version {
+		return errSessionConflict
+	}
+
+	saved := *session
+	saved.Version++
+	data, err := json.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteFileAtomic(f.path(session.ID), data); err != nil {
+		return err
+	}
+	*session = saved
+	f.index(session)
+	return nil
+}
+
+func (f *fileSessions) Unfinished(userID string) ([]*Session, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	f.mu.Lock()
+	ids := slices.Collect(maps.Keys(f.unfinished[userID]))
+	f.mu.Unlock()
+	return f.loadAll(ids)
+}
+
+func (f *fileSessions) InProgress() ([]*Session, error) {
+	f.mu.Lock()
+	var ids []string
+	for _, user := range f.unfinished {
+		ids = slices.AppendSeq(ids, maps.Keys(user))
+	}
+	f.mu.Unlock()
+	return f.loadAll(ids)
+}
+
+func (f *fileSessions) All() ([]*Session, error) {
+	return readSessionDir(f.dir)
+}
+
+func (f *fileSessions) Delete(session *Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(f.path(session.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ids := f.unfinished[session.UserID]
+	delete(ids, session.ID)
+	if len(ids) == 0 {
+		delete(f.unfinished, session.UserID)
+	}
+	return nil
+}
+
+// loadAll loads the sessions with the given ids that still exist
+func (f *fileSessions) loadAll(ids []string) ([]*Session, error) {
+	var sessions []*Session
+	for _, id := range ids {
+		session, err := f.Load(id)
+		if errors.Is(err, errSessionNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// redisSaveSessionScript saves the session data ARGV[3] in the hash KEYS[1] if its version is still ARGV[1],
+// setting it to ARGV[2]. The session id ARGV[4] is added to the set KEYS[2] of all sessions in progress and,
+// unless ARGV[5] is empty, to the set KEYS[3] of those of its user if ARGV[6] is 1, or removed from them
+// otherwise. It returns 1 if the session was saved.
+const redisSaveSessionScript = `
+local version = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if version ~= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('HSET', KEYS[1], 'version', ARGV[2], 'data', ARGV[3])
+local op = 'SREM'
+if ARGV[6] == '1' then
+  op = 'SADD'
+end
+redis.call(op, KEYS[2], ARGV[4])
+if ARGV[5] ~= '' then
+  redis.call(op, KEYS[3], ARGV[4])
+end
+return 1
+`
+
+// redisSessions keeps sessions in a Redis shared by replicas, as a hash of the version and data of each session
+// with sets of the sessions in progress, of all users and of each user
+type redisSessions struct {
+	client *redisClient
+	dir    string // where sessions are imported from
+}
+
+// openRedisSessions creates a backend keeping sessions in client, importing the sessions saved in dir that are
+// not there yet, such as those of a server that ran without Redis before
+func openRedisSessions(client *redisClient, dir string) (*redisSessions, error) {
+	r := &redisSessions{client: client, dir: dir}
+	sessions, err := readSessionDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	imported := 0
+	for _, session := range sessions {
+		session.Version = 0
+		switch err := r.Save(session); {
+		case err == nil:
+			imported++
+		case !errors.Is(err, errSessionConflict):
+			return nil, fmt.Errorf("failed to import session %s: %w", session.ID, err)
+		}
+	}
+	if imported > 0 {
+		log.Printf("Imported %d session(s) from %s into Redis", imported, dir)
+	}
+	return r, nil
+}
+
+func (r *redisSessions) Load(id string) (*Session, error) {
+	reply, err := r.client.do("HGET", r.client.key("session", id), "data")
+	if err != nil {
+		return nil, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, errSessionNotFound
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+func (r *redisSessions) Save(session *Session) error {
+	saved := *session
+	saved.Version++
+	data, err := json.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+	inProgress := "0"
+	if session.Status == sessionInProgress {
+		inProgress = "1"
+	}
+	reply, err := r.client.do("EVAL", redisSaveSessionScript, "3",
+		r.client.key("session", session.ID), r.client.key("sessions-in-progress"), r.client.key("user-sessions", session.UserID),
+		strconv.FormatInt(session.Version, 10), strconv.FormatInt(saved.Version, 10), string(data), session.ID, session.UserID, inProgress)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n != 1 {
+		return errSessionConflict
+	}
+	*session = saved
+	return nil
+}
+
+func (r *redisSessions) Unfinished(userID string) ([]*Session, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	return r.members(r.client.key("user-sessions", userID))
+}
+
+func (r *redisSessions) InProgress() ([]*Session, error) {
+	return r.members(r.client.key("sessions-in-progress"))
+}
+
+// members loads the sessions in progress listed in the set at key
+func (r *redisSessions) members(key string) ([]*Session, error) {
+	reply, err := r.client.do("SMEMBERS", key)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]any)
+	var sessions []*Session
+	for _, item := range items {
+		id, _ := item.([]byte)
+		session, err := r.Load(string(id))
+		if errors.Is(err, errSessionNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if session.Status == sessionInProgress {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *redisSessions) All() ([]*Session, error) {
+	prefix := r.client.key("session", "")
+	var sessions []*Session
+	for cursor := "0"; ; {
+		reply, err := r.client.do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("unexpected reply %v to SCAN", reply)
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]any)
+		for _, key := range keys {
+			name, _ := key.([]byte)
+			session, err := r.Load(strings.TrimPrefix(string(name), prefix))
+			if errors.Is(err, errSessionNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			sessions = append(sessions, session)
+		}
+		if cursor = string(next); cursor == "0" {
+			return sessions, nil
+		}
+	}
+}
+
+func (r *redisSessions) Delete(session *Session) error {
+	if _, err := r.client.do("SREM", r.client.key("sessions-in-progress"), session.ID); err != nil {
+		return err
+	}
+	if session.UserID != "" {
+		if _, err := r.client.do("SREM", r.client.key("user-sessions", session.UserID), session.ID); err != nil {
+			return err
+		}
+	}
+	if err := r.client.Del(r.client.key("session", session.ID)); err != nil {
+		return err
+	}
+	// The file a session was imported from would bring it back on the next start
+	if err := os.Remove(filepath.Join(r.dir, filepath.Base(session.ID)+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// newTestSessions returns a session store kept in a temporary directory, holding one session in progress with
+// the given number of unanswered questions
+func newTestSessions(t *testing.T, questions int) (*sessionStore, *fileSessions, *Session) {
+	t.Helper()
+	backend, err := openFileSessions(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openSessionStore(backend, time.Second, ConcurrentModes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{ID: exam.NewID(), Subject: "Math", Exam: "algebra.json", Status: sessionInProgress, Answers: make([]exam.Answer, questions)}
+	if err := backend.Save(session); err != nil {
+		t.Fatal(err)
+	}
+	return store, backend, session
+}
+
+func TestFileSessionsSaveRefusesStaleVersion(t *testing.T) {
+	_, backend, session := newTestSessions(t, 2)
+	if session.Version != 1 {
+		t.Fatalf("version after the first save = %d, want 1", session.Version)
+	}
+
+	stale, err := backend.Load(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Answers[0] = exam.ChoiceAnswer(1)
+	if err := backend.Save(session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Version != 2 {
+		t.Fatalf("version after the second save = %d, want 2", session.Version)
+	}
+
+	stale.Answers[1] = exam.ChoiceAnswer(0)
+	if err := backend.Save(stale); !errors.Is(err, errSessionConflict) {
+		t.Fatalf("saving a stale session: %v, want errSessionConflict", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("refused save changed the version to %d", stale.Version)
+	}
+	stored, err := backend.Load(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Answers[0] == nil || stored.Answers[1] != nil {
+		t.Errorf("stored answers = %s, want only the first save's", stored.Answers)
+	}
+
+	// A new session cannot overwrite a stored one of the same id
+	if err := backend.Save(&Session{ID: session.ID, Status: sessionInProgress}); !errors.Is(err, errSessionConflict) {
+		t.Errorf("saving over a stored session as new: %v, want errSessionConflict", err)
+	}
+}
+
+func TestSessionUpdateRetriesOnConflict(t *testing.T) {
+	store, backend, session := newTestSessions(t, 2)
+
+	// The first attempt of the change races another request that saves in between
+	calls := 0
+	updated, err := store.update(session.ID, func(s *Session) error {
+		calls++
+		if calls == 1 {
+			other, err := backend.Load(session.ID)
+			if err != nil {
+				return err
+			}
+			other.Answers[0] = exam.ChoiceAnswer(1)
+			if err := backend.Save(other); err != nil {
+				return err
+			}
+		}
+		s.Answers[1] = exam.ChoiceAnswer(0)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("change applied %d times, want 2", calls)
+	}
+	if updated.Answers[0] == nil || updated.Answers[1] == nil {
+		t.Errorf("answers = %s, want both saves kept", updated.Answers)
+	}
+	if updated.Version != 3 {
+		t.Errorf("version = %d, want 3", updated.Version)
+	}
+}
+
+func TestSessionUpdateConcurrentAnswers(t *testing.T) {
+	// Every concurrent change is saved before the retries run out, one per question
+	store, backend, session := newTestSessions(t, sessionRetries)
+	var wg sync.WaitGroup
+	errs := make([]error, sessionRetries)
+	for i := range sessionRetries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = store.update(session.ID, func(s *Session) error {
+				s.Answers[i] = exam.ChoiceAnswer(i)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("update %d: %v", i, err)
+		}
+	}
+
+	stored, err := backend.Load(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range stored.Answers {
+		if choice, ok := a.Choice(); !ok || choice != i {
+			t.Errorf("answer %d = %s, want %d", i, a, i)
+		}
+	}
+	if stored.Version != int64(1+sessionRetries) {
+		t.Errorf("version = %d, want %d", stored.Version, 1+sessionRetries)
+	}
+}
+
+func TestSessionUpdateSavesNothingOnFailedChange(t *testing.T) {
+	store, backend, session := newTestSessions(t, 1)
+	failed := errors.New("refused")
+	if _, err := store.update(session.ID, func(s *Session) error {
+		s.Answers[0] = exam.ChoiceAnswer(0)
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("update: %v, want the error of the change", err)
+	}
+	stored, err := backend.Load(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != 1 || stored.Answers[0] != nil {
+		t.Errorf("failed change was saved: version %d, answers %s", stored.Version, stored.Answers)
+	}
+}
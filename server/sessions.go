@@ -0,0 +1,567 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// Session states
+const (
+	sessionInProgress = "in_progress"
+	sessionSubmitted  = "submitted"
+	sessionExpired    = "expired" // left idle for longer than the session sweep allows
+)
+
+// ConcurrentModes lists how a session opened in a second window is handled:
+// allow shares it, block refuses the second window while the first is active, takeover moves it to the second window
+var ConcurrentModes = []string{"allow", "block", "takeover"}
+
+// sessionActiveWindow is how long after its last request a window still counts as holding its session
+const sessionActiveWindow = 2 * time.Minute
+
+// Errors returned when a window may not use a session held by another one
+var (
+	errSessionActive    = errors.New("session is active in another window")
+	errSessionTakenOver = errors.New("session was taken over by another window")
+)
+
+// Session is an exam being taken, whose answers are saved as they are given so it can be resumed
+type Session struct {
+	ID        string          `json:"id"`
+	UserID    string          `json:"userId,omitempty"`
+	Subject   string          `json:"subject"`
+	Exam      string          `json:"exam"`
+	ExamID    string          `json:"examId,omitempty"` // finds the exam again after its file is renamed
+	Variant   *VariantOptions `json:"variant,omitempty"`
+	LTILaunch string          `json:"ltiLaunch,omitempty"`
+	Status    string          `json:"status"`
+	StartedAt time.Time       `json:"startedAt"`
+	SavedAt   time.Time       `json:"savedAt,omitzero"`    // when answers were last saved
+	Answers   []exam.Answer   `json:"answers"`             // in exam order, or paper order for variants
+	TimeSpent []float64       `json:"timeSpent,omitempty"` // seconds spent on each question, in the order of Answers
+	Pauses    []SessionPause  `json:"pauses,omitempty"`    // pause history of untimed sessions, oldest first
+	Events    []ProctorEvent  `json:"events,omitempty"`    // integrity events reported by the client, oldest first
+	AttemptID string          `json:"attemptId,omitempty"`
+	SubmitKey string          `json:"submitKey,omitempty"` // Idempotency-Key of the submission, answered again on retries
+	Sitting   int             `json:"sitting,omitempty"`   // access code sitting the session was started in
+	Client    string          `json:"client,omitempty"`    // window currently holding the session
+	LastSeen  time.Time       `json:"lastSeen,omitzero"`   // last request from that window
+	Version   int64           `json:"version"`             // incremented on every save, for optimistic locking
+}
+
+// sessionResponse is a session together with the autosave settings clients should use
+type sessionResponse struct {
+	*Session
+	AutosaveDebounceMs int64   `json:"autosaveDebounceMs"`
+	Paused             bool    `json:"paused,omitempty"`
+	ElapsedSeconds     float64 `json:"elapsedSeconds,omitempty"` // time taken so far without the pauses, while in progress
+}
+
+// sessionStore serves the sessions kept by its backend. No session state is held in memory, so any replica
+// sharing the backend can serve any request of a session.
+type sessionStore struct {
+	backend     sessionBackend
+	debounce    time.Duration
+	concurrency string
+
+	mu        sync.Mutex
+	listeners []func(Session)
+	submitted []func(Session)
+}
+
+// sessionRetries is how many times a change to a session is tried again after losing to a concurrent change
+const sessionRetries = 5
+
+// Errors returned by changes to sessions
+var (
+	errSessionSubmitted = errors.New("session already submitted")
+	errSessionExpired   = errors.New("session expired")
+	errInvalidAnswers   = errors.New("invalid question index")
+)
+
+// openSessionStore creates a store for the sessions kept by backend
+func openSessionStore(backend sessionBackend, debounce time.Duration, concurrency string) (*sessionStore, error) {
+	if !slices.Contains(ConcurrentModes, concurrency) {
+		return nil, fmt.Errorf("unknown concurrent session mode %q (expected %s)", concurrency, strings.Join(ConcurrentModes, ", "))
+	}
+	return &sessionStore{backend: backend, debounce: debounce, concurrency: concurrency}, nil
+}
+
+// update applies change to the stored session with the given id and saves it, starting over from the newly
+// stored version when another request changed the session in between. Nothing is saved if change fails.
+func (s *sessionStore) update(id string, change func(*Session) error) (*Session, error) {
+	for range sessionRetries {
+		session, err := s.backend.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := change(session); err != nil {
+			return nil, err
+		}
+		if err := s.backend.Save(session); !errors.Is(err, errSessionConflict) {
+			return session, err
+		}
+	}
+	return nil, errSessionConflict
+}
+
+// ByUser returns the sessions of a user in any state
+func (s *sessionStore) ByUser(user string) ([]*Session, error) {
+	all, err := s.backend.All()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(all, func(session *Session) bool { return session.UserID != user }), nil
+}
+
+// EraseUser deletes the sessions of a user in any state
+func (s *sessionStore) EraseUser(user string) error {
+	sessions, err := s.ByUser(user)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := s.backend.Delete(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOpen returns why a session can no longer be changed, or nil while it is in progress
+func checkOpen(session *Session) error {
+	switch session.Status {
+	case sessionInProgress:
+		return nil
+	case sessionExpired:
+		return errSessionExpired
+	}
+	return errSessionSubmitted
+}
+
+// sessionError writes the response to a session request that failed with err
+func sessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		http.Error(w, "Session not found", http.StatusNotFound)
+	case errors.Is(err, errSessionSubmitted):
+		http.Error(w, "Session already submitted", http.StatusConflict)
+	case errors.Is(err, errSessionExpired):
+		http.Error(w, "Session expired", http.StatusGone)
+	case errors.Is(err, errSessionPaused):
+		http.Error(w, "Session is paused", http.StatusConflict)
+	case errors.Is(err, errNotPaused):
+		http.Error(w, "Session is not paused", http.StatusConflict)
+	case errors.Is(err, errTimedSession):
+		http.Error(w, "Timed exams cannot be paused", http.StatusConflict)
+	case errors.Is(err, errSessionActive), errors.Is(err, errSessionTakenOver):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errSessionConflict):
+		http.Error(w, "Session is being changed by another request, try again", http.StatusConflict)
+	default:
+		http.Error(w, "Failed to save session: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// claim records that client is using a session, refusing it when another window holds the session.
+// Only starting, which is an explicit choice of the learner, can take a session over; a client that
+// does not identify itself is never refused.
+func (s *sessionStore) claim(session *Session, client string, starting bool) error {
+	if client == "" {
+		return nil
+	}
+	now := time.Now().UTC()
+	other := session.Client != "" && session.Client != client
+	if other && s.concurrency != "allow" {
+		active := now.Sub(session.LastSeen) < sessionActiveWindow
+		switch {
+		case s.concurrency == "takeover" && starting:
+			log.Printf("Session %s of user %s taken over by another window", session.ID, session.UserID)
+		case s.concurrency == "takeover":
+			return errSessionTakenOver
+		case active:
+			log.Printf("Blocked second window for active session %s of user %s", session.ID, session.UserID)
+			return errSessionActive
+		}
+	}
+	session.Client, session.LastSeen = client, now
+	return nil
+}
+
+// respond writes a session with the autosave settings
+func (s *sessionStore) respond(w http.ResponseWriter, status int, session *Session) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	resp := sessionResponse{Session: session, AutosaveDebounceMs: s.debounce.Milliseconds(), Paused: session.paused()}
+	if session.Status == sessionInProgress {
+		resp.ElapsedSeconds = session.elapsed(time.Now().UTC()).Seconds()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// registerSessionRoutes adds the session endpoints to api, the group of routes under /api
+func registerSessionRoutes(api *router, sessions *sessionStore, store *examStore, attempts *attemptStore, codes *accessCodeStore, groups *groupStore, access examAccess) {
+	api.HandleFunc("POST /sessions", sessions.start(store, codes, groups, access))
+	api.HandleFunc("GET /sessions/{id}", sessions.get)
+	api.HandleFunc("PATCH /sessions/{id}/answers", sessions.saveAnswers)
+	api.HandleFunc("POST /sessions/{id}/pause", sessions.pause(store, true))
+	api.HandleFunc("POST /sessions/{id}/resume", sessions.pause(store, false))
+	api.HandleFunc("POST /sessions/{id}/events", sessions.recordEvents)
+	api.HandleFunc("POST /sessions/{id}/submit", sessions.submit(store, attempts))
+	api.HandleFunc("GET /sessions/{id}/report", serveScoreReport(store, sessions, attempts, false))
+	api.HandleFunc("GET /sessions/{id}/report.pdf", serveScoreReport(store, sessions, attempts, true))
+}
+
+// sessionStart is the body of a request to start or resume a session
+type sessionStart struct {
+	Session
+	AccessCode string `json:"accessCode"`
+}
+
+// sessionFor reports whether a session is of exam e of subject, under its current name or, if the file was
+// renamed since the session started, by its id
+func sessionFor(session *Session, subject string, e exam.ExamFile) bool {
+	return (session.ExamID != "" && session.ExamID == e.ID) || (session.Subject == subject && session.Exam == e.Name)
+}
+
+// takeError returns the status and message refusing a request for user to take exam e of subject with an access
+// code, or 0 if they may take it: exams not visible to the user are not found, exams assigned to groups are only
+// for their members and exams with an access code need the code of the current sitting
+func takeError(r *http.Request, access examAccess, codes *accessCodeStore, user, subject string, e exam.ExamFile, code string) (int, string) {
+	if !access.canSee(r, user, subject, e) {
+		return http.StatusNotFound, "Exam not found"
+	}
+	if !access.groups.CanTake(user, ExamRef{Subject: subject, Name: e.Name}) {
+		return http.StatusForbidden, "Exam is assigned to groups you are not a member of"
+	}
+	if !codes.Check(subject, e.Name, code) {
+		return http.StatusForbidden, "Access code required"
+	}
+	return 0, ""
+}
+
+// start returns a handler that opens a session, resuming the user's unfinished session of the exam if there is one.
+// Exams with an access code can only be started or resumed with the code of the current sitting,
+// exams assigned to groups only by their members, and exams not visible to the user as if they did not exist.
+func (s *sessionStore) start(store *examStore, codes *accessCodeStore, groups *groupStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req sessionStart
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e, ok := exam.FindExam(subjects, req.Subject, req.Exam)
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		if status, msg := takeError(r, access, codes, req.UserID, req.Subject, e, req.AccessCode); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		code, coded := codes.Get(req.Subject, e.Name)
+
+		// Anonymous sessions cannot be matched to a returning user and are always new
+		if req.UserID != "" {
+			unfinished, err := s.backend.Unfinished(req.UserID)
+			if err != nil {
+				http.Error(w, "Failed to read sessions: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if i := slices.IndexFunc(unfinished, func(existing *Session) bool { return sessionFor(existing, req.Subject, e) }); i >= 0 {
+				session, err := s.update(unfinished[i].ID, func(session *Session) error {
+					if err := checkOpen(session); err != nil {
+						return err
+					}
+					return s.claim(session, req.Client, true)
+				})
+				// A session submitted or expired in the meantime is followed by a new one
+				if err == nil {
+					s.respond(w, http.StatusOK, session)
+					return
+				} else if !errors.Is(err, errSessionSubmitted) && !errors.Is(err, errSessionExpired) {
+					sessionError(w, err)
+					return
+				}
+			}
+		}
+
+		// Sessions already started in a closed exam can still be resumed and submitted
+		if store.Closed(req.Subject, e.Name) {
+			http.Error(w, "Exam is closed", http.StatusForbidden)
+			return
+		}
+
+		questions := len(exam.Questions(e.Content))
+		if req.Variant != nil {
+			questions = len(newExamVariant(req.UserID, req.Subject, e, *req.Variant).order)
+		}
+		session := &Session{
+			ID:        newID(),
+			UserID:    req.UserID,
+			Subject:   req.Subject,
+			Exam:      e.Name,
+			ExamID:    e.ID,
+			Variant:   req.Variant,
+			LTILaunch: req.LTILaunch,
+			Status:    sessionInProgress,
+			StartedAt: time.Now().UTC(),
+			Answers:   make([]exam.Answer, questions),
+		}
+		if coded {
+			session.Sitting = code.Sitting
+		}
+		s.claim(session, req.Client, true)
+		if err := s.backend.Save(session); err != nil {
+			http.Error(w, "Failed to save session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		listeners := slices.Clone(s.listeners)
+		s.mu.Unlock()
+		for _, fn := range listeners {
+			fn(*session)
+		}
+		s.respond(w, http.StatusCreated, session)
+	}
+}
+
+// resumable reports whether user has an unfinished session of exam e of subject
+func (s *sessionStore) resumable(user, subject string, e exam.ExamFile) bool {
+	if user == "" {
+		return false
+	}
+	unfinished, err := s.backend.Unfinished(user)
+	return err == nil && slices.ContainsFunc(unfinished, func(session *Session) bool { return sessionFor(session, subject, e) })
+}
+
+// OnStart registers fn to be called with every new session started from now on; resumed sessions are not new
+func (s *sessionStore) OnStart(fn func(Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// OnSubmit registers fn to be called with every session submitted and graded from now on
+func (s *sessionStore) OnSubmit(fn func(Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.submitted = append(s.submitted, fn)
+}
+
+// get returns a session so an interrupted exam can be resumed
+func (s *sessionStore) get(w http.ResponseWriter, r *http.Request) {
+	session, err := s.backend.Load(r.PathValue("id"))
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	s.respond(w, http.StatusOK, session)
+}
+
+// saveAnswers merges partial answers into a session. The body maps question indexes to the chosen
+// choice or custom answer, or null to clear an answer, so clients only send what changed since the last save.
+// Clients may report the seconds spent on questions since the last save in timeSpent, keyed the same way;
+// otherwise the time since the last save is shared out between the questions answered in this one.
+func (s *sessionStore) saveAnswers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Answers   map[string]exam.Answer `json:"answers"`
+		TimeSpent map[string]float64     `json:"timeSpent"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	answers := map[int]exam.Answer{}
+	for key, answer := range req.Answers {
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 {
+			http.Error(w, "Invalid question index "+strings.TrimSpace(key), http.StatusBadRequest)
+			return
+		}
+		answers[i] = answer
+	}
+	times := map[int]float64{}
+	for key, t := range req.TimeSpent {
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 {
+			http.Error(w, "Invalid question index "+strings.TrimSpace(key), http.StatusBadRequest)
+			return
+		}
+		if !(t >= 0) || math.IsInf(t, 0) {
+			http.Error(w, "Invalid time spent on question "+key, http.StatusBadRequest)
+			return
+		}
+		times[i] = t
+	}
+
+	invalid := -1
+	session, err := s.update(r.PathValue("id"), func(session *Session) error {
+		if err := checkAnswerable(session); err != nil {
+			return err
+		}
+		if err := s.claim(session, r.Header.Get("X-Session-Client"), false); err != nil {
+			return err
+		}
+		for i, answer := range answers {
+			if i >= len(session.Answers) {
+				invalid = i
+				return errInvalidAnswers
+			}
+			session.Answers[i] = answer
+		}
+		now := time.Now().UTC()
+		if len(req.TimeSpent) == 0 {
+			times = sharedTime(answers, now.Sub(session.lastActive()))
+		}
+		for i, t := range times {
+			if i >= len(session.Answers) {
+				invalid = i
+				return errInvalidAnswers
+			}
+			if session.TimeSpent == nil {
+				session.TimeSpent = make([]float64, len(session.Answers))
+			}
+			session.TimeSpent[i] += t
+		}
+		session.SavedAt = now
+		return nil
+	})
+	if errors.Is(err, errInvalidAnswers) {
+		http.Error(w, "Invalid question index "+strconv.Itoa(invalid), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		sessionError(w, err)
+		return
+	}
+	s.respond(w, http.StatusOK, session)
+}
+
+// replaySubmission answers a retried submission of a session with the attempt its first try recorded
+func replaySubmission(w http.ResponseWriter, attempts *attemptStore, session Session) {
+	// The first try closes the session before grading it and only then records the attempt
+	if session.AttemptID == "" {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Submission is still being graded, try again", http.StatusConflict)
+		return
+	}
+	list := attempts.List(func(a Attempt) bool { return a.ID == session.AttemptID })
+	if len(list) == 0 {
+		http.Error(w, "Session already submitted", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(list[0])
+}
+
+// sharedTime shares the time between two saves out evenly between the questions answered in the second one
+func sharedTime(answers map[int]exam.Answer, elapsed time.Duration) map[int]float64 {
+	var answered []int
+	for i, answer := range answers {
+		if answer != nil {
+			answered = append(answered, i)
+		}
+	}
+	times := make(map[int]float64, len(answered))
+	for _, i := range answered {
+		times[i] = elapsed.Seconds() / float64(len(answered))
+	}
+	return times
+}
+
+// maxIdempotencyKey is the longest Idempotency-Key header accepted
+const maxIdempotencyKey = 255
+
+// errSubmitRetried is returned to the submit handler for a submission retried with the key of the first one
+var errSubmitRetried = errors.New("submission retried")
+
+// submit returns a handler that grades the saved answers of a session and closes it. A submission carrying an
+// Idempotency-Key header is answered with the attempt it recorded when sent again with the same key, so clients
+// can retry submissions whose response was lost.
+func (s *sessionStore) submit(store *examStore, attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key must not be longer than "+strconv.Itoa(maxIdempotencyKey)+" characters", http.StatusBadRequest)
+			return
+		}
+
+		// The session is closed before it is graded, so concurrent submissions through any replica record a
+		// single attempt
+		var retried Session
+		session, err := s.update(r.PathValue("id"), func(session *Session) error {
+			if key != "" && session.Status == sessionSubmitted && session.SubmitKey == key {
+				retried = *session
+				return errSubmitRetried
+			}
+			if err := checkAnswerable(session); err != nil {
+				return err
+			}
+			if err := s.claim(session, r.Header.Get("X-Session-Client"), false); err != nil {
+				return err
+			}
+			session.Status = sessionSubmitted
+			session.SubmitKey = key
+			return nil
+		})
+		if errors.Is(err, errSubmitRetried) {
+			replaySubmission(w, attempts, retried)
+			return
+		}
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+
+		a, ok := recordSubmission(w, r, store, attempts, submission{
+			Subject:   session.Subject,
+			Exam:      session.Exam,
+			ExamID:    session.ExamID,
+			UserID:    session.UserID,
+			StartedAt: session.StartedAt,
+			Answers:   session.Answers,
+			TimeSpent: session.TimeSpent,
+			LTILaunch: session.LTILaunch,
+			Variant:   session.Variant,
+		})
+		// A submission that could not be recorded reopens the session so it can be submitted again
+		saved, err := s.update(session.ID, func(session *Session) error {
+			if ok {
+				session.AttemptID = a.ID
+			} else {
+				session.Status = sessionInProgress
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to save submitted session %s: %v", session.ID, err)
+			return
+		}
+		if ok {
+			s.mu.Lock()
+			listeners := slices.Clone(s.submitted)
+			s.mu.Unlock()
+			for _, fn := range listeners {
+				fn(*saved)
+			}
+		}
+	}
+}
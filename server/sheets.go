@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// importSheetUpload returns a handler that converts an uploaded spreadsheet, or a Google Sheets link, into an
+// exam, which is a draft unless it replaces one
+func importSheetUpload(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, exam.MaxSheetSize+1<<20)
+		if err := r.ParseMultipartForm(exam.MaxSheetSize); err != nil {
+			http.Error(w, "Invalid upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		subject, name := r.FormValue("subject"), r.FormValue("name")
+		if !exam.ValidSubjectPath(subject) {
+			http.Error(w, "Missing or invalid \"subject\" form field", http.StatusBadRequest)
+			return
+		}
+		if !scopeOf(r).allows(subject) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		tmpl := exam.DefaultSheetTemplate
+		if raw := r.FormValue("template"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &tmpl); err != nil {
+				http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var rows [][]string
+		var err error
+		if link := r.FormValue("url"); link != "" {
+			// Only Google Sheets links are fetched so the endpoint cannot be used to probe other hosts
+			if u, perr := url.Parse(link); perr != nil || u.Scheme != "https" || u.Host != "docs.google.com" {
+				http.Error(w, "Only https://docs.google.com spreadsheet links can be imported", http.StatusBadRequest)
+				return
+			}
+			var data []byte
+			if data, err = exam.FetchSheet(link); err == nil {
+				rows, err = exam.ParseSheet(data, ".csv")
+			}
+			if name == "" {
+				name = exam.SheetName(link)
+			}
+		} else {
+			file, header, ferr := r.FormFile("file")
+			if ferr != nil {
+				http.Error(w, "Provide a \"file\" upload or a \"url\" field", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			var data []byte
+			if data, err = io.ReadAll(file); err == nil {
+				rows, err = exam.ParseSheet(data, strings.ToLower(path.Ext(header.Filename)))
+			}
+			if name == "" {
+				name = exam.SheetName(header.Filename)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		questions, problems := exam.SheetToQuestions(rows, tmpl)
+		if len(problems) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]any{"problems": problems})
+			return
+		}
+		if !exam.IsExamFile(name) {
+			name += ".json"
+		}
+
+		force := r.FormValue("overwrite") == "true"
+		ref := ExamRef{Subject: subject, Name: name}
+		_, err = os.Stat(filepath.Join(store.dir, filepath.FromSlash(subject), name))
+		added := errors.Is(err, os.ErrNotExist)
+		if added {
+			if err := drafts.Add(ref); err != nil {
+				http.Error(w, "Failed to save draft: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := exam.Import(store.dir, subject, exam.ExamFile{Name: name, Content: questions}, force); err != nil {
+			if added {
+				drafts.Discard(ref)
+			}
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if store.cached {
+			if err := store.Reload(context.WithoutCancel(r.Context())); err != nil {
+				log.Printf("Failed to reload exams after sheet import: %v", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"subject": subject, "name": name, "questionCount": len(questions)})
+	}
+}
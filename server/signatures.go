@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// signatureCheck holds the keys exam files are signed with; without keys signatures are not checked
+type signatureCheck struct {
+	keys     []exam.PublicKey
+	require  bool      // leave out exams whose signature does not verify
+	rejected *sync.Map // exam files already logged as rejected, by path and hash
+}
+
+// loadSignatureCheck reads the trusted public keys from the file at path, if one is given
+func loadSignatureCheck(path string, require bool) (signatureCheck, error) {
+	if path == "" {
+		if require {
+			return signatureCheck{}, errors.New("requiring exam signatures needs a file of signing keys")
+		}
+		return signatureCheck{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signatureCheck{}, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+	keys, err := exam.ParsePublicKeys(data)
+	if err != nil {
+		return signatureCheck{}, fmt.Errorf("failed to parse signing keys %s: %w", path, err)
+	}
+	return signatureCheck{keys: keys, require: require, rejected: &sync.Map{}}, nil
+}
+
+// apply marks every exam read from dir with its signature state and, when signatures are required, leaves out
+// the exams that are not verified
+func (c signatureCheck) apply(dir string, subjects []exam.Subject) []exam.Subject {
+	if len(c.keys) == 0 {
+		return subjects
+	}
+	exam.CheckSignatures(dir, subjects, c.keys)
+	if !c.require {
+		return subjects
+	}
+	return c.dropUnverified(subjects)
+}
+
+// dropUnverified returns a copy of the subject tree without the exams whose signature is not verified. Each
+// rejected file is logged once, rather than on every read of the exam directory.
+func (c signatureCheck) dropUnverified(subjects []exam.Subject) []exam.Subject {
+	out := make([]exam.Subject, len(subjects))
+	for i, s := range subjects {
+		out[i] = s
+		out[i].Exams = make([]exam.ExamFile, 0, len(s.Exams))
+		for _, e := range s.Exams {
+			if e.Signature == exam.SignatureVerified {
+				out[i].Exams = append(out[i].Exams, e)
+				continue
+			}
+			if _, logged := c.rejected.LoadOrStore(exam.SubjectID(s)+"/"+e.Name+":"+e.SHA256, true); !logged {
+				log.Printf("Rejected exam %s/%s: signature %s", exam.SubjectID(s), e.Name, e.Signature)
+			}
+		}
+		out[i].Subjects = c.dropUnverified(s.Subjects)
+	}
+	return out
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Settings of the similarity analysis
+const (
+	defaultSimilarityThreshold = 0.8 // score from which a pair of submissions is flagged
+	minSharedWrong             = 3   // wrong answers between two submissions below which matching ones mean little
+	minEssayWords              = 20  // words an essay needs before it is compared
+	essayShingle               = 3   // length of the word sequences compared between essays
+)
+
+// Statuses of a similarity flag in the review queue
+const (
+	similarityOpen      = "open"
+	similarityConfirmed = "confirmed"
+	similarityDismissed = "dismissed"
+)
+
+var errSimilarityNotFound = errors.New("similarity flag not found")
+
+// SimilarityFlag is a pair of submissions to the same exam sitting that are suspiciously alike
+type SimilarityFlag struct {
+	ID          string    `json:"id"`
+	Subject     string    `json:"subject"`
+	Exam        string    `json:"exam"`
+	ExamID      string    `json:"examId,omitempty"`
+	Sitting     string    `json:"sitting"` // access code sitting, or the day of submission for exams without one
+	Attempts    [2]string `json:"attempts"`
+	Users       [2]string `json:"users"`
+	AnswerScore float64   `json:"answerScore"` // share of the wrong answers of either submission that are the same wrong answer
+	SharedWrong int       `json:"sharedWrong"` // number of those identical wrong answers
+	EssayScore  float64   `json:"essayScore"`  // overlap of the most alike pair of essay answers, from 0 to 1
+	EssayIndex  *int      `json:"essayIndex,omitempty"`
+	DetectedAt  time.Time `json:"detectedAt"`
+	Status      string    `json:"status"`
+	ClosedAt    time.Time `json:"closedAt,omitzero"`
+	Note        string    `json:"note,omitempty"`
+}
+
+// similarityStore keeps the similarity flags raised by the analysis job in a JSON file, keyed by the pair of
+// attempts so the job can run again without raising a reviewed pair anew
+type similarityStore struct {
+	path string
+
+	mu    sync.RWMutex
+	flags map[string]*SimilarityFlag
+}
+
+// openSimilarityStore loads the similarity flags saved at path
+func openSimilarityStore(path string) (*similarityStore, error) {
+	s := &similarityStore{path: path, flags: map[string]*SimilarityFlag{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read similarity flags: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.flags); err != nil {
+		return nil, fmt.Errorf("failed to parse similarity flags: %w", err)
+	}
+	return s, nil
+}
+
+// save writes every flag; the caller holds the lock
+func (s *similarityStore) save() error {
+	data, err := json.MarshalIndent(s.flags, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// pairKey identifies a pair of attempts in either order
+func pairKey(a, b string) string {
+	return min(a, b) + ":" + max(a, b)
+}
+
+// Raise records the flags of new pairs and updates the scores of open ones, leaving reviewed pairs alone. It
+// returns how many pairs were new.
+func (s *similarityStore) Raise(flags []SimilarityFlag) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := maps.Clone(s.flags)
+	added := 0
+	for _, f := range flags {
+		key := pairKey(f.Attempts[0], f.Attempts[1])
+		switch existing := s.flags[key]; {
+		case existing == nil:
+			f.ID, f.Status = newID(), similarityOpen
+			s.flags[key] = &f
+			added++
+		case existing.Status == similarityOpen:
+			f.ID, f.Status = existing.ID, similarityOpen
+			s.flags[key] = &f
+		}
+	}
+	if err := s.save(); err != nil {
+		s.flags = prev
+		return 0, err
+	}
+	return added, nil
+}
+
+// List returns the flags with a status, or every flag when status is empty, highest score first
+func (s *similarityStore) List(status string) []SimilarityFlag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []SimilarityFlag{}
+	for _, f := range s.flags {
+		if status == "" || f.Status == status {
+			out = append(out, *f)
+		}
+	}
+	slices.SortFunc(out, func(a, b SimilarityFlag) int {
+		return cmp.Or(cmp.Compare(max(b.AnswerScore, b.EssayScore), max(a.AnswerScore, a.EssayScore)), cmp.Compare(a.ID, b.ID))
+	})
+	return out
+}
+
+// Close confirms or dismisses a flag with an optional note; flags outside scope are not found
+func (s *similarityStore) Close(id, status, note string, scope subjectScope) (SimilarityFlag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.flags {
+		if f.ID != id || !scope.allows(f.Subject) {
+			continue
+		}
+		prev := *f
+		f.Status, f.Note, f.ClosedAt = status, note, time.Now().UTC()
+		if err := s.save(); err != nil {
+			*f = prev
+			return SimilarityFlag{}, err
+		}
+		return *f, nil
+	}
+	return SimilarityFlag{}, errSimilarityNotFound
+}
+
+// EraseUser removes a user from the flags naming them, which stay in the review queue
+func (s *similarityStore) EraseUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := make(map[string]SimilarityFlag)
+	for key, f := range s.flags {
+		for i := range f.Users {
+			if f.Users[i] == user {
+				if _, ok := prev[key]; !ok {
+					prev[key] = *f
+				}
+				f.Users[i] = ""
+			}
+		}
+	}
+	if len(prev) == 0 {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		for key, f := range prev {
+			*s.flags[key] = f
+		}
+		return err
+	}
+	return nil
+}
+
+// analyzeSimilarity compares the submissions to every exam sitting pairwise and raises the pairs scoring at least
+// threshold. Each user's last attempt in a sitting stands for them; anonymous attempts are left out.
+func analyzeSimilarity(sessions *sessionStore, attempts *attemptStore, flags *similarityStore, threshold float64, now time.Time) error {
+	all, err := sessions.backend.All()
+	if err != nil {
+		return err
+	}
+	sittings := map[string]int{}
+	for _, session := range all {
+		if session.AttemptID != "" && session.Sitting > 0 {
+			sittings[session.AttemptID] = session.Sitting
+		}
+	}
+
+	type sitting struct {
+		subject, exam, examID, label string
+		byUser                       map[string]Attempt
+	}
+	groups := map[string]*sitting{}
+	for _, a := range attempts.List(func(a Attempt) bool { return a.UserID != "" }) {
+		label := a.SubmittedAt.UTC().Format(time.DateOnly)
+		if n := sittings[a.ID]; n > 0 {
+			label = "sitting " + strconv.Itoa(n)
+		}
+		key := cmp.Or(a.ExamID, a.Subject+"/"+a.Exam) + "\x00" + label
+		g := groups[key]
+		if g == nil {
+			g = &sitting{subject: a.Subject, exam: a.Exam, examID: a.ExamID, label: label, byUser: map[string]Attempt{}}
+			groups[key] = g
+		}
+		// Attempts are listed oldest first, so the last one of a user wins
+		g.byUser[a.UserID] = a
+	}
+
+	var raised []SimilarityFlag
+	for _, g := range groups {
+		users := slices.Sorted(maps.Keys(g.byUser))
+		for i, u := range users {
+			for _, v := range users[i+1:] {
+				f := compareAttempts(g.byUser[u], g.byUser[v])
+				if max(f.AnswerScore, f.EssayScore) < threshold {
+					continue
+				}
+				f.Subject, f.Exam, f.ExamID, f.Sitting, f.DetectedAt = g.subject, g.exam, g.examID, g.label, now
+				raised = append(raised, f)
+			}
+		}
+	}
+	added, err := flags.Raise(raised)
+	if err != nil {
+		return fmt.Errorf("failed to save similarity flags: %w", err)
+	}
+	log.Printf("Compared the submissions of %d exam sitting(s) and flagged %d new similar pair(s)", len(groups), added)
+	return nil
+}
+
+// compareAttempts scores how alike two attempts at the same exam are. Right answers are expected to match, so
+// answer patterns are compared on the wrong answers alone; essays are compared by the word sequences they share.
+func compareAttempts(a, b Attempt) SimilarityFlag {
+	f := SimilarityFlag{Attempts: [2]string{a.ID, b.ID}, Users: [2]string{a.UserID, b.UserID}}
+	wrong := 0
+	for i := range min(len(a.Answers), len(b.Answers), len(a.Correct), len(b.Correct)) {
+		wrongA := a.Answers[i] != nil && !a.Correct[i]
+		wrongB := b.Answers[i] != nil && !b.Correct[i]
+		if wrongA || wrongB {
+			wrong++
+		}
+		if wrongA && wrongB && bytes.Equal(a.Answers[i], b.Answers[i]) {
+			f.SharedWrong++
+		}
+
+		if _, choice := a.Answers[i].Choice(); choice || a.Answers[i] == nil || b.Answers[i] == nil {
+			continue
+		}
+		if score := essaySimilarity(a.Answers[i].String(), b.Answers[i].String()); score > f.EssayScore {
+			f.EssayScore, f.EssayIndex = score, &i
+		}
+	}
+	if wrong >= minSharedWrong {
+		f.AnswerScore = float64(f.SharedWrong) / float64(wrong)
+	}
+	return f
+}
+
+// essaySimilarity returns the Jaccard similarity of the word sequences of two essays, or 0 if either is too
+// short to tell
+func essaySimilarity(a, b string) float64 {
+	sa, sb := shingles(a), shingles(b)
+	if len(sa) == 0 || len(sb) == 0 {
+		return 0
+	}
+	shared := 0
+	for s := range sa {
+		if sb[s] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(sa)+len(sb)-shared)
+}
+
+// shingles returns the sequences of essayShingle consecutive words of a text, lowercased without punctuation
+func shingles(text string) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+	if len(words) < minEssayWords {
+		return nil
+	}
+	out := make(map[string]bool, len(words))
+	for i := range len(words) - essayShingle + 1 {
+		out[strings.Join(words[i:i+essayShingle], " ")] = true
+	}
+	return out
+}
+
+// listSimilarity returns a handler that lists the similarity review queue of the subjects of the token, open flags
+// by default (?status=open|confirmed|dismissed|all)
+func listSimilarity(flags *similarityStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := cmp.Or(r.URL.Query().Get("status"), similarityOpen)
+		switch status {
+		case "all":
+			status = ""
+		case similarityOpen, similarityConfirmed, similarityDismissed:
+		default:
+			http.Error(w, "Unknown status "+status, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		scope := scopeOf(r)
+		json.NewEncoder(w).Encode(slices.DeleteFunc(flags.List(status), func(f SimilarityFlag) bool {
+			return !scope.allows(f.Subject)
+		}))
+	}
+}
+
+// closeSimilarity returns a handler that moves a similarity flag out of the queue with the given status
+func closeSimilarity(flags *similarityStore, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := flags.Close(r.PathValue("id"), status, req.Note, scopeOf(r))
+		if errors.Is(err, errSimilarityNotFound) {
+			http.Error(w, "Similarity flag not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save similarity flag: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f)
+	}
+}
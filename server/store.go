@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// examStore provides the parsed exam content of a directory, either read fresh on every call or cached until Reload is called
+type examStore struct {
+	dir      string
+	cached   bool
+	policy   *bluemonday.Policy
+	sortMode string
+	redact   bool // strip the answer key from public responses
+	signed   signatureCheck
+	ids      *idStore
+	reported sync.Map // problems of exam files already logged, by file, hash and problem
+
+	mu         sync.RWMutex
+	subjects   []exam.Subject
+	generation uint64   // incremented on every reload, so responses built from older content are not reused
+	closed     []string // <subject>/<exam> patterns of exams that cannot be started
+
+	historyMu sync.Mutex
+	history   []catalogSnapshot
+
+	listenersMu sync.Mutex
+	listeners   []func([]exam.Subject)
+
+	editMu sync.Mutex // serializes admin edits of exam files, from checking their version to writing them
+}
+
+// newExamStore creates a store for dir; when cached is set the content is loaded once and kept until Reload.
+// A non-nil policy sanitizes any HTML in the exam content, sortMode orders subjects and exams, and ids gives
+// the exams and questions without an id of their own their identifiers. Exam signatures are checked as signed
+// sets out.
+func newExamStore(dir string, cached bool, policy *bluemonday.Policy, sortMode string, redact bool, signed signatureCheck, ids *idStore) (*examStore, error) {
+	if err := exam.CheckSortMode(sortMode); err != nil {
+		return nil, err
+	}
+	s := &examStore{dir: dir, cached: cached, policy: policy, sortMode: sortMode, redact: redact, signed: signed, ids: ids}
+	if cached {
+		if err := s.Reload(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Subjects returns all subjects with their exams. Reading the directory stops early when ctx is done.
+func (s *examStore) Subjects(ctx context.Context) ([]exam.Subject, error) {
+	if !s.cached {
+		return s.load(ctx)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subjects, nil
+}
+
+// SetClosed replaces the patterns of the exams that cannot be started
+func (s *examStore) SetClosed(patterns []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = slices.Clone(patterns)
+}
+
+// Closed reports whether an exam is closed by one of the availability patterns
+func (s *examStore) Closed(subject, exam string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.ContainsFunc(s.closed, func(pattern string) bool {
+		ok, _ := path.Match(pattern, subject+"/"+exam)
+		return ok
+	})
+}
+
+// Reload re-reads the exam directory and replaces the cached content, keeping the old content if reading fails
+// or ctx is done first
+func (s *examStore) Reload(ctx context.Context) error {
+	subjects, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.subjects = subjects
+	s.generation++
+	s.mu.Unlock()
+	return nil
+}
+
+// Generation returns the number of times the content has been reloaded
+func (s *examStore) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// OnLoad registers fn to be called with the content of every read of the exam directory from now on
+func (s *examStore) OnLoad(fn func([]exam.Subject)) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// load reads the exam directory, checks signatures, assigns identifiers, sorts the subjects and exams, and
+// sanitizes the content. Every new catalog version is recorded for delta sync.
+func (s *examStore) load(ctx context.Context) ([]exam.Subject, error) {
+	subjects, err := exam.ReadDir(ctx, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	s.logProblems(subjects)
+	subjects = s.signed.apply(s.dir, subjects)
+	// Identifiers that could not be saved are still used for as long as the server runs
+	if err := s.ids.assign(subjects); err != nil {
+		log.Printf("Failed to save exam ids: %v", err)
+	}
+	exam.SortSubjects(subjects, s.sortMode)
+	s.recordSnapshot(subjects)
+	subjects = sanitizeSubjects(subjects, s.policy)
+	s.notify(subjects)
+	return subjects, nil
+}
+
+// logProblems logs the problems found reading the exams of subjects, each once rather than on every read of the
+// exam directory
+func (s *examStore) logProblems(subjects []exam.Subject) {
+	exam.WalkExams(subjects, func(subject string, e exam.ExamFile) {
+		for _, p := range e.Problems {
+			if _, logged := s.reported.LoadOrStore(subject+"/"+e.Name+":"+e.SHA256+":"+p, true); !logged {
+				log.Printf("Exam %s/%s: %s", subject, e.Name, p)
+			}
+		}
+	})
+}
+
+// notify calls the listeners registered with OnLoad with the content of a read of the exam directory
+func (s *examStore) notify(subjects []exam.Subject) {
+	s.listenersMu.Lock()
+	listeners := slices.Clone(s.listeners)
+	s.listenersMu.Unlock()
+	for _, fn := range listeners {
+		fn(subjects)
+	}
+}
+
+// Stream calls fn with every subject holding exams, without its child subjects. Unless the exams are cached,
+// each subject is read, checked, identified and sanitized like load does and passed to fn as soon as its folder
+// is read, so the first exams can be served before the rest of the directory is read. The exams of a subject are
+// sorted, but subjects come in directory order. An error returned by fn stops the walk.
+func (s *examStore) Stream(ctx context.Context, fn func(exam.Subject) error) error {
+	if s.cached {
+		subjects, err := s.Subjects(ctx)
+		if err != nil {
+			return err
+		}
+		for _, subject := range exam.FlattenSubjects(subjects) {
+			if err := fn(subject); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var read []exam.Subject
+	err := exam.WalkDir(ctx, s.dir, func(subject exam.Subject) error {
+		if len(subject.Exams) == 0 {
+			return nil
+		}
+		s.logProblems([]exam.Subject{subject})
+		folder := s.signed.apply(s.dir, []exam.Subject{subject})
+		// Renames are told apart by looking on disk, since the rest of the directory is not read yet
+		if err := s.ids.assignWith(folder, s.examPresent); err != nil {
+			log.Printf("Failed to save exam ids: %v", err)
+		}
+		exam.SortSubjects(folder, s.sortMode)
+		folder = sanitizeSubjects(folder, s.policy)
+		read = append(read, folder...)
+		return fn(folder[0])
+	})
+	if err != nil {
+		return err
+	}
+	s.recordSnapshot(read)
+	s.notify(read)
+	return nil
+}
+
+// examPresent reports whether the exam of a <subject>/<exam file name> key is in the exam directory. Exams
+// placed directly in the directory have its name as subject, so both places count, as does a file that
+// cannot be checked.
+func (s *examStore) examPresent(key string) bool {
+	subject, name := path.Split(key)
+	subject = strings.TrimSuffix(subject, "/")
+	places := []string{filepath.Join(s.dir, filepath.FromSlash(subject), name)}
+	if subject == filepath.Base(s.dir) {
+		places = append(places, filepath.Join(s.dir, name))
+	}
+	for _, place := range places {
+		if _, err := os.Stat(place); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
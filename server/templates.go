@@ -0,0 +1,159 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// ExamTemplate is a starter exam whose placeholder questions new drafts begin from
+type ExamTemplate struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Meta        exam.ExamMeta    `json:"meta"`
+	Questions   []map[string]any `json:"questions"`
+}
+
+// placeholderChoice returns a multiple-choice question whose text and options are to be written by the author
+func placeholderChoice(n int, section string) map[string]any {
+	q := map[string]any{
+		"question":    "Question " + strconv.Itoa(n) + ": write the question here",
+		"choices":     []any{"Option A", "Option B", "Option C", "Option D"},
+		"correct":     0,
+		"explanation": "Explain why the correct option is right",
+	}
+	if section != "" {
+		q["section"] = section
+	}
+	return q
+}
+
+// placeholderText returns a free-text question whose text and expected answer are to be written by the author
+func placeholderText(n int, section string) map[string]any {
+	q := map[string]any{
+		"type":        "text",
+		"question":    "Question " + strconv.Itoa(n) + ": write the question here",
+		"answer":      "Expected answer",
+		"explanation": "Explain the expected answer",
+	}
+	if section != "" {
+		q["section"] = section
+	}
+	return q
+}
+
+// templatePassingScore is the passing score of the sections of the sectioned template, in percent
+var templatePassingScore = 50.0
+
+// examTemplates are the starter exams served by the templates endpoint
+var examTemplates = []ExamTemplate{
+	{
+		Name:        "mcq",
+		Description: "Multiple-choice questions only",
+		Meta:        exam.ExamMeta{Title: "Untitled exam", Instructions: "Choose the best answer to each question.", Duration: 30},
+		Questions:   []map[string]any{placeholderChoice(1, ""), placeholderChoice(2, ""), placeholderChoice(3, "")},
+	},
+	{
+		Name:        "mixed",
+		Description: "Multiple-choice and free-text questions",
+		Meta:        exam.ExamMeta{Title: "Untitled exam", Instructions: "Choose the best answer, or type it in.", Duration: 45},
+		Questions:   []map[string]any{placeholderChoice(1, ""), placeholderChoice(2, ""), placeholderText(3, "")},
+	},
+	{
+		Name:        "sectioned",
+		Description: "Two sections graded separately, each with its own passing score",
+		Meta: exam.ExamMeta{
+			Title:    "Untitled exam",
+			Duration: 60,
+			Sections: []exam.Section{{Name: "Section 1", PassingScore: &templatePassingScore}, {Name: "Section 2", PassingScore: &templatePassingScore}},
+		},
+		Questions: []map[string]any{
+			placeholderChoice(1, "Section 1"), placeholderChoice(2, "Section 1"),
+			placeholderChoice(3, "Section 2"), placeholderText(4, "Section 2"),
+		},
+	},
+}
+
+// listTemplates serves the starter exams
+func listTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(examTemplates)
+}
+
+// createFromTemplate returns a handler that writes a new draft exam from a starter template, to the subject and
+// file name of the request body and with its title if given
+func createFromTemplate(store *examStore, drafts *draftStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var tmpl *ExamTemplate
+		for i := range examTemplates {
+			if examTemplates[i].Name == r.PathValue("template") {
+				tmpl = &examTemplates[i]
+			}
+		}
+		if tmpl == nil {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			ExamRef
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name != "" && !exam.IsExamFile(req.Name) {
+			req.Name += ".json"
+		}
+		if !exam.ValidSubjectPath(req.Subject) || !exam.ValidName(req.Name) {
+			http.Error(w, "Missing or invalid subject or exam name", http.StatusBadRequest)
+			return
+		}
+		if !scopeOf(r).allows(req.Subject) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// The template is copied so the placeholders served to the next request are left as they are
+		var questions []any
+		data, _ := json.Marshal(tmpl.Questions)
+		json.Unmarshal(data, &questions)
+		meta := tmpl.Meta
+		meta.Title = cmp.Or(req.Title, meta.Title)
+
+		store.editMu.Lock()
+		defer store.editMu.Unlock()
+		if _, err := os.Stat(filepath.Join(store.dir, filepath.FromSlash(req.Subject), req.Name)); err == nil {
+			http.Error(w, "An exam with that name already exists", http.StatusConflict)
+			return
+		}
+		if err := drafts.Add(req.ExamRef); err != nil {
+			http.Error(w, "Failed to save draft: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := exam.Import(store.dir, req.Subject, exam.ExamFile{Name: req.Name, Meta: &meta, Content: questions}, false); err != nil {
+			drafts.Discard(req.ExamRef)
+			http.Error(w, "Failed to create exam: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if store.cached {
+			if err := store.Reload(context.WithoutCancel(r.Context())); err != nil {
+				log.Printf("Failed to reload exams after creating %s/%s: %v", req.Subject, req.Name, err)
+			}
+		}
+		log.Printf("Exam %s/%s created from template %s", req.Subject, req.Name, tmpl.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"subject": req.Subject, "name": req.Name, "template": tmpl.Name, "questionCount": len(questions)})
+	}
+}
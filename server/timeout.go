@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// parseTimeouts checks the route timeouts of the configuration file and returns them parsed
+func parseTimeouts(timeouts map[string]string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(timeouts))
+	for route, value := range timeouts {
+		if len(route) == 0 || route[0] != '/' {
+			return nil, fmt.Errorf("invalid timeout route %q (expected a path pattern starting with /)", route)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q for %s (expected a positive duration such as 5s)", value, route)
+		}
+		parsed[route] = d
+	}
+	return parsed, nil
+}
+
+// timeout cuts short the handlers of routes given a timeout in the configuration file, as http.TimeoutHandler
+// does: the request context is cancelled and the client gets 503 with a JSON error body. Responses of those
+// routes are buffered until the handler returns, so a handler stuck on a slow disk cannot hold the response.
+// A handler that flushes, such as a streaming one, ends the buffering: what it has written is sent and the rest
+// goes straight to the client, and a timeout after that only cancels the request context.
+func (c *liveConfig) timeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := c.current().timeouts[routePattern(r)]
+		if d <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, header: http.Header{}}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if tw.flushed {
+				// Trailers are only set once the body is written
+				maps.Copy(w.Header(), tw.header)
+				return
+			}
+			copyHeader(w.Header(), tw.header)
+			w.WriteHeader(cmp.Or(tw.status, http.StatusOK))
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if tw.flushed || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return // the response is under way or the client went away, so there is no one to answer
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Request timed out after %s", d)})
+		}
+	})
+}
+
+// timeoutWriter buffers the response of a handler running under a timeout until the handler flushes it
+type timeoutWriter struct {
+	w http.ResponseWriter
+
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	flushed  bool // the response was sent to w and is no longer buffered
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 && !w.timedOut && !w.flushed {
+		w.status = status
+	}
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.flushed {
+		return w.w.Write(p)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// Flush sends the response written so far and passes the rest of it through, so a streaming handler reaches
+// the client as it writes
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	if !w.flushed {
+		copyHeader(w.w.Header(), w.header)
+		w.w.WriteHeader(cmp.Or(w.status, http.StatusOK))
+		w.w.Write(w.body.Bytes())
+		w.body.Reset()
+		w.flushed = true
+	}
+	http.NewResponseController(w.w).Flush()
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can reach it, for instance to extend the
+// write deadline of a long stream
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+// copyHeader sets the header fields of src on dst, adding to the Vary field set by outer middleware rather than
+// replacing it
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		if key == "Vary" {
+			dst[key] = append(dst[key], values...)
+		} else {
+			dst[key] = values
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	parsed, err := parseTimeouts(map[string]string{"/api/v1/exams": "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed["/api/v1/exams"] != 5*time.Second {
+		t.Errorf("timeout = %v, want 5s", parsed["/api/v1/exams"])
+	}
+	for route, value := range map[string]string{"api/v1/exams": "5s", "/slow": "soon", "/never": "0s", "/back": "-1s"} {
+		if _, err := parseTimeouts(map[string]string{route: value}); err == nil {
+			t.Errorf("timeout %q for %s accepted", value, route)
+		}
+	}
+}
+
+// newTimeoutMux returns a mux serving handler at /slow and /fast, with a timeout of d on /slow only
+func newTimeoutMux(d time.Duration, handler http.HandlerFunc) *http.ServeMux {
+	c := &liveConfig{cfg: runtimeConfig{timeouts: map[string]time.Duration{"/slow": d}}}
+	mux := http.NewServeMux()
+	mux.Handle("/slow", c.timeout(handler))
+	mux.Handle("/fast", c.timeout(handler))
+	return mux
+}
+
+func TestRouteTimeoutAnswersJSON503(t *testing.T) {
+	stuck := func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Write([]byte("too late"))
+	}
+	rec := httptest.NewRecorder()
+	newTimeoutMux(10*time.Millisecond, stuck).ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", rec.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Errorf("body %q is not a JSON error", rec.Body)
+	}
+}
+
+func TestRouteTimeoutPassesResponse(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}
+	for _, path := range []string{"/slow", "/fast"} {
+		rec := httptest.NewRecorder()
+		newTimeoutMux(time.Second, ok).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusCreated || rec.Body.String() != "done" || rec.Header().Get("X-Test") != "1" {
+			t.Errorf("%s: got %d %q %v, want the handler's response", path, rec.Code, rec.Body, rec.Header())
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window of the moving averages of score trends, in attempts
+const (
+	defaultTrendWindow = 5
+	maxTrendWindow     = 50
+)
+
+// TrendPoint is an attempt in a score time series, with the average of the attempts in the window ending at it
+type TrendPoint struct {
+	AttemptID     string    `json:"attemptId"`
+	Subject       string    `json:"subject"`
+	Exam          string    `json:"exam"`
+	SubmittedAt   time.Time `json:"submittedAt"`
+	Percent       float64   `json:"percent"`
+	MovingAverage float64   `json:"movingAverage"`
+}
+
+// Trend is the score time series of a user over one exam, or over all of their attempts, oldest first
+type Trend struct {
+	Subject  string       `json:"subject,omitempty"`
+	Exam     string       `json:"exam,omitempty"`
+	ExamID   string       `json:"examId,omitempty"`
+	Attempts int          `json:"attempts"`
+	Change   float64      `json:"change"` // moving average of the latest attempt less that of the first, in points
+	Points   []TrendPoint `json:"points"`
+}
+
+// TrendReport is the response of the trend endpoint
+type TrendReport struct {
+	UserID  string  `json:"userId"`
+	Subject string  `json:"subject,omitempty"`
+	Window  int     `json:"window"`
+	Overall Trend   `json:"overall"`
+	Exams   []Trend `json:"exams"` // most attempted first
+}
+
+// trend builds the time series of attempts, which are in submission order, with trailing moving averages over
+// window attempts
+func trend(list []Attempt, window int) Trend {
+	t := Trend{Attempts: len(list), Points: make([]TrendPoint, len(list))}
+	for i, a := range list {
+		sum := 0.0
+		for _, prev := range list[max(0, i+1-window) : i+1] {
+			sum += prev.Percent
+		}
+		t.Points[i] = TrendPoint{
+			AttemptID:     a.ID,
+			Subject:       a.Subject,
+			Exam:          a.Exam,
+			SubmittedAt:   a.SubmittedAt,
+			Percent:       a.Percent,
+			MovingAverage: sum / float64(min(i+1, window)),
+		}
+	}
+	if len(t.Points) > 0 {
+		t.Change = t.Points[len(t.Points)-1].MovingAverage - t.Points[0].MovingAverage
+	}
+	return t
+}
+
+// serveTrends returns a handler that returns the score trends of a user across their attempts, overall and per
+// exam. ?subject= limits them to a subject and its children and ?window= sets the moving average window.
+func serveTrends(attempts *attemptStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("id")
+		subject := r.URL.Query().Get("subject")
+		window := defaultTrendWindow
+		if v := r.URL.Query().Get("window"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxTrendWindow {
+				http.Error(w, "window must be between 1 and "+strconv.Itoa(maxTrendWindow), http.StatusBadRequest)
+				return
+			}
+			window = n
+		}
+
+		list := attempts.List(func(a Attempt) bool {
+			return a.UserID == user && (subject == "" || a.Subject == subject || strings.HasPrefix(a.Subject, subject+"/"))
+		})
+		slices.SortStableFunc(list, func(a, b Attempt) int { return a.SubmittedAt.Compare(b.SubmittedAt) })
+
+		byExam := map[string][]Attempt{}
+		for _, a := range list {
+			key := cmp.Or(a.ExamID, a.Subject+"/"+a.Exam)
+			byExam[key] = append(byExam[key], a)
+		}
+		report := TrendReport{UserID: user, Subject: subject, Window: window, Overall: trend(list, window), Exams: []Trend{}}
+		for _, exams := range byExam {
+			// Exams are named as in their latest attempt, which follows them when they move
+			latest := exams[len(exams)-1]
+			t := trend(exams, window)
+			t.Subject, t.Exam, t.ExamID = latest.Subject, latest.Exam, latest.ExamID
+			report.Exams = append(report.Exams, t)
+		}
+		slices.SortFunc(report.Exams, func(a, b Trend) int {
+			return cmp.Or(cmp.Compare(b.Attempts, a.Attempts), cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Exam, b.Exam))
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// questionShape is the shape of a question of exam content as the API serves it. Exam content keeps questions as
+// parsed JSON, so custom question types may carry further fields.
+type questionShape struct {
+	ID              string                `json:"id,omitempty"`
+	Type            string                `json:"type,omitempty"` // custom question type; multiple choice when empty
+	Question        string                `json:"question"`
+	QuestionHTML    string                `json:"questionHtml,omitempty"` // Markdown rendered to HTML, with render=html
+	Choices         []string              `json:"choices,omitempty"`
+	Correct         *int                  `json:"correct,omitempty"` // left out when answer keys are redacted
+	Answer          any                   `json:"answer,omitempty"`
+	Explanation     string                `json:"explanation,omitempty"`
+	ExplanationHTML string                `json:"explanationHtml,omitempty"`
+	Tags            []string              `json:"tags,omitempty"`
+	Image           string                `json:"image,omitempty"`
+	Audio           string                `json:"audio,omitempty"`
+	Section         string                `json:"section,omitempty"`
+	Math            map[string][]MathSpan `json:"math,omitempty"` // formulas found in each field, by field path
+}
+
+// typeScriptTypes are the API types emitted as TypeScript interfaces, by the name the frontend knows them by
+var typeScriptTypes = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Subject", reflect.TypeFor[exam.Subject]()},
+	{"ExamFile", reflect.TypeFor[exam.ExamFile]()},
+	{"Question", reflect.TypeFor[questionShape]()},
+	{"Session", reflect.TypeFor[sessionResponse]()},
+	{"Result", reflect.TypeFor[Attempt]()},
+}
+
+// WriteTypeScript writes TypeScript interfaces of the API types to w, along with the types they refer to, so
+// the frontend can be checked against the JSON the server sends
+func WriteTypeScript(w io.Writer) error {
+	g := typeScriptGen{names: map[reflect.Type]string{}}
+	for _, t := range typeScriptTypes {
+		g.names[t.typ] = t.name
+	}
+	for _, t := range typeScriptTypes {
+		g.declare(t.typ)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "// Code generated by \"gen types\" from the Go types of the server. DO NOT EDIT.")
+	for _, decl := range g.decls {
+		fmt.Fprintf(bw, "\n%s", decl)
+	}
+	return bw.Flush()
+}
+
+// typeScriptGen collects TypeScript declarations of Go types in the order they are first met
+type typeScriptGen struct {
+	names    map[reflect.Type]string
+	declared map[reflect.Type]bool
+	decls    []string
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// declare adds the interface declaration of struct type t, and those of the structs it refers to
+func (g *typeScriptGen) declare(t reflect.Type) string {
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		g.names[t] = name
+	}
+	if g.declared == nil {
+		g.declared = map[reflect.Type]bool{}
+	}
+	if g.declared[t] {
+		return name
+	}
+	g.declared[t] = true
+	i := len(g.decls)
+	g.decls = append(g.decls, "")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "export interface %s {\n", name)
+	g.fields(&b, t)
+	b.WriteString("}\n")
+	g.decls[i] = b.String()
+	return name
+}
+
+// fields writes the JSON fields of struct type t, including those of embedded structs
+func (g *typeScriptGen) fields(b *strings.Builder, t reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(b, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		optional := strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero")
+		tsType := g.typeOf(ft)
+		if ft.Kind() == reflect.Pointer {
+			if optional {
+				// A nil pointer is left out rather than sent as null
+				tsType = g.typeOf(ft.Elem())
+			}
+			optional = true
+		}
+		if optional {
+			name += "?"
+		}
+		fmt.Fprintf(b, "  %s: %s;\n", name, tsType)
+	}
+}
+
+// typeOf returns the TypeScript type of the JSON encoding of Go type t
+func (g *typeScriptGen) typeOf(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "string" // RFC 3339
+	case t.Implements(jsonMarshalerType):
+		return "unknown"
+	case t.Implements(textMarshalerType):
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Pointer:
+		return g.typeOf(t.Elem()) + " | null"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return "string" // base64
+		}
+		elem := g.typeOf(t.Elem())
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + g.typeOf(t.Elem()) + ">"
+	case reflect.Struct:
+		return g.declare(t)
+	}
+	return "unknown"
+}
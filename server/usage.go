@@ -0,0 +1,220 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/storage"
+)
+
+// Usage events counted per exam
+const (
+	usageView       = "view"
+	usageStart      = "start"
+	usageCompletion = "completion"
+)
+
+// ExamUsage counts how often an exam was opened, started and submitted
+type ExamUsage struct {
+	Subject        string    `json:"subject"`
+	Exam           string    `json:"exam"`
+	ExamID         string    `json:"examId,omitempty"`
+	Views          int       `json:"views"`
+	Starts         int       `json:"starts"`
+	Completions    int       `json:"completions"`
+	CompletionRate *float64  `json:"completionRate,omitempty"` // completions per start, once the exam was started
+	LastActivity   time.Time `json:"lastActivity,omitzero"`
+}
+
+// usageStore keeps the usage counts of every exam in a JSON file
+type usageStore struct {
+	path string
+
+	mu    sync.RWMutex
+	exams map[string]*ExamUsage // keyed by exam id, or <subject>/<exam file name> for usage recorded without one
+}
+
+// openUsageStore loads the usage counts saved at path. A new store is backfilled with the completions
+// of the attempts recorded before usage was tracked.
+func openUsageStore(path string, attempts *attemptStore) (*usageStore, error) {
+	s := &usageStore{path: path, exams: map[string]*ExamUsage{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		for _, a := range attempts.List(nil) {
+			s.count(a.Subject, a.Exam, a.ExamID, usageCompletion, a.SubmittedAt)
+		}
+		if len(s.exams) == 0 {
+			return s, nil
+		}
+		return s, s.save()
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.exams); err != nil {
+		return nil, fmt.Errorf("failed to parse usage: %w", err)
+	}
+	return s, nil
+}
+
+// save writes the counts of every exam; the caller holds the lock
+func (s *usageStore) save() error {
+	data, err := json.MarshalIndent(s.exams, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path, data)
+}
+
+// lookup returns the usage of an exam, moving counts recorded under its name before it had an id to its id;
+// the caller holds the write lock if create is set
+func (s *usageStore) lookup(subject, exam, id string, create bool) *ExamUsage {
+	byName := subject + "/" + exam
+	if id == "" {
+		if u := s.exams[byName]; u != nil || !create {
+			return u
+		}
+		u := &ExamUsage{Subject: subject, Exam: exam}
+		s.exams[byName] = u
+		return u
+	}
+	u := s.exams[id]
+	if !create {
+		return cmp.Or(u, s.exams[byName])
+	}
+	if u == nil {
+		if u = s.exams[byName]; u != nil {
+			delete(s.exams, byName)
+		} else {
+			u = &ExamUsage{}
+		}
+		s.exams[id] = u
+	}
+	// The exam is listed under the name it was last used by
+	u.Subject, u.Exam, u.ExamID = subject, exam, id
+	return u
+}
+
+// count adds one event to an exam; the caller holds the lock
+func (s *usageStore) count(subject, exam, id, event string, at time.Time) {
+	u := s.lookup(subject, exam, id, true)
+	switch event {
+	case usageView:
+		u.Views++
+	case usageStart:
+		u.Starts++
+	case usageCompletion:
+		u.Completions++
+	}
+	if at.After(u.LastActivity) {
+		u.LastActivity = at
+	}
+}
+
+// Record counts an event of an exam and saves the counts
+func (s *usageStore) Record(subject, exam, id, event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count(subject, exam, id, event, time.Now().UTC())
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save usage of %s/%s: %v", subject, exam, err)
+	}
+}
+
+// sessionStarted counts a newly started session
+func (s *usageStore) sessionStarted(session Session) {
+	s.Record(session.Subject, session.Exam, session.ExamID, usageStart)
+}
+
+// attemptRecorded counts a submitted attempt
+func (s *usageStore) attemptRecorded(a Attempt) {
+	s.Record(a.Subject, a.Exam, a.ExamID, usageCompletion)
+}
+
+// List returns the usage of every exam that was used, most viewed first
+func (s *usageStore) List() []ExamUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ExamUsage, 0, len(s.exams))
+	for _, u := range s.exams {
+		out = append(out, u.withRate())
+	}
+	slices.SortFunc(out, func(a, b ExamUsage) int {
+		return cmp.Or(cmp.Compare(b.Views, a.Views), cmp.Compare(b.Starts, a.Starts), cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Exam, b.Exam))
+	})
+	return out
+}
+
+// Get returns the usage of an exam, with zero counts if it was never used
+func (s *usageStore) Get(subject, exam, id string) ExamUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u := s.lookup(subject, exam, id, false); u != nil {
+		return u.withRate()
+	}
+	return ExamUsage{Subject: subject, Exam: exam, ExamID: id}
+}
+
+// withRate returns a copy of the usage with its completion rate filled in
+func (u *ExamUsage) withRate() ExamUsage {
+	out := *u
+	if u.Starts > 0 {
+		rate := float64(u.Completions) / float64(u.Starts)
+		out.CompletionRate = &rate
+	}
+	return out
+}
+
+// recordView returns a handler that counts an exam being opened by a client
+func recordView(store *examStore, usage *usageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		usage.Record(subject, e.Name, e.ID, usageView)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listUsage returns a handler that returns the usage of every exam
+func listUsage(usage *usageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage.List())
+	}
+}
+
+// examUsage returns a handler that returns the usage of one exam
+func examUsage(store *examStore, usage *usageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage.Get(subject, e.Name, e.ID))
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// VariantOptions selects how a per-user paper is drawn from an exam
+type VariantOptions struct {
+	Questions int `json:"questions,omitempty"` // number of questions drawn; all questions when 0
+}
+
+// examVariant is a deterministic paper of an exam for one user
+type examVariant struct {
+	Seed      string `json:"seed"`
+	Subject   string `json:"subject"`
+	Exam      string `json:"exam"`
+	Questions []any  `json:"questions"`
+
+	source  []any   // questions in their original order, with templates filled in for this paper
+	order   []int   // original index of each question on the paper
+	choices [][]int // original index of each choice, per question on the paper
+}
+
+// newExamVariant draws the paper of user for an exam: a subset of the questions in shuffled order,
+// with shuffled choices and template values of their own
+func newExamVariant(user, subject string, e exam.ExamFile, opts VariantOptions) *examVariant {
+	seed := exam.VariantSeed(user, subject, e.Name)
+	questions := exam.WithQuestionIDs(exam.InstantiateQuestions(exam.Questions(e.Content), seed), subject, e.Name)
+
+	order := exam.VariantRand(seed, "questions").Perm(len(questions))
+	if opts.Questions > 0 && opts.Questions < len(order) {
+		order = order[:opts.Questions]
+	}
+
+	v := &examVariant{
+		Seed:      hex.EncodeToString(seed[:8]),
+		Subject:   subject,
+		Exam:      e.Name,
+		Questions: make([]any, len(order)),
+		source:    questions,
+		order:     order,
+		choices:   make([][]int, len(order)),
+	}
+	for i, orig := range order {
+		q, ok := questions[orig].(map[string]any)
+		if !ok {
+			v.Questions[i] = questions[orig]
+			continue
+		}
+		rng := exam.VariantRand(seed, "choices "+strconv.Itoa(orig))
+		v.Questions[i], v.choices[i] = shuffleChoices(q, rng)
+	}
+	return v
+}
+
+// shuffleChoices returns a copy of a question with its choices permuted and the correct index remapped,
+// together with the original index of each choice
+func shuffleChoices(q map[string]any, rng *rand.Rand) (map[string]any, []int) {
+	out := make(map[string]any, len(q))
+	for k, v := range q {
+		out[k] = v
+	}
+	choices, ok := q["choices"].([]any)
+	if !ok {
+		return out, nil
+	}
+
+	perm := rng.Perm(len(choices))
+	shuffled := make([]any, len(choices))
+	for i, orig := range perm {
+		shuffled[i] = choices[orig]
+	}
+	out["choices"] = shuffled
+	if correct, ok := q["correct"].(float64); ok {
+		for i, orig := range perm {
+			if orig == int(correct) {
+				out["correct"] = float64(i)
+			}
+		}
+	}
+	return out, perm
+}
+
+// originalAnswers maps answers given on the paper back to the original question and choice order
+func (v *examVariant) originalAnswers(answers []exam.Answer, total int) []exam.Answer {
+	out := make([]exam.Answer, total)
+	for i, a := range answers {
+		if i >= len(v.order) || a == nil {
+			continue
+		}
+		if choice, ok := a.Choice(); ok && v.choices[i] != nil {
+			perm := v.choices[i]
+			if choice < 0 || choice >= len(perm) {
+				continue
+			}
+			a = exam.ChoiceAnswer(perm[choice])
+		}
+		out[v.order[i]] = a
+	}
+	return out
+}
+
+// originalTimes maps the seconds spent on the questions of the paper to the questions of the exam
+func (v *examVariant) originalTimes(times []float64, total int) []float64 {
+	out := make([]float64, total)
+	for i, t := range times {
+		if i < len(v.order) {
+			out[v.order[i]] = t
+		}
+	}
+	return out
+}
+
+// serveExamVariant returns a handler that returns the paper of a user for an exam
+func serveExamVariant(store *examStore, access examAccess) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		user := q.Get("user")
+		if user == "" {
+			http.Error(w, "Missing user", http.StatusBadRequest)
+			return
+		}
+		var opts VariantOptions
+		if n := q.Get("questions"); n != "" {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				http.Error(w, "Invalid questions count", http.StatusBadRequest)
+				return
+			}
+			opts.Questions = count
+		}
+
+		subjects, err := store.Subjects(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read exam files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subject := r.PathValue("subject")
+		e, ok := exam.FindExam(subjects, subject, r.PathValue("exam"))
+		if !ok || !access.canSee(r, user, subject, e) {
+			http.Error(w, "Exam not found", http.StatusNotFound)
+			return
+		}
+
+		variant := newExamVariant(user, subject, e, opts)
+		if store.redact {
+			variant.Questions = exam.Questions(redactAnswers(variant.Questions))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(variant)
+	}
+}
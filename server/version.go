@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiPrefix is the path prefix of the current API version. Breaking changes to request or response shapes go
+// to a new version, so clients of an older one keep working.
+const apiPrefix = "/api/v1"
+
+// serveUnversioned returns a handler that serves unversioned /api/... paths, used by clients written before the
+// API was versioned, with the routes of the current version registered on mux
+func serveUnversioned(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api")
+		// Paths of an unknown version are not routed again, which would never end
+		if version, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/"); isAPIVersion(version) {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = apiPrefix + rest
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = apiPrefix + strings.TrimPrefix(r.URL.RawPath, "/api")
+		}
+		mux.ServeHTTP(w, r2)
+	})
+}
+
+// isAPIVersion reports whether a path segment names an API version, such as v1
+func isAPIVersion(segment string) bool {
+	digits, ok := strings.CutPrefix(segment, "v")
+	return ok && digits != "" && strings.Trim(digits, "0123456789") == ""
+}
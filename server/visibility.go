@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// examAccess decides which exams a request may list and fetch, from the visibility in their metadata
+type examAccess struct {
+	tokens tokenRoles
+	groups *groupStore
+	drafts *draftStore
+}
+
+// canSee reports whether a request for user may see exam e of subject. Instructors and admins see every exam of
+// the subjects their token is limited to, drafts included; group exams are for the members of the groups they are
+// assigned to.
+func (a examAccess) canSee(r *http.Request, user, subject string, e exam.ExamFile) bool {
+	grant := a.tokens.grantOf(r)
+	return a.visible(user, subject, e) || (grant.role != "" && grant.subjects.allows(subject))
+}
+
+// visible reports whether exam e of subject is visible to user without a role
+func (a examAccess) visible(user, subject string, e exam.ExamFile) bool {
+	if a.drafts.IsDraft(subject, e.Name) {
+		return false
+	}
+	switch exam.Visibility(e) {
+	case exam.VisibilityPublic:
+		return true
+	case exam.VisibilityGroup:
+		return user != "" && a.groups.IsAssigned(user, ExamRef{Subject: subject, Name: e.Name})
+	}
+	return false
+}
+
+// filter returns the subject tree without the exams a request for user may not see
+func (a examAccess) filter(r *http.Request, user string, subjects []exam.Subject) []exam.Subject {
+	grant := a.tokens.grantOf(r)
+	if grant.role != "" && grant.subjects == nil {
+		return subjects
+	}
+	return exam.FilterExams(subjects, func(subject string, e exam.ExamFile) bool {
+		return a.visible(user, subject, e) || (grant.role != "" && grant.subjects.allows(subject))
+	})
+}
+
+// audience returns a key shared by every request that sees the same exams, so cached listings are only reused
+// for requests that see the same ones: staff, or users assigned the same group exams while the same drafts are
+// unpublished, staff limited to the same subjects included
+func (a examAccess) audience(r *http.Request, user string) string {
+	grant := a.tokens.grantOf(r)
+	if grant.role != "" && grant.subjects == nil {
+		return "staff"
+	}
+	scope := ""
+	if grant.role != "" {
+		scope = "staff:" + strings.Join(grant.subjects, ":") + ":"
+	}
+	var assigned []string
+	for _, g := range a.groups.MemberGroups(user) {
+		for _, ref := range g.Exams {
+			assigned = append(assigned, ref.Subject+"/"+ref.Name)
+		}
+	}
+	slices.Sort(assigned)
+	return scope + "drafts:" + strconv.FormatUint(a.drafts.Version(), 36) + ":assigned:" + strings.Join(slices.Compact(assigned), ",")
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VanzPaul/Mock_Exam/exam"
+)
+
+// fileState records the attributes used to detect that an exam file changed
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotExamDir records the state of every exam file under dir
+func snapshotExamDir(dir string) (map[string]fileState, error) {
+	snapshot := make(map[string]fileState)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// A new signature changes the signature state of its exam
+		if !info.IsDir() && (exam.IsJSONFile(path) || strings.HasSuffix(path, exam.SignatureSuffix)) {
+			snapshot[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return snapshot, err
+}
+
+// diffSnapshots lists the files added, modified, and removed between two snapshots
+func diffSnapshots(before, after map[string]fileState) (added, modified, removed []string) {
+	for path, state := range after {
+		old, ok := before[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case !old.modTime.Equal(state.modTime) || old.size != state.size:
+			modified = append(modified, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(modified)
+	sort.Strings(removed)
+	return added, modified, removed
+}
+
+// watchExamDir polls the store's directory every interval and reloads the store when exam files change
+func watchExamDir(store *examStore, interval time.Duration, stop <-chan struct{}) {
+	previous, err := snapshotExamDir(store.dir)
+	if err != nil {
+		log.Printf("Watch: failed to scan %s: %v", store.dir, err)
+	}
+	log.Printf("Watching %s for changes every %s", store.dir, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := snapshotExamDir(store.dir)
+		if err != nil {
+			log.Printf("Watch: failed to scan %s: %v", store.dir, err)
+			continue
+		}
+
+		added, modified, removed := diffSnapshots(previous, current)
+		if len(added)+len(modified)+len(removed) == 0 {
+			continue
+		}
+
+		// Keep serving the old content if the new files do not parse, and retry on the next change
+		if err := store.Reload(context.Background()); err != nil {
+			log.Printf("Watch: reload failed, keeping previous content: %v", err)
+			previous = current
+			continue
+		}
+		previous = current
+
+		var changes []string
+		if len(added) > 0 {
+			changes = append(changes, "added "+strings.Join(added, ", "))
+		}
+		if len(modified) > 0 {
+			changes = append(changes, "modified "+strings.Join(modified, ", "))
+		}
+		if len(removed) > 0 {
+			changes = append(changes, "removed "+strings.Join(removed, ", "))
+		}
+		log.Printf("Reloaded exams: %s", strings.Join(changes, "; "))
+	}
+}
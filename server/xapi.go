@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xAPI verbs emitted for attempts
+var (
+	xapiAnswered  = xapiVerb{ID: "http://adlnet.gov/expapi/verbs/answered", Display: map[string]string{"en-US": "answered"}}
+	xapiCompleted = xapiVerb{ID: "http://adlnet.gov/expapi/verbs/completed", Display: map[string]string{"en-US": "completed"}}
+	xapiPassed    = xapiVerb{ID: "http://adlnet.gov/expapi/verbs/passed", Display: map[string]string{"en-US": "passed"}}
+	xapiFailed    = xapiVerb{ID: "http://adlnet.gov/expapi/verbs/failed", Display: map[string]string{"en-US": "failed"}}
+)
+
+// xapiVerb is the action a statement reports
+type xapiVerb struct {
+	ID      string            `json:"id"`
+	Display map[string]string `json:"display"`
+}
+
+// xapiAccount identifies a learner by their ID in this system
+type xapiAccount struct {
+	HomePage string `json:"homePage"`
+	Name     string `json:"name"`
+}
+
+// xapiActor is the learner a statement is about
+type xapiActor struct {
+	ObjectType string      `json:"objectType"`
+	Account    xapiAccount `json:"account"`
+}
+
+// xapiDefinition describes an activity
+type xapiDefinition struct {
+	Name            map[string]string `json:"name,omitempty"`
+	Type            string            `json:"type"`
+	InteractionType string            `json:"interactionType,omitempty"`
+}
+
+// xapiActivity is the exam or question a statement is about
+type xapiActivity struct {
+	ObjectType string         `json:"objectType"`
+	ID         string         `json:"id"`
+	Definition xapiDefinition `json:"definition"`
+}
+
+// xapiScore is the score of a completed attempt
+type xapiScore struct {
+	Scaled float64 `json:"scaled"`
+	Raw    int     `json:"raw"`
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+}
+
+// xapiResult is the outcome reported by a statement
+type xapiResult struct {
+	Score      *xapiScore `json:"score,omitempty"`
+	Success    *bool      `json:"success,omitempty"`
+	Completion *bool      `json:"completion,omitempty"`
+	Response   string     `json:"response,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+}
+
+// xapiContext relates a statement to its attempt and exam
+type xapiContext struct {
+	Registration      string                    `json:"registration,omitempty"`
+	ContextActivities map[string][]xapiActivity `json:"contextActivities,omitempty"`
+	Platform          string                    `json:"platform,omitempty"`
+}
+
+// xapiStatement is an xAPI 1.0.3 statement
+type xapiStatement struct {
+	ID        string       `json:"id"`
+	Actor     xapiActor    `json:"actor"`
+	Verb      xapiVerb     `json:"verb"`
+	Object    xapiActivity `json:"object"`
+	Result    *xapiResult  `json:"result,omitempty"`
+	Context   *xapiContext `json:"context,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// XAPIConfig holds the LRS connection settings
+type XAPIConfig struct {
+	Endpoint     string // statements are posted to <Endpoint>/statements
+	Username     string
+	Password     string
+	ActivityBase string // prefix of the activity IDs of exams and questions
+	HomePage     string // account home page identifying the system that issued user IDs
+}
+
+// xapiEmitter sends statements to an LRS from a background queue
+type xapiEmitter struct {
+	cfg    XAPIConfig
+	client *http.Client
+	queue  chan []xapiStatement
+}
+
+// newXAPIEmitter starts the delivery worker of an emitter
+func newXAPIEmitter(cfg XAPIConfig) *xapiEmitter {
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	e := &xapiEmitter{cfg: cfg, client: &http.Client{Timeout: 15 * time.Second}, queue: make(chan []xapiStatement, 256)}
+	go e.deliver()
+	return e
+}
+
+// attemptRecorded queues the answered, completed and passed or failed statements of an attempt.
+// Attempts without a user ID have no learner to attribute the statements to and are skipped.
+func (e *xapiEmitter) attemptRecorded(a Attempt) {
+	if a.UserID == "" {
+		return
+	}
+	select {
+	case e.queue <- e.statements(a):
+	default:
+		log.Printf("xAPI queue full, dropping statements of attempt %s", a.ID)
+	}
+}
+
+// statements builds the statements describing an attempt
+func (e *xapiEmitter) statements(a Attempt) []xapiStatement {
+	actor := xapiActor{ObjectType: "Agent", Account: xapiAccount{HomePage: e.cfg.HomePage, Name: a.UserID}}
+	exam := xapiActivity{
+		ObjectType: "Activity",
+		ID:         e.cfg.ActivityBase + "exams/" + a.Subject + "/" + a.Exam,
+		Definition: xapiDefinition{Name: map[string]string{"en-US": a.Subject + " " + a.Exam}, Type: "http://adlnet.gov/expapi/activities/assessment"},
+	}
+	registration := xapiUUID(a.ID, "registration")
+	context := &xapiContext{Registration: registration, Platform: "Mock Exam", ContextActivities: map[string][]xapiActivity{"parent": {exam}}}
+
+	var out []xapiStatement
+	for i, answer := range a.Answers {
+		if answer == nil {
+			continue
+		}
+		success := a.Correct[i]
+		interaction := "choice"
+		if _, ok := answer.Choice(); !ok {
+			interaction = "other"
+		}
+		out = append(out, xapiStatement{
+			ID:    xapiUUID(a.ID, "answered", strconv.Itoa(i)),
+			Actor: actor,
+			Verb:  xapiAnswered,
+			Object: xapiActivity{
+				ObjectType: "Activity",
+				ID:         exam.ID + "#q" + strconv.Itoa(i+1),
+				Definition: xapiDefinition{Type: "http://adlnet.gov/expapi/activities/cmi.interaction", InteractionType: interaction},
+			},
+			Result:    &xapiResult{Success: &success, Response: answer.String()},
+			Context:   context,
+			Timestamp: a.SubmittedAt,
+		})
+	}
+
+	score := &xapiScore{Raw: a.Score, Max: a.Total}
+	if a.Total > 0 {
+		score.Scaled = float64(a.Score) / float64(a.Total)
+	}
+	completion := true
+	result := &xapiResult{Score: score, Completion: &completion}
+	if !a.StartedAt.IsZero() && a.SubmittedAt.After(a.StartedAt) {
+		result.Duration = fmt.Sprintf("PT%.0fS", a.SubmittedAt.Sub(a.StartedAt).Seconds())
+	}
+	context = &xapiContext{Registration: registration, Platform: "Mock Exam"}
+	out = append(out, xapiStatement{ID: xapiUUID(a.ID, "completed"), Actor: actor, Verb: xapiCompleted, Object: exam, Result: result, Context: context, Timestamp: a.SubmittedAt})
+
+	if a.Passed != nil {
+		verb := xapiFailed
+		if *a.Passed {
+			verb = xapiPassed
+		}
+		outcome := *result
+		outcome.Success = a.Passed
+		out = append(out, xapiStatement{ID: xapiUUID(a.ID, verb.Display["en-US"]), Actor: actor, Verb: verb, Object: exam, Result: &outcome, Context: context, Timestamp: a.SubmittedAt})
+	}
+	return out
+}
+
+// xapiUUID derives a stable statement ID so redelivered statements are recognised as duplicates by the LRS
+func xapiUUID(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// deliver posts queued statements, retrying failed batches with backoff
+func (e *xapiEmitter) deliver() {
+	for batch := range e.queue {
+		var err error
+		for try, wait := 0, time.Second; try < 4; try, wait = try+1, wait*4 {
+			if try > 0 {
+				time.Sleep(wait)
+			}
+			if err = e.post(batch); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to send %d xAPI statements: %v", len(batch), err)
+		}
+	}
+}
+
+// post sends one batch of statements to the LRS
+func (e *xapiEmitter) post(batch []xapiStatement) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint+"/statements", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Experience-API-Version", "1.0.3")
+	if e.cfg.Username != "" || e.cfg.Password != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("LRS returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/VanzPaul/Mock_Exam/client"
+	"github.com/VanzPaul/Mock_Exam/exam"
+	"github.com/VanzPaul/Mock_Exam/server"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// runTake runs an exam in the terminal, from the exam directory or from a server
+func runTake(args []string) error {
+	fs, dir := newFlagSet("take")
+	serverURL := fs.String("server", os.Getenv("MOCK_EXAM_SERVER"), "take the exam on the server at this URL instead of from -dir, saving answers in a session (defaults to $MOCK_EXAM_SERVER)")
+	token := fs.String("token", os.Getenv("MOCK_EXAM_TOKEN"), "bearer token sent to the server (defaults to $MOCK_EXAM_TOKEN)")
+	user := fs.String("user", "", "user ID the session is started as on the server, which lets an unfinished session be resumed")
+	accessCode := fs.String("access-code", "", "access code of the exam, if the server requires one")
+	graderFlag(fs)
+	setAnswerKey := answerKeyFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: take <subject>/<exam> [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	var ref string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		ref, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if ref == "" {
+		ref = fs.Arg(0)
+	}
+	i := strings.LastIndexByte(ref, '/')
+	if i <= 0 || i == len(ref)-1 {
+		fs.Usage()
+		return errors.New("take: give the exam as <subject>/<exam>")
+	}
+	subject, name := ref[:i], ref[i+1:]
+
+	ctx := context.Background()
+	var taker examTaker
+	var err error
+	if *serverURL != "" {
+		c := client.New(*serverURL)
+		c.Token = *token
+		c.Window = exam.NewID()
+		taker, err = startRemoteTaker(ctx, c, client.SessionStart{Subject: subject, Exam: name, UserID: *user, AccessCode: *accessCode})
+	} else {
+		if err := setAnswerKey(); err != nil {
+			return err
+		}
+		taker, err = startLocalTaker(ctx, *dir, subject, name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(taker.questions()) == 0 {
+		return fmt.Errorf("take: exam %q has no questions", ref)
+	}
+
+	final, err := tea.NewProgram(newTakeUI(taker), tea.WithAltScreen()).Run()
+	if err != nil {
+		return fmt.Errorf("take needs an interactive terminal: %w", err)
+	}
+	if final.(*takeUI).result == nil {
+		fmt.Println(taker.leaveNotice())
+	}
+	return nil
+}
+
+// examTaker is an exam being taken in the terminal, graded either locally or by the server it was started on
+type examTaker interface {
+	title() string
+	questions() []map[string]any
+	answers() []exam.Answer // saved answers, of a resumed session
+	save(i int, answer exam.Answer) error
+	submit() (*server.Attempt, error)
+	leaveNotice() string // shown when the exam is left unsubmitted
+}
+
+// localTaker takes an exam read from the exam directory, grading it as the server would
+type localTaker struct {
+	subjects  []exam.Subject
+	subject   string
+	exam      exam.ExamFile
+	qs        []map[string]any
+	given     []exam.Answer
+	startedAt time.Time
+}
+
+// startLocalTaker reads the exam name of subject from dir
+func startLocalTaker(ctx context.Context, dir, subject, name string) (*localTaker, error) {
+	subjects, err := exam.ReadDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := exam.FindExam(subjects, subject, name)
+	if !ok {
+		return nil, fmt.Errorf("take: exam %q not found in %s", subject+"/"+name, dir)
+	}
+	// Templates are filled in with the shared paper of the exam, which is what GradeAttempt grades against
+	questions := exam.InstantiateQuestions(exam.Questions(e.Content), exam.VariantSeed("", subject, e.Name))
+	t := &localTaker{subjects: subjects, subject: subject, exam: e, qs: questionMaps(questions), startedAt: time.Now().UTC()}
+	t.given = make([]exam.Answer, len(t.qs))
+	return t, nil
+}
+
+func (t *localTaker) title() string               { return examTitle(t.subject, t.exam) }
+func (t *localTaker) questions() []map[string]any { return t.qs }
+func (t *localTaker) answers() []exam.Answer      { return t.given }
+
+func (t *localTaker) leaveNotice() string {
+	return "Left the exam; answers given offline are not kept."
+}
+
+func (t *localTaker) save(i int, a exam.Answer) error {
+	t.given[i] = a
+	return nil
+}
+
+func (t *localTaker) submit() (*server.Attempt, error) {
+	a, err := server.GradeAttempt(t.subjects, t.subject, t.exam.Name, t.startedAt, t.given)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// remoteTaker takes an exam in a session on a server, which saves every answer as it is given
+type remoteTaker struct {
+	ctx     context.Context
+	client  *client.Client
+	subject string
+	exam    exam.ExamFile
+	qs      []map[string]any
+	session *server.Session
+}
+
+// startRemoteTaker starts a session of the exam on the server, or resumes the user's unfinished one
+func startRemoteTaker(ctx context.Context, c *client.Client, start client.SessionStart) (*remoteTaker, error) {
+	e, err := c.Exam(ctx, start.Subject, start.Exam)
+	if err != nil {
+		return nil, err
+	}
+	start.Exam = e.Name
+	session, err := c.StartSession(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteTaker{ctx: ctx, client: c, subject: start.Subject, exam: e, qs: questionMaps(exam.Questions(e.Content)), session: session}, nil
+}
+
+func (t *remoteTaker) title() string               { return examTitle(t.subject, t.exam) }
+func (t *remoteTaker) questions() []map[string]any { return t.qs }
+func (t *remoteTaker) answers() []exam.Answer      { return t.session.Answers }
+
+func (t *remoteTaker) leaveNotice() string {
+	return fmt.Sprintf("Left the exam; your answers are saved in session %s.", t.session.ID)
+}
+
+func (t *remoteTaker) save(i int, a exam.Answer) error {
+	session, err := t.client.SaveAnswers(t.ctx, t.session.ID, map[int]exam.Answer{i: a})
+	if err != nil {
+		return err
+	}
+	t.session = session
+	return nil
+}
+
+func (t *remoteTaker) submit() (*server.Attempt, error) {
+	return t.client.SubmitSession(t.ctx, t.session.ID)
+}
+
+// examTitle returns the title of an exam, or its subject and name if it has none
+func examTitle(subject string, e exam.ExamFile) string {
+	if e.Meta != nil && e.Meta.Title != "" {
+		return e.Meta.Title
+	}
+	return subject + "/" + e.Name
+}
+
+// questionMaps returns the questions of exam content as objects; anything else becomes an empty question
+func questionMaps(questions []any) []map[string]any {
+	out := make([]map[string]any, len(questions))
+	for i, item := range questions {
+		q, ok := item.(map[string]any)
+		if !ok {
+			q = map[string]any{}
+		}
+		out[i] = q
+	}
+	return out
+}
+
+// Keys of the UI that are not printable characters, as named by tea.KeyMsg
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyTab       = "tab"
+	keyEnter     = "enter"
+	keyBackspace = "backspace"
+	keySubmit    = "ctrl+s"
+	keyQuit      = "ctrl+c"
+	keyEOF       = "ctrl+d"
+)
+
+// submittedMsg is the outcome of submitting the exam
+type submittedMsg struct {
+	attempt *server.Attempt
+	err     error
+}
+
+// takeUI is the Bubble Tea model of the terminal UI of an exam: it updates on each key press and renders as a whole
+type takeUI struct {
+	taker     examTaker
+	questions []map[string]any
+	answers   []exam.Answer
+	width     int
+
+	index      int    // question shown
+	cursor     int    // highlighted choice of the question shown
+	text       []rune // answer being typed to a question without choices
+	confirm    bool   // submitting waits for confirmation
+	submitting bool   // the exam is being submitted
+	status     string // outcome of the last action, such as a failed save
+	result     *server.Attempt
+}
+
+// newTakeUI returns the UI of an exam starting at its first question, with the answers it already has
+func newTakeUI(taker examTaker) *takeUI {
+	ui := &takeUI{taker: taker, questions: taker.questions(), width: 80}
+	ui.answers = make([]exam.Answer, len(ui.questions))
+	copy(ui.answers, taker.answers())
+	ui.show(0)
+	return ui
+}
+
+// Init starts the UI without a command; the terminal size arrives as the first message
+func (ui *takeUI) Init() tea.Cmd {
+	return nil
+}
+
+// Update applies a message: the terminal size, a key press, or the outcome of submitting
+func (ui *takeUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		ui.width = max(20, min(msg.Width, 100))
+	case submittedMsg:
+		ui.submitting = false
+		if msg.err != nil {
+			ui.status = "Failed to submit: " + msg.err.Error()
+		} else {
+			ui.result = msg.attempt
+		}
+	case tea.KeyMsg:
+		return ui, ui.update(msg.String())
+	}
+	return ui, nil
+}
+
+// View renders the whole screen
+func (ui *takeUI) View() string {
+	return ui.view()
+}
+
+// submit returns the command submitting the exam, which the server may take a while to grade
+func (ui *takeUI) submit() tea.Cmd {
+	ui.submitting = true
+	ui.status = ""
+	return func() tea.Msg {
+		a, err := ui.taker.submit()
+		return submittedMsg{attempt: a, err: err}
+	}
+}
+
+// choices returns the choices of the question shown, if it is a multiple-choice question
+func (ui *takeUI) choices() []any {
+	choices, _ := ui.questions[ui.index]["choices"].([]any)
+	return choices
+}
+
+// show moves to question i, highlighting its chosen answer or loading its typed one
+func (ui *takeUI) show(i int) {
+	ui.index = max(0, min(i, len(ui.questions)-1))
+	ui.cursor, ui.text = 0, nil
+	answer := ui.answers[ui.index]
+	if choice, ok := answer.Choice(); ok && choice < len(ui.choices()) {
+		ui.cursor = choice
+	} else if answer != nil {
+		ui.text = []rune(answer.String())
+	}
+}
+
+// answer records the answer to the question shown and saves it
+func (ui *takeUI) answer(a exam.Answer) {
+	if err := ui.taker.save(ui.index, a); err != nil {
+		ui.status = "Failed to save the answer: " + err.Error()
+		return
+	}
+	ui.answers[ui.index] = a
+	ui.status = ""
+}
+
+// update applies a key press, returning tea.Quit once the UI is left
+func (ui *takeUI) update(k string) tea.Cmd {
+	if ui.result != nil || k == keyQuit || k == keyEOF {
+		return tea.Quit
+	}
+	if ui.submitting {
+		return nil
+	}
+	if ui.confirm {
+		ui.confirm = false
+		if k == "y" || k == "Y" {
+			return ui.submit()
+		}
+		return nil
+	}
+
+	choices := ui.choices()
+	switch {
+	case k == keySubmit || (k == "s" && choices != nil):
+		ui.confirm = true
+	case k == keyLeft || (choices != nil && (k == "p" || k == "h")):
+		ui.show(ui.index - 1)
+	case k == keyRight || k == keyTab || (choices != nil && (k == "n" || k == "l")):
+		ui.show(ui.index + 1)
+	case choices != nil:
+		switch {
+		case k == keyUp || k == "k":
+			ui.cursor = max(ui.cursor-1, 0)
+		case k == keyDown || k == "j":
+			ui.cursor = min(ui.cursor+1, len(choices)-1)
+		case k == keyEnter || k == " ":
+			ui.answer(exam.ChoiceAnswer(ui.cursor))
+		case len(k) == 1 && k[0] >= '1' && k[0] <= '9' && int(k[0]-'1') < len(choices):
+			ui.cursor = int(k[0] - '1')
+			ui.answer(exam.ChoiceAnswer(ui.cursor))
+		case k == "q":
+			return tea.Quit
+		}
+	default:
+		switch {
+		case k == keyEnter:
+			if text := strings.TrimSpace(string(ui.text)); text == "" {
+				ui.answer(nil)
+			} else {
+				data, _ := json.Marshal(text)
+				ui.answer(exam.Answer(data))
+			}
+			if ui.status == "" && ui.index < len(ui.questions)-1 {
+				ui.show(ui.index + 1)
+			}
+		case k == keyBackspace:
+			if len(ui.text) > 0 {
+				ui.text = ui.text[:len(ui.text)-1]
+			}
+		case utf8.RuneCountInString(k) == 1 && k >= " ":
+			ui.text = append(ui.text, []rune(k)...)
+		}
+	}
+	return nil
+}
+
+// view renders the screen of the question shown, or of the result once submitted
+func (ui *takeUI) view() string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\n")
+	}
+	answered := 0
+	for _, a := range ui.answers {
+		if a != nil {
+			answered++
+		}
+	}
+
+	if ui.result != nil {
+		line("\x1b[1m%s\x1b[0m", ui.taker.title())
+		line("")
+		summary := fmt.Sprintf("Score: %d/%d (%.1f%%)", ui.result.Score, ui.result.Total, ui.result.Percent)
+		if ui.result.Passed != nil {
+			summary += map[bool]string{true: " - passed", false: " - not passed"}[*ui.result.Passed]
+		}
+		line("%s", summary)
+		if ui.result.Scaled != nil || ui.result.Percentile != nil {
+			var report []string
+			if ui.result.Scaled != nil {
+				report = append(report, fmt.Sprintf("Scaled score: %g", *ui.result.Scaled))
+			}
+			if ui.result.Percentile != nil {
+				report = append(report, fmt.Sprintf("Percentile: %.0f", *ui.result.Percentile))
+			}
+			line("%s", strings.Join(report, " - "))
+		}
+		for _, s := range ui.result.Sections {
+			section := fmt.Sprintf("  %s: %d/%d (%.1f%%)", s.Name, s.Correct, s.Total, s.Percent)
+			if s.Passed != nil {
+				section += map[bool]string{true: " - passed", false: " - not passed"}[*s.Passed]
+			}
+			line("%s", section)
+		}
+		line("")
+		for i, q := range ui.questions {
+			mark := "\x1b[31m✗\x1b[0m"
+			if i < len(ui.result.Correct) && ui.result.Correct[i] {
+				mark = "\x1b[32m✓\x1b[0m"
+			}
+			text, _ := q["question"].(string)
+			line(" %s %3d. %s", mark, i+1, truncate(firstLine(text), ui.width-8))
+		}
+		line("")
+		line("\x1b[2mPress any key to exit\x1b[0m")
+		return b.String()
+	}
+
+	q := ui.questions[ui.index]
+	line("\x1b[1m%s\x1b[0m", ui.taker.title())
+	line("Question %d of %d · %d answered", ui.index+1, len(ui.questions), answered)
+	line("%s", strings.Repeat("─", ui.width))
+	text, _ := q["question"].(string)
+	for _, l := range wrapText(text, ui.width) {
+		line("%s", l)
+	}
+	line("")
+	if choices := ui.choices(); choices != nil {
+		chosen, hasChoice := ui.answers[ui.index].Choice()
+		for i, c := range choices {
+			marker, radio := "  ", "( )"
+			if i == ui.cursor {
+				marker = "\x1b[1m>\x1b[0m "
+			}
+			if hasChoice && i == chosen {
+				radio = "(•)"
+			}
+			label := fmt.Sprint(c)
+			for j, l := range wrapText(label, ui.width-10) {
+				if j == 0 {
+					line("%s%s %d. %s", marker, radio, i+1, l)
+				} else {
+					line("         %s", l)
+				}
+			}
+		}
+	} else {
+		line("Answer: %s\x1b[7m \x1b[0m", string(ui.text))
+	}
+	line("")
+	switch {
+	case ui.submitting:
+		line("Submitting…")
+	case ui.confirm:
+		line("\x1b[1mSubmit with %d of %d questions answered? (y/n)\x1b[0m", answered, len(ui.questions))
+	case ui.status != "":
+		line("\x1b[31m%s\x1b[0m", ui.status)
+	default:
+		line("")
+	}
+	if ui.choices() != nil {
+		line("\x1b[2m↑/↓ move · enter or 1-9 choose · ←/→ question · s submit · q quit\x1b[0m")
+	} else {
+		line("\x1b[2mtype the answer · enter save · ←/→ question · ctrl+s submit · ctrl+c quit\x1b[0m")
+	}
+	return b.String()
+}
+
+// wrapText breaks text into lines of at most width characters at spaces, keeping its line breaks
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		var cur []string
+		n := 0
+		for _, word := range strings.Fields(para) {
+			w := utf8.RuneCountInString(word)
+			if n > 0 && n+1+w > width {
+				lines = append(lines, strings.Join(cur, " "))
+				cur, n = nil, 0
+			}
+			if n > 0 {
+				n++
+			}
+			cur = append(cur, word)
+			n += w
+		}
+		lines = append(lines, strings.Join(cur, " "))
+	}
+	return lines
+}
+
+// firstLine returns the text up to its first line break
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+// truncate shortens text to at most width characters, marking the cut with an ellipsis
+func truncate(text string, width int) string {
+	if utf8.RuneCountInString(text) <= width || width < 1 {
+		return text
+	}
+	return string([]rune(text)[:width-1]) + "…"
+}